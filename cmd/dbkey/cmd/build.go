@@ -0,0 +1,387 @@
+// Package cmd provides empirical library building implementation
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/filter"
+	"github.com/ChrisMcGann/DBKey/pkg/ident"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/mzml"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Flags for build command
+	buildIDs            []string
+	buildMzML           []string
+	buildOutput         string
+	buildScore          string
+	buildScoreThreshold float64
+	buildMaxQValue      float64
+	buildFragmentation  string
+	buildMassAnalyzer   string
+	buildTopN           int
+	buildCutoff         float64
+	buildForce          bool
+	buildReportFile     string
+)
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build an empirical library from search results and mzML spectra",
+	Long: `Build an empirical spectral library from peptide identifications in pepXML
+or mzIdentML and the matching MS2 scans in mzML.
+
+Rank 1 matches are filtered by q-value (when the results report one) and
+optionally by a score threshold. For each modified sequence and charge the
+best-scoring replicate is kept, and its scan is read from the mzML file of
+the same run. The precursor m/z is recalculated from the peptide; retention
+time, collision energy and fragmentation come from the scan.
+
+A JSON report (<out>.report.json unless --report is given) records the
+settings, counts and every spectrum skipped by filtering or validation.
+
+Scores are named as in the input, e.g. expect, hyperscore,
+peptideprophet_probability or MS-GF:SpecEValue. Expectation values, q-values
+and posterior error probabilities rank lower values first. By default the
+first available of iprophet_probability, peptideprophet_probability,
+hyperscore, xcorr, MS-GF:SpecEValue, Mascot:score and expect is used.
+
+Examples:
+  # Build from MSFragger + PeptideProphet results
+  dbkey build --ids interact.pep.xml --mzml run1.mzML --mzml run2.mzML --out empirical.db
+
+  # Build from MS-GF+ results, keeping matches with SpecEValue <= 1e-10
+  dbkey build --ids run1.mzid --mzml run1.mzML --out empirical.db \
+    --score MS-GF:SpecEValue --score-threshold 1e-10`,
+	RunE: runBuild,
+}
+
+func init() {
+	rootCmd.AddCommand(buildCmd)
+
+	buildCmd.Flags().StringArrayVar(&buildIDs, "ids", nil, "pepXML or mzIdentML identification file (repeatable, required)")
+	buildCmd.Flags().StringArrayVar(&buildMzML, "mzml", nil, "mzML file with the identified spectra (repeatable, required)")
+	buildCmd.Flags().StringVarP(&buildOutput, "out", "o", "", "Output database file (required)")
+	buildCmd.Flags().StringVar(&buildScore, "score", "", "Score used to filter and rank replicates (default: first preferred score available)")
+	buildCmd.Flags().Float64Var(&buildScoreThreshold, "score-threshold", 0, "Keep matches scoring at least this well on --score")
+	buildCmd.Flags().Float64Var(&buildMaxQValue, "max-qvalue", 0.01, "Maximum q-value for matches that report one (-1 = no q-value filter)")
+	buildCmd.Flags().StringVar(&buildFragmentation, "fragmentation", "read", "Fragmentation mode: HCD, CID, or 'read' to read from the mzML file")
+	buildCmd.Flags().StringVar(&buildMassAnalyzer, "mass-analyzer", "FT", "Mass analyzer: FT or IT")
+	buildCmd.Flags().IntVar(&buildTopN, "top-n", 0, "Keep only top N most intense peaks (0 = no limit)")
+	buildCmd.Flags().Float64Var(&buildCutoff, "cutoff", 0, "Intensity cutoff as % of base peak (0 = no cutoff)")
+	buildCmd.Flags().BoolVar(&buildForce, "force", false, "Overwrite an existing output database")
+	buildCmd.Flags().StringVar(&buildReportFile, "report", "", "Path to the JSON build report (default: <out>.report.json)")
+	addLibraryFlags(buildCmd)
+
+	buildCmd.MarkFlagRequired("ids")
+	buildCmd.MarkFlagRequired("mzml")
+	buildCmd.MarkFlagRequired("out")
+}
+
+func runBuild(cmd *cobra.Command, args []string) error {
+	for _, path := range append(append([]string{}, buildIDs...), buildMzML...) {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("input file does not exist: %s", path)
+		}
+	}
+
+	opts, err := libraryOptions(cmd)
+	if err != nil {
+		return err
+	}
+	opts.Overwrite = buildForce
+	if _, err := os.Stat(buildOutput); err == nil && !buildForce {
+		return fmt.Errorf("output file %s already exists, use --force to overwrite", buildOutput)
+	}
+
+	report := newBuildReport(opts)
+	err = buildLibrary(cmd, report, opts)
+	report.finish(err)
+
+	reportPath := buildReportFile
+	if reportPath == "" {
+		reportPath = buildOutput + ".report.json"
+	}
+	return saveReport(report, reportPath, err)
+}
+
+// newBuildReport starts a report for the current build settings
+func newBuildReport(opts sqlite.Options) *conversionReport {
+	maxQValue := buildMaxQValue
+	return &conversionReport{
+		Version:   reportVersion,
+		Status:    "running",
+		StartTime: time.Now(),
+		Settings: convertSettings{
+			Identifications: buildIDs,
+			Spectra:         buildMzML,
+			Format:          "mzml",
+			Output:          buildOutput,
+			OutputMode:      opts.Mode.String(),
+			Fragmentation:   buildFragmentation,
+			MassAnalyzer:    buildMassAnalyzer,
+			TopN:            buildTopN,
+			IntensityCutoff: buildCutoff,
+			MaxQValue:       &maxQValue,
+			Library:         opts,
+		},
+		Rejections: make(map[string]int),
+	}
+}
+
+// buildLibrary runs the build pipeline, recording counts and skipped
+// spectra in the report
+func buildLibrary(cmd *cobra.Command, report *conversionReport, opts sqlite.Options) error {
+	ctx := cmd.Context()
+	modDB := loadModDatabase()
+
+	// Read identifications
+	var psms []*ident.PSM
+	for _, path := range buildIDs {
+		fmt.Printf("Reading %s...\n", path)
+		ids, err := readIdentifications(path, modDB)
+		if err != nil {
+			return err
+		}
+		psms = append(psms, ids...)
+	}
+
+	score := buildScore
+	if score == "" {
+		score = ident.DefaultScore(psms)
+	}
+	threshold := math.NaN()
+	if cmd.Flags().Changed("score-threshold") {
+		if score == "" {
+			return fmt.Errorf("--score-threshold needs a --score")
+		}
+		threshold = buildScoreThreshold
+		report.Settings.ScoreThreshold = &threshold
+	}
+	report.Settings.Score = score
+
+	passed := ident.Filter(psms, score, threshold, buildMaxQValue)
+	selected := ident.BestPerPrecursor(passed, score)
+
+	fmt.Printf("Identifications: %d rank 1 matches, %d passed filters, %d unique precursors\n",
+		len(psms), len(passed), len(selected))
+	if score != "" {
+		direction := "higher is better"
+		if ident.LowerIsBetter(score) {
+			direction = "lower is better"
+		}
+		fmt.Printf("Score: %s (%s)\n", score, direction)
+	}
+
+	index := newScanIndex(selected, len(buildMzML) == 1)
+
+	filterConfig := &filter.Config{
+		TopN:            buildTopN,
+		IntensityCutoff: buildCutoff,
+	}
+
+	writer, err := sqlite.NewWriterWithOptions(buildOutput, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create output database: %w", err)
+	}
+	defer writer.Abort()
+
+	for _, path := range buildMzML {
+		fmt.Printf("Reading %s...\n", path)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		run := ident.RunName(path)
+
+		in := mzml.NewReader(f)
+		in.SetMSLevel(2)
+		for in.Next() {
+			if ctx.Err() != nil {
+				break
+			}
+
+			psm := index.take(run, in.ID(), in.ScanNumber(), in.Index())
+			if psm == nil {
+				continue
+			}
+
+			report.Counts.Read++
+			spec := in.Spectrum()
+			spec.Sequence = psm.Sequence
+			spec.Modifications = psm.Modifications
+			spec.Charge = psm.Charge
+			spec.SourceFile = path
+			if spec.RetentionTime == nil {
+				spec.RetentionTime = psm.RetentionTime
+			}
+			if buildFragmentation != "" && buildFragmentation != "read" {
+				spec.FragmentationMode = buildFragmentation
+			}
+			if buildMassAnalyzer != "" && buildMassAnalyzer != "read" {
+				spec.MassAnalyzer = buildMassAnalyzer
+			}
+
+			// Libraries use the theoretical precursor m/z
+			spec.PrecursorMZ = 0
+			if err := applyFormatDefaults(spec); err != nil {
+				report.skip(spec.Name(), in.ID(), rejectFormula, err)
+				continue
+			}
+
+			filter.RemoveZeroIntensityPeaks(spec)
+			if err := filterConfig.Apply(spec); err != nil {
+				report.skip(spec.Name(), in.ID(), rejectFilterError, err)
+				continue
+			}
+			if err := spec.Validate(); err != nil {
+				report.skip(spec.Name(), in.ID(), rejectionReason(err), err)
+				continue
+			}
+
+			writeStart := time.Now()
+			err := writer.WriteSpectrumContext(ctx, spec)
+			report.writeTime += time.Since(writeStart)
+			if err != nil {
+				f.Close()
+				if ctx.Err() != nil {
+					return fmt.Errorf("build interrupted: %w", ctx.Err())
+				}
+				return fmt.Errorf("failed to write spectrum %s: %w", spec.Name(), err)
+			}
+			report.Counts.Written++
+		}
+
+		err = in.Err()
+		f.Close()
+		if ctx.Err() != nil {
+			return fmt.Errorf("build interrupted: %w", ctx.Err())
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+	}
+
+	report.Counts.Missing = index.remaining()
+	finalizeStart := time.Now()
+	err = writer.Finalize()
+	report.finalizeTime = time.Since(finalizeStart)
+	if err != nil {
+		return fmt.Errorf("failed to finalize database: %w", err)
+	}
+
+	fmt.Printf("\nBuild complete!\n")
+	fmt.Printf("Written: %d spectra\n", report.Counts.Written)
+	if report.Counts.Rejected > 0 {
+		fmt.Printf("Skipped: %d spectra\n", report.Counts.Rejected)
+		for _, reason := range sortedReasons(report.Rejections) {
+			fmt.Printf("  %s: %d\n", reason, report.Rejections[reason])
+		}
+	}
+	if report.Counts.Missing > 0 {
+		fmt.Printf("Missing: %d identified scans not found in the mzML files\n", report.Counts.Missing)
+	}
+	fmt.Printf("Output: %s\n", buildOutput)
+
+	return nil
+}
+
+// readIdentifications reads a pepXML or mzIdentML file, chosen by extension
+func readIdentifications(path string, modDB *core.ModDatabase) ([]*ident.PSM, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	lower := strings.ToLower(path)
+	var psms []*ident.PSM
+	switch {
+	case strings.HasSuffix(lower, ".pepxml"), strings.HasSuffix(lower, ".pep.xml"):
+		psms, err = ident.ReadPepXML(f, modDB)
+	case strings.HasSuffix(lower, ".mzid"):
+		psms, err = ident.ReadMzIdentML(f, modDB)
+	default:
+		return nil, fmt.Errorf("cannot detect identification format of %s, expected .pep.xml, .pepXML or .mzid", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return psms, nil
+}
+
+// scanIndex finds the selected match for an mzML spectrum by native ID,
+// scan number or index within its run. With a single mzML file every match
+// belongs to it, whatever run name the search results report.
+type scanIndex struct {
+	singleRun bool
+	byID      map[string]*ident.PSM
+	byScan    map[string]*ident.PSM
+	byIndex   map[string]*ident.PSM
+	taken     map[*ident.PSM]bool
+	total     int
+}
+
+// newScanIndex indexes the selected matches
+func newScanIndex(psms []*ident.PSM, singleRun bool) *scanIndex {
+	idx := &scanIndex{
+		singleRun: singleRun,
+		byID:      make(map[string]*ident.PSM),
+		byScan:    make(map[string]*ident.PSM),
+		byIndex:   make(map[string]*ident.PSM),
+		taken:     make(map[*ident.PSM]bool),
+		total:     len(psms),
+	}
+	for _, p := range psms {
+		run := idx.runKey(p.Run)
+		if p.SpectrumID != "" {
+			idx.byID[run+"\x00"+p.SpectrumID] = p
+		}
+		if p.Scan > 0 {
+			idx.byScan[fmt.Sprintf("%s\x00%d", run, p.Scan)] = p
+		}
+		if p.Index >= 0 {
+			idx.byIndex[fmt.Sprintf("%s\x00%d", run, p.Index)] = p
+		}
+	}
+	return idx
+}
+
+// runKey returns the run name used for lookups
+func (idx *scanIndex) runKey(run string) string {
+	if idx.singleRun {
+		return ""
+	}
+	return run
+}
+
+// take returns the match for a spectrum, or nil, and marks it used so each
+// match is written once
+func (idx *scanIndex) take(run, id string, scan, index int) *ident.PSM {
+	run = idx.runKey(run)
+	p := idx.byID[run+"\x00"+id]
+	if p == nil && scan > 0 {
+		p = idx.byScan[fmt.Sprintf("%s\x00%d", run, scan)]
+	}
+	if p == nil {
+		p = idx.byIndex[fmt.Sprintf("%s\x00%d", run, index)]
+	}
+	if p == nil || idx.taken[p] {
+		return nil
+	}
+	idx.taken[p] = true
+	return p
+}
+
+// remaining returns the number of matches whose spectra were not found
+func (idx *scanIndex) remaining() int {
+	return idx.total - len(idx.taken)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/ident"
+)
+
+func TestScanIndex(t *testing.T) {
+	psms := []*ident.PSM{
+		{Run: "run01", SpectrumID: "controllerType=0 controllerNumber=1 scan=10", Scan: 10, Index: -1, Sequence: "AAAK"},
+		{Run: "run01", Scan: 20, Index: -1, Sequence: "CCCK"},
+		{Run: "run02", Index: 5, Sequence: "DDDK"},
+		{Run: "run02", Scan: 10, Index: -1, Sequence: "EEEK"},
+	}
+
+	type lookup struct {
+		run   string
+		id    string
+		scan  int
+		index int
+		want  string // Sequence of the match, "" for none
+	}
+	tests := []struct {
+		name          string
+		singleRun     bool
+		lookups       []lookup
+		wantRemaining int
+	}{
+		{
+			name: "by native ID, scan number and index",
+			lookups: []lookup{
+				{run: "run01", id: "controllerType=0 controllerNumber=1 scan=10", scan: 10, index: 9, want: "AAAK"},
+				{run: "run01", id: "scan=20", scan: 20, index: 19, want: "CCCK"},
+				{run: "run02", id: "index=5", index: 5, want: "DDDK"},
+				{run: "run02", id: "scan=10", scan: 10, index: 9, want: "EEEK"},
+			},
+		},
+		{
+			name: "each match is taken once",
+			lookups: []lookup{
+				{run: "run01", id: "scan=20", scan: 20, index: 19, want: "CCCK"},
+				{run: "run01", id: "scan=20", scan: 20, index: 19, want: ""},
+			},
+			wantRemaining: 3,
+		},
+		{
+			name: "runs are kept apart",
+			lookups: []lookup{
+				{run: "run03", id: "scan=20", scan: 20, index: 19, want: ""},
+				{run: "run02", id: "scan=20", scan: 20, index: 19, want: ""},
+			},
+			wantRemaining: 4,
+		},
+		{
+			name:      "single run ignores run names",
+			singleRun: true,
+			lookups: []lookup{
+				{run: "other", id: "scan=20", scan: 20, index: 19, want: "CCCK"},
+				{run: "other", id: "index=5", index: 5, want: "DDDK"},
+			},
+			wantRemaining: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newScanIndex(psms, tt.singleRun)
+			for _, l := range tt.lookups {
+				got := ""
+				if p := idx.take(l.run, l.id, l.scan, l.index); p != nil {
+					got = p.Sequence
+				}
+				if got != l.want {
+					t.Errorf("take(%q, %q, %d, %d) = %q, want %q", l.run, l.id, l.scan, l.index, got, l.want)
+				}
+			}
+			if got := idx.remaining(); got != tt.wantRemaining {
+				t.Errorf("remaining() = %d, want %d", got, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+// mzmlArray returns a base64 binaryDataArray of 64-bit floats
+func mzmlArray(accession string, values []float64) string {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+	}
+	return fmt.Sprintf(`<binaryDataArray><cvParam accession="MS:1000523"/><cvParam accession="%s"/><binary>%s</binary></binaryDataArray>`,
+		accession, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// mzmlScan returns an HCD MS2 spectrum with the given peaks
+func mzmlScan(scan int, mzs, intensities []float64) string {
+	return fmt.Sprintf(`<spectrum index="%d" id="controllerType=0 controllerNumber=1 scan=%d">
+<cvParam accession="MS:1000511" value="2"/>
+<scanList><scan><cvParam accession="MS:1000016" value="600" unitAccession="UO:0000010"/></scan></scanList>
+<precursorList><precursor><activation><cvParam accession="MS:1000422"/></activation></precursor></precursorList>
+<binaryDataArrayList>%s%s</binaryDataArrayList>
+</spectrum>
+`, scan-1, scan, mzmlArray("MS:1000514", mzs), mzmlArray("MS:1000515", intensities))
+}
+
+// buildPepXMLQuery returns a spectrum query with a rank 1 match
+func buildPepXMLQuery(scan int, peptide string) string {
+	return fmt.Sprintf(`<spectrum_query spectrum="run01.%05d.%05d.2" start_scan="%d" assumed_charge="2">
+<search_result><search_hit hit_rank="1" peptide="%s"><search_score name="hyperscore" value="30"/></search_hit></search_result>
+</spectrum_query>
+`, scan, scan, scan, peptide)
+}
+
+func TestBuildReport(t *testing.T) {
+	dir := t.TempDir()
+	ids := filepath.Join(dir, "run01.pep.xml")
+	spectra := filepath.Join(dir, "run01.mzML")
+	output := filepath.Join(dir, "empirical.db")
+
+	// PEPTIDEK is written, AAAK has only zero-intensity peaks and the scan
+	// of LLLK is not in the mzML file
+	pepXML := `<msms_pipeline_analysis><msms_run_summary base_name="run01">` +
+		buildPepXMLQuery(2, "PEPTIDEK") + buildPepXMLQuery(3, "AAAK") + buildPepXMLQuery(99, "LLLK") +
+		`</msms_run_summary></msms_pipeline_analysis>`
+	mzML := `<mzML><run><spectrumList>` +
+		mzmlScan(2, []float64{175.119, 300.5}, []float64{100, 50}) +
+		mzmlScan(3, []float64{175.119}, []float64{0}) +
+		`</spectrumList></run></mzML>`
+	if err := os.WriteFile(ids, []byte(pepXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spectra, []byte(mzML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldIDs, oldMzML, oldOutput, oldReport := buildIDs, buildMzML, buildOutput, buildReportFile
+	oldScore, oldMaxQ, oldForce := buildScore, buildMaxQValue, buildForce
+	oldFragmentation, oldAnalyzer, oldTopN, oldCutoff := buildFragmentation, buildMassAnalyzer, buildTopN, buildCutoff
+	t.Cleanup(func() {
+		buildIDs, buildMzML, buildOutput, buildReportFile = oldIDs, oldMzML, oldOutput, oldReport
+		buildScore, buildMaxQValue, buildForce = oldScore, oldMaxQ, oldForce
+		buildFragmentation, buildMassAnalyzer, buildTopN, buildCutoff = oldFragmentation, oldAnalyzer, oldTopN, oldCutoff
+	})
+	buildIDs, buildMzML, buildOutput, buildReportFile = []string{ids}, []string{spectra}, output, ""
+	buildScore, buildMaxQValue, buildForce = "", 0.01, false
+	buildFragmentation, buildMassAnalyzer, buildTopN, buildCutoff = "read", "FT", 0, 0
+
+	buildCmd.SetContext(context.Background())
+	if err := runBuild(buildCmd, nil); err != nil {
+		t.Fatalf("runBuild() error = %v", err)
+	}
+
+	data, err := os.ReadFile(output + ".report.json")
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var report conversionReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "completed" {
+		t.Errorf("report status = %q, want completed", report.Status)
+	}
+	wantCounts := reportCounts{Read: 2, Written: 1, Rejected: 1, Missing: 1}
+	if report.Counts != wantCounts {
+		t.Errorf("report counts = %+v, want %+v", report.Counts, wantCounts)
+	}
+	if report.Rejections["validation_peaks"] != 1 {
+		t.Errorf("report rejections = %v, want validation_peaks: 1", report.Rejections)
+	}
+	if len(report.Skipped) != 1 {
+		t.Fatalf("report skipped = %+v, want 1 spectrum", report.Skipped)
+	}
+	skipped := report.Skipped[0]
+	if skipped.Spectrum != "AAAK/2" || skipped.ScanID != "controllerType=0 controllerNumber=1 scan=3" ||
+		skipped.Reason != "validation_peaks" || !strings.Contains(skipped.Message, "at least one peak") {
+		t.Errorf("skipped spectrum = %+v", skipped)
+	}
+	if report.Settings.Score != "hyperscore" || len(report.Settings.Identifications) != 1 || len(report.Settings.Spectra) != 1 {
+		t.Errorf("report settings = %+v", report.Settings)
+	}
+
+	db, err := sql.Open("sqlite3", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.QueryRow(`SELECT Name FROM CompoundTable`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "PEPTIDEK/2" {
+		t.Errorf("built compound = %q, want PEPTIDEK/2", name)
+	}
+}
@@ -0,0 +1,152 @@
+// Package cmd provides retention time calibration setup
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/ChrisMcGann/DBKey/pkg/calibration"
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+)
+
+// loadRTCalibration loads a saved RT model or fits one from anchor peptides,
+// recording its fit quality in the report. Returns nil when no calibration
+// is configured.
+func loadRTCalibration(ctx context.Context, report *conversionReport, modDB *core.ModDatabase, dialect msp.Dialect) (*calibration.Model, error) {
+	if rtModelFile != "" {
+		f, err := os.Open(rtModelFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open RT model: %w", err)
+		}
+		defer f.Close()
+
+		model, err := calibration.Load(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load RT model %s: %w", rtModelFile, err)
+		}
+		fmt.Printf("Loaded %s RT calibration model from %s\n", model.Type, rtModelFile)
+		report.RTCalibration = &reportRTCalibration{Type: model.Type, Source: rtModelFile, Stats: model.Stats}
+		return model, nil
+	}
+
+	if rtAnchorsCSV == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(rtAnchorsCSV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RT anchor CSV: %w", err)
+	}
+	anchors, err := calibration.LoadAnchorsCSV(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load RT anchor CSV: %w", err)
+	}
+
+	anchors, skipped, err := resolveAnchorIRTs(ctx, anchors, modDB, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := calibration.DefaultOptions()
+	opts.Segments = rtSegments
+	opts.LowessSpan = rtLowessSpan
+
+	model, err := calibration.Fit(anchors, rtModelType, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fit RT calibration: %w", err)
+	}
+	printRTCalibration(model)
+	report.RTCalibration = &reportRTCalibration{
+		Type:           model.Type,
+		Source:         rtAnchorsCSV,
+		Stats:          model.Stats,
+		SkippedAnchors: skipped,
+	}
+
+	if rtModelSave != "" {
+		out, err := os.Create(rtModelSave)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create RT model file: %w", err)
+		}
+		if err := model.Save(out); err != nil {
+			out.Close()
+			return nil, fmt.Errorf("failed to save RT model: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return nil, fmt.Errorf("failed to save RT model: %w", err)
+		}
+		fmt.Printf("Saved RT calibration model to %s\n", rtModelSave)
+	}
+
+	return model, nil
+}
+
+// resolveAnchorIRTs fills in iRT values for anchors that do not provide one
+// by averaging the library retention times of spectra with the same sequence.
+// The model is needed before converting, so this reads the input in a pass
+// of its own, with the reader settings of the conversion. Anchors not found
+// in the library are dropped with a warning and returned as skipped.
+func resolveAnchorIRTs(ctx context.Context, anchors []calibration.Anchor, modDB *core.ModDatabase, dialect msp.Dialect) ([]calibration.Anchor, []string, error) {
+	needed := make(map[string]bool)
+	for _, a := range anchors {
+		if math.IsNaN(a.IRT) {
+			needed[a.Sequence] = true
+		}
+	}
+	if len(needed) == 0 {
+		return anchors, nil, nil
+	}
+
+	in, err := openConvertInput(ctx, modDB, dialect)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for in.Next() {
+		spec := in.Spectrum()
+		if needed[spec.Sequence] && spec.RetentionTime != nil {
+			sums[spec.Sequence] += *spec.RetentionTime
+			counts[spec.Sequence]++
+		}
+	}
+	if err := in.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading input file for RT anchors: %w", err)
+	}
+
+	var resolved []calibration.Anchor
+	var skipped []string
+	for _, a := range anchors {
+		if math.IsNaN(a.IRT) {
+			n := counts[a.Sequence]
+			if n == 0 {
+				fmt.Fprintf(os.Stderr, "Warning: RT anchor %s not found in library, skipping\n", a.Sequence)
+				skipped = append(skipped, a.Sequence)
+				continue
+			}
+			a.IRT = sums[a.Sequence] / float64(n)
+		}
+		resolved = append(resolved, a)
+	}
+
+	return resolved, skipped, nil
+}
+
+// printRTCalibration reports fit quality and per-anchor residuals
+func printRTCalibration(model *calibration.Model) {
+	stats := model.Stats
+	fmt.Printf("RT calibration: %s model from %d anchors\n", model.Type, stats.N)
+	fmt.Printf("  R²: %.4f\n", stats.RSquared)
+	fmt.Printf("  RMSE: %.4f\n", stats.RMSE)
+	fmt.Printf("  Max |residual|: %.4f\n", stats.MaxAbsResidual)
+	fmt.Printf("  %-30s %10s %10s %10s %10s\n", "Anchor", "iRT", "RT", "Predicted", "Residual")
+	for _, r := range stats.Residuals {
+		fmt.Printf("  %-30s %10.3f %10.3f %10.3f %10.3f\n", r.Sequence, r.IRT, r.RT, r.Predicted, r.Residual)
+	}
+}
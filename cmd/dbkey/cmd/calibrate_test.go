@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/calibration"
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+)
+
+// anchorMSP has two PEPTIDEK spectra, one LLLK spectrum and a malformed
+// AAAK entry between them
+const anchorMSP = `Name: PEPTIDEK/2
+Comment: Parent=464.73 iRT=10.5
+Num peaks: 1
+100.1	50
+
+Name: AAAK/2
+Comment: Parent=200.5 iRT=3
+Num peaks: abc
+100.1	50
+
+Name: LLLK/2
+Comment: Parent=250.2 iRT=20
+Num peaks: 1
+200.1	100
+
+Name: PEPTIDEK/2
+Comment: Parent=464.73 iRT=11.5
+Num peaks: 1
+100.1	50
+`
+
+// setConvertInput points the convert flags at an MSP file for one test
+func setConvertInput(t *testing.T, content string, limit int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.msp")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldInput, oldFormat, oldErrors := inputFile, inputFormat, maxErrors
+	t.Cleanup(func() { inputFile, inputFormat, maxErrors = oldInput, oldFormat, oldErrors })
+	inputFile, inputFormat, maxErrors = path, "msp", limit
+}
+
+func TestResolveAnchorIRTs(t *testing.T) {
+	anchors := []calibration.Anchor{
+		{Sequence: "PEPTIDEK", IRT: math.NaN(), RT: 30},
+		{Sequence: "LLLK", IRT: math.NaN(), RT: 50},
+		{Sequence: "MISSINGK", IRT: math.NaN(), RT: 70},
+		{Sequence: "AAAK", IRT: 3, RT: 10},
+	}
+
+	// The anchor pass stops at a malformed entry like the conversion
+	setConvertInput(t, anchorMSP, 0)
+	if _, _, err := resolveAnchorIRTs(context.Background(), anchors, core.DefaultModDatabase(), msp.DialectPeptide); err == nil {
+		t.Fatal("resolveAnchorIRTs() without --max-errors succeeded")
+	}
+
+	// and skips it with --max-errors
+	setConvertInput(t, anchorMSP, 1)
+	resolved, skipped, err := resolveAnchorIRTs(context.Background(), anchors, core.DefaultModDatabase(), msp.DialectPeptide)
+	if err != nil {
+		t.Fatalf("resolveAnchorIRTs() error = %v", err)
+	}
+	want := map[string]float64{"PEPTIDEK": 11, "LLLK": 20, "AAAK": 3}
+	if len(resolved) != len(want) {
+		t.Fatalf("resolveAnchorIRTs() = %v, want %d anchors", resolved, len(want))
+	}
+	for _, a := range resolved {
+		if math.Abs(a.IRT-want[a.Sequence]) > 1e-9 {
+			t.Errorf("anchor %s iRT = %f, want %f", a.Sequence, a.IRT, want[a.Sequence])
+		}
+	}
+	if len(skipped) != 1 || skipped[0] != "MISSINGK" {
+		t.Errorf("skipped anchors = %v, want [MISSINGK]", skipped)
+	}
+}
+
+func TestLoadRTCalibrationReport(t *testing.T) {
+	setConvertInput(t, anchorMSP, 1)
+	anchorsPath := filepath.Join(t.TempDir(), "anchors.csv")
+	if err := os.WriteFile(anchorsPath, []byte("Sequence,RT\nPEPTIDEK,30\nLLLK,48\nMISSINGK,70\nAAAK,3,10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldAnchors, oldType, oldModel, oldSave := rtAnchorsCSV, rtModelType, rtModelFile, rtModelSave
+	t.Cleanup(func() { rtAnchorsCSV, rtModelType, rtModelFile, rtModelSave = oldAnchors, oldType, oldModel, oldSave })
+	rtAnchorsCSV, rtModelType, rtModelFile, rtModelSave = anchorsPath, "linear", "", ""
+
+	report := newConversionReport()
+	model, err := loadRTCalibration(context.Background(), report, core.DefaultModDatabase(), msp.DialectPeptide)
+	if err != nil {
+		t.Fatalf("loadRTCalibration() error = %v", err)
+	}
+	cal := report.RTCalibration
+	if cal == nil {
+		t.Fatal("report has no RT calibration")
+	}
+	if cal.Type != "linear" || cal.Source != anchorsPath {
+		t.Errorf("RT calibration = %s from %s, want linear from %s", cal.Type, cal.Source, anchorsPath)
+	}
+	if cal.Stats != model.Stats || cal.Stats.N != 3 || len(cal.Stats.Residuals) != 3 {
+		t.Errorf("RT calibration stats = %+v, want the 3-anchor fit", cal.Stats)
+	}
+	if cal.Stats.RSquared <= 0.9 {
+		t.Errorf("RT calibration R² = %f, want > 0.9", cal.Stats.RSquared)
+	}
+	if len(cal.SkippedAnchors) != 1 || cal.SkippedAnchors[0] != "MISSINGK" {
+		t.Errorf("skipped anchors = %v, want [MISSINGK]", cal.SkippedAnchors)
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+// fingerprintBytes is how much of the input is hashed for its fingerprint
+const fingerprintBytes = 1 << 20
+
+// checkpointState is the part of the report restored when resuming
+type checkpointState struct {
+	Counts      reportCounts       `json:"counts"`
+	Rejections  map[string]int     `json:"rejections"`
+	ParseErrors []reportParseError `json:"parse_errors,omitempty"`
+	// RejectsSize is the length of the rejects file at the checkpoint
+	RejectsSize int64 `json:"rejects_size"`
+}
+
+// checkpointMark is the report state after the last written spectrum,
+// kept cheaply so that the checkpoint state is only built when a batch is
+// committed
+type checkpointMark struct {
+	offset      int64        // Input offset after the spectrum
+	counts      reportCounts // Counts after the spectrum
+	parseErrors int          // Parse errors recorded up to the spectrum
+	rejectsSize int64        // Length of the rejects file after the spectrum
+	pending     []string     // Rejection reasons counted since
+}
+
+// inputFingerprint identifies an input file by its size, modification time
+// and a hash of its first megabyte
+func inputFingerprint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, fingerprintBytes); err != nil && err != io.EOF {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s:%s", info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano),
+		hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// settingsHash identifies the settings that affect the converted spectra,
+// including the contents of the CSV and model files they name and of
+// unimod_custom.csv
+func settingsHash(settings convertSettings) (string, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+
+	files := []string{settings.MassOffsetCSV, settings.CompoundClassCSV, settings.RTAnchorsCSV, settings.RTModelFile}
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		if err := hashFile(h, path); err != nil {
+			return "", err
+		}
+	}
+	if err := hashFile(h, "unimod_custom.csv"); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile adds the name and contents of a file to h
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(h, "\x00%s\x00", path)
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return nil
+}
+
+// startCheckpoints starts tracking the state stored with checkpoints
+func (r *conversionReport) startCheckpoints() {
+	r.mark = &checkpointMark{}
+}
+
+// markCheckpoint records the state after a written spectrum, which ends at
+// the input offset
+func (r *conversionReport) markCheckpoint(offset, rejectsSize int64) {
+	m := r.mark
+	m.offset = offset
+	m.counts = r.Counts
+	m.parseErrors = len(r.ParseErrors)
+	m.rejectsSize = rejectsSize
+	m.pending = m.pending[:0]
+}
+
+// checkpoint returns the writer checkpoint for the last marked spectrum.
+// Rejections counted since are left out, as their entries are read again
+// when resuming.
+func (r *conversionReport) checkpoint(fingerprint, hash string) (sqlite.Checkpoint, error) {
+	m := r.mark
+	rejections := make(map[string]int, len(r.Rejections))
+	for reason, n := range r.Rejections {
+		rejections[reason] = n
+	}
+	for _, reason := range m.pending {
+		if rejections[reason]--; rejections[reason] == 0 {
+			delete(rejections, reason)
+		}
+	}
+
+	state, err := json.Marshal(checkpointState{
+		Counts:      m.counts,
+		Rejections:  rejections,
+		ParseErrors: r.ParseErrors[:m.parseErrors],
+		RejectsSize: m.rejectsSize,
+	})
+	if err != nil {
+		return sqlite.Checkpoint{}, err
+	}
+	return sqlite.Checkpoint{
+		InputFingerprint: fingerprint,
+		SettingsHash:     hash,
+		Offset:           m.offset,
+		State:            string(state),
+	}, nil
+}
+
+// resume checks that a checkpoint was written for the same input and
+// settings, and restores the report counts saved with it
+func (r *conversionReport) resume(cp *sqlite.Checkpoint, fingerprint, hash string) error {
+	if cp.InputFingerprint != fingerprint {
+		return fmt.Errorf("cannot resume: input file %s has changed since the checkpoint", r.Settings.Input)
+	}
+	if cp.SettingsHash != hash {
+		return fmt.Errorf("cannot resume: conversion settings differ from the checkpoint")
+	}
+
+	var state checkpointState
+	if err := json.Unmarshal([]byte(cp.State), &state); err != nil {
+		return fmt.Errorf("cannot resume: invalid checkpoint state: %w", err)
+	}
+	r.Counts = state.Counts
+	if state.Rejections != nil {
+		r.Rejections = state.Rejections
+	}
+	r.ParseErrors = state.ParseErrors
+	offset := cp.Offset
+	r.ResumedFrom = &offset
+	if r.mark == nil {
+		r.startCheckpoints()
+	}
+	r.markCheckpoint(offset, state.RejectsSize)
+	return nil
+}
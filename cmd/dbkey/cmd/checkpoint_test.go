@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+func TestSettingsHashFileContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsets.csv")
+	if err := os.WriteFile(path, []byte("PEPTIDEK,2,0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings := convertSettings{Input: "input.msp", MassOffsetCSV: path}
+
+	first, err := settingsHash(settings)
+	if err != nil {
+		t.Fatalf("settingsHash() error = %v", err)
+	}
+	if again, _ := settingsHash(settings); again != first {
+		t.Errorf("settingsHash() = %s, then %s for the same settings", first, again)
+	}
+
+	if err := os.WriteFile(path, []byte("PEPTIDEK,2,0.7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := settingsHash(settings)
+	if err != nil {
+		t.Fatalf("settingsHash() error = %v", err)
+	}
+	if changed == first {
+		t.Error("settingsHash() unchanged after editing the mass offset CSV")
+	}
+
+	settings.CompoundClassCSV = filepath.Join(t.TempDir(), "missing.csv")
+	if _, err := settingsHash(settings); err == nil {
+		t.Error("settingsHash() with a missing CSV succeeded")
+	}
+}
+
+func TestResumeMismatch(t *testing.T) {
+	report := newConversionReport()
+	report.startCheckpoints()
+	report.Counts = reportCounts{Read: 5, Written: 4, Rejected: 1}
+	report.Rejections["no peaks"] = 1
+	report.markCheckpoint(300, 20)
+	cp, err := report.checkpoint("input", "settings")
+	if err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		hash        string
+		wantErr     bool
+	}{
+		{name: "same input and settings", fingerprint: "input", hash: "settings"},
+		{name: "changed input", fingerprint: "other", hash: "settings", wantErr: true},
+		{name: "changed settings", fingerprint: "input", hash: "other", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumed := newConversionReport()
+			err := resumed.resume(&cp, tt.fingerprint, tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if resumed.Counts != report.Counts {
+				t.Errorf("resumed counts = %+v, want %+v", resumed.Counts, report.Counts)
+			}
+			if resumed.Rejections["no peaks"] != 1 {
+				t.Errorf("resumed rejections = %v, want no peaks: 1", resumed.Rejections)
+			}
+			if resumed.ResumedFrom == nil || *resumed.ResumedFrom != 300 {
+				t.Errorf("ResumedFrom = %v, want 300", resumed.ResumedFrom)
+			}
+			if resumed.mark.rejectsSize != 20 {
+				t.Errorf("resumed rejects size = %d, want 20", resumed.mark.rejectsSize)
+			}
+		})
+	}
+}
+
+func TestCheckpointExcludesPending(t *testing.T) {
+	report := newConversionReport()
+	report.startCheckpoints()
+	report.Counts.Read = 2
+	report.Counts.Written = 1
+	report.parseError(1, errors.New("bad entry"))
+	report.markCheckpoint(100, 10)
+
+	// Entries read after the last written spectrum are read again when
+	// resuming, so they are left out of the checkpoint
+	report.Counts.Read++
+	report.reject("no peaks")
+	report.parseError(9, errors.New("bad entry"))
+
+	cp, err := report.checkpoint("input", "settings")
+	if err != nil {
+		t.Fatalf("checkpoint() error = %v", err)
+	}
+	if cp.Offset != 100 {
+		t.Errorf("checkpoint offset = %d, want 100", cp.Offset)
+	}
+	var state checkpointState
+	if err := json.Unmarshal([]byte(cp.State), &state); err != nil {
+		t.Fatal(err)
+	}
+	want := reportCounts{Read: 3, Written: 1, Rejected: 1}
+	if state.Counts != want {
+		t.Errorf("checkpoint counts = %+v, want %+v", state.Counts, want)
+	}
+	if len(state.Rejections) != 1 || state.Rejections[rejectParseError] != 1 {
+		t.Errorf("checkpoint rejections = %v, want %s: 1", state.Rejections, rejectParseError)
+	}
+	if len(state.ParseErrors) != 1 || state.ParseErrors[0].Line != 1 {
+		t.Errorf("checkpoint parse errors = %+v, want line 1 only", state.ParseErrors)
+	}
+	if state.RejectsSize != 10 {
+		t.Errorf("checkpoint rejects size = %d, want 10", state.RejectsSize)
+	}
+}
+
+func TestRejectsFileResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.msp")
+
+	rejects, err := createRejectsFile(path, -1)
+	if err != nil {
+		t.Fatalf("createRejectsFile() error = %v", err)
+	}
+	rejects.write("Name: A")
+	size := rejects.size()
+	rejects.write("Name: B")
+	if err := rejects.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resuming drops the entries written after the checkpoint
+	rejects, err = createRejectsFile(path, size)
+	if err != nil {
+		t.Fatalf("createRejectsFile() resume error = %v", err)
+	}
+	rejects.write("Name: C")
+	if err := rejects.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Name: A\nName: C\n"; string(data) != want {
+		t.Errorf("rejects file = %q, want %q", data, want)
+	}
+
+	if _, err := createRejectsFile(path, int64(len(data))+1); err == nil {
+		t.Error("createRejectsFile() resuming a shortened file succeeded")
+	}
+}
+
+// cancelAfter is a context cancelled on the nth call to Err, so a test can
+// interrupt a conversion at a fixed point
+type cancelAfter struct {
+	context.Context
+	cancel context.CancelFunc
+	n      int
+}
+
+func newCancelAfter(n int) *cancelAfter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cancelAfter{Context: ctx, cancel: cancel, n: n}
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n == 0 {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+// readReport reads a conversion report
+func readReport(t *testing.T, path string) conversionReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var report conversionReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestConvertCancelled(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "library.msp")
+	output := filepath.Join(dir, "library.db")
+	report := filepath.Join(dir, "report.json")
+
+	// Ten peptides, written in batches of three
+	var msp strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&msp, "Name: %sK/2\nComment: iRT=%d\nNum peaks: 1\n147.113\t100\t\"y1/0.1ppm\"\n\n", strings.Repeat("A", i+2), i)
+	}
+	if err := os.WriteFile(input, []byte(msp.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldInput, oldFormat, oldTargets, oldReport, oldForce := inputFile, inputFormat, outputTargets, reportFile, forceOutput
+	oldCheckpoint, oldResume, oldChunk := checkpointOutput, resumeOutput, chunkSize
+	t.Cleanup(func() {
+		inputFile, inputFormat, outputTargets, reportFile, forceOutput = oldInput, oldFormat, oldTargets, oldReport, oldForce
+		checkpointOutput, resumeOutput, chunkSize = oldCheckpoint, oldResume, oldChunk
+	})
+	inputFile, inputFormat, outputTargets, reportFile, forceOutput = input, "", []string{output}, report, false
+	checkpointOutput, resumeOutput, chunkSize = true, false, 3
+
+	ctx := newCancelAfter(15)
+	convertCmd.SetContext(ctx)
+	err := runConvert(convertCmd, nil)
+	// main exits with status 130 for errors wrapping context.Canceled
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runConvert() error = %v, want context.Canceled", err)
+	}
+
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output exists after cancelling: %v", err)
+	}
+	cancelled := readReport(t, report)
+	if cancelled.Status != "cancelled" {
+		t.Errorf("report status = %q, want cancelled", cancelled.Status)
+	}
+
+	// Only committed batches are kept in the partial output
+	db, err := sql.Open("sqlite3", sqlite.PartialPath(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var committed int
+	err = db.QueryRow(`SELECT COUNT(*) FROM SpectrumTable`).Scan(&committed)
+	db.Close()
+	if err != nil {
+		t.Fatalf("partial output: %v", err)
+	}
+	if committed == 0 || committed%chunkSize != 0 || committed >= cancelled.Counts.Written {
+		t.Errorf("partial output holds %d spectra after %d were written, want the committed batches only",
+			committed, cancelled.Counts.Written)
+	}
+
+	// Resuming finishes the conversion from the partial output
+	resumeOutput = true
+	convertCmd.SetContext(context.Background())
+	if err := runConvert(convertCmd, nil); err != nil {
+		t.Fatalf("runConvert() resume error = %v", err)
+	}
+	resumed := readReport(t, report)
+	if resumed.Status != "completed" || resumed.ResumedFrom == nil || resumed.Counts.Written != 10 {
+		t.Errorf("resumed report status %q, resumed from %v, %d written, want completed, an offset, 10",
+			resumed.Status, resumed.ResumedFrom, resumed.Counts.Written)
+	}
+	if _, err := os.Stat(sqlite.PartialPath(output)); !os.IsNotExist(err) {
+		t.Errorf("partial output left after resuming: %v", err)
+	}
+
+	db, err = sql.Open("sqlite3", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var written int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM SpectrumTable`).Scan(&written); err != nil {
+		t.Fatal(err)
+	}
+	if written != 10 {
+		t.Errorf("resumed output holds %d spectra, want 10", written)
+	}
+}
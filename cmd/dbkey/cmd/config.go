@@ -0,0 +1,86 @@
+// Package cmd provides the JSON configuration file
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Library metadata flags shared by commands that write a database
+	configFile          string
+	libraryDescription  string
+	libraryCompany      string
+	libraryReadOnly     bool
+	libraryCurator      string
+	libraryCurationType string
+)
+
+// fileConfig is the layout of the --config JSON file. The "library"
+// section describes the output database.
+type fileConfig struct {
+	Library sqlite.Options `json:"library"`
+}
+
+// addLibraryFlags registers the config file and library metadata flags
+func addLibraryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&configFile, "config", "", "Path to a JSON config file with a \"library\" section")
+	cmd.Flags().StringVar(&libraryDescription, "description", "", "Library description (HeaderTable)")
+	cmd.Flags().StringVar(&libraryCompany, "company", "", "Company (HeaderTable)")
+	cmd.Flags().BoolVar(&libraryReadOnly, "read-only", false, "Mark the library read-only in mzVault")
+	cmd.Flags().StringVar(&libraryCurator, "curator", "", "Curator recorded for every spectrum")
+	cmd.Flags().StringVar(&libraryCurationType, "curation-type", "", "Curation type recorded for every spectrum (e.g. Predicted, Empirical)")
+}
+
+// loadConfig reads a JSON config file. Unknown keys are rejected so that
+// typos do not go unnoticed.
+func loadConfig(path string) (*fileConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	var cfg fileConfig
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// libraryOptions returns the database metadata from the config file,
+// overridden by any library flags set on the command line
+func libraryOptions(cmd *cobra.Command) (sqlite.Options, error) {
+	var opts sqlite.Options
+	if configFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return opts, err
+		}
+		opts = cfg.Library
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("description") {
+		opts.Description = libraryDescription
+	}
+	if flags.Changed("company") {
+		opts.Company = libraryCompany
+	}
+	if flags.Changed("read-only") {
+		opts.ReadOnly = libraryReadOnly
+	}
+	if flags.Changed("curator") {
+		opts.Curator = libraryCurator
+	}
+	if flags.Changed("curation-type") {
+		opts.CurationType = libraryCurationType
+	}
+	return opts, nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/spf13/cobra"
+)
+
+// writeConfig writes a config file and returns its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dbkey.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    sqlite.Options
+		wantErr string
+	}{
+		{
+			name: "library",
+			data: `{"library": {"description": "Prosit HCD", "company": "Lab", "read_only": true, "curator": "jdoe", "curation_type": "Predicted"}}`,
+			want: sqlite.Options{Description: "Prosit HCD", Company: "Lab", ReadOnly: true, Curator: "jdoe", CurationType: "Predicted"},
+		},
+		{name: "empty", data: `{}`},
+		{name: "unknown section", data: `{"libary": {}}`, wantErr: `unknown field "libary"`},
+		{name: "unknown library key", data: `{"library": {"descripton": "x"}}`, wantErr: `unknown field "descripton"`},
+		{name: "writer settings are not configurable", data: `{"library": {"Overwrite": true}}`, wantErr: `unknown field "Overwrite"`},
+		{name: "malformed", data: `{"library": `, wantErr: "invalid config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfig(t, tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if cfg.Library != tt.want {
+				t.Errorf("loadConfig() library = %+v, want %+v", cfg.Library, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadConfig() of a missing file succeeded")
+	}
+}
+
+func TestLibraryOptions(t *testing.T) {
+	config := writeConfig(t, `{"library": {"description": "From config", "company": "Lab", "read_only": true, "curator": "jdoe"}}`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    sqlite.Options
+		wantErr bool
+	}{
+		{
+			name: "flags only",
+			args: []string{"--description", "From flag", "--curation-type", "Empirical"},
+			want: sqlite.Options{Description: "From flag", CurationType: "Empirical"},
+		},
+		{
+			name: "config only",
+			args: []string{"--config", config},
+			want: sqlite.Options{Description: "From config", Company: "Lab", ReadOnly: true, Curator: "jdoe"},
+		},
+		{
+			// Flags set on the command line win, even when set to the zero value
+			name: "flags override config",
+			args: []string{"--config", config, "--description", "From flag", "--read-only=false", "--curator", ""},
+			want: sqlite.Options{Description: "From flag", Company: "Lab"},
+		},
+		{
+			name:    "invalid config",
+			args:    []string{"--config", writeConfig(t, `{"library": {"descripton": "x"}}`), "--description", "From flag"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldConfig, oldDescription, oldCompany := configFile, libraryDescription, libraryCompany
+			oldReadOnly, oldCurator, oldCurationType := libraryReadOnly, libraryCurator, libraryCurationType
+			t.Cleanup(func() {
+				configFile, libraryDescription, libraryCompany = oldConfig, oldDescription, oldCompany
+				libraryReadOnly, libraryCurator, libraryCurationType = oldReadOnly, oldCurator, oldCurationType
+			})
+
+			cmd := &cobra.Command{Use: "test"}
+			addLibraryFlags(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := libraryOptions(cmd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("libraryOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("libraryOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
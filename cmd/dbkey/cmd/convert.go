@@ -0,0 +1,508 @@
+// Package cmd provides the shared conversion pipeline
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/filter"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+	"github.com/ChrisMcGann/DBKey/pkg/writer"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/shard"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+)
+
+// checkOutput refuses to replace an existing output database unless it is
+// being updated or overwriting was requested with --force, and checks the
+// partial output when checkpointing
+func checkOutput(path string, opts sqlite.Options) error {
+	partial := sqlite.PartialPath(path)
+	_, partialErr := os.Stat(partial)
+	switch {
+	case opts.Resume && partialErr != nil:
+		return fmt.Errorf("no partial output %s to resume", partial)
+	case opts.Checkpoint && !opts.Resume && partialErr == nil && !opts.Overwrite:
+		return fmt.Errorf("partial output %s exists, use --resume to continue it or --force to start over", partial)
+	}
+
+	if opts.Mode != sqlite.ModeCreate || opts.Overwrite {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("output file %s already exists, use --force to overwrite or --append/--upsert to update it", path)
+	}
+	return nil
+}
+
+// convertLibrary runs the conversion pipeline and always writes the JSON
+// conversion report, including when the run fails
+func convertLibrary(ctx context.Context, opts sqlite.Options) error {
+	report := newConversionReport()
+	report.Settings.Library = opts
+	report.Settings.OutputMode = opts.Mode.String()
+	err := runConversion(ctx, report, opts)
+	report.finish(err)
+	if err != nil && opts.Checkpoint {
+		if _, statErr := os.Stat(sqlite.PartialPath(outputFile)); statErr == nil {
+			fmt.Fprintf(os.Stderr, "Partial output kept in %s, rerun with --resume to continue\n", sqlite.PartialPath(outputFile))
+		}
+	}
+
+	reportPath := reportFile
+	if reportPath == "" {
+		reportPath = outputFile + ".report.json"
+	}
+	return saveReport(report, reportPath, err)
+}
+
+// unwrapWriter returns the format-specific writer behind an output, or the
+// output itself if it is not wrapped
+func unwrapWriter(w writer.Writer) any {
+	if u, ok := w.(interface{ Unwrap() any }); ok {
+		return u.Unwrap()
+	}
+	return w
+}
+
+// sqliteWriter returns the mzVault database writer behind an output, or nil
+// for other formats
+func sqliteWriter(w writer.Writer) *sqlite.Writer {
+	db, _ := unwrapWriter(w).(*sqlite.Writer)
+	return db
+}
+
+// openConvertInput opens the conversion input with the --max-errors and
+// --msp-dialect settings, so every pass over it reads the same spectra
+func openConvertInput(ctx context.Context, modDB *core.ModDatabase, dialect msp.Dialect) (*reader.File, error) {
+	in, err := reader.OpenContext(ctx, inputFile, inputFormat, modDB)
+	if err != nil {
+		return nil, err
+	}
+	in.SetMaxErrors(maxErrors)
+	in.SetMSPDialect(dialect)
+	return in, nil
+}
+
+// runConversion runs the conversion pipeline for any supported input format:
+// read, annotate, calibrate, filter, validate and write each spectrum to
+// every output. Cancelling ctx stops reading, rolls back the open
+// transactions and removes the temporary output files.
+func runConversion(ctx context.Context, report *conversionReport, opts sqlite.Options) error {
+	// Load modification database, including unimod_custom.csv if it exists
+	modDB := loadModDatabase()
+
+	dialect, err := msp.ParseDialect(mspDialect)
+	if err != nil {
+		return err
+	}
+
+	// Polarity override, empty when read from the file
+	var forcePolarity string
+	if polarity != "" && polarity != "read" {
+		forcePolarity, err = core.ParsePolarity(polarity)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create input reader
+	in, err := openConvertInput(ctx, modDB, dialect)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Checkpoints identify the input and settings they were written for
+	var fingerprint, hash string
+	if opts.Checkpoint {
+		if _, ok := in.Offset(); !ok {
+			return fmt.Errorf("checkpointing is not supported for %s input", in.Format)
+		}
+		if fingerprint, err = inputFingerprint(inputFile); err != nil {
+			return fmt.Errorf("failed to fingerprint input file: %w", err)
+		}
+		if hash, err = settingsHash(report.Settings); err != nil {
+			return err
+		}
+	}
+
+	// Set up filter config
+	filterConfig := &filter.Config{
+		TopN:            topN,
+		IntensityCutoff: cutoffPercent,
+		OldModMass:      oldModMass,
+		NewModMass:      newModMass,
+		IonTypes:        report.Settings.IonTypes,
+	}
+
+	// Load mass offset mapping if provided
+	massOffsetMap := make(map[string]float64)
+	if massOffsetCSV != "" {
+		var err error
+		massOffsetMap, err = loadMassOffsetCSV(massOffsetCSV)
+		if err != nil {
+			return fmt.Errorf("failed to load mass offset CSV: %w", err)
+		}
+		fmt.Printf("Loaded %d mass offset mappings\n", len(massOffsetMap))
+	}
+
+	// Load compound class mapping if provided
+	compoundClassMap := make(map[string]string)
+	if compoundClassCSV != "" {
+		var err error
+		compoundClassMap, err = loadCompoundClassCSV(compoundClassCSV)
+		if err != nil {
+			return fmt.Errorf("failed to load compound class CSV: %w", err)
+		}
+		fmt.Printf("Loaded %d compound class mappings\n", len(compoundClassMap))
+	}
+
+	// Fit or load retention time calibration if configured
+	rtModel, err := loadRTCalibration(ctx, report, modDB, dialect)
+	if err != nil {
+		return err
+	}
+
+	// Create a writer for every output; unfinished outputs are removed
+	cfg := writer.Config{
+		Library:   opts,
+		Shard:     sharding,
+		Selection: transition.DefaultSelection,
+		BatchSize: opts.BatchSize,
+		Overwrite: opts.Overwrite,
+		ModDB:     modDB,
+	}
+	writers := make([]writer.Writer, 0, len(outputs))
+	defer func() {
+		for _, w := range writers {
+			writer.Abort(w)
+		}
+	}()
+	for _, target := range outputs {
+		w, err := writer.Open(ctx, target.Format, target.Path, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create output %s: %w", target.Path, err)
+		}
+		writers = append(writers, w)
+	}
+
+	// Checkpointing writes a single database, continuing reading after its
+	// last committed spectrum when resuming
+	var checkpointDB *sqlite.Writer
+	rejectsAt := int64(-1)
+	if opts.Checkpoint {
+		report.startCheckpoints()
+		checkpointDB = sqliteWriter(writers[0])
+		if cp := checkpointDB.Resumed(); cp != nil {
+			if err := report.resume(cp, fingerprint, hash); err != nil {
+				return err
+			}
+			if err := in.SeekOffset(cp.Offset); err != nil {
+				return err
+			}
+			rejectsAt = report.mark.rejectsSize
+			fmt.Printf("Resuming at byte %d after %d written spectra\n", cp.Offset, report.Counts.Written)
+		}
+	}
+
+	// Create rejects file if requested, dropping the entries written after
+	// the checkpoint when resuming
+	rejects, err := createRejectsFile(rejectsFile, rejectsAt)
+	if err != nil {
+		return err
+	}
+	defer rejects.close()
+	report.Rejects = rejectsFile
+
+	// The checkpoint state is built when the writer commits a batch
+	if checkpointDB != nil {
+		checkpointDB.SetCheckpointFunc(func() (sqlite.Checkpoint, error) {
+			if err := rejects.flush(); err != nil {
+				return sqlite.Checkpoint{}, fmt.Errorf("failed to write rejects file: %w", err)
+			}
+			return report.checkpoint(fingerprint, hash)
+		})
+	}
+
+	// reject records a dropped spectrum in the report and the rejects file
+	reject := func(reason, name string, cause error) error {
+		fmt.Fprintf(os.Stderr, "Warning: rejected spectrum %s (%s): %v\n", name, reason, cause)
+		report.reject(reason)
+		if err := rejects.write(in.Raw()); err != nil {
+			return fmt.Errorf("failed to write rejects file: %w", err)
+		}
+		return nil
+	}
+
+	// recordParseErrors reports malformed entries the reader skipped
+	reportedParseErrors := 0
+	recordParseErrors := func() error {
+		parseErrors := in.ParseErrors()
+		for ; reportedParseErrors < len(parseErrors); reportedParseErrors++ {
+			perr := parseErrors[reportedParseErrors]
+			fmt.Fprintf(os.Stderr, "Warning: skipped malformed entry at %v\n", perr)
+			report.parseError(perr.Line, perr.Err)
+			if err := rejects.write(perr.Raw); err != nil {
+				return fmt.Errorf("failed to write rejects file: %w", err)
+			}
+		}
+		return nil
+	}
+
+	// Flag overrides, calibration and filters around the shared pipeline
+	steps := prepareSteps{
+		override: func(spec *core.Spectrum) {
+			// Apply mass offset if configured
+			if offset, ok := massOffsetMap[spec.Sequence]; ok {
+				spec.MassOffset = offset
+			}
+
+			// Apply compound class if configured
+			if class, ok := compoundClassMap[spec.Sequence]; ok {
+				spec.CompoundClass = class
+			}
+
+			// Set fragmentation mode if specified
+			if fragmentation != "" && fragmentation != "read" {
+				spec.FragmentationMode = fragmentation
+			}
+
+			// Set mass analyzer if specified
+			if massAnalyzer != "" && massAnalyzer != "read" {
+				spec.MassAnalyzer = massAnalyzer
+			}
+
+			// Set polarity if specified, flipping the charge sign to match
+			if forcePolarity != "" {
+				spec.Polarity = forcePolarity
+				if core.PolarityFromCharge(spec.Charge) != forcePolarity {
+					spec.Charge = -spec.Charge
+				}
+			}
+
+			// Set collision energy if specified
+			if collisionEnergy > 0 {
+				spec.CollisionEnergy = &collisionEnergy
+			}
+
+			// Map iRT to run-specific retention time
+			if rtModel != nil && spec.RetentionTime != nil {
+				rt := rtModel.Predict(*spec.RetentionTime)
+				spec.RetentionTime = &rt
+			}
+		},
+		filter: func(spec *core.Spectrum) (string, error) {
+			// Remove zero intensity peaks
+			hadPeaks := len(spec.Peaks) > 0
+			filter.RemoveZeroIntensityPeaks(spec)
+
+			if err := filterConfig.Apply(spec); err != nil {
+				return rejectFilterError, err
+			}
+			if hadPeaks && len(spec.Peaks) == 0 {
+				return rejectFilterEmptied, fmt.Errorf("no peaks left after filtering")
+			}
+			return "", nil
+		},
+	}
+
+	// Process spectra
+	for {
+		readStart := time.Now()
+		ok := in.Next()
+		report.readTime += time.Since(readStart)
+		if err := recordParseErrors(); err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		spec := in.Spectrum()
+		report.Counts.Read++
+
+		if reason, err := prepareSpectrum(in, spec, steps); err != nil {
+			if err := reject(reason, spec.Name(), err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Write to every output
+		writeStart := time.Now()
+		for i, w := range writers {
+			err := w.Write(spec)
+			if ctx.Err() != nil {
+				return fmt.Errorf("conversion interrupted: %w", ctx.Err())
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write spectrum %s to %s: %w", spec.Name(), outputs[i].Path, err)
+			}
+		}
+		report.writeTime += time.Since(writeStart)
+
+		report.Counts.Written++
+		if checkpointDB != nil {
+			offset, _ := in.Offset()
+			report.markCheckpoint(offset, rejects.size())
+		}
+		if report.Counts.Written%1000 == 0 {
+			fmt.Printf("Processed %d spectra...\n", report.Counts.Written)
+		}
+	}
+
+	if err := in.Err(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("conversion interrupted: %w", ctx.Err())
+		}
+		// Keep the partial entry the reader stopped on
+		line, raw := 0, in.Raw()
+		var perr *core.ParseError
+		if errors.As(err, &perr) {
+			line, raw = perr.Line, perr.Raw
+		}
+		report.parseError(line, err)
+		if werr := rejects.write(raw); werr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write rejects file: %v\n", werr)
+		}
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+
+	// Finalize outputs
+	finalizeStart := time.Now()
+	for i, w := range writers {
+		if err := w.Close(); err != nil {
+			report.finalizeTime = time.Since(finalizeStart)
+			return fmt.Errorf("failed to finalize %s: %w", outputs[i].Path, err)
+		}
+		if db := sqliteWriter(w); db != nil {
+			report.Counts.Replaced += db.Replaced()
+		}
+	}
+	report.finalizeTime = time.Since(finalizeStart)
+
+	fmt.Printf("\nConversion complete!\n")
+	fmt.Printf("Processed: %d spectra\n", report.Counts.Written)
+	if opts.Mode == sqlite.ModeUpsert {
+		fmt.Printf("Replaced: %d existing spectra\n", report.Counts.Replaced)
+	}
+	if report.Counts.Rejected > 0 {
+		fmt.Printf("Rejected: %d spectra\n", report.Counts.Rejected)
+		for _, reason := range sortedReasons(report.Rejections) {
+			fmt.Printf("  %s: %d\n", reason, report.Rejections[reason])
+		}
+	}
+	if rejectsFile != "" {
+		fmt.Printf("Rejects: %s\n", rejectsFile)
+	}
+	for i, target := range outputs {
+		sharded, ok := writers[i].(*shard.Writer)
+		if !ok {
+			fmt.Printf("Output: %s\n", target)
+			continue
+		}
+		fmt.Printf("Output: %s in %d shards\n", target, len(sharded.Manifest().Shards))
+		for _, s := range sharded.Manifest().Shards {
+			fmt.Printf("  %s: %d spectra\n", s.Path, s.Spectra)
+		}
+		fmt.Printf("Manifest: %s\n", sharded.ManifestPath())
+	}
+
+	return nil
+}
+
+func loadMassOffsetCSV(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	result := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+
+	// Skip header line
+	if scanner.Scan() {
+		// header
+	}
+
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields (Sequence,massOffset), got %d", lineNum, len(parts))
+		}
+
+		sequence := strings.TrimSpace(parts[0])
+		offsetStr := strings.TrimSpace(parts[1])
+
+		offset, err := strconv.ParseFloat(offsetStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid mass offset value '%s': %w", lineNum, offsetStr, err)
+		}
+
+		result[sequence] = offset
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+
+	return result, nil
+}
+
+func loadCompoundClassCSV(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+
+	// Skip header line
+	if scanner.Scan() {
+		// header
+	}
+
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields (Sequence,CompoundClass), got %d", lineNum, len(parts))
+		}
+
+		sequence := strings.TrimSpace(parts[0])
+		class := strings.TrimSpace(parts[1])
+
+		result[sequence] = class
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+
+	return result, nil
+}
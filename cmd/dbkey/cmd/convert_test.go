@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.db")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    sqlite.Options
+		wantErr bool
+	}{
+		{name: "new output", path: filepath.Join(dir, "new.db")},
+		{name: "existing output", path: existing, wantErr: true},
+		{name: "existing output with --force", path: existing, opts: sqlite.Options{Overwrite: true}},
+		{name: "append to existing output", path: existing, opts: sqlite.Options{Mode: sqlite.ModeAppend}},
+		{name: "upsert into existing output", path: existing, opts: sqlite.Options{Mode: sqlite.ModeUpsert}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutput(tt.path, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// convertTestMSP is a Prosit-style MSP library of two peptides
+const convertTestMSP = `Name: PEPTIDEK/2
+MW: 927.4549
+Comment: Parent=464.7348 iRT=10.5
+Num peaks: 2
+375.2	50	"y3/0.1ppm"
+504.2	100	"y4/0.1ppm"
+
+Name: AAAK/2
+MW: 359.2169
+Comment: Parent=180.6157 iRT=5.0
+Num peaks: 1
+147.113	100	"y1/0.1ppm"
+`
+
+func TestConvertOutputs(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  string // Output file present before converting
+		wantErr   string
+		wantFiles []string
+	}{
+		{
+			name:      "all outputs written",
+			wantFiles: []string{"library.blib", "library.db", "library.jsonl", "library.msp"},
+		},
+		{
+			// The BLIB writer refuses the existing file after the database
+			// writer has started, which must be aborted
+			name:      "failed output aborts the others",
+			existing:  "library.blib",
+			wantErr:   "failed to create output",
+			wantFiles: []string{"library.blib", "library.msp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "library.msp")
+			if err := os.WriteFile(input, []byte(convertTestMSP), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.existing), []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			oldInput, oldFormat, oldTargets, oldReport, oldForce := inputFile, inputFormat, outputTargets, reportFile, forceOutput
+			t.Cleanup(func() {
+				inputFile, inputFormat, outputTargets, reportFile, forceOutput = oldInput, oldFormat, oldTargets, oldReport, oldForce
+			})
+			inputFile, inputFormat, forceOutput = input, "", false
+			reportFile = filepath.Join(t.TempDir(), "report.json")
+			outputTargets = []string{
+				filepath.Join(dir, "library.db"),
+				filepath.Join(dir, "library.blib"),
+				"jsonl:" + filepath.Join(dir, "library.jsonl"),
+			}
+
+			convertCmd.SetContext(context.Background())
+			err := runConvert(convertCmd, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runConvert() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("runConvert() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			if strings.Join(files, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+			if tt.existing != "" {
+				if data, _ := os.ReadFile(filepath.Join(dir, tt.existing)); string(data) != "existing" {
+					t.Errorf("%s = %q, want it untouched", tt.existing, data)
+				}
+			}
+		})
+	}
+}
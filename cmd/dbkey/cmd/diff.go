@@ -0,0 +1,403 @@
+// Package cmd provides library diff implementation
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/similarity"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Flags for diff command
+	diffFormatA    string
+	diffFormatB    string
+	diffTSV        string
+	diffForce      bool
+	diffTolerance  string
+	diffMetric     string
+	diffMaxOnlyA   int
+	diffMaxOnlyB   int
+	diffMinSim     float64
+	diffMaxMZDelta float64
+	diffMaxRTDelta float64
+	diffMaxCEDelta float64
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [library A] [library B]",
+	Short: "Compare two spectral libraries",
+	Long: `Compare two spectral libraries in any supported input format, including DBKey
+SQLite output. Precursors are matched by modified sequence and charge and
+reported as only in A, only in B, or shared. Shared precursors are compared by
+precursor m/z, retention time and collision energy deltas and by spectral
+similarity.
+
+Threshold flags make the command exit with an error when exceeded, so CI jobs
+can fail on unexpected drift. Thresholds are only checked when specified.
+
+Both libraries are held in memory while they are compared, so memory grows
+with the combined library size. An existing --tsv report is only replaced
+with --force.
+
+Examples:
+  # Summarize differences and write a per-precursor report
+  dbkey diff old.db new.db --tsv diff.tsv
+
+  # Fail if any precursor disappeared or any spectrum changed noticeably
+  dbkey diff old.db new.db --max-only-a 0 --min-similarity 0.95`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffFormatA, "from-a", "", "Format of library A (auto-detect if not specified)")
+	diffCmd.Flags().StringVar(&diffFormatB, "from-b", "", "Format of library B (auto-detect if not specified)")
+	diffCmd.Flags().StringVar(&diffTSV, "tsv", "", "Write per-precursor differences to this TSV file")
+	diffCmd.Flags().BoolVar(&diffForce, "force", false, "Overwrite an existing TSV report")
+	diffCmd.Flags().StringVar(&diffTolerance, "tolerance", "20ppm", "Fragment matching tolerance (e.g. 20ppm, 0.02Da)")
+	diffCmd.Flags().StringVar(&diffMetric, "metric", string(similarity.DefaultMetric), "Similarity metric: dot, angle, entropy, weighted-entropy, pearson")
+	diffCmd.Flags().IntVar(&diffMaxOnlyA, "max-only-a", 0, "Fail if more precursors than this are only in A")
+	diffCmd.Flags().IntVar(&diffMaxOnlyB, "max-only-b", 0, "Fail if more precursors than this are only in B")
+	diffCmd.Flags().Float64Var(&diffMinSim, "min-similarity", 0, "Fail if any shared precursor has a lower similarity")
+	diffCmd.Flags().Float64Var(&diffMaxMZDelta, "max-mz-delta", 0, "Fail if any shared precursor m/z differs by more than this (Th)")
+	diffCmd.Flags().Float64Var(&diffMaxRTDelta, "max-rt-delta", 0, "Fail if any shared retention time differs by more than this")
+	diffCmd.Flags().Float64Var(&diffMaxCEDelta, "max-ce-delta", 0, "Fail if any shared collision energy differs by more than this")
+}
+
+// diffRow is the comparison result for one precursor
+type diffRow struct {
+	key        string
+	status     string // only_a, only_b, shared
+	a, b       *core.Spectrum
+	mzDelta    float64
+	rtDelta    *float64
+	ceDelta    *float64
+	similarity float64
+}
+
+// diffLibrary is a library loaded into memory keyed by precursor
+type diffLibrary struct {
+	spectra    map[string]*core.Spectrum
+	order      []string
+	duplicates int
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	tol, err := similarity.ParseTolerance(diffTolerance)
+	if err != nil {
+		return err
+	}
+	metric, err := similarity.ParseMetric(diffMetric)
+	if err != nil {
+		return err
+	}
+
+	// The report is created first so an existing file fails before the
+	// libraries are loaded
+	var report *atomicfile.File
+	if diffTSV != "" {
+		report, err = atomicfile.Create(diffTSV, diffForce)
+		if errors.Is(err, atomicfile.ErrExists) {
+			return fmt.Errorf("%w, use --force to overwrite", err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create TSV report: %w", err)
+		}
+		defer report.Abort()
+	}
+
+	modDB := loadModDatabase()
+
+	libA, err := loadDiffLibrary(cmd.Context(), args[0], diffFormatA, modDB)
+	if err != nil {
+		return err
+	}
+	libB, err := loadDiffLibrary(cmd.Context(), args[1], diffFormatB, modDB)
+	if err != nil {
+		return err
+	}
+
+	// Compare in library A order, followed by precursors only in B
+	var rows []*diffRow
+	for _, key := range libA.order {
+		a := libA.spectra[key]
+		b, ok := libB.spectra[key]
+		if !ok {
+			rows = append(rows, &diffRow{key: key, status: "only_a", a: a})
+			continue
+		}
+
+		row := &diffRow{
+			key:     key,
+			status:  "shared",
+			a:       a,
+			b:       b,
+			mzDelta: b.PrecursorMZ - a.PrecursorMZ,
+			rtDelta: optionalDelta(a.RetentionTime, b.RetentionTime),
+			ceDelta: optionalDelta(a.CollisionEnergy, b.CollisionEnergy),
+		}
+		row.similarity, err = similarity.Score(metric, a, b, tol)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	for _, key := range libB.order {
+		if _, ok := libA.spectra[key]; !ok {
+			rows = append(rows, &diffRow{key: key, status: "only_b", b: libB.spectra[key]})
+		}
+	}
+
+	if report != nil {
+		if err := writeDiffTSV(report, rows); err != nil {
+			return fmt.Errorf("failed to write TSV report: %w", err)
+		}
+		if err := report.Commit(); err != nil {
+			return fmt.Errorf("failed to write TSV report: %w", err)
+		}
+	}
+
+	summary := summarizeDiff(rows)
+	printDiffSummary(args[0], args[1], libA, libB, summary, metric)
+	if diffTSV != "" {
+		fmt.Printf("Report: %s\n", diffTSV)
+	}
+
+	return checkDiffThresholds(cmd, summary)
+}
+
+// loadDiffLibrary reads a library into memory, keeping the first spectrum for
+// each precursor
+func loadDiffLibrary(ctx context.Context, path, format string, modDB *core.ModDatabase) (*diffLibrary, error) {
+	in, err := reader.OpenContext(ctx, path, format, modDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer in.Close()
+
+	lib := &diffLibrary{spectra: make(map[string]*core.Spectrum)}
+	for in.Next() {
+		spec := in.Spectrum()
+		if spec.PrecursorMZ == 0 {
+			if err := recalculatePrecursor(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: spectrum %s in %s: %v\n", spec.Name(), path, err)
+			}
+		}
+
+		key := spec.Key()
+		if _, ok := lib.spectra[key]; ok {
+			lib.duplicates++
+			continue
+		}
+		lib.spectra[key] = spec
+		lib.order = append(lib.order, key)
+	}
+	if err := in.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	return lib, nil
+}
+
+// optionalDelta returns b - a when both values are present
+func optionalDelta(a, b *float64) *float64 {
+	if a == nil || b == nil {
+		return nil
+	}
+	d := *b - *a
+	return &d
+}
+
+// diffSummary aggregates diff rows
+type diffSummary struct {
+	onlyA, onlyB, shared int
+	maxMZDelta           float64
+	maxRTDelta           float64
+	maxCEDelta           float64
+	minSimilarity        float64
+	meanSimilarity       float64
+	medianSimilarity     float64
+}
+
+func summarizeDiff(rows []*diffRow) *diffSummary {
+	s := &diffSummary{}
+	var sims []float64
+
+	for _, row := range rows {
+		switch row.status {
+		case "only_a":
+			s.onlyA++
+		case "only_b":
+			s.onlyB++
+		case "shared":
+			s.shared++
+			s.maxMZDelta = math.Max(s.maxMZDelta, math.Abs(row.mzDelta))
+			if row.rtDelta != nil {
+				s.maxRTDelta = math.Max(s.maxRTDelta, math.Abs(*row.rtDelta))
+			}
+			if row.ceDelta != nil {
+				s.maxCEDelta = math.Max(s.maxCEDelta, math.Abs(*row.ceDelta))
+			}
+			sims = append(sims, row.similarity)
+		}
+	}
+
+	if len(sims) > 0 {
+		sort.Float64s(sims)
+		s.minSimilarity = sims[0]
+		total := 0.0
+		for _, v := range sims {
+			total += v
+		}
+		s.meanSimilarity = total / float64(len(sims))
+		mid := len(sims) / 2
+		if len(sims)%2 == 0 {
+			s.medianSimilarity = (sims[mid-1] + sims[mid]) / 2
+		} else {
+			s.medianSimilarity = sims[mid]
+		}
+	}
+
+	return s
+}
+
+func printDiffSummary(pathA, pathB string, libA, libB *diffLibrary, s *diffSummary, metric similarity.Metric) {
+	fmt.Printf("A: %s (%d precursors", pathA, len(libA.order))
+	if libA.duplicates > 0 {
+		fmt.Printf(", %d duplicates ignored", libA.duplicates)
+	}
+	fmt.Printf(")\n")
+	fmt.Printf("B: %s (%d precursors", pathB, len(libB.order))
+	if libB.duplicates > 0 {
+		fmt.Printf(", %d duplicates ignored", libB.duplicates)
+	}
+	fmt.Printf(")\n\n")
+
+	fmt.Printf("%-24s %12s\n", "Category", "Precursors")
+	fmt.Printf("%-24s %12d\n", "Only in A", s.onlyA)
+	fmt.Printf("%-24s %12d\n", "Only in B", s.onlyB)
+	fmt.Printf("%-24s %12d\n", "Shared", s.shared)
+
+	if s.shared > 0 {
+		fmt.Printf("\n%-24s %12s\n", "Shared precursors", "Value")
+		fmt.Printf("%-24s %12.6f\n", "Max |m/z delta|", s.maxMZDelta)
+		fmt.Printf("%-24s %12.4f\n", "Max |RT delta|", s.maxRTDelta)
+		fmt.Printf("%-24s %12.4f\n", "Max |CE delta|", s.maxCEDelta)
+		fmt.Printf("%-24s %12.4f\n", "Min "+string(metric), s.minSimilarity)
+		fmt.Printf("%-24s %12.4f\n", "Mean "+string(metric), s.meanSimilarity)
+		fmt.Printf("%-24s %12.4f\n", "Median "+string(metric), s.medianSimilarity)
+	}
+}
+
+// checkDiffThresholds returns an error listing every threshold that was exceeded
+func checkDiffThresholds(cmd *cobra.Command, s *diffSummary) error {
+	flags := cmd.Flags()
+	var failures []string
+
+	if flags.Changed("max-only-a") && s.onlyA > diffMaxOnlyA {
+		failures = append(failures, fmt.Sprintf("%d precursors only in A (max %d)", s.onlyA, diffMaxOnlyA))
+	}
+	if flags.Changed("max-only-b") && s.onlyB > diffMaxOnlyB {
+		failures = append(failures, fmt.Sprintf("%d precursors only in B (max %d)", s.onlyB, diffMaxOnlyB))
+	}
+	if s.shared > 0 {
+		if flags.Changed("min-similarity") && s.minSimilarity < diffMinSim {
+			failures = append(failures, fmt.Sprintf("minimum similarity %.4f (min %.4f)", s.minSimilarity, diffMinSim))
+		}
+		if flags.Changed("max-mz-delta") && s.maxMZDelta > diffMaxMZDelta {
+			failures = append(failures, fmt.Sprintf("m/z delta %.6f (max %.6f)", s.maxMZDelta, diffMaxMZDelta))
+		}
+		if flags.Changed("max-rt-delta") && s.maxRTDelta > diffMaxRTDelta {
+			failures = append(failures, fmt.Sprintf("RT delta %.4f (max %.4f)", s.maxRTDelta, diffMaxRTDelta))
+		}
+		if flags.Changed("max-ce-delta") && s.maxCEDelta > diffMaxCEDelta {
+			failures = append(failures, fmt.Sprintf("CE delta %.4f (max %.4f)", s.maxCEDelta, diffMaxCEDelta))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("drift thresholds exceeded: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// writeDiffTSV writes one line per precursor
+func writeDiffTSV(out io.Writer, rows []*diffRow) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, strings.Join([]string{
+		"Precursor", "Status",
+		"PrecursorMZ_A", "PrecursorMZ_B", "PrecursorMZ_Delta",
+		"RT_A", "RT_B", "RT_Delta",
+		"CE_A", "CE_B", "CE_Delta",
+		"Peaks_A", "Peaks_B", "Similarity",
+	}, "\t"))
+
+	for _, row := range rows {
+		fields := []string{row.key, row.status}
+		fields = append(fields,
+			formatMZ(row.a), formatMZ(row.b), formatSharedFloat(row, row.mzDelta, "%.6f"),
+			formatOptional(rtOf(row.a)), formatOptional(rtOf(row.b)), formatOptional(row.rtDelta),
+			formatOptional(ceOf(row.a)), formatOptional(ceOf(row.b)), formatOptional(row.ceDelta),
+			formatPeakCount(row.a), formatPeakCount(row.b), formatSharedFloat(row, row.similarity, "%.4f"),
+		)
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func formatMZ(spec *core.Spectrum) string {
+	if spec == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.6f", spec.PrecursorMZ)
+}
+
+func formatPeakCount(spec *core.Spectrum) string {
+	if spec == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", len(spec.Peaks))
+}
+
+func formatSharedFloat(row *diffRow, v float64, format string) string {
+	if row.status != "shared" {
+		return ""
+	}
+	return fmt.Sprintf(format, v)
+}
+
+func formatOptional(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.4f", *v)
+}
+
+func rtOf(spec *core.Spectrum) *float64 {
+	if spec == nil {
+		return nil
+	}
+	return spec.RetentionTime
+}
+
+func ceOf(spec *core.Spectrum) *float64 {
+	if spec == nil {
+		return nil
+	}
+	return spec.CollisionEnergy
+}
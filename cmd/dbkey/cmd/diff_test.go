@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+const diffLibraryA = `Name: PEPTIDEK/2
+Comment: Parent=464.73 iRT=10
+Num peaks: 2
+175.119	100	"y1/0.1ppm"
+300.2	50	"b3/0.1ppm"
+
+Name: AAAK/2
+Comment: Parent=195.6 iRT=5
+Num peaks: 1
+147.113	100	"y1/0.1ppm"
+`
+
+const diffLibraryB = `Name: PEPTIDEK/2
+Comment: Parent=464.73 iRT=12
+Num peaks: 2
+175.119	100	"y1/0.1ppm"
+400.3	80	"y3/0.1ppm"
+
+Name: LLLK/2
+Comment: Parent=244.2 iRT=30
+Num peaks: 1
+147.113	100	"y1/0.1ppm"
+`
+
+// writeDiffLibraries writes the two diff fixtures and returns their paths
+func writeDiffLibraries(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.msp")
+	b := filepath.Join(dir, "b.msp")
+	if err := os.WriteFile(a, []byte(diffLibraryA), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte(diffLibraryB), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+// resetDiffFlags restores the diff flags to their defaults, as cobra keeps
+// them set between runs
+func resetDiffFlags(t *testing.T) {
+	t.Helper()
+	diffCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("reset --%s: %v", f.Name, err)
+		}
+		f.Changed = false
+	})
+}
+
+func TestDiffThresholds(t *testing.T) {
+	a, b := writeDiffLibraries(t)
+	t.Cleanup(func() { resetDiffFlags(t) })
+
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr string
+	}{
+		{name: "no thresholds"},
+		{name: "only in A within limit", flags: map[string]string{"max-only-a": "1"}},
+		{name: "only in A exceeded", flags: map[string]string{"max-only-a": "0"}, wantErr: "1 precursors only in A (max 0)"},
+		{name: "only in B exceeded", flags: map[string]string{"max-only-b": "0"}, wantErr: "1 precursors only in B (max 0)"},
+		{name: "RT delta within limit", flags: map[string]string{"max-rt-delta": "2.5"}},
+		{name: "RT delta exceeded", flags: map[string]string{"max-rt-delta": "1"}, wantErr: "RT delta 2.0000 (max 1.0000)"},
+		{name: "similarity within limit", flags: map[string]string{"min-similarity": "0.1"}},
+		{name: "similarity exceeded", flags: map[string]string{"min-similarity": "0.99"}, wantErr: "minimum similarity"},
+		{
+			name:    "every exceeded threshold is listed",
+			flags:   map[string]string{"max-only-a": "0", "max-rt-delta": "1"},
+			wantErr: "1 precursors only in A (max 0); RT delta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetDiffFlags(t)
+			for name, value := range tt.flags {
+				if err := diffCmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			diffCmd.SetContext(context.Background())
+
+			// An error makes dbkey exit with status 1
+			err := runDiff(diffCmd, []string{a, b})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("runDiff() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runDiff() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffTSV(t *testing.T) {
+	a, b := writeDiffLibraries(t)
+	t.Cleanup(func() { resetDiffFlags(t) })
+	resetDiffFlags(t)
+
+	path := filepath.Join(t.TempDir(), "diff.tsv")
+	if err := diffCmd.Flags().Set("tsv", path); err != nil {
+		t.Fatal(err)
+	}
+	diffCmd.SetContext(context.Background())
+	if err := runDiff(diffCmd, []string{a, b}); err != nil {
+		t.Fatalf("runDiff() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	want := [][2]string{{"Precursor", "Status"}, {"PEPTIDEK/2", "shared"}, {"AAAK/2", "only_a"}, {"LLLK/2", "only_b"}}
+	if len(lines) != len(want) {
+		t.Fatalf("TSV has %d lines, want %d:\n%s", len(lines), len(want), data)
+	}
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 14 {
+			t.Errorf("line %d has %d fields, want 14", i+1, len(fields))
+			continue
+		}
+		if fields[0] != want[i][0] || fields[1] != want[i][1] {
+			t.Errorf("line %d = %s %s, want %s %s", i+1, fields[0], fields[1], want[i][0], want[i][1])
+		}
+	}
+	shared := strings.Split(lines[1], "\t")
+	if shared[7] != "2.0000" {
+		t.Errorf("RT_Delta = %s, want 2.0000", shared[7])
+	}
+}
+
+func TestDiffTSVExists(t *testing.T) {
+	a, b := writeDiffLibraries(t)
+	t.Cleanup(func() { resetDiffFlags(t) })
+
+	tests := []struct {
+		name     string
+		force    bool
+		libB     string
+		wantErr  string
+		wantKeep bool // The earlier report is left unchanged
+	}{
+		{name: "existing", libB: b, wantErr: "use --force", wantKeep: true},
+		{name: "failed diff with force", force: true, libB: b + ".missing", wantErr: "failed to open", wantKeep: true},
+		{name: "force", force: true, libB: b},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "diff.tsv")
+			if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			resetDiffFlags(t)
+			if err := diffCmd.Flags().Set("tsv", path); err != nil {
+				t.Fatal(err)
+			}
+			if tt.force {
+				if err := diffCmd.Flags().Set("force", "true"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			diffCmd.SetContext(context.Background())
+
+			err := runDiff(diffCmd, []string{a, tt.libB})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("runDiff() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("runDiff() error = %v, want %q", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := string(data) == "existing"; kept != tt.wantKeep {
+				t.Errorf("report kept = %v, want %v", kept, tt.wantKeep)
+			}
+			if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+				t.Errorf("files left = %v, %v, want the report only", entries, err)
+			}
+		})
+	}
+}
+
+func TestSummarizeDiff(t *testing.T) {
+	rt := func(v float64) *float64 { return &v }
+	rows := []*diffRow{
+		{status: "shared", mzDelta: -0.002, rtDelta: rt(-1.5), similarity: 0.9},
+		{status: "shared", mzDelta: 0.001, ceDelta: rt(2), similarity: 0.5},
+		{status: "shared", similarity: 0.7},
+		{status: "only_a"},
+		{status: "only_b"},
+		{status: "only_b"},
+	}
+	s := summarizeDiff(rows)
+	if s.onlyA != 1 || s.onlyB != 2 || s.shared != 3 {
+		t.Errorf("counts = %d/%d/%d, want 1/2/3", s.onlyA, s.onlyB, s.shared)
+	}
+	if s.maxMZDelta != 0.002 || s.maxRTDelta != 1.5 || s.maxCEDelta != 2 {
+		t.Errorf("max deltas = %f/%f/%f, want 0.002/1.5/2", s.maxMZDelta, s.maxRTDelta, s.maxCEDelta)
+	}
+	if s.minSimilarity != 0.5 || s.medianSimilarity != 0.7 || s.meanSimilarity < 0.6999 || s.meanSimilarity > 0.7001 {
+		t.Errorf("similarity min/median/mean = %f/%f/%f, want 0.5/0.7/0.7", s.minSimilarity, s.medianSimilarity, s.meanSimilarity)
+	}
+}
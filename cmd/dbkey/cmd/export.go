@@ -0,0 +1,217 @@
+// Package cmd provides transition list export implementation
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/writer"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/parquet"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// Flags for export command
+	exportInput           string
+	exportFormat          string
+	exportOutput          string
+	exportLayout          string
+	exportTopN            int
+	exportIonTypes        string
+	exportMinIonNumber    int
+	exportMinMZ           float64
+	exportMaxMZ           float64
+	exportPrecursorWindow float64
+	exportForce           bool
+	exportRowGroupSize    int
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a spectral library as a transition list",
+	Long: `Export a spectral library as a transition list for targeted (PRM/SRM) or
+DIA method building, with one row per fragment, or in any other output
+format without conversion options.
+
+For each precursor the most intense annotated fragments are listed, after
+applying the ion type, ion number and m/z rules. Intensities are relative to
+the most intense listed fragment. Layouts:
+  skyline      Skyline transition list (CSV, mass-shift modifications)
+  diann        DIA-NN spectral library (TSV, UniMod modifications)
+  spectronaut  Spectronaut spectral library (TSV, named modifications)
+  openswath    OpenSWATH assay library (TSV, UniMod modifications)
+  pqp          OpenSWATH PQP assay library (SQLite, UniMod modifications)
+  parquet      Parquet precursor and peak tables with every spectrum field
+  jsonl        JSON Lines records of every spectrum field, read back with
+               --from jsonl
+  db           mzVault SQLite database
+  blib         BiblioSpec library for Skyline
+The fragment selection flags only apply to transition lists and PQP.
+
+Without --layout the layout is taken from a "layout:path" prefix of --out or
+its extension, falling back to skyline. --to is accepted as an alias of
+--layout.
+
+Examples:
+  # Six most intense b/y ions from y3/b3 upwards for Skyline
+  dbkey export --in library.msp --out transitions.csv
+
+  # DIA-NN library with fragments between 200 and 1800 m/z
+  dbkey export --in library.db --out library.tsv --layout diann --top-n 12 --min-mz 200 --max-mz 1800
+
+  # OpenSWATH PQP library
+  dbkey export --in library.tsv --out library.pqp --layout pqp
+
+  # library.precursors.parquet and library.peaks.parquet for DuckDB or pandas
+  dbkey export --in library.db --out library --layout parquet
+
+  # Edit spectra with jq and convert the result
+  dbkey export --in library.msp --out library.jsonl --to jsonl
+  jq -c 'select(.charge == 2)' library.jsonl > charge2.jsonl
+  dbkey convert --in charge2.jsonl --from jsonl --out charge2.db`,
+	RunE: runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportInput, "in", "i", "", "Input file path (required)")
+	exportCmd.Flags().StringVarP(&exportFormat, "from", "f", "", "Input format: msp, sptxt, tsv, db, dlib, elib, jsonl (auto-detect if not specified)")
+	exportCmd.Flags().StringVarP(&exportOutput, "out", "o", "", "Output file (required)")
+	exportCmd.Flags().StringVar(&exportLayout, "layout", "", "Layout: "+strings.Join(writer.Formats(), ", ")+" (default: from --out, else skyline)")
+	exportCmd.Flags().IntVar(&exportTopN, "top-n", transition.DefaultSelection.TopN, "Transitions per precursor, most intense first (0 = all)")
+	exportCmd.Flags().StringVar(&exportIonTypes, "ion-types", strings.Join(transition.DefaultSelection.IonTypes, ","), "Comma-separated ion types to list (empty = all)")
+	exportCmd.Flags().IntVar(&exportMinIonNumber, "min-ion-number", transition.DefaultSelection.MinIonNumber, "Smallest fragment ion number to list, e.g. 3 excludes b1, b2, y1 and y2")
+	exportCmd.Flags().Float64Var(&exportMinMZ, "min-mz", 0, "Minimum fragment m/z (0 = no limit)")
+	exportCmd.Flags().Float64Var(&exportMaxMZ, "max-mz", 0, "Maximum fragment m/z (0 = no limit)")
+	exportCmd.Flags().Float64Var(&exportPrecursorWindow, "precursor-window", 0, "Exclude fragments within this many m/z of the precursor (0 = keep)")
+	exportCmd.Flags().IntVar(&exportRowGroupSize, "row-group-size", parquet.DefaultRowGroupRows, "Rows per Parquet row group, bounding memory use")
+	exportCmd.Flags().BoolVar(&exportForce, "force", false, "Overwrite an existing output file")
+
+	exportCmd.Flags().SetNormalizeFunc(exportFlagAlias)
+
+	exportCmd.MarkFlagRequired("in")
+	exportCmd.MarkFlagRequired("out")
+}
+
+// exportFlagAlias accepts --to for --layout
+func exportFlagAlias(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "to" {
+		name = "layout"
+	}
+	return pflag.NormalizedName(name)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	if _, err := os.Stat(exportInput); os.IsNotExist(err) {
+		return fmt.Errorf("input file does not exist: %s", exportInput)
+	}
+
+	selection := transition.Selection{
+		TopN:            exportTopN,
+		MinIonNumber:    exportMinIonNumber,
+		MinMZ:           exportMinMZ,
+		MaxMZ:           exportMaxMZ,
+		PrecursorWindow: exportPrecursorWindow,
+	}
+	for _, t := range strings.Split(exportIonTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			selection.IonTypes = append(selection.IonTypes, t)
+		}
+	}
+
+	target := exportTarget()
+
+	ctx := cmd.Context()
+	modDB := loadModDatabase()
+
+	in, err := reader.OpenContext(ctx, exportInput, exportFormat, modDB)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := writer.Open(ctx, target.Format, target.Path, writer.Config{
+		Selection:    selection,
+		RowGroupRows: exportRowGroupSize,
+		Overwrite:    exportForce,
+		ModDB:        modDB,
+	})
+	if errors.Is(err, atomicfile.ErrExists) {
+		return fmt.Errorf("%w, use --force to overwrite", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer writer.Abort(out)
+
+	fmt.Printf("Exporting %s to %s...\n", exportInput, target.Path)
+	fmt.Printf("Layout: %s\n", target.Format)
+
+	for in.Next() {
+		spec := in.Spectrum()
+		if _, err := prepareSpectrum(in, spec, prepareSteps{}); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipped spectrum %s: %v\n", spec.Name(), err)
+			continue
+		}
+
+		if err := out.Write(spec); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("export interrupted: %w", ctx.Err())
+			}
+			return fmt.Errorf("failed to write spectrum %s: %w", spec.Name(), err)
+		}
+	}
+	if err := in.Err(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("export interrupted: %w", ctx.Err())
+		}
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nExport complete!\n")
+	if c, ok := out.(interface{ Written() int }); ok {
+		fmt.Printf("Precursors: %d\n", c.Written())
+	}
+	if tw, ok := unwrapWriter(out).(interface {
+		Transitions() int
+		Skipped() int
+	}); ok {
+		fmt.Printf("Transitions: %d\n", tw.Transitions())
+		if tw.Skipped() > 0 {
+			fmt.Printf("Skipped: %d spectra without matching annotated fragments\n", tw.Skipped())
+		}
+	}
+	outputs := []string{target.Path}
+	if pw, ok := unwrapWriter(out).(*parquet.Writer); ok {
+		precursors, peaks := pw.Paths()
+		outputs = []string{precursors, peaks}
+	}
+	fmt.Printf("Output: %s\n", strings.Join(outputs, ", "))
+
+	return nil
+}
+
+// exportTarget returns --out in the --layout format, or parsed as an output
+// target when no layout is given. Outputs whose format cannot be told from
+// --out are Skyline lists.
+func exportTarget() writer.Target {
+	if exportLayout != "" {
+		return writer.Target{Format: strings.ToLower(strings.TrimSpace(exportLayout)), Path: exportOutput}
+	}
+	target, err := writer.ParseTarget(exportOutput)
+	if err != nil {
+		return writer.Target{Format: string(transition.LayoutSkyline), Path: exportOutput}
+	}
+	return target
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportOutputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      string
+		layout   string
+		existing bool
+		force    bool
+		want     []string // Files written
+		wantErr  string
+	}{
+		{name: "skyline from extension", out: "list.csv", want: []string{"list.csv"}},
+		{name: "skyline fallback", out: "list.txt", want: []string{"list.txt"}},
+		{name: "layout prefix", out: "diann:list.tsv", want: []string{"list.tsv"}},
+		{name: "db", out: "library.db", want: []string{"library.db"}},
+		{name: "blib", out: "library.blib", want: []string{"library.blib"}},
+		{name: "layout flag", out: "library", layout: "parquet", want: []string{"library.precursors.parquet", "library.peaks.parquet"}},
+		{name: "unknown layout", out: "list.csv", layout: "mascot", wantErr: "unsupported output format"},
+		{name: "existing", out: "list.csv", existing: true, wantErr: "use --force"},
+		{name: "existing with force", out: "list.csv", existing: true, force: true, want: []string{"list.csv"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "library.msp")
+			if err := os.WriteFile(input, []byte(convertTestMSP), 0644); err != nil {
+				t.Fatal(err)
+			}
+			out := tt.out
+			if i := strings.IndexByte(out, ':'); i >= 0 {
+				out = out[:i+1] + filepath.Join(dir, out[i+1:])
+			} else {
+				out = filepath.Join(dir, out)
+			}
+			if tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, tt.out), []byte("existing"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			oldInput, oldFormat, oldOutput, oldLayout, oldForce := exportInput, exportFormat, exportOutput, exportLayout, exportForce
+			t.Cleanup(func() {
+				exportInput, exportFormat, exportOutput, exportLayout, exportForce = oldInput, oldFormat, oldOutput, oldLayout, oldForce
+			})
+			exportInput, exportFormat, exportOutput, exportLayout, exportForce = input, "", out, tt.layout, tt.force
+
+			exportCmd.SetContext(context.Background())
+			err := runExport(exportCmd, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runExport() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runExport() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want)+1 {
+				var files []string
+				for _, e := range entries {
+					files = append(files, e.Name())
+				}
+				t.Errorf("files = %v, want library.msp and %v", files, tt.want)
+			}
+			for _, name := range tt.want {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("output %s: %v", name, err)
+				} else if info.Size() <= int64(len("existing")) {
+					t.Errorf("output %s has %d bytes", name, info.Size())
+				}
+			}
+		})
+	}
+}
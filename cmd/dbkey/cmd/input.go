@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+)
+
+// loadModDatabase returns the default modification database extended with
+// unimod_custom.csv from the working directory, if present
+func loadModDatabase() *core.ModDatabase {
+	modDB := core.DefaultModDatabase()
+
+	if _, err := os.Stat("unimod_custom.csv"); err == nil {
+		f, err := os.Open("unimod_custom.csv")
+		if err == nil {
+			if err := modDB.LoadFromCSV(f); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to load unimod_custom.csv: %v\n", err)
+			}
+			f.Close()
+		}
+	}
+
+	return modDB
+}
+
+// prepareSteps are the command-specific steps of prepareSpectrum. Either may
+// be nil.
+type prepareSteps struct {
+	// override applies command flags before the precursor is recalculated
+	override func(spec *core.Spectrum)
+	// filter runs after the format defaults, returning the rejection reason
+	// and error of a spectrum that should not be kept
+	filter func(spec *core.Spectrum) (string, error)
+}
+
+// prepareSpectrum readies a spectrum read from in for writing: spectra with
+// unknown modifications are rejected, Prosit MSP precursors and missing
+// ones are recalculated, format defaults are filled in and the spectrum is
+// validated. It returns the rejection reason and error of a spectrum that
+// cannot be written.
+func prepareSpectrum(in *reader.File, spec *core.Spectrum, steps prepareSteps) (string, error) {
+	if unknown := in.UnknownMods(); len(unknown) > 0 {
+		return rejectUnknownMod, fmt.Errorf("unknown modification(s): %s", strings.Join(unknown, ", "))
+	}
+
+	if steps.override != nil {
+		steps.override(spec)
+	}
+
+	// Small molecules keep the precursor m/z from the file
+	if (in.Format == "msp" && !spec.IsSmallMolecule()) || spec.PrecursorMZ == 0 {
+		if err := recalculatePrecursor(spec); err != nil {
+			return rejectFormula, err
+		}
+	}
+	if err := applyFormatDefaults(spec); err != nil {
+		return rejectFormula, err
+	}
+
+	if steps.filter != nil {
+		if reason, err := steps.filter(spec); err != nil {
+			return reason, err
+		}
+	}
+
+	if err := spec.Validate(); err != nil {
+		return rejectionReason(err), err
+	}
+	return "", nil
+}
+
+// applyFormatDefaults fills in fragmentation mode, mass analyzer and precursor
+// m/z for spectra whose source file does not provide them. It returns the
+// error of a precursor m/z that cannot be calculated.
+func applyFormatDefaults(spec *core.Spectrum) error {
+	if spec.FragmentationMode == "" {
+		switch spec.SourceFormat {
+		case "sptxt":
+			spec.FragmentationMode = "CID"
+		default:
+			spec.FragmentationMode = "HCD"
+		}
+	}
+
+	if spec.MassAnalyzer == "" {
+		switch spec.SourceFormat {
+		case "sptxt":
+			spec.MassAnalyzer = "IT"
+		default:
+			spec.MassAnalyzer = "FT"
+		}
+	}
+
+	if spec.PrecursorMZ == 0 {
+		return recalculatePrecursor(spec)
+	}
+	return nil
+}
+
+// recalculatePrecursor computes precursor m/z from sequence, modifications
+// and mass offset, or from formula and precursor type for small molecules.
+// A small molecule without a formula or precursor type keeps its precursor;
+// one whose formula or precursor type cannot be parsed returns an error.
+func recalculatePrecursor(spec *core.Spectrum) error {
+	if spec.IsSmallMolecule() {
+		if strings.TrimSpace(spec.Formula) == "" || spec.PrecursorType == "" {
+			return nil
+		}
+		formula, err := core.ParseFormula(spec.Formula)
+		if err != nil {
+			return err
+		}
+		adduct, err := core.ParseAdduct(spec.PrecursorType)
+		if err != nil {
+			return err
+		}
+		spec.PrecursorMZ = adduct.MZ(formula.MonoisotopicMass() + spec.MassOffset)
+		return nil
+	}
+
+	if len(spec.Sequence) == 0 || spec.Charge == 0 {
+		return nil
+	}
+
+	calculatedMZ := core.CalculatePeptideMass(spec.Sequence, spec.Charge, spec.Modifications)
+	// Add mass offset to precursor if configured
+	if spec.MassOffset != 0 {
+		calculatedMZ += spec.MassOffset / math.Abs(float64(spec.Charge))
+	}
+	spec.PrecursorMZ = calculatedMZ
+	return nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+)
+
+func TestRecalculatePrecursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    core.Spectrum
+		wantMZ  float64
+		wantErr bool
+	}{
+		{
+			name:   "peptide",
+			spec:   core.Spectrum{Sequence: "PEPTIDEK", Charge: 2, PrecursorMZ: 1},
+			wantMZ: 464.7348,
+		},
+		{
+			name:   "small molecule",
+			spec:   core.Spectrum{CompoundName: "Glucose", Formula: "C6H12O6", PrecursorType: "[M+Na]+", PrecursorMZ: 1},
+			wantMZ: 203.0526,
+		},
+		{
+			name:   "small molecule with a heavy element",
+			spec:   core.Spectrum{CompoundName: "Cisplatin", Formula: "Pt(NH3)2Cl2", PrecursorType: "[M+H]+", PrecursorMZ: 1},
+			wantMZ: 299.9629,
+		},
+		{
+			name:   "small molecule without precursor type keeps its m/z",
+			spec:   core.Spectrum{CompoundName: "Glucose", Formula: "C6H12O6", PrecursorMZ: 181.07},
+			wantMZ: 181.07,
+		},
+		{
+			name:    "unknown element",
+			spec:    core.Spectrum{CompoundName: "Unknown", Formula: "C6Xx2", PrecursorType: "[M+H]+", PrecursorMZ: 1},
+			wantMZ:  1,
+			wantErr: true,
+		},
+		{
+			name:    "invalid precursor type",
+			spec:    core.Spectrum{CompoundName: "Glucose", Formula: "C6H12O6", PrecursorType: "M+H", PrecursorMZ: 1},
+			wantMZ:  1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			err := recalculatePrecursor(&spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("recalculatePrecursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(spec.PrecursorMZ-tt.wantMZ) > 1e-3 {
+				t.Errorf("PrecursorMZ = %.4f, want %.4f", spec.PrecursorMZ, tt.wantMZ)
+			}
+		})
+	}
+}
+
+func TestPrepareSpectrum(t *testing.T) {
+	const entry = "Name: PEPTIDEK/2\nComment: Parent=500.5\nNum peaks: 1\n175.119\t100\t\"y1/0.1ppm\"\n"
+	const unknownMod = "Name: PEPTIDEK/2\nComment: Parent=500.5 ModString=PEPTIDEK//NoSuchMod@T4/2\nNum peaks: 1\n175.119\t100\t\"y1/0.1ppm\"\n"
+
+	tests := []struct {
+		name       string
+		msp        string
+		steps      prepareSteps
+		wantReason string
+		wantMZ     float64
+	}{
+		{name: "prosit precursor recalculated", msp: entry, wantMZ: 464.7348},
+		{name: "unknown modification", msp: unknownMod, wantReason: rejectUnknownMod},
+		{
+			name:   "override before recalculation",
+			msp:    entry,
+			steps:  prepareSteps{override: func(spec *core.Spectrum) { spec.MassOffset = 2 }},
+			wantMZ: 465.7348,
+		},
+		{
+			name:       "validation",
+			msp:        entry,
+			steps:      prepareSteps{override: func(spec *core.Spectrum) { spec.Polarity = core.PolarityNegative }},
+			wantReason: "validation_polarity",
+		},
+		{
+			name: "filter",
+			msp:  entry,
+			steps: prepareSteps{filter: func(spec *core.Spectrum) (string, error) {
+				return rejectFilterEmptied, fmt.Errorf("no peaks left after filtering")
+			}},
+			wantReason: rejectFilterEmptied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "library.msp")
+			if err := os.WriteFile(path, []byte(tt.msp), 0644); err != nil {
+				t.Fatal(err)
+			}
+			in, err := reader.Open(path, "", core.DefaultModDatabase())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			if !in.Next() {
+				t.Fatalf("Next() = false, error %v", in.Err())
+			}
+
+			spec := in.Spectrum()
+			reason, err := prepareSpectrum(in, spec, tt.steps)
+			if reason != tt.wantReason || (err != nil) != (tt.wantReason != "") {
+				t.Fatalf("prepareSpectrum() = %q, %v, want %q", reason, err, tt.wantReason)
+			}
+			if tt.wantReason != "" {
+				return
+			}
+			if math.Abs(spec.PrecursorMZ-tt.wantMZ) > 1e-3 {
+				t.Errorf("PrecursorMZ = %.4f, want %.4f", spec.PrecursorMZ, tt.wantMZ)
+			}
+			if spec.FragmentationMode != "HCD" || spec.MassAnalyzer != "FT" {
+				t.Errorf("defaults = %s, %s, want HCD, FT", spec.FragmentationMode, spec.MassAnalyzer)
+			}
+		})
+	}
+}
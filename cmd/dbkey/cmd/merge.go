@@ -0,0 +1,311 @@
+// Package cmd provides library merge implementation
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/spf13/cobra"
+)
+
+// Merge conflict resolution policies
+const (
+	mergePolicyPriority  = "priority"
+	mergePolicyIntensity = "intensity"
+	mergePolicyPeaks     = "peaks"
+	mergePolicyRecent    = "recent"
+)
+
+var (
+	// Flags for merge command
+	mergeInputs        []string
+	mergeOutput        string
+	mergePolicy        string
+	mergeFragmentation string
+	mergeMassAnalyzer  string
+	mergeDialect       string
+	mergeMaxErrors     int
+	mergeForce         bool
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merge multiple spectral libraries into one SQLite database",
+	Long: `Merge spectral libraries in any supported input format into a single SQLite
+database. Spectra are deduplicated by modified sequence and charge; when the
+same precursor appears in several inputs the conflict is resolved by --policy:
+
+  priority   keep the spectrum from the input listed first (default)
+  intensity  keep the spectrum with the highest summed intensity of annotated
+             peaks (compare inputs with the same intensity scale)
+  peaks      keep the spectrum with the most peaks
+  recent     keep the spectrum from the most recently modified input file
+
+The source file of each spectrum is recorded in the RawFileURL column.
+Spectra with modifications missing from the modification database, or that
+fail validation, are skipped with a warning.
+
+Only the selected input and a score are kept per precursor; the inputs are
+read a second time to write the selected spectra, input by input in the
+order given, so memory use grows with the number of unique precursors
+rather than with the number of peaks.
+
+Examples:
+  # Merge Prosit predictions with an empirical SpectraST library
+  dbkey merge --in prosit_a.msp --in prosit_b.msp --in empirical.sptxt --out merged.db
+
+  # Prefer the spectrum with the most peaks
+  dbkey merge --in a.msp --in b.sptxt --out merged.db --policy peaks`,
+	RunE: runMerge,
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringArrayVarP(&mergeInputs, "in", "i", nil, "Input file path, in priority order (repeatable, required)")
+	mergeCmd.Flags().StringVarP(&mergeOutput, "out", "o", "", "Output database file (required)")
+	mergeCmd.Flags().StringVar(&mergePolicy, "policy", mergePolicyPriority, "Conflict policy: priority, intensity, peaks, or recent")
+	mergeCmd.Flags().StringVar(&mergeFragmentation, "fragmentation", "read", "Fragmentation mode: HCD, CID, or 'read' to read from file")
+	mergeCmd.Flags().StringVar(&mergeMassAnalyzer, "mass-analyzer", "read", "Mass analyzer: FT, IT, or 'read' to read from file")
+	mergeCmd.Flags().StringVar(&mergeDialect, "msp-dialect", string(msp.DialectPeptide), "MSP dialect: peptide (Prosit/NIST) or small-molecule (MS-DIAL/MoNA/NIST)")
+	mergeCmd.Flags().IntVar(&mergeMaxErrors, "max-errors", 0, "Malformed entries to skip per input before aborting (0 = fail on first error, -1 = no limit)")
+
+	mergeCmd.Flags().BoolVar(&mergeForce, "force", false, "Overwrite an existing output database")
+	addLibraryFlags(mergeCmd)
+
+	mergeCmd.MarkFlagRequired("in")
+	mergeCmd.MarkFlagRequired("out")
+}
+
+// mergeEntry is the spectrum currently selected for one precursor. Only
+// what the policies compare is kept; the selected spectra are read again
+// when the merged library is written.
+type mergeEntry struct {
+	input     int     // Index of the input the spectrum came from
+	index     int     // Position among the spectra accepted from the input
+	peaks     int     // Number of peaks
+	intensity float64 // Summed intensity of annotated peaks
+}
+
+// newMergeEntry returns the entry of the index-th spectrum accepted from an
+// input
+func newMergeEntry(spec *core.Spectrum, input, index int) *mergeEntry {
+	return &mergeEntry{
+		input:     input,
+		index:     index,
+		peaks:     len(spec.Peaks),
+		intensity: annotatedIntensity(spec),
+	}
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	policy := strings.ToLower(mergePolicy)
+	switch policy {
+	case mergePolicyPriority, mergePolicyIntensity, mergePolicyPeaks, mergePolicyRecent:
+	default:
+		return fmt.Errorf("invalid merge policy '%s', must be priority, intensity, peaks, or recent", mergePolicy)
+	}
+	dialect, err := msp.ParseDialect(mergeDialect)
+	if err != nil {
+		return err
+	}
+
+	// Input modification times are only needed for the recent policy
+	modTimes := make([]time.Time, len(mergeInputs))
+	for i, path := range mergeInputs {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("input file does not exist: %s", path)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	opts, err := libraryOptions(cmd)
+	if err != nil {
+		return err
+	}
+	opts.Overwrite = mergeForce
+	if _, err := os.Stat(mergeOutput); err == nil && !mergeForce {
+		return fmt.Errorf("output file %s already exists, use --force to overwrite", mergeOutput)
+	}
+
+	ctx := cmd.Context()
+	modDB := loadModDatabase()
+
+	// Select one spectrum per precursor
+	entries := make(map[string]*mergeEntry)
+	read := make([]int, len(mergeInputs))
+	kept := make([]int, len(mergeInputs))
+	skipped := 0
+
+	for i, path := range mergeInputs {
+		fmt.Printf("Reading %s...\n", path)
+
+		n, bad, err := readMergeInput(ctx, path, modDB, dialect, true, func(spec *core.Spectrum, index int) error {
+			key := spec.Key()
+			candidate := newMergeEntry(spec, i, index)
+			existing, ok := entries[key]
+			if !ok {
+				entries[key] = candidate
+				kept[i]++
+				return nil
+			}
+			if preferNew(policy, candidate, existing, modTimes) {
+				kept[existing.input]--
+				kept[i]++
+				entries[key] = candidate
+			}
+			return nil
+		})
+		read[i] = n
+		skipped += bad
+		if err != nil {
+			return err
+		}
+	}
+
+	// Read the inputs again, writing the selected spectra
+	selected := make([]map[int]bool, len(mergeInputs))
+	for i := range selected {
+		selected[i] = make(map[int]bool, kept[i])
+	}
+	for _, entry := range entries {
+		selected[entry.input][entry.index] = true
+	}
+
+	writer, err := sqlite.NewWriterWithOptions(mergeOutput, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create output database: %w", err)
+	}
+	defer writer.Abort()
+
+	for i, path := range mergeInputs {
+		if kept[i] == 0 {
+			continue
+		}
+		_, _, err := readMergeInput(ctx, path, modDB, dialect, false, func(spec *core.Spectrum, index int) error {
+			if !selected[i][index] {
+				return nil
+			}
+			if err := writer.WriteSpectrumContext(ctx, spec); err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("merge interrupted: %w", ctx.Err())
+				}
+				return fmt.Errorf("failed to write spectrum %s: %w", spec.Name(), err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Finalize(); err != nil {
+		return fmt.Errorf("failed to finalize database: %w", err)
+	}
+
+	fmt.Printf("\nMerge complete!\n")
+	for i, path := range mergeInputs {
+		fmt.Printf("%s: read %d, kept %d\n", path, read[i], kept[i])
+	}
+	fmt.Printf("Merged: %d unique precursors\n", len(entries))
+	if skipped > 0 {
+		fmt.Printf("Skipped: %d spectra (unknown modifications or validation errors)\n", skipped)
+	}
+	fmt.Printf("Output: %s\n", mergeOutput)
+
+	return nil
+}
+
+// readMergeInput reads one merge input, passing each accepted spectrum and
+// its position among the accepted spectra to fn. Spectra with unknown
+// modifications or failing validation are skipped, with a warning if warn
+// is set. It returns the number of spectra read and skipped.
+func readMergeInput(ctx context.Context, path string, modDB *core.ModDatabase, dialect msp.Dialect, warn bool, fn func(spec *core.Spectrum, index int) error) (read, skipped int, err error) {
+	in, err := reader.OpenContext(ctx, path, "", modDB)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer in.Close()
+	in.SetMaxErrors(mergeMaxErrors)
+	in.SetMSPDialect(dialect)
+
+	skip := func(spec *core.Spectrum, err error) {
+		if warn {
+			fmt.Fprintf(os.Stderr, "Warning: skipped spectrum %s in %s: %v\n", spec.Name(), path, err)
+		}
+		skipped++
+	}
+
+	steps := prepareSteps{override: func(spec *core.Spectrum) {
+		if mergeFragmentation != "" && mergeFragmentation != "read" {
+			spec.FragmentationMode = mergeFragmentation
+		}
+		if mergeMassAnalyzer != "" && mergeMassAnalyzer != "read" {
+			spec.MassAnalyzer = mergeMassAnalyzer
+		}
+	}}
+
+	index := 0
+	for in.Next() {
+		spec := in.Spectrum()
+		read++
+
+		if _, err := prepareSpectrum(in, spec, steps); err != nil {
+			skip(spec, err)
+			continue
+		}
+
+		if err := fn(spec, index); err != nil {
+			return read, skipped, err
+		}
+		index++
+	}
+
+	if warn {
+		for _, perr := range in.ParseErrors() {
+			fmt.Fprintf(os.Stderr, "Warning: skipped malformed entry in %s at %v\n", path, perr)
+		}
+	}
+	if ctx.Err() != nil {
+		return read, skipped, fmt.Errorf("merge interrupted: %w", ctx.Err())
+	}
+	if err := in.Err(); err != nil {
+		return read, skipped, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return read, skipped, nil
+}
+
+// preferNew reports whether a newly read spectrum should replace the
+// currently selected one under the given policy. Ties keep the earlier input.
+func preferNew(policy string, candidate, existing *mergeEntry, modTimes []time.Time) bool {
+	switch policy {
+	case mergePolicyIntensity:
+		return candidate.intensity > existing.intensity
+	case mergePolicyPeaks:
+		return candidate.peaks > existing.peaks
+	case mergePolicyRecent:
+		return modTimes[candidate.input].After(modTimes[existing.input])
+	default:
+		return false
+	}
+}
+
+// annotatedIntensity returns the summed intensity of the annotated peaks.
+// Unannotated peaks ("" or "?") do not count.
+func annotatedIntensity(spec *core.Spectrum) float64 {
+	annotated := 0.0
+	for _, peak := range spec.Peaks {
+		if peak.Annotation != "" && peak.Annotation != "?" {
+			annotated += peak.Intensity
+		}
+	}
+	return annotated
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+func TestAnnotatedIntensity(t *testing.T) {
+	spec := &core.Spectrum{Peaks: []core.Peak{
+		{MZ: 100, Intensity: 300, Annotation: "y1"},
+		{MZ: 200, Intensity: 500, Annotation: "?"},
+		{MZ: 300, Intensity: 700},
+		{MZ: 400, Intensity: 50, Annotation: "b2"},
+	}}
+	if got := annotatedIntensity(spec); got != 350 {
+		t.Errorf("annotatedIntensity() = %f, want 350", got)
+	}
+}
+
+func TestPreferNew(t *testing.T) {
+	// A spectrum whose only peak is annotated, and one with more annotated
+	// intensity but a lower annotated fraction
+	annotatedOnly := &core.Spectrum{Peaks: []core.Peak{{MZ: 100, Intensity: 200, Annotation: "y1"}}}
+	moreIntense := &core.Spectrum{Peaks: []core.Peak{
+		{MZ: 100, Intensity: 300, Annotation: "y1"},
+		{MZ: 200, Intensity: 700, Annotation: "?"},
+	}}
+	now := time.Now()
+	modTimes := []time.Time{now, now.Add(time.Hour), now.Add(-time.Hour)}
+
+	tests := []struct {
+		name     string
+		policy   string
+		spec     *core.Spectrum
+		input    int
+		existing *core.Spectrum
+		want     bool
+	}{
+		{"priority keeps the first input", mergePolicyPriority, moreIntense, 1, annotatedOnly, false},
+		{"intensity prefers more annotated intensity", mergePolicyIntensity, moreIntense, 1, annotatedOnly, true},
+		{"intensity keeps less annotated intensity out", mergePolicyIntensity, annotatedOnly, 1, moreIntense, false},
+		{"intensity tie keeps the first input", mergePolicyIntensity, annotatedOnly, 1, annotatedOnly, false},
+		{"peaks prefers more peaks", mergePolicyPeaks, moreIntense, 1, annotatedOnly, true},
+		{"peaks tie keeps the first input", mergePolicyPeaks, annotatedOnly, 1, annotatedOnly, false},
+		{"recent prefers a newer input", mergePolicyRecent, annotatedOnly, 1, annotatedOnly, true},
+		{"recent keeps an older input out", mergePolicyRecent, annotatedOnly, 2, annotatedOnly, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := newMergeEntry(tt.spec, tt.input, 0)
+			existing := newMergeEntry(tt.existing, 0, 0)
+			if got := preferNew(tt.policy, candidate, existing, modTimes); got != tt.want {
+				t.Errorf("preferNew() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mergeEntryMSP returns a Prosit MSP entry with one annotated y1 peak
+func mergeEntryMSP(name string, intensity string) string {
+	return "Name: " + name + "\nComment: Parent=500.5\nNum peaks: 1\n175.119\t" + intensity + "\t\"y1/0.1ppm\"\n\n"
+}
+
+// setMergeFlags sets the merge flags for a test, restoring them afterwards
+func setMergeFlags(t *testing.T, inputs []string, output, policy string, maxErrors int) {
+	t.Helper()
+	oldInputs, oldOutput, oldPolicy := mergeInputs, mergeOutput, mergePolicy
+	oldFragmentation, oldAnalyzer, oldForce := mergeFragmentation, mergeMassAnalyzer, mergeForce
+	oldDialect, oldMaxErrors := mergeDialect, mergeMaxErrors
+	t.Cleanup(func() {
+		mergeInputs, mergeOutput, mergePolicy = oldInputs, oldOutput, oldPolicy
+		mergeFragmentation, mergeMassAnalyzer, mergeForce = oldFragmentation, oldAnalyzer, oldForce
+		mergeDialect, mergeMaxErrors = oldDialect, oldMaxErrors
+	})
+	mergeInputs, mergeOutput, mergePolicy = inputs, output, policy
+	mergeFragmentation, mergeMassAnalyzer, mergeForce = "read", "read", false
+	mergeDialect, mergeMaxErrors = "peptide", maxErrors
+	mergeCmd.SetContext(context.Background())
+}
+
+// mergedSources returns the RawFileURL of each spectrum in a merged library
+func mergedSources(t *testing.T, path string) map[string]string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`
+		SELECT c.Name, s.RawFileURL FROM SpectrumTable s
+		JOIN CompoundTable c ON c.CompoundId = s.CompoundId
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	got := make(map[string]string)
+	for rows.Next() {
+		var name, source string
+		if err := rows.Scan(&name, &source); err != nil {
+			t.Fatal(err)
+		}
+		got[name] = source
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestMergeSourceFile(t *testing.T) {
+	dir := t.TempDir()
+	inputA := filepath.Join(dir, "a.msp")
+	inputB := filepath.Join(dir, "b.msp")
+	output := filepath.Join(dir, "merged.db")
+	if err := os.WriteFile(inputA, []byte(mergeEntryMSP("PEPTIDEK/2", "100")+mergeEntryMSP("AAAK/2", "100")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inputB, []byte(mergeEntryMSP("PEPTIDEK/2", "500")+mergeEntryMSP("LLLK/2", "100")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setMergeFlags(t, []string{inputA, inputB}, output, mergePolicyIntensity, 0)
+	if err := runMerge(mergeCmd, nil); err != nil {
+		t.Fatalf("runMerge() error = %v", err)
+	}
+
+	// The more intense PEPTIDEK spectrum comes from the second input
+	want := map[string]string{"PEPTIDEK/2": inputB, "AAAK/2": inputA, "LLLK/2": inputB}
+	got := mergedSources(t, output)
+	if len(got) != len(want) {
+		t.Errorf("merged spectra = %v, want %v", got, want)
+	}
+	for name, source := range want {
+		if got[name] != source {
+			t.Errorf("%s RawFileURL = %q, want %q", name, got[name], source)
+		}
+	}
+}
+
+func TestMergeSkipped(t *testing.T) {
+	dir := t.TempDir()
+	inputA := filepath.Join(dir, "a.msp")
+	inputB := filepath.Join(dir, "b.msp")
+	output := filepath.Join(dir, "merged.db")
+
+	// The more intense PEPTIDEK/2 of the first input has an unknown
+	// modification, and the second input has a malformed entry
+	unknownMod := "Name: PEPTIDEK/2\nComment: Parent=500.5 ModString=PEPTIDEK//NoSuchMod@T4/2\nNum peaks: 1\n175.119\t900\t\"y1/0.1ppm\"\n\n"
+	malformed := "Name: BADK/2\nComment: Parent=300.5\nNum peaks: abc\n100\t10\n\n"
+	if err := os.WriteFile(inputA, []byte(unknownMod+mergeEntryMSP("AAAK/2", "100")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inputB, []byte(mergeEntryMSP("PEPTIDEK/2", "100")+malformed+mergeEntryMSP("LLLK/2", "100")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setMergeFlags(t, []string{inputA, inputB}, output, mergePolicyIntensity, 0)
+	if err := runMerge(mergeCmd, nil); err == nil {
+		t.Fatal("runMerge() with a malformed entry and no --max-errors succeeded")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output written after a failed merge: %v", err)
+	}
+
+	mergeMaxErrors = 1
+	if err := runMerge(mergeCmd, nil); err != nil {
+		t.Fatalf("runMerge() with --max-errors 1 error = %v", err)
+	}
+	want := map[string]string{"PEPTIDEK/2": inputB, "AAAK/2": inputA, "LLLK/2": inputB}
+	got := mergedSources(t, output)
+	if len(got) != len(want) {
+		t.Errorf("merged spectra = %v, want %v", got, want)
+	}
+	for name, source := range want {
+		if got[name] != source {
+			t.Errorf("%s RawFileURL = %q, want %q", name, got[name], source)
+		}
+	}
+}
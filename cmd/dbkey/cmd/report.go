@@ -0,0 +1,390 @@
+// Package cmd provides the conversion report and rejected-spectra file
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ChrisMcGann/DBKey/pkg/calibration"
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/shard"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+// reportVersion is the version of the JSON conversion report layout
+const reportVersion = 1
+
+// Rejection reasons recorded in the conversion report. Validation failures
+// are recorded as "validation_<field>" using the first failing field.
+const (
+	rejectParseError    = "parse_error"
+	rejectUnknownMod    = "unknown_modification"
+	rejectFilterEmptied = "filter_emptied_peaks"
+	rejectFilterError   = "filter_error"
+	rejectFormula       = "formula_error"
+	rejectValidation    = "validation"
+)
+
+// convertSettings are the effective settings of a conversion run
+type convertSettings struct {
+	Input            string         `json:"input,omitempty"`
+	Identifications  []string       `json:"identifications,omitempty"`
+	Spectra          []string       `json:"spectra,omitempty"`
+	Format           string         `json:"format"`
+	MSPDialect       string         `json:"msp_dialect,omitempty"`
+	Output           string         `json:"output"`
+	OutputMode       string         `json:"output_mode"`
+	Outputs          []string       `json:"outputs,omitempty"`
+	Shard            *shard.Options `json:"shard,omitempty"`
+	Fragmentation    string         `json:"fragmentation"`
+	CollisionEnergy  float64        `json:"collision_energy"`
+	MassAnalyzer     string         `json:"mass_analyzer"`
+	Polarity         string         `json:"polarity"`
+	TopN             int            `json:"top_n"`
+	IntensityCutoff  float64        `json:"intensity_cutoff"`
+	IonTypes         []string       `json:"ion_types,omitempty"`
+	MassOffsetCSV    string         `json:"mass_offset_csv,omitempty"`
+	CompoundClassCSV string         `json:"compound_class_csv,omitempty"`
+	OldModMass       float64        `json:"adjust_fragments_old,omitempty"`
+	NewModMass       float64        `json:"adjust_fragments_new,omitempty"`
+	RTAnchorsCSV     string         `json:"rt_anchors,omitempty"`
+	RTModelType      string         `json:"rt_model_type,omitempty"`
+	RTModelFile      string         `json:"rt_model,omitempty"`
+	Score            string         `json:"score,omitempty"`
+	ScoreThreshold   *float64       `json:"score_threshold,omitempty"`
+	MaxQValue        *float64       `json:"max_qvalue,omitempty"`
+	MaxErrors        int            `json:"max_errors"`
+	Library          sqlite.Options `json:"library"`
+}
+
+// currentConvertSettings collects the effective settings from the convert flags
+func currentConvertSettings() convertSettings {
+	settings := convertSettings{
+		Input:            inputFile,
+		Format:           inputFormat,
+		MSPDialect:       mspDialect,
+		Output:           outputFile,
+		Fragmentation:    fragmentation,
+		CollisionEnergy:  collisionEnergy,
+		MassAnalyzer:     massAnalyzer,
+		Polarity:         polarity,
+		TopN:             topN,
+		IntensityCutoff:  cutoffPercent,
+		MassOffsetCSV:    massOffsetCSV,
+		CompoundClassCSV: compoundClassCSV,
+		OldModMass:       oldModMass,
+		NewModMass:       newModMass,
+		RTAnchorsCSV:     rtAnchorsCSV,
+		RTModelFile:      rtModelFile,
+		MaxErrors:        maxErrors,
+	}
+	if len(outputs) > 1 {
+		for _, target := range outputs {
+			settings.Outputs = append(settings.Outputs, target.String())
+		}
+	}
+	if sharding.Enabled() {
+		opts := sharding
+		settings.Shard = &opts
+	}
+	if ionTypes != "" {
+		for _, t := range strings.Split(ionTypes, ",") {
+			settings.IonTypes = append(settings.IonTypes, strings.TrimSpace(t))
+		}
+	}
+	if rtAnchorsCSV != "" {
+		settings.RTModelType = rtModelType
+	}
+	return settings
+}
+
+// reportTimings records where conversion time was spent
+type reportTimings struct {
+	TotalSeconds    float64 `json:"total_seconds"`
+	ReadSeconds     float64 `json:"read_seconds"`
+	ProcessSeconds  float64 `json:"process_seconds"`
+	WriteSeconds    float64 `json:"write_seconds"`
+	FinalizeSeconds float64 `json:"finalize_seconds"`
+}
+
+// reportCounts summarizes spectrum counts
+type reportCounts struct {
+	Read     int `json:"read"`
+	Written  int `json:"written"`
+	Replaced int `json:"replaced,omitempty"`
+	Rejected int `json:"rejected"`
+	// Missing counts identified scans not found by a build
+	Missing int `json:"missing,omitempty"`
+}
+
+// reportParseError is a malformed input entry
+type reportParseError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// reportSkipped is a spectrum skipped by a build
+type reportSkipped struct {
+	Spectrum string `json:"spectrum"`
+	ScanID   string `json:"scan_id"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+// reportRTCalibration describes the retention time model and its fit
+type reportRTCalibration struct {
+	Type string `json:"type"`
+	// Source is the anchor CSV the model was fitted to, or the model file
+	Source string             `json:"source"`
+	Stats  *calibration.Stats `json:"stats,omitempty"`
+	// SkippedAnchors are anchors without an iRT that were not in the input
+	SkippedAnchors []string `json:"skipped_anchors,omitempty"`
+}
+
+// conversionReport is the machine-readable summary of a conversion run
+type conversionReport struct {
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Rejects string `json:"rejects,omitempty"`
+	// ResumedFrom is the input offset a resumed conversion continued from
+	ResumedFrom *int64          `json:"resumed_from,omitempty"`
+	StartTime   time.Time       `json:"start_time"`
+	EndTime     time.Time       `json:"end_time"`
+	Timings     reportTimings   `json:"timings"`
+	Settings    convertSettings `json:"settings"`
+	Counts      reportCounts    `json:"counts"`
+	Rejections  map[string]int  `json:"rejections"`
+	// ParseErrors lists the malformed entries skipped or failed on
+	ParseErrors []reportParseError `json:"parse_errors,omitempty"`
+	// RTCalibration is the retention time model applied, if any
+	RTCalibration *reportRTCalibration `json:"rt_calibration,omitempty"`
+	// Skipped lists the spectra a build rejected
+	Skipped []reportSkipped `json:"skipped,omitempty"`
+
+	mark         *checkpointMark // Set when checkpointing
+	readTime     time.Duration
+	writeTime    time.Duration
+	finalizeTime time.Duration
+}
+
+// newConversionReport starts a report for the current convert settings
+func newConversionReport() *conversionReport {
+	return &conversionReport{
+		Version:    reportVersion,
+		Status:     "running",
+		StartTime:  time.Now(),
+		Settings:   currentConvertSettings(),
+		Rejections: make(map[string]int),
+	}
+}
+
+// reject counts a rejected spectrum under a reason
+func (r *conversionReport) reject(reason string) {
+	r.Counts.Rejected++
+	r.Rejections[reason]++
+	if r.mark != nil {
+		r.mark.pending = append(r.mark.pending, reason)
+	}
+}
+
+// skip counts a spectrum rejected by a build and records which one
+func (r *conversionReport) skip(name, scanID, reason string, err error) {
+	r.reject(reason)
+	r.Skipped = append(r.Skipped, reportSkipped{Spectrum: name, ScanID: scanID, Reason: reason, Message: err.Error()})
+}
+
+// parseError counts a malformed input entry
+func (r *conversionReport) parseError(line int, err error) {
+	r.Counts.Read++
+	r.reject(rejectParseError)
+	r.ParseErrors = append(r.ParseErrors, reportParseError{Line: line, Message: err.Error()})
+}
+
+// finish records the end time, timings and final status
+func (r *conversionReport) finish(err error) {
+	r.EndTime = time.Now()
+	total := r.EndTime.Sub(r.StartTime)
+
+	r.Timings = reportTimings{
+		TotalSeconds:    total.Seconds(),
+		ReadSeconds:     r.readTime.Seconds(),
+		WriteSeconds:    r.writeTime.Seconds(),
+		FinalizeSeconds: r.finalizeTime.Seconds(),
+		ProcessSeconds:  (total - r.readTime - r.writeTime - r.finalizeTime).Seconds(),
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		r.Status = "cancelled"
+		r.Error = err.Error()
+	case err != nil:
+		r.Status = "failed"
+		r.Error = err.Error()
+	default:
+		r.Status = "completed"
+	}
+}
+
+// save writes the report as indented JSON
+func (r *conversionReport) save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// saveReport writes the report after a run that ended with runErr and
+// returns runErr. A failure to write the report is only returned when the
+// run succeeded.
+func saveReport(r *conversionReport, path string, runErr error) error {
+	if err := r.save(path); err != nil {
+		if runErr == nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: failed to write report: %v\n", err)
+	} else {
+		fmt.Printf("Report: %s\n", path)
+	}
+	return runErr
+}
+
+// rejectionReason returns the rejection reason for a validation error,
+// named after its first failing field
+func rejectionReason(err error) string {
+	var verrs core.ValidationErrors
+	if errors.As(err, &verrs) && len(verrs) > 0 {
+		return validationReason(verrs[0].Field)
+	}
+	return rejectValidation
+}
+
+// validationReason returns the rejection reason for a validation field,
+// e.g. "PrecursorMZ" -> "validation_precursor_mz"
+func validationReason(field string) string {
+	var b strings.Builder
+	b.WriteString(rejectValidation)
+	b.WriteString("_")
+	runes := []rune(field)
+	for i, c := range runes {
+		if unicode.IsUpper(c) {
+			// Start a new word at a lower-to-upper transition or at the end of an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteString("_")
+			}
+			b.WriteRune(unicode.ToLower(c))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// rejectsWriter collects rejected entries in their original format
+type rejectsWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	written int
+	bytes   int64 // Length of the file including buffered entries
+}
+
+// createRejectsFile creates a rejects file, or returns nil if path is empty.
+// A resumed conversion passes the length of the file at its checkpoint
+// (resumeAt >= 0); entries written after the checkpoint are removed, as
+// they are rejected again.
+func createRejectsFile(path string, resumeAt int64) (*rejectsWriter, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resumeAt >= 0 {
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rejects file: %w", err)
+	}
+	if resumeAt > 0 {
+		info, err := f.Stat()
+		if err == nil && info.Size() < resumeAt {
+			err = fmt.Errorf("%s is shorter than at the checkpoint", path)
+		}
+		if err == nil {
+			err = f.Truncate(resumeAt)
+		}
+		if err == nil {
+			_, err = f.Seek(resumeAt, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to resume rejects file: %w", err)
+		}
+	}
+	return &rejectsWriter{file: f, w: bufio.NewWriter(f), bytes: max(resumeAt, 0)}, nil
+}
+
+// write appends one original entry followed by a blank line. Entries
+// without original text (e.g. from database inputs) are skipped.
+func (rf *rejectsWriter) write(raw string) error {
+	if rf == nil || raw == "" {
+		return nil
+	}
+	if _, err := rf.w.WriteString(raw); err != nil {
+		return err
+	}
+	if err := rf.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	rf.written++
+	rf.bytes += int64(len(raw)) + 1
+	return nil
+}
+
+// size returns the length of the rejects file once flushed
+func (rf *rejectsWriter) size() int64 {
+	if rf == nil {
+		return 0
+	}
+	return rf.bytes
+}
+
+// flush writes the buffered entries to the file
+func (rf *rejectsWriter) flush() error {
+	if rf == nil {
+		return nil
+	}
+	return rf.w.Flush()
+}
+
+// close flushes and closes the rejects file
+func (rf *rejectsWriter) close() error {
+	if rf == nil {
+		return nil
+	}
+	if err := rf.w.Flush(); err != nil {
+		rf.file.Close()
+		return err
+	}
+	return rf.file.Close()
+}
+
+// sortedReasons returns rejection reasons in alphabetical order
+func sortedReasons(rejections map[string]int) []string {
+	reasons := make([]string, 0, len(rejections))
+	for reason := range rejections {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
@@ -2,11 +2,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/ChrisMcGann/DBKey/pkg/calibration"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+	"github.com/ChrisMcGann/DBKey/pkg/writer"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/shard"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +20,9 @@ var (
 	// Flags for convert command
 	inputFile        string
 	inputFormat      string
-	outputFile       string
+	outputTargets    []string
+	outputs          []writer.Target // Parsed --out targets
+	outputFile       string          // Path of the first output
 	fragmentation    string
 	collisionEnergy  float64
 	massAnalyzer     string
@@ -25,15 +33,39 @@ var (
 	compoundClassCSV string
 	oldModMass       float64
 	newModMass       float64
+	rtAnchorsCSV     string
+	rtModelType      string
+	rtModelFile      string
+	rtModelSave      string
+	rtSegments       int
+	rtLowessSpan     float64
+	reportFile       string
+	maxErrors        int
+	mspDialect       string
+	polarity         string
+	appendOutput     bool
+	upsertOutput     bool
+	forceOutput      bool
+	checkpointOutput bool
+	resumeOutput     bool
+	rejectsFile      string
 	threads          int
 	chunkSize        int
+	shardMaxSpectra  int
+	shardMaxSize     string
+	shardMZWindows   string
+	shardByClass     bool
+	sharding         shard.Options // Parsed --shard-* flags
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "dbkey",
 	Short: "DBKey - Spectral library conversion tool",
-	Long: `DBKey converts spectral libraries (MSP, SPTXT, BLIB) to SQLite databases
-compatible with RTLS/mzVault workflows.
+	Long: `DBKey converts spectral libraries to SQLite databases compatible with
+RTLS/mzVault workflows, and to other library and transition list formats.
+
+Input formats: ` + strings.Join(reader.Formats, ", ") + `
+Output formats: ` + strings.Join(writer.Formats(), ", ") + `
 
 Fast, memory-efficient, and cross-platform conversion with support for:
 - Peak filtering (top-N, intensity cutoff)
@@ -47,18 +79,25 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+// ExecuteContext runs the root command with a context that commands use to
+// stop early, e.g. when interrupted
+func ExecuteContext(ctx context.Context) error {
+	return rootCmd.ExecuteContext(ctx)
+}
+
 func init() {
 	rootCmd.AddCommand(convertCmd)
-	rootCmd.AddCommand(validateCmd)
 	rootCmd.AddCommand(summarizeCmd)
 
 	// Convert command flags
 	convertCmd.Flags().StringVarP(&inputFile, "in", "i", "", "Input file path (required)")
-	convertCmd.Flags().StringVarP(&inputFormat, "from", "f", "", "Input format: msp, sptxt, blib (auto-detect if not specified)")
-	convertCmd.Flags().StringVarP(&outputFile, "out", "o", "", "Output database file (required)")
+	convertCmd.Flags().StringVarP(&inputFormat, "from", "f", "", "Input format: msp, sptxt, tsv, db, dlib, elib, jsonl (auto-detect if not specified)")
+	convertCmd.Flags().StringVar(&mspDialect, "msp-dialect", string(msp.DialectPeptide), "MSP dialect: peptide (Prosit/NIST) or small-molecule (MS-DIAL/MoNA/NIST)")
+	convertCmd.Flags().StringArrayVarP(&outputTargets, "out", "o", nil, "Output file as [format:]path, repeatable (required)")
 	convertCmd.Flags().StringVar(&fragmentation, "fragmentation", "HCD", "Fragmentation mode: HCD, CID, or 'read' to read from file")
 	convertCmd.Flags().Float64Var(&collisionEnergy, "collision-energy", 0, "Collision energy (0 = read from file)")
 	convertCmd.Flags().StringVar(&massAnalyzer, "mass-analyzer", "FT", "Mass analyzer: FT or IT")
+	convertCmd.Flags().StringVar(&polarity, "polarity", "read", "Polarity: positive, negative, or 'read' to read from file")
 	convertCmd.Flags().IntVar(&topN, "top-n", 0, "Keep only top N most intense peaks (0 = no limit)")
 	convertCmd.Flags().Float64Var(&cutoffPercent, "cutoff", 0, "Intensity cutoff as % of base peak (0 = no cutoff)")
 	convertCmd.Flags().StringVar(&ionTypes, "ion-types", "", "Comma-separated ion types to keep (e.g., 'b,y')")
@@ -66,8 +105,30 @@ func init() {
 	convertCmd.Flags().StringVar(&compoundClassCSV, "compound-class", "", "Path to compound class CSV file")
 	convertCmd.Flags().Float64Var(&oldModMass, "adjust-fragments-old", 0, "Old modification mass for fragment adjustment")
 	convertCmd.Flags().Float64Var(&newModMass, "adjust-fragments-new", 0, "New modification mass for fragment adjustment")
+	convertCmd.Flags().StringVar(&rtAnchorsCSV, "rt-anchors", "", "Path to RT anchor CSV file (format: Sequence,RT or Sequence,iRT,RT)")
+	convertCmd.Flags().StringVar(&rtModelType, "rt-model-type", calibration.ModelLinear, "RT calibration model: linear, piecewise, or lowess")
+	convertCmd.Flags().StringVar(&rtModelFile, "rt-model", "", "Path to a saved RT calibration model to apply instead of fitting")
+	convertCmd.Flags().StringVar(&rtModelSave, "rt-model-save", "", "Save the fitted RT calibration model to this JSON file")
+	convertCmd.Flags().IntVar(&rtSegments, "rt-segments", 3, "Number of segments for piecewise RT calibration")
+	convertCmd.Flags().Float64Var(&rtLowessSpan, "rt-lowess-span", 2.0/3.0, "Fraction of anchors in each LOWESS neighbourhood")
+	convertCmd.Flags().StringVar(&reportFile, "report", "", "Path to the JSON conversion report (default: <out>.report.json)")
+	convertCmd.Flags().IntVar(&maxErrors, "max-errors", 0, "Malformed entries to skip before aborting (0 = fail on first error, -1 = no limit)")
+	convertCmd.Flags().StringVar(&rejectsFile, "rejects", "", "Write rejected spectra in their original format to this file")
 	convertCmd.Flags().IntVar(&threads, "threads", 1, "Number of worker threads (currently not implemented)")
-	convertCmd.Flags().IntVar(&chunkSize, "chunk-size", 10000, "Chunk size for batch processing")
+	convertCmd.Flags().IntVar(&chunkSize, "chunk-size", sqlite.DefaultBatchSize, "Spectra written per database transaction")
+
+	convertCmd.Flags().BoolVar(&appendOutput, "append", false, "Append to an existing database, continuing its IDs")
+	convertCmd.Flags().BoolVar(&upsertOutput, "upsert", false, "Update an existing database, replacing spectra for the same precursor")
+	convertCmd.MarkFlagsMutuallyExclusive("append", "upsert")
+	convertCmd.Flags().BoolVar(&forceOutput, "force", false, "Overwrite an existing output database")
+	convertCmd.Flags().BoolVar(&checkpointOutput, "checkpoint", false, "Build the database in <out>.partial with a checkpoint per chunk, kept if the conversion fails")
+	convertCmd.Flags().BoolVar(&resumeOutput, "resume", false, "Resume a failed or interrupted --checkpoint conversion from <out>.partial")
+
+	convertCmd.Flags().IntVar(&shardMaxSpectra, "shard-max-spectra", 0, "Split database outputs into shards of at most this many spectra (0 = no limit)")
+	convertCmd.Flags().StringVar(&shardMaxSize, "shard-max-size", "", "Split database outputs into shards of about this size, e.g. 500M or 2G")
+	convertCmd.Flags().StringVar(&shardMZWindows, "shard-mz-windows", "", "Split database outputs by precursor m/z windows, e.g. 400-600,600-800")
+	convertCmd.Flags().BoolVar(&shardByClass, "shard-by-class", false, "Split database outputs by compound class")
+	addLibraryFlags(convertCmd)
 
 	convertCmd.MarkFlagRequired("in")
 	convertCmd.MarkFlagRequired("out")
@@ -76,31 +137,41 @@ func init() {
 var convertCmd = &cobra.Command{
 	Use:   "convert",
 	Short: "Convert spectral library to SQLite database",
-	Long: `Convert spectral libraries in MSP, SPTXT, or BLIB format to SQLite databases
-compatible with RTLS and mzVault workflows.
+	Long: `Convert spectral libraries in ` + strings.Join(reader.Formats, ", ") + ` format to SQLite
+databases compatible with RTLS and mzVault workflows.
+
+--out can be repeated to write every converted spectrum to several outputs in
+one pass. Each output is given as format:path, or as a path whose format is
+detected from its extension (.db, .blib, .jsonl, .parquet, .pqp, .csv).
+Formats: ` + strings.Join(writer.Formats(), ", ") + `. Transition lists and PQP
+outputs list the six most intense b and y ions from b3/y3 upwards.
+
+The --shard-* flags split database outputs into several databases named after
+the output, e.g. library.mz400-600.001.db, each with its own header tables.
+The shards are listed with their ranges in library.manifest.json.
 
 Examples:
   # Convert MSP file with default settings
   dbkey convert --in library.msp --out library.db
 
+  # Convert an MS-DIAL or MoNA metabolite library
+  dbkey convert --in metabolites.msp --out metabolites.db --msp-dialect small-molecule
+
   # Convert with filtering and mass analyzer specification
   dbkey convert --in library.msp --out library.db --top-n 150 --cutoff 1 --mass-analyzer FT
 
   # Convert with ion type filtering and fragment adjustment
-  dbkey convert --in library.msp --out library.db --ion-types b,y --adjust-fragments-old 229.16 --adjust-fragments-new 304.21`,
-	RunE: runConvert,
-}
+  dbkey convert --in library.msp --out library.db --ion-types b,y --adjust-fragments-old 229.16 --adjust-fragments-new 304.21
 
-var validateCmd = &cobra.Command{
-	Use:   "validate [file]",
-	Short: "Validate input file format and contents",
-	Long:  `Validate that an input file is properly formatted and contains valid spectral data.`,
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		// TODO: Implement validation
-		fmt.Fprintf(os.Stderr, "Validation not yet implemented\n")
-		return nil
-	},
+  # Write a database, a Skyline library and a DIA-NN library in one pass
+  dbkey convert --in library.msp --out library.db --out library.blib --out diann:library.tsv
+
+  # Split the database into shards per precursor m/z window of at most 1 GB
+  dbkey convert --in library.msp --out library.db --shard-mz-windows 400-600,600-800,800-1000 --shard-max-size 1G
+
+  # Calibrate Prosit iRT to run-specific retention times from anchor peptides
+  dbkey convert --in library.msp --out library.db --rt-anchors anchors.csv --rt-model-type lowess --rt-model-save rt.json`,
+	RunE: runConvert,
 }
 
 var summarizeCmd = &cobra.Command{
@@ -123,34 +194,53 @@ func runConvert(cmd *cobra.Command, args []string) error {
 
 	// Auto-detect format if not specified
 	if inputFormat == "" {
-		ext := strings.ToLower(filepath.Ext(inputFile))
-		switch ext {
-		case ".msp":
-			inputFormat = "msp"
-		case ".sptxt":
-			inputFormat = "sptxt"
-		case ".blib":
-			inputFormat = "blib"
-		default:
-			return fmt.Errorf("cannot auto-detect format from extension '%s', please specify --from", ext)
+		format, err := reader.DetectFormat(inputFile)
+		if err != nil {
+			return fmt.Errorf("%w, please specify --from", err)
 		}
+		inputFormat = format
 	}
 
-	// Validate format
 	inputFormat = strings.ToLower(inputFormat)
-	if inputFormat != "msp" && inputFormat != "sptxt" && inputFormat != "blib" {
-		return fmt.Errorf("invalid input format '%s', must be msp, sptxt, or blib", inputFormat)
-	}
 
-	// BLIB is not yet implemented
-	if inputFormat == "blib" {
-		return fmt.Errorf("format 'blib' is not yet implemented")
+	opts, err := libraryOptions(cmd)
+	if err != nil {
+		return err
+	}
+	switch {
+	case appendOutput:
+		opts.Mode = sqlite.ModeAppend
+	case upsertOutput:
+		opts.Mode = sqlite.ModeUpsert
+	}
+	opts.Overwrite = forceOutput
+	opts.BatchSize = chunkSize
+	opts.Checkpoint = checkpointOutput || resumeOutput
+	opts.Resume = resumeOutput
+	if sharding, err = shardOptions(); err != nil {
+		return err
+	}
+	if err := parseOutputs(opts); err != nil {
+		return err
 	}
 
-	fmt.Printf("Converting %s to %s...\n", inputFile, outputFile)
+	targets := make([]string, len(outputs))
+	for i, target := range outputs {
+		targets[i] = target.String()
+	}
+	fmt.Printf("Converting %s to %s...\n", inputFile, strings.Join(targets, ", "))
 	fmt.Printf("Format: %s\n", inputFormat)
+	if opts.Mode != sqlite.ModeCreate {
+		fmt.Printf("Mode: %s\n", opts.Mode)
+	}
+	if sharding.Enabled() {
+		fmt.Printf("Sharding: %s\n", describeSharding(sharding))
+	}
 	fmt.Printf("Fragmentation: %s\n", fragmentation)
 	fmt.Printf("Mass Analyzer: %s\n", massAnalyzer)
+	if polarity != "" && polarity != "read" {
+		fmt.Printf("Polarity: %s\n", polarity)
+	}
 
 	if topN > 0 {
 		fmt.Printf("Top N filter: %d\n", topN)
@@ -162,13 +252,104 @@ func runConvert(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Ion types: %s\n", ionTypes)
 	}
 
-	// Call the appropriate conversion logic
-	switch inputFormat {
-	case "msp":
-		return convertMSP()
-	case "sptxt":
-		return convertSPTXT()
-	default:
-		return fmt.Errorf("unsupported format: %s", inputFormat)
+	return convertLibrary(cmd.Context(), opts)
+}
+
+// parseOutputs parses the --out targets and checks that each database
+// output can be written. Updates need a database output and checkpoints a
+// single one.
+func parseOutputs(opts sqlite.Options) error {
+	outputs = outputs[:0]
+	hasDB := false
+	for _, s := range outputTargets {
+		target, err := writer.ParseTarget(s)
+		if err != nil {
+			return err
+		}
+		if target.Format == writer.FormatDB {
+			hasDB = true
+			if sharding.Enabled() {
+				// Shards are named after the path, which is not written
+				manifest := shard.ManifestPath(target.Path)
+				if _, err := os.Stat(manifest); err == nil && !opts.Overwrite {
+					return fmt.Errorf("sharded output %s already exists, use --force to overwrite", manifest)
+				}
+				outputs = append(outputs, target)
+				continue
+			}
+			if err := checkOutput(target.Path, opts); err != nil {
+				return err
+			}
+		}
+		outputs = append(outputs, target)
+	}
+	if len(outputs) == 0 {
+		return fmt.Errorf("at least one --out is required")
+	}
+	outputFile = outputs[0].Path
+
+	if opts.Mode != sqlite.ModeCreate && !hasDB {
+		return fmt.Errorf("--append and --upsert need a %s output", writer.FormatDB)
+	}
+	if opts.Checkpoint && (len(outputs) > 1 || outputs[0].Format != writer.FormatDB) {
+		return fmt.Errorf("--checkpoint and --resume need a single %s output", writer.FormatDB)
+	}
+	if sharding.Enabled() {
+		switch {
+		case !hasDB:
+			return fmt.Errorf("sharding needs a %s output", writer.FormatDB)
+		case opts.Mode != sqlite.ModeCreate:
+			return fmt.Errorf("sharded output cannot be combined with --append or --upsert")
+		case opts.Checkpoint:
+			return fmt.Errorf("sharded output cannot be combined with --checkpoint or --resume")
+		}
+	}
+	return nil
+}
+
+// shardOptions parses the --shard-* flags
+func shardOptions() (shard.Options, error) {
+	var opts shard.Options
+	if shardMaxSpectra < 0 {
+		return opts, fmt.Errorf("--shard-max-spectra must not be negative")
+	}
+	opts.MaxSpectra = shardMaxSpectra
+	if shardMaxSize != "" {
+		size, err := shard.ParseSize(shardMaxSize)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --shard-max-size: %w", err)
+		}
+		opts.MaxBytes = size
+	}
+	if shardMZWindows != "" {
+		windows, err := shard.ParseWindows(shardMZWindows)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --shard-mz-windows: %w", err)
+		}
+		opts.MZWindows = windows
+	}
+	opts.ByClass = shardByClass
+	return opts, nil
+}
+
+// describeSharding summarizes the sharding options for the console
+func describeSharding(opts shard.Options) string {
+	var parts []string
+	if len(opts.MZWindows) > 0 {
+		windows := make([]string, len(opts.MZWindows))
+		for i, w := range opts.MZWindows {
+			windows[i] = w.String()
+		}
+		parts = append(parts, "m/z "+strings.Join(windows, ", "))
+	}
+	if opts.ByClass {
+		parts = append(parts, "compound class")
+	}
+	if opts.MaxSpectra > 0 {
+		parts = append(parts, fmt.Sprintf("max %d spectra", opts.MaxSpectra))
+	}
+	if opts.MaxBytes > 0 {
+		parts = append(parts, fmt.Sprintf("max %d bytes", opts.MaxBytes))
 	}
+	return strings.Join(parts, "; ")
 }
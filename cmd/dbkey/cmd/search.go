@@ -0,0 +1,337 @@
+// Package cmd provides library search implementation
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/mgf"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/mzvault"
+	"github.com/ChrisMcGann/DBKey/pkg/similarity"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Flags for search command
+	searchLibrary      string
+	searchInput        string
+	searchOutput       string
+	searchPrecursorTol string
+	searchFragmentTol  string
+	searchMetric       string
+	searchTopK         int
+	searchMinScore     float64
+	searchForce        bool
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Search experimental MS2 spectra against a DBKey SQLite library",
+	Long: `Match experimental MS2 spectra (MGF) against a DBKey SQLite library, mirroring
+what RTLS does on the instrument. Library candidates are selected by precursor
+m/z tolerance and charge, scored with a spectral similarity metric, and the
+top-k matches per query are written as TSV.
+
+Queries without a CHARGE are matched against candidates of any charge.
+
+The query spectra and their top-k matches are held in memory while the
+library is streamed once, so memory grows with the MGF file, not the library.
+An existing output file is only replaced with --force.
+
+Examples:
+  # Top match per query with default tolerances
+  dbkey search --library library.db --in run.mgf --out hits.tsv
+
+  # Top 5 matches by entropy similarity, reporting hit rate at score >= 0.7
+  dbkey search --library library.db --in run.mgf --out hits.tsv --top-k 5 --metric entropy --min-score 0.7`,
+	RunE: runSearch,
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().StringVarP(&searchLibrary, "library", "l", "", "DBKey SQLite library (required)")
+	searchCmd.Flags().StringVarP(&searchInput, "in", "i", "", "Experimental spectra in MGF format (required)")
+	searchCmd.Flags().StringVarP(&searchOutput, "out", "o", "", "Output TSV file (required)")
+	searchCmd.Flags().StringVar(&searchPrecursorTol, "precursor-tolerance", "10ppm", "Precursor m/z tolerance (e.g. 10ppm, 0.01Da)")
+	searchCmd.Flags().StringVar(&searchFragmentTol, "fragment-tolerance", "20ppm", "Fragment matching tolerance (e.g. 20ppm, 0.02Da)")
+	searchCmd.Flags().StringVar(&searchMetric, "metric", string(similarity.DefaultMetric), "Similarity metric: dot, angle, entropy, weighted-entropy, pearson")
+	searchCmd.Flags().IntVar(&searchTopK, "top-k", 1, "Number of matches to report per query")
+	searchCmd.Flags().Float64Var(&searchMinScore, "min-score", 0, "Minimum score for a match to be reported and counted as a hit")
+	searchCmd.Flags().BoolVar(&searchForce, "force", false, "Overwrite an existing output file")
+
+	searchCmd.MarkFlagRequired("library")
+	searchCmd.MarkFlagRequired("in")
+	searchCmd.MarkFlagRequired("out")
+}
+
+// searchQuery is an experimental spectrum with its precursor m/z window and
+// the best library matches found so far
+type searchQuery struct {
+	title      string
+	spec       *core.Spectrum
+	lo, hi     float64
+	candidates int
+	hits       []searchHit // Best first, at most top-k
+}
+
+// searchHit is one scored library candidate for a query. Only the reported
+// fields of the entry are kept so the library can be streamed.
+type searchHit struct {
+	key     string
+	mz      float64
+	charge  int
+	peaks   int
+	score   float64
+	matched int
+	order   int // Position in the library
+}
+
+// searchParams are the matching settings of a search
+type searchParams struct {
+	precursorTol similarity.Tolerance
+	fragmentTol  similarity.Tolerance
+	metric       similarity.Metric
+	topK         int
+	minScore     float64
+}
+
+// searchCounts summarizes a search: queries read, queries with at least one
+// precursor candidate, queries with a reported match and library spectra read
+type searchCounts struct {
+	queries, withCandidates, hits int
+	library                       int // Library spectra read
+}
+
+func runSearch(cmd *cobra.Command, args []string) error {
+	precursorTol, err := similarity.ParseTolerance(searchPrecursorTol)
+	if err != nil {
+		return err
+	}
+	fragmentTol, err := similarity.ParseTolerance(searchFragmentTol)
+	if err != nil {
+		return err
+	}
+	metric, err := similarity.ParseMetric(searchMetric)
+	if err != nil {
+		return err
+	}
+	if searchTopK < 1 {
+		return fmt.Errorf("--top-k must be at least 1")
+	}
+	params := searchParams{
+		precursorTol: precursorTol,
+		fragmentTol:  fragmentTol,
+		metric:       metric,
+		topK:         searchTopK,
+		minScore:     searchMinScore,
+	}
+
+	ctx := cmd.Context()
+	inFile, err := os.Open(searchInput)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inFile.Close()
+
+	out, err := atomicfile.Create(searchOutput, searchForce)
+	if errors.Is(err, atomicfile.ErrExists) {
+		return fmt.Errorf("%w, use --force to overwrite", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Abort()
+
+	library, err := openSearchLibrary(ctx, searchLibrary)
+	if err != nil {
+		return err
+	}
+	defer library.Close()
+
+	w := bufio.NewWriter(out)
+	counts, err := searchSpectra(ctx, library, mgf.NewReader(inFile), w, params)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := out.Commit(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	fmt.Printf("\nSearch complete!\n")
+	fmt.Printf("Library spectra: %d\n", counts.library)
+	fmt.Printf("Queries: %d\n", counts.queries)
+	fmt.Printf("With candidates: %d\n", counts.withCandidates)
+	if counts.queries > 0 {
+		fmt.Printf("Hits: %d (%.1f%%)\n", counts.hits, 100*float64(counts.hits)/float64(counts.queries))
+	}
+	fmt.Printf("Output: %s\n", searchOutput)
+
+	return nil
+}
+
+// searchSpectra reads every query from r, streams the library once against
+// them and writes the top-k matches of each query as TSV in input order
+func searchSpectra(ctx context.Context, library reader.Reader, r *mgf.Reader, w io.Writer, params searchParams) (searchCounts, error) {
+	queries, err := readQueries(ctx, r, params.precursorTol)
+	if err != nil {
+		return searchCounts{}, err
+	}
+	counts := searchCounts{queries: len(queries)}
+
+	// Windows grow with the precursor m/z, so the queries matching a
+	// library entry are adjacent once sorted
+	byMZ := make([]*searchQuery, len(queries))
+	copy(byMZ, queries)
+	sort.SliceStable(byMZ, func(i, j int) bool {
+		return byMZ[i].spec.PrecursorMZ < byMZ[j].spec.PrecursorMZ
+	})
+
+	for library.Next() {
+		if err := ctx.Err(); err != nil {
+			return counts, fmt.Errorf("search interrupted: %w", err)
+		}
+		entry := library.Spectrum()
+		for _, q := range matchQueries(byMZ, entry) {
+			q.candidates++
+			al := similarity.MatchSpectra(q.spec, entry, params.fragmentTol)
+			score, err := al.Score(params.metric)
+			if err != nil {
+				return counts, err
+			}
+			if score < params.minScore {
+				continue
+			}
+			q.add(searchHit{
+				key:     entry.Key(),
+				mz:      entry.PrecursorMZ,
+				charge:  entry.Charge,
+				peaks:   len(entry.Peaks),
+				score:   score,
+				matched: al.MatchedCount(),
+				order:   counts.library,
+			}, params.topK)
+		}
+		counts.library++
+	}
+	if err := library.Err(); err != nil {
+		return counts, fmt.Errorf("error reading library: %w", err)
+	}
+
+	fmt.Fprintln(w, strings.Join([]string{
+		"Query", "QueryPrecursorMZ", "QueryCharge", "Rank", "Precursor",
+		"LibraryPrecursorMZ", "LibraryCharge", "PrecursorErrorPPM",
+		"Score", "MatchedPeaks", "LibraryPeaks",
+	}, "\t"))
+	for _, q := range queries {
+		if q.candidates > 0 {
+			counts.withCandidates++
+		}
+		if len(q.hits) > 0 {
+			counts.hits++
+		}
+		for rank, hit := range q.hits {
+			ppm := (q.spec.PrecursorMZ - hit.mz) / hit.mz * 1e6
+			fmt.Fprintf(w, "%s\t%.6f\t%d\t%d\t%s\t%.6f\t%d\t%.2f\t%.4f\t%d\t%d\n",
+				q.title, q.spec.PrecursorMZ, q.spec.Charge, rank+1, hit.key,
+				hit.mz, hit.charge, ppm, hit.score, hit.matched, hit.peaks)
+		}
+	}
+	return counts, nil
+}
+
+// readQueries reads the query spectra and their precursor windows
+func readQueries(ctx context.Context, r *mgf.Reader, tol similarity.Tolerance) ([]*searchQuery, error) {
+	var queries []*searchQuery
+	for r.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("search interrupted: %w", err)
+		}
+		title := r.Title()
+		if title == "" {
+			title = fmt.Sprintf("query%d", len(queries)+1)
+		}
+		queries = append(queries, newSearchQuery(title, r.Spectrum(), tol))
+	}
+	if err := r.Err(); err != nil {
+		return nil, fmt.Errorf("error reading input file: %w", err)
+	}
+	return queries, nil
+}
+
+// newSearchQuery returns a query with the precursor window of tol
+func newSearchQuery(title string, spec *core.Spectrum, tol similarity.Tolerance) *searchQuery {
+	window := tol.Window(spec.PrecursorMZ)
+	return &searchQuery{
+		title: title,
+		spec:  spec,
+		lo:    spec.PrecursorMZ - window,
+		hi:    spec.PrecursorMZ + window,
+	}
+}
+
+// matchQueries returns the queries, sorted by precursor m/z, whose window
+// holds the library entry and whose charge matches
+func matchQueries(byMZ []*searchQuery, entry *core.Spectrum) []*searchQuery {
+	mz := entry.PrecursorMZ
+	start := sort.Search(len(byMZ), func(i int) bool {
+		return byMZ[i].hi >= mz
+	})
+
+	var matched []*searchQuery
+	for i := start; i < len(byMZ) && byMZ[i].lo <= mz; i++ {
+		if byMZ[i].spec.Charge != 0 && byMZ[i].spec.Charge != entry.Charge {
+			continue
+		}
+		matched = append(matched, byMZ[i])
+	}
+	return matched
+}
+
+// add keeps hit if it is among the top-k of the query. Equal scores rank
+// by library precursor m/z, then library order.
+func (q *searchQuery) add(hit searchHit, topK int) {
+	i := sort.Search(len(q.hits), func(i int) bool {
+		return hit.before(q.hits[i])
+	})
+	if i >= topK {
+		return
+	}
+	q.hits = append(q.hits, searchHit{})
+	copy(q.hits[i+1:], q.hits[i:])
+	q.hits[i] = hit
+	if len(q.hits) > topK {
+		q.hits = q.hits[:topK]
+	}
+}
+
+// before reports whether h ranks ahead of other
+func (h searchHit) before(other searchHit) bool {
+	if h.score != other.score {
+		return h.score > other.score
+	}
+	if h.mz != other.mz {
+		return h.mz < other.mz
+	}
+	return h.order < other.order
+}
+
+// openSearchLibrary opens a DBKey SQLite library for streaming
+func openSearchLibrary(ctx context.Context, path string) (*mzvault.Reader, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("library file does not exist: %s", path)
+	}
+	return mzvault.OpenContext(ctx, path, loadModDatabase())
+}
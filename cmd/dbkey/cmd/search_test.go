@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/mgf"
+	"github.com/ChrisMcGann/DBKey/pkg/similarity"
+)
+
+// searchEntry returns a library peptide with the given precursor and peaks
+func searchEntry(seq string, mz float64, charge int, peaks ...core.Peak) *core.Spectrum {
+	return &core.Spectrum{Sequence: seq, PrecursorMZ: mz, Charge: charge, Peaks: peaks}
+}
+
+// searchLibraryReader streams library entries in order
+type searchLibraryReader struct {
+	specs []*core.Spectrum
+	next  int
+}
+
+func (r *searchLibraryReader) Next() bool {
+	r.next++
+	return r.next <= len(r.specs)
+}
+
+func (r *searchLibraryReader) Spectrum() *core.Spectrum { return r.specs[r.next-1] }
+func (r *searchLibraryReader) Err() error               { return nil }
+
+func TestMatchQueries(t *testing.T) {
+	tol := similarity.PPM(10)
+	byMZ := []*searchQuery{
+		newSearchQuery("A", searchEntry("", 499.994, 2), tol),
+		newSearchQuery("C", searchEntry("", 499.996, 2), tol),
+		newSearchQuery("D", searchEntry("", 500.000, 3), tol),
+		newSearchQuery("any", searchEntry("", 500.000, 0), tol),
+		newSearchQuery("E", searchEntry("", 500.004, 2), tol),
+		newSearchQuery("F", searchEntry("", 500.006, 2), tol),
+		newSearchQuery("G", searchEntry("", 600.000, 2), tol),
+	}
+	dalton := []*searchQuery{
+		newSearchQuery("A", searchEntry("", 499.994, 2), similarity.Tolerance{Value: 0.01}),
+		newSearchQuery("F", searchEntry("", 500.006, 2), similarity.Tolerance{Value: 0.01}),
+		newSearchQuery("G", searchEntry("", 600.000, 2), similarity.Tolerance{Value: 0.01}),
+	}
+
+	tests := []struct {
+		name    string
+		queries []*searchQuery
+		mz      float64
+		charge  int
+		want    []string
+	}{
+		{name: "ppm window", queries: byMZ, mz: 500, charge: 2, want: []string{"C", "any", "E"}},
+		{name: "other charge", queries: byMZ, mz: 500, charge: 3, want: []string{"D", "any"}},
+		{name: "dalton window", queries: dalton, mz: 500, charge: 2, want: []string{"A", "F"}},
+		{name: "last query", queries: byMZ, mz: 600, charge: 2, want: []string{"G"}},
+		{name: "no queries", queries: byMZ, mz: 700, charge: 2, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, q := range matchQueries(tt.queries, searchEntry("PEPTIDEK", tt.mz, tt.charge)) {
+				got = append(got, q.title)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("matchQueries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchQueryAdd(t *testing.T) {
+	hits := []searchHit{
+		{key: "AAAK/2", mz: 500, score: 0.8, order: 0},
+		{key: "CCCK/2", mz: 500, score: 1, order: 1},
+		{key: "DDDK/2", mz: 500, score: 0, order: 2},
+		{key: "EEEK/2", mz: 500, score: 1, order: 3},
+	}
+
+	tests := []struct {
+		name  string
+		extra []searchHit // Added after hits
+		topK  int
+		want  []string
+	}{
+		{name: "top 1", topK: 1, want: []string{"CCCK/2"}},
+		{name: "ties keep library order", topK: 3, want: []string{"CCCK/2", "EEEK/2", "AAAK/2"}},
+		{name: "all hits", topK: 10, want: []string{"CCCK/2", "EEEK/2", "AAAK/2", "DDDK/2"}},
+		{
+			name:  "ties prefer lower precursor m/z",
+			extra: []searchHit{{key: "FFFK/2", mz: 499.999, score: 1, order: 4}},
+			topK:  2,
+			want:  []string{"FFFK/2", "CCCK/2"},
+		},
+		{
+			name:  "below the top k",
+			extra: []searchHit{{key: "FFFK/2", mz: 499.999, score: 0.5, order: 4}},
+			topK:  3,
+			want:  []string{"CCCK/2", "EEEK/2", "AAAK/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &searchQuery{}
+			for _, hit := range append(append([]searchHit(nil), hits...), tt.extra...) {
+				q.add(hit, tt.topK)
+			}
+			got := make([]string, len(q.hits))
+			for i, hit := range q.hits {
+				got[i] = hit.key
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("add() kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// searchTestMGF has a query with two candidates, one whose only candidate
+// scores below the minimum, and one without candidates
+const searchTestMGF = `BEGIN IONS
+TITLE=hit
+PEPMASS=500.001
+CHARGE=2+
+100 100
+200 50
+END IONS
+
+BEGIN IONS
+PEPMASS=600
+CHARGE=2+
+900 10
+END IONS
+
+BEGIN IONS
+TITLE=none
+PEPMASS=700
+CHARGE=2+
+100 100
+END IONS
+`
+
+func TestSearchSpectra(t *testing.T) {
+	// The library is streamed in file order, not by precursor m/z
+	library := []*core.Spectrum{
+		searchEntry("DDDK", 600.000, 2, core.Peak{MZ: 100, Intensity: 100}),
+		searchEntry("CCCK", 500.002, 2, core.Peak{MZ: 100, Intensity: 100}, core.Peak{MZ: 300, Intensity: 50}),
+		searchEntry("AAAK", 500.000, 2, core.Peak{MZ: 100, Intensity: 100}, core.Peak{MZ: 200, Intensity: 50}),
+	}
+
+	tests := []struct {
+		name       string
+		topK       int
+		minScore   float64
+		wantCounts searchCounts
+		wantRows   []string
+	}{
+		{
+			name:       "top 1",
+			topK:       1,
+			minScore:   0.5,
+			wantCounts: searchCounts{queries: 3, withCandidates: 2, hits: 1, library: 3},
+			wantRows:   []string{"hit 1 AAAK/2"},
+		},
+		{
+			name:       "top 2",
+			topK:       2,
+			minScore:   0.5,
+			wantCounts: searchCounts{queries: 3, withCandidates: 2, hits: 1, library: 3},
+			wantRows:   []string{"hit 1 AAAK/2", "hit 2 CCCK/2"},
+		},
+		{
+			name:       "no minimum score",
+			topK:       1,
+			wantCounts: searchCounts{queries: 3, withCandidates: 2, hits: 2, library: 3},
+			wantRows:   []string{"hit 1 AAAK/2", "query2 1 DDDK/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := searchParams{
+				precursorTol: similarity.PPM(10),
+				fragmentTol:  similarity.PPM(20),
+				metric:       similarity.MetricDotProduct,
+				topK:         tt.topK,
+				minScore:     tt.minScore,
+			}
+			var out bytes.Buffer
+			counts, err := searchSpectra(context.Background(), &searchLibraryReader{specs: library}, mgf.NewReader(strings.NewReader(searchTestMGF)), &out, params)
+			if err != nil {
+				t.Fatalf("searchSpectra() error = %v", err)
+			}
+			if counts != tt.wantCounts {
+				t.Errorf("searchSpectra() counts = %+v, want %+v", counts, tt.wantCounts)
+			}
+
+			lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+			if !strings.HasPrefix(lines[0], "Query\tQueryPrecursorMZ") {
+				t.Errorf("header = %q", lines[0])
+			}
+			var rows []string
+			for _, line := range lines[1:] {
+				fields := strings.Split(line, "\t")
+				if len(fields) != 11 {
+					t.Fatalf("row %q has %d fields, want 11", line, len(fields))
+				}
+				rows = append(rows, fields[0]+" "+fields[3]+" "+fields[4])
+			}
+			if strings.Join(rows, ",") != strings.Join(tt.wantRows, ",") {
+				t.Errorf("rows = %v, want %v", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestSearchSpectraInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	params := searchParams{precursorTol: similarity.PPM(10), fragmentTol: similarity.PPM(20), metric: similarity.DefaultMetric, topK: 1}
+	_, err := searchSpectra(ctx, &searchLibraryReader{}, mgf.NewReader(strings.NewReader(searchTestMGF)), &bytes.Buffer{}, params)
+	if err == nil || !strings.Contains(err.Error(), "search interrupted") {
+		t.Errorf("searchSpectra() error = %v, want search interrupted", err)
+	}
+}
+
+func TestSearchOutputExists(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "run.mgf")
+	output := filepath.Join(dir, "hits.tsv")
+	for path, data := range map[string]string{input: searchTestMGF, output: "existing"} {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldLibrary, oldInput, oldOutput, oldForce := searchLibrary, searchInput, searchOutput, searchForce
+	t.Cleanup(func() {
+		searchLibrary, searchInput, searchOutput, searchForce = oldLibrary, oldInput, oldOutput, oldForce
+	})
+	searchLibrary, searchInput, searchOutput = filepath.Join(dir, "missing.db"), input, output
+	searchCmd.SetContext(context.Background())
+
+	tests := []struct {
+		force   bool
+		wantErr string
+	}{
+		{force: false, wantErr: "use --force"},
+		// A failed search keeps the earlier output
+		{force: true, wantErr: "library file does not exist"},
+	}
+	for _, tt := range tests {
+		searchForce = tt.force
+		err := runSearch(searchCmd, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("runSearch() with force %v error = %v, want %q", tt.force, err, tt.wantErr)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("runSearch() with force %v left %d files, want 2", tt.force, len(entries))
+		}
+		if data, err := os.ReadFile(output); err != nil || string(data) != "existing" {
+			t.Errorf("output after runSearch() with force %v = %q, %v, want unchanged", tt.force, data, err)
+		}
+	}
+}
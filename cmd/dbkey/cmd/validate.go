@@ -0,0 +1,94 @@
+// Package cmd provides input validation implementation
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ChrisMcGann/DBKey/pkg/reader"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Flags for validate command
+	validateFormat    string
+	validateMaxErrors int
+	validateDialect   string
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate input file format and contents",
+	Long: `Validate that an input file is properly formatted and contains valid spectral data.
+
+Every entry is parsed and checked the same way convert would, without writing
+any output. Validation is strict by default and stops at the first malformed
+entry; use --max-errors to skip malformed entries and list them all.
+
+Examples:
+  # Stop at the first malformed entry
+  dbkey validate library.msp
+
+  # Report every malformed entry
+  dbkey validate library.msp --max-errors -1`,
+	Args: cobra.ExactArgs(1),
+	RunE: runValidate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&validateFormat, "from", "f", "", "Input format: msp, sptxt, tsv, db, dlib, elib, jsonl (auto-detect if not specified)")
+	validateCmd.Flags().StringVar(&validateDialect, "msp-dialect", string(msp.DialectPeptide), "MSP dialect: peptide (Prosit/NIST) or small-molecule (MS-DIAL/MoNA/NIST)")
+	validateCmd.Flags().IntVar(&validateMaxErrors, "max-errors", 0, "Malformed entries to skip before aborting (0 = fail on first error, -1 = no limit)")
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("input file does not exist: %s", path)
+	}
+
+	dialect, err := msp.ParseDialect(validateDialect)
+	if err != nil {
+		return err
+	}
+
+	in, err := reader.OpenContext(cmd.Context(), path, validateFormat, loadModDatabase())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	in.SetMaxErrors(validateMaxErrors)
+	in.SetMSPDialect(dialect)
+
+	total, invalid := 0, 0
+	for in.Next() {
+		spec := in.Spectrum()
+		total++
+
+		if _, err := prepareSpectrum(in, spec, prepareSteps{}); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid spectrum %s: %v\n", spec.Name(), err)
+			invalid++
+		}
+	}
+
+	parseErrors := in.ParseErrors()
+	for _, perr := range parseErrors {
+		fmt.Fprintf(os.Stderr, "Malformed entry at %v\n", perr)
+	}
+	if err := in.Err(); err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+
+	fmt.Printf("Spectra: %d\n", total)
+	fmt.Printf("Invalid: %d\n", invalid)
+	fmt.Printf("Malformed entries: %d\n", len(parseErrors))
+
+	if invalid > 0 || len(parseErrors) > 0 {
+		return fmt.Errorf("validation failed: %d invalid spectra, %d malformed entries", invalid, len(parseErrors))
+	}
+	fmt.Printf("%s is valid\n", path)
+	return nil
+}
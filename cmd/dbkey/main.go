@@ -2,15 +2,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ChrisMcGann/DBKey/cmd/dbkey/cmd"
 )
 
+// exitInterrupted is the exit status after SIGINT or SIGTERM (128 + SIGINT)
+const exitInterrupted = 130
+
 func main() {
-	if err := cmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal terminates immediately
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := cmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitStatus(err))
+	}
+}
+
+// exitStatus returns the exit status for a command error
+func exitStatus(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
 	}
+	return 1
 }
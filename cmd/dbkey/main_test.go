@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"failed", errors.New("failed to open input file"), 1},
+		{"interrupted", fmt.Errorf("conversion interrupted: %w", context.Canceled), exitInterrupted},
+		{"deadline", context.DeadlineExceeded, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitStatus(tt.err); got != tt.want {
+				t.Errorf("exitStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package calibration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LoadAnchorsCSV loads anchor peptides from a CSV file with a header line.
+// Two formats are accepted: "Sequence,RT", where the iRT is looked up in the
+// library later (IRT is NaN), and "Sequence,iRT,RT".
+func LoadAnchorsCSV(r io.Reader) ([]Anchor, error) {
+	scanner := bufio.NewScanner(r)
+
+	// Skip header line
+	if scanner.Scan() {
+		// header
+	}
+
+	var anchors []Anchor
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+
+		anchor := Anchor{Sequence: parts[0], IRT: math.NaN()}
+		var rtStr string
+		switch len(parts) {
+		case 2:
+			rtStr = parts[1]
+		case 3:
+			irt, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid iRT value '%s': %w", lineNum, parts[1], err)
+			}
+			anchor.IRT = irt
+			rtStr = parts[2]
+		default:
+			return nil, fmt.Errorf("line %d: expected 2 fields (Sequence,RT) or 3 fields (Sequence,iRT,RT), got %d", lineNum, len(parts))
+		}
+
+		rt, err := strconv.ParseFloat(rtStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid RT value '%s': %w", lineNum, rtStr, err)
+		}
+		anchor.RT = rt
+
+		anchors = append(anchors, anchor)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading CSV: %w", err)
+	}
+
+	return anchors, nil
+}
@@ -0,0 +1,490 @@
+// Package calibration provides retention time calibration models that map
+// library iRT values to run-specific retention times using anchor peptides.
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Model types
+const (
+	ModelLinear    = "linear"
+	ModelPiecewise = "piecewise"
+	ModelLowess    = "lowess"
+)
+
+// Anchor is a peptide with a known library iRT and an observed retention time
+type Anchor struct {
+	Sequence string
+	IRT      float64
+	RT       float64
+}
+
+// Point is a knot of a piecewise linear model
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Model maps iRT to retention time. Linear models use Slope and Intercept;
+// piecewise and LOWESS models interpolate linearly between Knots and
+// extrapolate along the first and last segments.
+type Model struct {
+	Type      string  `json:"type"`
+	Slope     float64 `json:"slope,omitempty"`
+	Intercept float64 `json:"intercept,omitempty"`
+	Knots     []Point `json:"knots,omitempty"`
+	Stats     *Stats  `json:"stats,omitempty"`
+}
+
+// Residual is the fit residual of a single anchor
+type Residual struct {
+	Sequence  string  `json:"sequence"`
+	IRT       float64 `json:"irt"`
+	RT        float64 `json:"rt"`
+	Predicted float64 `json:"predicted"`
+	Residual  float64 `json:"residual"`
+}
+
+// Stats describes the quality of a fit
+type Stats struct {
+	N              int        `json:"n"`
+	RSquared       float64    `json:"r_squared"`
+	RMSE           float64    `json:"rmse"`
+	MaxAbsResidual float64    `json:"max_abs_residual"`
+	Residuals      []Residual `json:"residuals"`
+}
+
+// Options configures model fitting
+type Options struct {
+	Segments   int     // Number of segments for piecewise models (default 3)
+	LowessSpan float64 // Fraction of points in each LOWESS neighbourhood (default 2/3)
+	LowessIter int     // Number of LOWESS robustness iterations (default 3)
+}
+
+// DefaultOptions returns the default fitting options
+func DefaultOptions() Options {
+	return Options{
+		Segments:   3,
+		LowessSpan: 2.0 / 3.0,
+		LowessIter: 3,
+	}
+}
+
+// Fit fits a model of the given type to the anchors and computes its fit statistics
+func Fit(anchors []Anchor, modelType string, opts Options) (*Model, error) {
+	if len(anchors) < 2 {
+		return nil, fmt.Errorf("at least 2 anchors are required, got %d", len(anchors))
+	}
+
+	sorted := make([]Anchor, len(anchors))
+	copy(sorted, anchors)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].IRT < sorted[j].IRT
+	})
+
+	xs := make([]float64, len(sorted))
+	ys := make([]float64, len(sorted))
+	for i, a := range sorted {
+		xs[i] = a.IRT
+		ys[i] = a.RT
+	}
+	if xs[0] == xs[len(xs)-1] {
+		return nil, fmt.Errorf("anchors must span more than one iRT value")
+	}
+
+	var model *Model
+	var err error
+	switch strings.ToLower(modelType) {
+	case ModelLinear:
+		model, err = fitLinear(xs, ys)
+	case ModelPiecewise:
+		model, err = fitPiecewise(xs, ys, opts.Segments)
+	case ModelLowess:
+		model, err = fitLowess(xs, ys, opts.LowessSpan, opts.LowessIter)
+	default:
+		return nil, fmt.Errorf("unknown model type '%s', must be linear, piecewise, or lowess", modelType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	model.Stats = model.Evaluate(sorted)
+	return model, nil
+}
+
+// Predict maps an iRT value to a retention time
+func (m *Model) Predict(x float64) float64 {
+	if m.Type == ModelLinear || len(m.Knots) < 2 {
+		return m.Slope*x + m.Intercept
+	}
+
+	k := m.Knots
+	// Find the segment containing x, clamping to the end segments for extrapolation
+	i := sort.Search(len(k), func(i int) bool { return k[i].X >= x })
+	if i == 0 {
+		i = 1
+	}
+	if i >= len(k) {
+		i = len(k) - 1
+	}
+
+	a, b := k[i-1], k[i]
+	if b.X == a.X {
+		return a.Y
+	}
+	return a.Y + (x-a.X)*(b.Y-a.Y)/(b.X-a.X)
+}
+
+// Evaluate computes fit statistics of the model on a set of anchors
+func (m *Model) Evaluate(anchors []Anchor) *Stats {
+	stats := &Stats{N: len(anchors)}
+	if len(anchors) == 0 {
+		return stats
+	}
+
+	mean := 0.0
+	for _, a := range anchors {
+		mean += a.RT
+	}
+	mean /= float64(len(anchors))
+
+	var ssRes, ssTot float64
+	for _, a := range anchors {
+		pred := m.Predict(a.IRT)
+		res := a.RT - pred
+		ssRes += res * res
+		ssTot += (a.RT - mean) * (a.RT - mean)
+		stats.MaxAbsResidual = math.Max(stats.MaxAbsResidual, math.Abs(res))
+		stats.Residuals = append(stats.Residuals, Residual{
+			Sequence:  a.Sequence,
+			IRT:       a.IRT,
+			RT:        a.RT,
+			Predicted: pred,
+			Residual:  res,
+		})
+	}
+
+	stats.RMSE = math.Sqrt(ssRes / float64(len(anchors)))
+	if ssTot > 0 {
+		stats.RSquared = 1 - ssRes/ssTot
+	}
+	return stats
+}
+
+// Save writes the model as JSON
+func (m *Model) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Load reads a model saved with Save
+func Load(r io.Reader) (*Model, error) {
+	var m Model
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode model: %w", err)
+	}
+
+	switch m.Type {
+	case ModelLinear:
+	case ModelPiecewise, ModelLowess:
+		if len(m.Knots) < 2 {
+			return nil, fmt.Errorf("%s model requires at least 2 knots", m.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown model type '%s'", m.Type)
+	}
+
+	return &m, nil
+}
+
+// fitLinear fits y = slope*x + intercept by ordinary least squares
+func fitLinear(xs, ys []float64) (*Model, error) {
+	slope, intercept, ok := leastSquares(xs, ys, nil)
+	if !ok {
+		return nil, fmt.Errorf("linear fit is degenerate")
+	}
+	return &Model{Type: ModelLinear, Slope: slope, Intercept: intercept}, nil
+}
+
+// fitPiecewise fits a continuous piecewise linear model with breakpoints at
+// quantiles of x, using a hinge function basis
+func fitPiecewise(xs, ys []float64, segments int) (*Model, error) {
+	if segments < 1 {
+		segments = 1
+	}
+	// Each segment needs at least two points to be determined
+	if maxSegments := len(xs) / 2; segments > maxSegments {
+		segments = maxSegments
+	}
+
+	var breaks []float64
+	for i := 1; i < segments; i++ {
+		b := quantile(xs, float64(i)/float64(segments))
+		if b > xs[0] && b < xs[len(xs)-1] && (len(breaks) == 0 || b > breaks[len(breaks)-1]) {
+			breaks = append(breaks, b)
+		}
+	}
+
+	// Basis: 1, x, max(0, x - b_j)
+	n := 2 + len(breaks)
+	basis := func(x float64) []float64 {
+		row := make([]float64, n)
+		row[0] = 1
+		row[1] = x
+		for j, b := range breaks {
+			row[2+j] = math.Max(0, x-b)
+		}
+		return row
+	}
+
+	ata := make([][]float64, n)
+	for i := range ata {
+		ata[i] = make([]float64, n)
+	}
+	atb := make([]float64, n)
+	for i, x := range xs {
+		row := basis(x)
+		for r := 0; r < n; r++ {
+			atb[r] += row[r] * ys[i]
+			for c := 0; c < n; c++ {
+				ata[r][c] += row[r] * row[c]
+			}
+		}
+	}
+
+	coef, ok := solve(ata, atb)
+	if !ok {
+		return nil, fmt.Errorf("piecewise fit is degenerate, try fewer segments")
+	}
+
+	eval := func(x float64) float64 {
+		y := 0.0
+		for i, v := range basis(x) {
+			y += coef[i] * v
+		}
+		return y
+	}
+
+	knotXs := append([]float64{xs[0]}, breaks...)
+	knotXs = append(knotXs, xs[len(xs)-1])
+	knots := make([]Point, len(knotXs))
+	for i, x := range knotXs {
+		knots[i] = Point{X: x, Y: eval(x)}
+	}
+
+	return &Model{Type: ModelPiecewise, Knots: knots}, nil
+}
+
+// fitLowess fits a locally weighted linear regression (Cleveland 1979) and
+// stores the smoothed values at each distinct x as knots
+func fitLowess(xs, ys []float64, span float64, iterations int) (*Model, error) {
+	if span <= 0 || span > 1 {
+		return nil, fmt.Errorf("LOWESS span must be in (0, 1], got %g", span)
+	}
+	if iterations < 0 {
+		iterations = 0
+	}
+
+	n := len(xs)
+	k := int(math.Ceil(span * float64(n)))
+	if k < 2 {
+		k = 2
+	}
+
+	robust := make([]float64, n)
+	for i := range robust {
+		robust[i] = 1
+	}
+	fitted := make([]float64, n)
+
+	for iter := 0; iter <= iterations; iter++ {
+		for i := 0; i < n; i++ {
+			fitted[i] = lowessPoint(xs, ys, robust, i, k)
+		}
+
+		if iter == iterations {
+			break
+		}
+
+		// Update robustness weights with the bisquare of the residuals
+		residuals := make([]float64, n)
+		for i := range residuals {
+			residuals[i] = math.Abs(ys[i] - fitted[i])
+		}
+		s := median(residuals)
+		if s == 0 {
+			break
+		}
+		for i, r := range residuals {
+			u := r / (6 * s)
+			if u < 1 {
+				robust[i] = (1 - u*u) * (1 - u*u)
+			} else {
+				robust[i] = 0
+			}
+		}
+	}
+
+	var knots []Point
+	for i := 0; i < n; i++ {
+		if len(knots) > 0 && knots[len(knots)-1].X == xs[i] {
+			continue
+		}
+		knots = append(knots, Point{X: xs[i], Y: fitted[i]})
+	}
+	if len(knots) < 2 {
+		return nil, fmt.Errorf("LOWESS fit requires at least 2 distinct iRT values")
+	}
+
+	return &Model{Type: ModelLowess, Knots: knots}, nil
+}
+
+// lowessPoint computes the LOWESS estimate at xs[i] using its k nearest neighbours
+func lowessPoint(xs, ys, robust []float64, i, k int) float64 {
+	n := len(xs)
+	x0 := xs[i]
+
+	// Expand a window of k nearest neighbours around i (xs is sorted)
+	lo, hi := i, i
+	for hi-lo+1 < k {
+		switch {
+		case lo == 0:
+			hi++
+		case hi == n-1:
+			lo--
+		case x0-xs[lo-1] <= xs[hi+1]-x0:
+			lo--
+		default:
+			hi++
+		}
+	}
+
+	h := math.Max(x0-xs[lo], xs[hi]-x0)
+	weights := make([]float64, n)
+	for j := lo; j <= hi; j++ {
+		w := 1.0
+		if h > 0 {
+			u := math.Abs(xs[j]-x0) / h
+			if u < 1 {
+				w = math.Pow(1-u*u*u, 3)
+			} else {
+				w = 0
+			}
+		}
+		weights[j] = w * robust[j]
+	}
+
+	slope, intercept, ok := leastSquares(xs[lo:hi+1], ys[lo:hi+1], weights[lo:hi+1])
+	if !ok {
+		// Fall back to the weighted mean when the neighbourhood is degenerate
+		sw, swy := 0.0, 0.0
+		for j := lo; j <= hi; j++ {
+			sw += weights[j]
+			swy += weights[j] * ys[j]
+		}
+		if sw == 0 {
+			return ys[i]
+		}
+		return swy / sw
+	}
+	return slope*x0 + intercept
+}
+
+// leastSquares fits a weighted straight line; nil weights means unweighted
+func leastSquares(xs, ys, weights []float64) (slope, intercept float64, ok bool) {
+	var sw, sx, sy float64
+	for i := range xs {
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+		sw += w
+		sx += w * xs[i]
+		sy += w * ys[i]
+	}
+	if sw == 0 {
+		return 0, 0, false
+	}
+	mx, my := sx/sw, sy/sw
+
+	var sxx, sxy float64
+	for i := range xs {
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+		sxx += w * (xs[i] - mx) * (xs[i] - mx)
+		sxy += w * (xs[i] - mx) * (ys[i] - my)
+	}
+	if sxx == 0 {
+		return 0, 0, false
+	}
+
+	slope = sxy / sxx
+	return slope, my - slope*mx, true
+}
+
+// solve solves a small dense linear system by Gaussian elimination with partial pivoting
+func solve(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = append(append([]float64{}, a[i]...), b[i])
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		for r := col + 1; r < n; r++ {
+			f := m[r][col] / m[col][col]
+			for c := col; c <= n; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		sum := m[r][n]
+		for c := r + 1; c < n; c++ {
+			sum -= m[r][c] * x[c]
+		}
+		x[r] = sum / m[r][r]
+	}
+	return x, true
+}
+
+// quantile returns the q-quantile of sorted values by linear interpolation
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(i)
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+
+// median returns the median of values without modifying them
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return quantile(sorted, 0.5)
+}
@@ -0,0 +1,115 @@
+package calibration
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func linearAnchors() []Anchor {
+	var anchors []Anchor
+	for i := 0; i < 10; i++ {
+		x := float64(i*10 - 20)
+		anchors = append(anchors, Anchor{IRT: x, RT: 0.5*x + 30})
+	}
+	return anchors
+}
+
+func TestFitExactLinear(t *testing.T) {
+	for _, modelType := range []string{ModelLinear, ModelPiecewise, ModelLowess} {
+		t.Run(modelType, func(t *testing.T) {
+			model, err := Fit(linearAnchors(), modelType, DefaultOptions())
+			if err != nil {
+				t.Fatalf("Fit() error = %v", err)
+			}
+			if math.Abs(model.Stats.RSquared-1) > 1e-6 {
+				t.Errorf("RSquared = %.6f, want 1", model.Stats.RSquared)
+			}
+			// Extrapolation beyond the anchor range follows the end segments
+			if got := model.Predict(100); math.Abs(got-80) > 1e-6 {
+				t.Errorf("Predict(100) = %.4f, want 80", got)
+			}
+		})
+	}
+}
+
+func TestFitPiecewiseBend(t *testing.T) {
+	var anchors []Anchor
+	for i := 0; i <= 20; i++ {
+		x := float64(i)
+		y := x
+		if x > 10 {
+			y = 10 + 3*(x-10)
+		}
+		anchors = append(anchors, Anchor{IRT: x, RT: y})
+	}
+
+	opts := DefaultOptions()
+	opts.Segments = 2
+	piecewise, err := Fit(anchors, ModelPiecewise, opts)
+	if err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+	linear, err := Fit(anchors, ModelLinear, opts)
+	if err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+
+	if piecewise.Stats.RMSE >= linear.Stats.RMSE {
+		t.Errorf("piecewise RMSE %.4f should be below linear RMSE %.4f", piecewise.Stats.RMSE, linear.Stats.RMSE)
+	}
+}
+
+func TestFitErrors(t *testing.T) {
+	if _, err := Fit([]Anchor{{IRT: 1, RT: 1}}, ModelLinear, DefaultOptions()); err == nil {
+		t.Error("expected error for a single anchor")
+	}
+	if _, err := Fit(linearAnchors(), "spline", DefaultOptions()); err == nil {
+		t.Error("expected error for unknown model type")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	model, err := Fit(linearAnchors(), ModelLowess, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := model.Save(&buf); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for _, x := range []float64{-50, 0, 33.3, 200} {
+		if got, want := loaded.Predict(x), model.Predict(x); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Predict(%g) = %.6f after reload, want %.6f", x, got, want)
+		}
+	}
+}
+
+func TestLoadAnchorsCSV(t *testing.T) {
+	input := "Sequence,iRT,RT\nPEPTIDEK,10.5,22.1\n\nLGGNEQVTR,-24.9,12.0\n"
+	anchors, err := LoadAnchorsCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("LoadAnchorsCSV() error = %v", err)
+	}
+	if len(anchors) != 2 {
+		t.Fatalf("got %d anchors, want 2", len(anchors))
+	}
+	if anchors[1].Sequence != "LGGNEQVTR" || anchors[1].IRT != -24.9 || anchors[1].RT != 12.0 {
+		t.Errorf("unexpected anchor %+v", anchors[1])
+	}
+
+	anchors, err = LoadAnchorsCSV(strings.NewReader("Sequence,RT\nPEPTIDEK,22.1\n"))
+	if err != nil {
+		t.Fatalf("LoadAnchorsCSV() error = %v", err)
+	}
+	if !math.IsNaN(anchors[0].IRT) {
+		t.Errorf("IRT = %v, want NaN when not provided", anchors[0].IRT)
+	}
+}
@@ -0,0 +1,194 @@
+// Package core provides ESI adduct calculations
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// adductAliases are common abbreviations used in adduct names
+var adductAliases = map[string]string{
+	"ACN":     "C2H3N",
+	"FA":      "CH2O2",
+	"MeOH":    "CH4O",
+	"IsoProp": "C3H8O",
+	"DMSO":    "C2H6OS",
+	"TFA":     "C2HF3O2",
+	"Hac":     "C2H4O2",
+	"HAc":     "C2H4O2",
+}
+
+// Adduct is a precursor ion type such as [M+H]+ or [2M+Na]+
+type Adduct struct {
+	Name     string  // Canonical name, e.g. "[M+H]+"
+	Multimer int     // Number of M, e.g. 2 for [2M+H]+
+	Delta    Formula // Atoms added (positive) and lost (negative)
+	Charge   int     // Signed charge
+}
+
+// ParseAdduct parses an adduct such as "[M+H]+", "[M+Na]+", "[M-H]-",
+// "[M+2H]2+", "[M+H-H2O]+" or "[2M+NH4]+"
+func ParseAdduct(s string) (Adduct, error) {
+	s = strings.TrimSpace(s)
+	open := strings.Index(s, "[")
+	end := strings.LastIndex(s, "]")
+	if open != 0 || end < 0 {
+		return Adduct{}, fmt.Errorf("invalid adduct '%s', expected e.g. [M+H]+", s)
+	}
+
+	charge, ok := parseChargeSuffix(s[end+1:])
+	if !ok {
+		return Adduct{}, fmt.Errorf("invalid adduct '%s': missing charge, expected e.g. [M+H]+", s)
+	}
+
+	body := s[1:end]
+	m := strings.Index(body, "M")
+	if m < 0 {
+		return Adduct{}, fmt.Errorf("invalid adduct '%s': missing M", s)
+	}
+	multimer := 1
+	if m > 0 {
+		n, err := strconv.Atoi(body[:m])
+		if err != nil || n <= 0 {
+			return Adduct{}, fmt.Errorf("invalid adduct '%s': bad multimer '%s'", s, body[:m])
+		}
+		multimer = n
+	}
+
+	// Terms such as "+2H", "-H2O" or "+Na"
+	delta := Formula{}
+	rest := body[m+1:]
+	for rest != "" {
+		sign := 1
+		switch rest[0] {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return Adduct{}, fmt.Errorf("invalid adduct '%s': expected + or - before '%s'", s, rest)
+		}
+		rest = rest[1:]
+
+		next := strings.IndexAny(rest, "+-")
+		if next < 0 {
+			next = len(rest)
+		}
+		term := rest[:next]
+		rest = rest[next:]
+
+		count := 1
+		i := 0
+		for i < len(term) && term[i] >= '0' && term[i] <= '9' {
+			i++
+		}
+		if i > 0 {
+			count, _ = strconv.Atoi(term[:i])
+			term = term[i:]
+		}
+		if alias, ok := adductAliases[term]; ok {
+			term = alias
+		}
+
+		f, err := ParseFormula(term)
+		if err != nil || len(f) == 0 {
+			return Adduct{}, fmt.Errorf("invalid adduct '%s': bad term '%s'", s, term)
+		}
+		delta = delta.Add(f.Mul(sign * count))
+	}
+
+	return Adduct{Name: s, Multimer: multimer, Delta: delta, Charge: charge}, nil
+}
+
+// ProtonAdduct returns the protonated ([M+zH]z+) or deprotonated
+// ([M-zH]z-) adduct for a signed charge
+func ProtonAdduct(charge int) Adduct {
+	z := charge
+	if z < 0 {
+		z = -z
+	}
+
+	var name strings.Builder
+	name.WriteString("[M")
+	if charge < 0 {
+		name.WriteString("-")
+	} else {
+		name.WriteString("+")
+	}
+	if z > 1 {
+		name.WriteString(strconv.Itoa(z))
+	}
+	name.WriteString("H]")
+	if z > 1 {
+		name.WriteString(strconv.Itoa(z))
+	}
+	if charge < 0 {
+		name.WriteString("-")
+	} else {
+		name.WriteString("+")
+	}
+
+	return Adduct{
+		Name:     name.String(),
+		Multimer: 1,
+		Delta:    Formula{Atom{Symbol: "H"}: charge},
+		Charge:   charge,
+	}
+}
+
+// MZ returns the m/z of the adduct ion for a neutral monoisotopic mass
+func (a Adduct) MZ(neutralMass float64) float64 {
+	ionMass := float64(a.Multimer)*neutralMass + a.Delta.MonoisotopicMass() - float64(a.Charge)*ElectronMass
+	return ionMass / math.Abs(float64(a.Charge))
+}
+
+// NeutralMass returns the neutral monoisotopic mass for an observed m/z
+func (a Adduct) NeutralMass(mz float64) float64 {
+	ionMass := mz * math.Abs(float64(a.Charge))
+	return (ionMass + float64(a.Charge)*ElectronMass - a.Delta.MonoisotopicMass()) / float64(a.Multimer)
+}
+
+func (a Adduct) String() string {
+	return a.Name
+}
+
+// PrecursorTypeCharge returns the signed charge of a precursor ion type such
+// as "[M+H]+", "[M+2H]2+" or "[M-H]-". It returns false if the type has no
+// charge suffix.
+func PrecursorTypeCharge(precursorType string) (int, bool) {
+	t := strings.TrimSpace(precursorType)
+	if i := strings.LastIndex(t, "]"); i >= 0 {
+		t = t[i+1:]
+	} else {
+		// Unbracketed types such as "M+H+" only carry a final sign
+		t = t[len(t)-min(len(t), 1):]
+	}
+	return parseChargeSuffix(t)
+}
+
+// parseChargeSuffix parses a charge suffix such as "+", "2+" or "3-"
+func parseChargeSuffix(t string) (int, bool) {
+	if t == "" {
+		return 0, false
+	}
+
+	sign := 1
+	switch t[len(t)-1] {
+	case '+':
+	case '-':
+		sign = -1
+	default:
+		return 0, false
+	}
+
+	n := 1
+	if digits := t[:len(t)-1]; digits != "" {
+		v, err := strconv.Atoi(digits)
+		if err != nil || v <= 0 {
+			return 0, false
+		}
+		n = v
+	}
+	return sign * n, true
+}
@@ -0,0 +1,53 @@
+// Package core provides fragment ion annotation parsing
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// IonAnnotation is a parsed fragment ion annotation
+type IonAnnotation struct {
+	Type   string // Ion series, e.g. "b" or "y"
+	Number int    // Position in the series
+	Loss   string // Neutral loss as written, e.g. "H2O" or "18"; empty for none
+	Charge int    // Fragment charge, 1 if not given
+}
+
+// annotationPattern matches "y3", "b2^2", "y5-H2O" and "y5-18^2"
+var annotationPattern = regexp.MustCompile(`^([a-z])(\d+)(?:-([A-Za-z0-9]+))?(?:\^(\d+))?$`)
+
+// ParseAnnotation parses a fragment ion annotation such as "y3", "b2^2" or
+// the SpectraST form with a neutral loss, "y5-18^2"
+func ParseAnnotation(s string) (IonAnnotation, error) {
+	m := annotationPattern.FindStringSubmatch(s)
+	if m == nil {
+		return IonAnnotation{}, fmt.Errorf("invalid ion annotation '%s'", s)
+	}
+
+	a := IonAnnotation{Type: m[1], Loss: m[3], Charge: 1}
+	var err error
+	if a.Number, err = strconv.Atoi(m[2]); err != nil {
+		return IonAnnotation{}, fmt.Errorf("invalid position in annotation '%s'", s)
+	}
+	if m[4] != "" {
+		if a.Charge, err = strconv.Atoi(m[4]); err != nil || a.Charge == 0 {
+			return IonAnnotation{}, fmt.Errorf("invalid charge in annotation '%s'", s)
+		}
+	}
+	return a, nil
+}
+
+// String formats the annotation as parsed by ParseAnnotation, omitting a
+// charge of 1
+func (a IonAnnotation) String() string {
+	s := a.Type + strconv.Itoa(a.Number)
+	if a.Loss != "" {
+		s += "-" + a.Loss
+	}
+	if a.Charge > 1 {
+		s += "^" + strconv.Itoa(a.Charge)
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+package core
+
+import "testing"
+
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    IonAnnotation
+		wantErr bool
+	}{
+		{input: "y3", want: IonAnnotation{Type: "y", Number: 3, Charge: 1}},
+		{input: "b2^2", want: IonAnnotation{Type: "b", Number: 2, Charge: 2}},
+		{input: "y10^3", want: IonAnnotation{Type: "y", Number: 10, Charge: 3}},
+		{input: "y5-H2O", want: IonAnnotation{Type: "y", Number: 5, Loss: "H2O", Charge: 1}},
+		{input: "y5-18^2", want: IonAnnotation{Type: "y", Number: 5, Loss: "18", Charge: 2}},
+		{input: "?", wantErr: true},
+		{input: "y", wantErr: true},
+		{input: "p-H2O", wantErr: true},
+		{input: "y3^0", wantErr: true},
+		{input: "y3i", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAnnotation(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAnnotation(%q) expected error, got %+v", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAnnotation(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAnnotation(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+		if s := got.String(); s != tt.input {
+			t.Errorf("ParseAnnotation(%q).String() = %q", tt.input, s)
+		}
+	}
+}
@@ -47,6 +47,7 @@ var AminoAcidMasses = map[rune]AminoAcidComposition{
 
 // CalculatePeptideMass computes monoisotopic mass of a peptide sequence
 // including modifications, then returns the m/z for a given charge state.
+// Negative charges give the m/z of the deprotonated ion.
 func CalculatePeptideMass(sequence string, charge int, modifications []Modification) float64 {
 	comp := AminoAcidComposition{C: 0, H: 2, N: 0, O: 1, S: 0} // Add water
 
@@ -71,12 +72,25 @@ func CalculatePeptideMass(sequence string, charge int, modifications []Modificat
 		mass += mod.Mass
 	}
 
-	// Calculate m/z: (mass + charge * proton) / charge
-	mz := (mass + float64(charge)*ProtonMass) / float64(charge)
+	// Calculate m/z: (mass + charge * proton) / |charge|
+	mz := (mass + float64(charge)*ProtonMass) / math.Abs(float64(charge))
 
 	return mz
 }
 
+// ResidueMass returns the monoisotopic residue mass of an amino acid
+func ResidueMass(aa rune) (float64, bool) {
+	comp, ok := AminoAcidMasses[aa]
+	if !ok {
+		return 0, false
+	}
+	return float64(comp.C)*MassC +
+		float64(comp.H)*MassH +
+		float64(comp.N)*MassN +
+		float64(comp.O)*MassO +
+		float64(comp.S)*MassS, true
+}
+
 // CalculateNeutralMass computes the neutral monoisotopic mass of a peptide
 func CalculateNeutralMass(sequence string, modifications []Modification) float64 {
 	comp := AminoAcidComposition{C: 0, H: 2, N: 0, O: 1, S: 0} // Add water
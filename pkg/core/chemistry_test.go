@@ -30,6 +30,14 @@ func TestCalculatePeptideMass(t *testing.T) {
 			wantMZ:        116.569, // Approximate
 			tolerance:     0.1,
 		},
+		{
+			name:          "negative charge",
+			sequence:      "AAA",
+			charge:        -1,
+			modifications: nil,
+			wantMZ:        230.115, // Approximate
+			tolerance:     0.1,
+		},
 		{
 			name:     "peptide with modification",
 			sequence: "PEPTIDE",
@@ -88,6 +96,96 @@ func TestCalculateNeutralMass(t *testing.T) {
 	}
 }
 
+func TestResidueMass(t *testing.T) {
+	tests := []struct {
+		aa   rune
+		want float64
+	}{
+		{'G', 57.021464},
+		{'C', 103.009185},
+		{'M', 131.040485},
+		{'K', 128.094963},
+	}
+	for _, tt := range tests {
+		got, ok := ResidueMass(tt.aa)
+		if !ok || math.Abs(got-tt.want) > 1e-5 {
+			t.Errorf("ResidueMass(%c) = %f, %v, want %f", tt.aa, got, ok, tt.want)
+		}
+	}
+	if _, ok := ResidueMass('X'); ok {
+		t.Error("ResidueMass(X) should not be found")
+	}
+}
+
+func TestNameForMass(t *testing.T) {
+	db := DefaultModDatabase()
+
+	if name, ok := db.NameForMass(15.9949, 0.01); !ok || name != "Oxidation" {
+		t.Errorf("NameForMass(15.9949) = %q, %v, want Oxidation", name, ok)
+	}
+	// Mass shared by several names resolves to the first name
+	if name, ok := db.NameForMass(229.1629, 0.01); !ok || name != "TMT" {
+		t.Errorf("NameForMass(229.1629) = %q, %v, want TMT", name, ok)
+	}
+	if _, ok := db.NameForMass(1.5, 0.01); ok {
+		t.Error("NameForMass(1.5) should not be found")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	db := DefaultModDatabase()
+	db.Add("UniMod:121", 114.042927)
+
+	tests := []struct {
+		token string
+		name  string
+		mass  float64
+		ok    bool
+	}{
+		{"Oxidation", "Oxidation", 15.994915, true},
+		{"Oxidation (M)", "Oxidation", 15.994915, true},
+		{"Acetyl (Protein N-term)", "Acetyl", 42.010565, true},
+		{"UniMod:4", "Carbamidomethyl", 57.021464, true},
+		{"UNIMOD:35", "Oxidation", 15.994915, true},
+		{"UniMod:121", "UniMod:121", 114.042927, true},
+		{"+15.9949", "Oxidation", 15.9949, true},
+		{"-0.9840", "Amidated", -0.984, true},
+		{"+1.5", "+1.5", 1.5, true},
+		{"UniMod:99999", "", 0, false},
+		{"Unknown (K)", "", 0, false},
+		{"160", "", 0, false},
+	}
+	for _, tt := range tests {
+		name, mass, ok := db.Resolve(tt.token)
+		if ok != tt.ok || name != tt.name || math.Abs(mass-tt.mass) > 1e-6 {
+			t.Errorf("Resolve(%q) = %q, %f, %v, want %q, %f, %v", tt.token, name, mass, ok, tt.name, tt.mass, tt.ok)
+		}
+	}
+}
+
+func TestUniModAccession(t *testing.T) {
+	db := DefaultModDatabase()
+
+	tests := []struct {
+		name string
+		mass float64
+		want int
+		ok   bool
+	}{
+		{"Oxidation", 15.994915, 35, true},
+		{"Carbamidomethyl", 57.021464, 4, true},
+		{"TMT10plex", 229.162932, 737, true},
+		{"160", 57.0215, 4, true},
+		{"Unknown", 1.5, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := db.UniModAccession(tt.name, tt.mass)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("UniModAccession(%q, %f) = %d, %v, want %d, %v", tt.name, tt.mass, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
 func TestRoundFloat(t *testing.T) {
 	tests := []struct {
 		name      string
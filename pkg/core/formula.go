@@ -0,0 +1,406 @@
+// Package core provides elemental formulas for arbitrary compounds
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ElectronMass is the rest mass of an electron in Da
+const ElectronMass = 0.000548579909
+
+// Isotope is one isotope of an element with its natural abundance
+type Isotope struct {
+	MassNumber int
+	Mass       float64
+	Abundance  float64
+}
+
+// Element is a chemical element with its stable isotopes
+type Element struct {
+	Symbol   string
+	Isotopes []Isotope
+}
+
+// MonoisotopicMass returns the mass of the most abundant isotope
+func (e Element) MonoisotopicMass() float64 {
+	best := e.Isotopes[0]
+	for _, iso := range e.Isotopes[1:] {
+		if iso.Abundance > best.Abundance {
+			best = iso
+		}
+	}
+	return best.Mass
+}
+
+// AverageMass returns the abundance-weighted mass of the element
+func (e Element) AverageMass() float64 {
+	mass, total := 0.0, 0.0
+	for _, iso := range e.Isotopes {
+		mass += iso.Mass * iso.Abundance
+		total += iso.Abundance
+	}
+	return mass / total
+}
+
+// Isotope returns the isotope with the given mass number
+func (e Element) Isotope(massNumber int) (Isotope, bool) {
+	for _, iso := range e.Isotopes {
+		if iso.MassNumber == massNumber {
+			return iso, true
+		}
+	}
+	return Isotope{}, false
+}
+
+// Elements maps the symbols of the stable elements, and of thorium and
+// uranium, to their isotope masses and natural abundances (NIST)
+var Elements = map[string]Element{
+	"H":  {"H", []Isotope{{1, 1.00782503207, 0.999885}, {2, 2.0141017778, 0.000115}}},
+	"He": {"He", []Isotope{{3, 3.0160293191, 0.00000134}, {4, 4.00260325415, 0.99999866}}},
+	"Li": {"Li", []Isotope{{6, 6.015122795, 0.0759}, {7, 7.01600455, 0.9241}}},
+	"Be": {"Be", []Isotope{{9, 9.0121822, 1}}},
+	"B":  {"B", []Isotope{{10, 10.0129370, 0.199}, {11, 11.0093054, 0.801}}},
+	"C":  {"C", []Isotope{{12, 12.0, 0.9893}, {13, 13.0033548378, 0.0107}}},
+	"N":  {"N", []Isotope{{14, 14.0030740048, 0.99636}, {15, 15.0001088982, 0.00364}}},
+	"O":  {"O", []Isotope{{16, 15.99491461956, 0.99757}, {17, 16.99913170, 0.00038}, {18, 17.9991610, 0.00205}}},
+	"F":  {"F", []Isotope{{19, 18.99840322, 1}}},
+	"Ne": {"Ne", []Isotope{{20, 19.9924401754, 0.9048}, {21, 20.99384668, 0.0027}, {22, 21.991385114, 0.0925}}},
+	"Na": {"Na", []Isotope{{23, 22.9897692809, 1}}},
+	"Mg": {"Mg", []Isotope{{24, 23.985041700, 0.7899}, {25, 24.98583692, 0.1000}, {26, 25.982592929, 0.1101}}},
+	"Al": {"Al", []Isotope{{27, 26.98153863, 1}}},
+	"Si": {"Si", []Isotope{{28, 27.9769265325, 0.92223}, {29, 28.976494700, 0.04685}, {30, 29.97377017, 0.03092}}},
+	"P":  {"P", []Isotope{{31, 30.97376163, 1}}},
+	"S":  {"S", []Isotope{{32, 31.97207100, 0.9499}, {33, 32.97145876, 0.0075}, {34, 33.96786690, 0.0425}, {36, 35.96708076, 0.0001}}},
+	"Cl": {"Cl", []Isotope{{35, 34.96885268, 0.7576}, {37, 36.96590259, 0.2424}}},
+	"Ar": {"Ar", []Isotope{{36, 35.967545106, 0.003365}, {38, 37.9627324, 0.000632}, {40, 39.9623831225, 0.996003}}},
+	"K":  {"K", []Isotope{{39, 38.96370668, 0.932581}, {40, 39.96399848, 0.000117}, {41, 40.96182576, 0.067302}}},
+	"Ca": {"Ca", []Isotope{{40, 39.96259098, 0.96941}, {42, 41.95861801, 0.00647}, {43, 42.9587666, 0.00135}, {44, 43.9554818, 0.02086}, {46, 45.9536926, 0.00004}, {48, 47.952534, 0.00187}}},
+	"Sc": {"Sc", []Isotope{{45, 44.9559119, 1}}},
+	"Ti": {"Ti", []Isotope{{46, 45.9526316, 0.0825}, {47, 46.9517631, 0.0744}, {48, 47.9479463, 0.7372}, {49, 48.9478700, 0.0541}, {50, 49.9447912, 0.0518}}},
+	"V":  {"V", []Isotope{{50, 49.9471585, 0.0025}, {51, 50.9439595, 0.9975}}},
+	"Cr": {"Cr", []Isotope{{50, 49.9460442, 0.04345}, {52, 51.9405075, 0.83789}, {53, 52.9406494, 0.09501}, {54, 53.9388804, 0.02365}}},
+	"Mn": {"Mn", []Isotope{{55, 54.9380451, 1}}},
+	"Fe": {"Fe", []Isotope{{54, 53.9396105, 0.05845}, {56, 55.9349375, 0.91754}, {57, 56.9353940, 0.02119}, {58, 57.9332756, 0.00282}}},
+	"Co": {"Co", []Isotope{{59, 58.9331950, 1}}},
+	"Ni": {"Ni", []Isotope{{58, 57.9353429, 0.680769}, {60, 59.9307864, 0.262231}, {61, 60.9310560, 0.011399}, {62, 61.9283451, 0.036345}, {64, 63.9279660, 0.009256}}},
+	"Cu": {"Cu", []Isotope{{63, 62.9295975, 0.6915}, {65, 64.9277895, 0.3085}}},
+	"Zn": {"Zn", []Isotope{{64, 63.9291422, 0.48268}, {66, 65.9260334, 0.27975}, {67, 66.9271273, 0.04102}, {68, 67.9248442, 0.19024}, {70, 69.9253193, 0.00631}}},
+	"Ga": {"Ga", []Isotope{{69, 68.9255736, 0.60108}, {71, 70.9247013, 0.39892}}},
+	"Ge": {"Ge", []Isotope{{70, 69.9242474, 0.2038}, {72, 71.9220758, 0.2731}, {73, 72.9234589, 0.0776}, {74, 73.9211778, 0.3672}, {76, 75.9214026, 0.0783}}},
+	"As": {"As", []Isotope{{75, 74.9215965, 1}}},
+	"Se": {"Se", []Isotope{{74, 73.9224764, 0.0089}, {76, 75.9192136, 0.0937}, {77, 76.9199140, 0.0763}, {78, 77.9173091, 0.2377}, {80, 79.9165213, 0.4961}, {82, 81.9166994, 0.0873}}},
+	"Br": {"Br", []Isotope{{79, 78.9183371, 0.5069}, {81, 80.9162906, 0.4931}}},
+	"Kr": {"Kr", []Isotope{{78, 77.9203648, 0.00355}, {80, 79.9163790, 0.02286}, {82, 81.9134836, 0.11593}, {83, 82.914136, 0.11500}, {84, 83.911507, 0.56987}, {86, 85.91061073, 0.17279}}},
+	"Rb": {"Rb", []Isotope{{85, 84.911789738, 0.7217}, {87, 86.909180527, 0.2783}}},
+	"Sr": {"Sr", []Isotope{{84, 83.913425, 0.0056}, {86, 85.9092602, 0.0986}, {87, 86.9088771, 0.0700}, {88, 87.9056121, 0.8258}}},
+	"Y":  {"Y", []Isotope{{89, 88.9058483, 1}}},
+	"Zr": {"Zr", []Isotope{{90, 89.9047044, 0.5145}, {91, 90.9056458, 0.1122}, {92, 91.9050408, 0.1715}, {94, 93.9063152, 0.1738}, {96, 95.9082734, 0.0280}}},
+	"Nb": {"Nb", []Isotope{{93, 92.9063781, 1}}},
+	"Mo": {"Mo", []Isotope{{92, 91.906811, 0.1453}, {94, 93.9050883, 0.0915}, {95, 94.9058421, 0.1584}, {96, 95.9046795, 0.1667}, {97, 96.9060215, 0.0960}, {98, 97.9054082, 0.2439}, {100, 99.907477, 0.0982}}},
+	"Ru": {"Ru", []Isotope{{96, 95.907598, 0.0554}, {98, 97.905287, 0.0187}, {99, 98.9059393, 0.1276}, {100, 99.9042195, 0.1260}, {101, 100.9055821, 0.1706}, {102, 101.9043493, 0.3155}, {104, 103.905433, 0.1862}}},
+	"Rh": {"Rh", []Isotope{{103, 102.905504, 1}}},
+	"Pd": {"Pd", []Isotope{{102, 101.905609, 0.0102}, {104, 103.904036, 0.1114}, {105, 104.905085, 0.2233}, {106, 105.903486, 0.2733}, {108, 107.903892, 0.2646}, {110, 109.905153, 0.1172}}},
+	"Ag": {"Ag", []Isotope{{107, 106.905097, 0.51839}, {109, 108.904752, 0.48161}}},
+	"Cd": {"Cd", []Isotope{{106, 105.906459, 0.0125}, {108, 107.904184, 0.0089}, {110, 109.9030021, 0.1249}, {111, 110.9041781, 0.1280}, {112, 111.9027578, 0.2413}, {113, 112.9044017, 0.1222}, {114, 113.9033585, 0.2873}, {116, 115.904756, 0.0749}}},
+	"In": {"In", []Isotope{{113, 112.904058, 0.0429}, {115, 114.903878, 0.9571}}},
+	"Sn": {"Sn", []Isotope{{112, 111.904818, 0.0097}, {114, 113.902779, 0.0066}, {115, 114.903342, 0.0034}, {116, 115.901741, 0.1454}, {117, 116.902952, 0.0768}, {118, 117.901603, 0.2422}, {119, 118.903308, 0.0859}, {120, 119.9021947, 0.3258}, {122, 121.9034390, 0.0463}, {124, 123.9052739, 0.0579}}},
+	"Sb": {"Sb", []Isotope{{121, 120.9038157, 0.5721}, {123, 122.9042140, 0.4279}}},
+	"Te": {"Te", []Isotope{{120, 119.904020, 0.0009}, {122, 121.9030439, 0.0255}, {123, 122.9042700, 0.0089}, {124, 123.9028179, 0.0474}, {125, 124.9044307, 0.0707}, {126, 125.9033117, 0.1884}, {128, 127.9044631, 0.3174}, {130, 129.9062244, 0.3408}}},
+	"I":  {"I", []Isotope{{127, 126.904473, 1}}},
+	"Xe": {"Xe", []Isotope{{124, 123.9058930, 0.000952}, {126, 125.904274, 0.000890}, {128, 127.9035313, 0.019102}, {129, 128.9047794, 0.264006}, {130, 129.9035080, 0.040710}, {131, 130.9050824, 0.212324}, {132, 131.9041535, 0.269086}, {134, 133.9053945, 0.104357}, {136, 135.907219, 0.088573}}},
+	"Cs": {"Cs", []Isotope{{133, 132.905451933, 1}}},
+	"Ba": {"Ba", []Isotope{{130, 129.9063208, 0.00106}, {132, 131.9050613, 0.00101}, {134, 133.9045084, 0.02417}, {135, 134.9056886, 0.06592}, {136, 135.9045759, 0.07854}, {137, 136.9058274, 0.11232}, {138, 137.9052472, 0.71698}}},
+	"La": {"La", []Isotope{{138, 137.907112, 0.00090}, {139, 138.9063533, 0.99910}}},
+	"Ce": {"Ce", []Isotope{{136, 135.907172, 0.00185}, {138, 137.905991, 0.00251}, {140, 139.9054387, 0.88450}, {142, 141.909244, 0.11114}}},
+	"Pr": {"Pr", []Isotope{{141, 140.9076528, 1}}},
+	"Nd": {"Nd", []Isotope{{142, 141.9077233, 0.272}, {143, 142.9098143, 0.122}, {144, 143.9100873, 0.238}, {145, 144.9125736, 0.083}, {146, 145.9131169, 0.172}, {148, 147.916893, 0.057}, {150, 149.920891, 0.056}}},
+	"Sm": {"Sm", []Isotope{{144, 143.911999, 0.0307}, {147, 146.9148979, 0.1499}, {148, 147.9148227, 0.1124}, {149, 148.9171847, 0.1382}, {150, 149.9172755, 0.0738}, {152, 151.9197324, 0.2675}, {154, 153.9222093, 0.2275}}},
+	"Eu": {"Eu", []Isotope{{151, 150.9198502, 0.4781}, {153, 152.9212303, 0.5219}}},
+	"Gd": {"Gd", []Isotope{{152, 151.9197910, 0.0020}, {154, 153.9208656, 0.0218}, {155, 154.9226220, 0.1480}, {156, 155.9221227, 0.2047}, {157, 156.9239601, 0.1565}, {158, 157.9241039, 0.2484}, {160, 159.9270541, 0.2186}}},
+	"Tb": {"Tb", []Isotope{{159, 158.9253468, 1}}},
+	"Dy": {"Dy", []Isotope{{156, 155.924283, 0.00056}, {158, 157.924409, 0.00095}, {160, 159.9251975, 0.02329}, {161, 160.9269334, 0.18889}, {162, 161.9267984, 0.25475}, {163, 162.9287312, 0.24896}, {164, 163.9291748, 0.28260}}},
+	"Ho": {"Ho", []Isotope{{165, 164.9303221, 1}}},
+	"Er": {"Er", []Isotope{{162, 161.928778, 0.00139}, {164, 163.929200, 0.01601}, {166, 165.9302931, 0.33503}, {167, 166.9320482, 0.22869}, {168, 167.9323702, 0.26978}, {170, 169.9354643, 0.14910}}},
+	"Tm": {"Tm", []Isotope{{169, 168.9342133, 1}}},
+	"Yb": {"Yb", []Isotope{{168, 167.933897, 0.00123}, {170, 169.9347618, 0.02982}, {171, 170.9363258, 0.1409}, {172, 171.9363815, 0.2168}, {173, 172.9382108, 0.16103}, {174, 173.9388621, 0.32026}, {176, 175.9425717, 0.12996}}},
+	"Lu": {"Lu", []Isotope{{175, 174.9407718, 0.9741}, {176, 175.9426863, 0.0259}}},
+	"Hf": {"Hf", []Isotope{{174, 173.940046, 0.0016}, {176, 175.9414086, 0.0526}, {177, 176.9432207, 0.1860}, {178, 177.9436988, 0.2728}, {179, 178.9458161, 0.1362}, {180, 179.9465500, 0.3508}}},
+	"Ta": {"Ta", []Isotope{{180, 179.9474648, 0.00012}, {181, 180.9479958, 0.99988}}},
+	"W":  {"W", []Isotope{{180, 179.946704, 0.0012}, {182, 181.9482042, 0.2650}, {183, 182.9502230, 0.1431}, {184, 183.9509312, 0.3064}, {186, 185.9543641, 0.2843}}},
+	"Re": {"Re", []Isotope{{185, 184.9529550, 0.3740}, {187, 186.9557531, 0.6260}}},
+	"Os": {"Os", []Isotope{{184, 183.9524891, 0.0002}, {186, 185.9538382, 0.0159}, {187, 186.9557505, 0.0196}, {188, 187.9558382, 0.1324}, {189, 188.9581475, 0.1615}, {190, 189.9584470, 0.2626}, {192, 191.9614807, 0.4078}}},
+	"Ir": {"Ir", []Isotope{{191, 190.9605940, 0.373}, {193, 192.9629264, 0.627}}},
+	"Pt": {"Pt", []Isotope{{190, 189.959932, 0.00014}, {192, 191.9610380, 0.00782}, {194, 193.9626803, 0.32967}, {195, 194.9647911, 0.33832}, {196, 195.9649515, 0.25242}, {198, 197.967893, 0.07163}}},
+	"Au": {"Au", []Isotope{{197, 196.9665687, 1}}},
+	"Hg": {"Hg", []Isotope{{196, 195.965833, 0.0015}, {198, 197.9667690, 0.0997}, {199, 198.9682799, 0.1687}, {200, 199.9683260, 0.2310}, {201, 200.9703023, 0.1318}, {202, 201.9706430, 0.2986}, {204, 203.9734939, 0.0687}}},
+	"Tl": {"Tl", []Isotope{{203, 202.9723442, 0.2952}, {205, 204.9744275, 0.7048}}},
+	"Pb": {"Pb", []Isotope{{204, 203.9730436, 0.014}, {206, 205.9744653, 0.241}, {207, 206.9758969, 0.221}, {208, 207.9766521, 0.524}}},
+	"Bi": {"Bi", []Isotope{{209, 208.9803987, 1}}},
+	"Th": {"Th", []Isotope{{232, 232.0380553, 1}}},
+	"U":  {"U", []Isotope{{234, 234.0409521, 0.000054}, {235, 235.0439299, 0.007204}, {238, 238.0507882, 0.992742}}},
+}
+
+// Atom identifies an element, or one specific isotope of it when MassNumber
+// is not zero
+type Atom struct {
+	Symbol     string
+	MassNumber int
+}
+
+func (a Atom) String() string {
+	if a.MassNumber == 0 {
+		return a.Symbol
+	}
+	return fmt.Sprintf("[%d%s]", a.MassNumber, a.Symbol)
+}
+
+// mass returns the monoisotopic (or isotope) and average mass of the atom
+func (a Atom) mass() (mono, avg float64) {
+	el := Elements[a.Symbol]
+	if a.MassNumber != 0 {
+		iso, _ := el.Isotope(a.MassNumber)
+		return iso.Mass, iso.Mass
+	}
+	return el.MonoisotopicMass(), el.AverageMass()
+}
+
+// Formula is an elemental composition. Counts may be negative for formulas
+// describing a loss, e.g. the delta of an adduct.
+type Formula map[Atom]int
+
+// ParseFormula parses a formula such as "C6H12O6", "C2H3N1O1[13C]2",
+// "(CH2)3" or "H-1". "D" is accepted for deuterium.
+func ParseFormula(s string) (Formula, error) {
+	p := &formulaParser{s: strings.TrimSpace(s)}
+	f, err := p.parseGroup()
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula '%s': %w", s, err)
+	}
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("invalid formula '%s': unexpected '%c' at position %d", s, p.s[p.pos], p.pos+1)
+	}
+	return f, nil
+}
+
+// formulaParser is a recursive descent parser over a formula string
+type formulaParser struct {
+	s   string
+	pos int
+}
+
+// parseGroup parses atoms and parenthesized groups up to ")" or the end
+func (p *formulaParser) parseGroup() (Formula, error) {
+	f := Formula{}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '(':
+			p.pos++
+			inner, err := p.parseGroup()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+				return nil, fmt.Errorf("missing ')'")
+			}
+			p.pos++
+			f = f.Add(inner.Mul(p.parseCount()))
+		case c == ')':
+			return f, nil
+		case c == '[':
+			atom, err := p.parseIsotope()
+			if err != nil {
+				return nil, err
+			}
+			f[atom] += p.parseCount()
+		case c >= 'A' && c <= 'Z':
+			atom, err := p.parseElement()
+			if err != nil {
+				return nil, err
+			}
+			f[atom] += p.parseCount()
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at position %d", c, p.pos+1)
+		}
+	}
+	return f.normalize(), nil
+}
+
+// parseElement parses an element symbol such as "C" or "Na"
+func (p *formulaParser) parseElement() (Atom, error) {
+	start := p.pos
+	p.pos++
+	if p.pos < len(p.s) && p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z' {
+		p.pos++
+	}
+	symbol := p.s[start:p.pos]
+	if symbol == "D" {
+		return Atom{Symbol: "H", MassNumber: 2}, nil
+	}
+	if _, ok := Elements[symbol]; !ok {
+		return Atom{}, fmt.Errorf("unknown element '%s'", symbol)
+	}
+	return Atom{Symbol: symbol}, nil
+}
+
+// parseIsotope parses an isotope label such as "[13C]"
+func (p *formulaParser) parseIsotope() (Atom, error) {
+	end := strings.IndexByte(p.s[p.pos:], ']')
+	if end < 0 {
+		return Atom{}, fmt.Errorf("missing ']'")
+	}
+	label := p.s[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+
+	i := strings.IndexFunc(label, unicode.IsLetter)
+	if i <= 0 {
+		return Atom{}, fmt.Errorf("invalid isotope '[%s]', expected e.g. [13C]", label)
+	}
+	massNumber, err := strconv.Atoi(label[:i])
+	if err != nil {
+		return Atom{}, fmt.Errorf("invalid isotope '[%s]'", label)
+	}
+	el, ok := Elements[label[i:]]
+	if !ok {
+		return Atom{}, fmt.Errorf("unknown element '%s'", label[i:])
+	}
+	if _, ok := el.Isotope(massNumber); !ok {
+		return Atom{}, fmt.Errorf("unknown isotope '[%s]'", label)
+	}
+	return Atom{Symbol: el.Symbol, MassNumber: massNumber}, nil
+}
+
+// parseCount parses an optional signed count, defaulting to 1
+func (p *formulaParser) parseCount() int {
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return 1
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		// A lone "-" is not a count
+		p.pos = start
+		return 1
+	}
+	return n
+}
+
+// normalize removes atoms with a zero count
+func (f Formula) normalize() Formula {
+	for atom, n := range f {
+		if n == 0 {
+			delete(f, atom)
+		}
+	}
+	return f
+}
+
+// Add returns the sum of two formulas
+func (f Formula) Add(g Formula) Formula {
+	out := make(Formula, len(f)+len(g))
+	for atom, n := range f {
+		out[atom] += n
+	}
+	for atom, n := range g {
+		out[atom] += n
+	}
+	return out.normalize()
+}
+
+// Sub returns f minus g
+func (f Formula) Sub(g Formula) Formula {
+	return f.Add(g.Mul(-1))
+}
+
+// Mul returns the formula with every count multiplied by n
+func (f Formula) Mul(n int) Formula {
+	out := make(Formula, len(f))
+	for atom, count := range f {
+		out[atom] = count * n
+	}
+	return out.normalize()
+}
+
+// Count returns the number of atoms of an element, including its isotopes
+func (f Formula) Count(symbol string) int {
+	total := 0
+	for atom, n := range f {
+		if atom.Symbol == symbol {
+			total += n
+		}
+	}
+	return total
+}
+
+// MonoisotopicMass returns the monoisotopic mass. Labelled isotopes count
+// with their own mass.
+func (f Formula) MonoisotopicMass() float64 {
+	mass := 0.0
+	for atom, n := range f {
+		mono, _ := atom.mass()
+		mass += float64(n) * mono
+	}
+	return mass
+}
+
+// AverageMass returns the average mass using natural isotope abundances
+func (f Formula) AverageMass() float64 {
+	mass := 0.0
+	for atom, n := range f {
+		_, avg := atom.mass()
+		mass += float64(n) * avg
+	}
+	return mass
+}
+
+// String returns the formula in Hill order (C, H, then alphabetical), with
+// labelled isotopes after the natural elements
+func (f Formula) String() string {
+	atoms := make([]Atom, 0, len(f))
+	hasCarbon := f[Atom{Symbol: "C"}] != 0
+	for atom := range f {
+		atoms = append(atoms, atom)
+	}
+
+	rank := func(a Atom) int {
+		switch {
+		case a.MassNumber != 0:
+			return 3
+		case hasCarbon && a.Symbol == "C":
+			return 0
+		case hasCarbon && a.Symbol == "H":
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.Slice(atoms, func(i, j int) bool {
+		ri, rj := rank(atoms[i]), rank(atoms[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if atoms[i].Symbol != atoms[j].Symbol {
+			return atoms[i].Symbol < atoms[j].Symbol
+		}
+		return atoms[i].MassNumber < atoms[j].MassNumber
+	})
+
+	var b strings.Builder
+	for _, atom := range atoms {
+		b.WriteString(atom.String())
+		if n := f[atom]; n != 1 {
+			b.WriteString(strconv.Itoa(n))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseFormula(t *testing.T) {
+	tests := []struct {
+		input    string
+		want     string
+		wantMono float64
+		wantErr  bool
+	}{
+		{input: "C6H12O6", want: "C6H12O6", wantMono: 180.063388},
+		{input: "H2O", want: "H2O", wantMono: 18.010565},
+		{input: "C2H3N1O1[13C]2", want: "C2H3NO[13C]2", wantMono: 83.028174},
+		{input: "CH3(CH2)2OH", want: "C3H8O", wantMono: 60.057515},
+		{input: "C2D3", want: "C2[2H]3", wantMono: 30.042305},
+		{input: "NaCl", want: "ClNa", wantMono: 57.958622},
+		{input: "H-1", want: "H-1", wantMono: -1.007825},
+		{input: "Pt(NH3)2Cl2", want: "Cl2H6N2Pt", wantMono: 298.955595},
+		{input: "AsH3", want: "AsH3", wantMono: 77.945072},
+		{input: "[235U]F6", want: "F6[235U]", wantMono: 349.034349},
+		{input: "Xx2", wantErr: true},
+		{input: "C6(H2", wantErr: true},
+		{input: "[14Q]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			f, err := ParseFormula(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormula() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := f.String(); got != tt.want {
+				t.Errorf("String() = %s, want %s", got, tt.want)
+			}
+			if got := f.MonoisotopicMass(); math.Abs(got-tt.wantMono) > 1e-5 {
+				t.Errorf("MonoisotopicMass() = %.6f, want %.6f", got, tt.wantMono)
+			}
+		})
+	}
+}
+
+func TestElements(t *testing.T) {
+	// Every element from hydrogen to bismuth except technetium and
+	// promethium, plus thorium and uranium
+	if len(Elements) != 83 {
+		t.Errorf("len(Elements) = %d, want 83", len(Elements))
+	}
+	for symbol, el := range Elements {
+		if el.Symbol != symbol {
+			t.Errorf("Elements[%s].Symbol = %s", symbol, el.Symbol)
+		}
+		total := 0.0
+		for _, iso := range el.Isotopes {
+			total += iso.Abundance
+			if math.Abs(iso.Mass-float64(iso.MassNumber)) > 0.1 {
+				t.Errorf("%s: isotope %d has mass %f", symbol, iso.MassNumber, iso.Mass)
+			}
+		}
+		if math.Abs(total-1) > 1e-3 {
+			t.Errorf("%s: abundances sum to %f", symbol, total)
+		}
+	}
+
+	// Standard atomic weights
+	tests := []struct {
+		symbol   string
+		wantMono float64
+		wantAvg  float64
+	}{
+		{"C", 12, 12.011},
+		{"Ti", 47.947946, 47.867},
+		{"Mo", 97.905408, 95.95},
+		{"Gd", 157.924104, 157.25},
+		{"Pt", 194.964791, 195.084},
+		{"Hg", 201.970643, 200.59},
+		{"U", 238.050788, 238.029},
+	}
+	for _, tt := range tests {
+		el := Elements[tt.symbol]
+		if got := el.MonoisotopicMass(); math.Abs(got-tt.wantMono) > 1e-5 {
+			t.Errorf("%s MonoisotopicMass() = %.6f, want %.6f", tt.symbol, got, tt.wantMono)
+		}
+		if got := el.AverageMass(); math.Abs(got-tt.wantAvg) > 0.01 {
+			t.Errorf("%s AverageMass() = %.3f, want %.3f", tt.symbol, got, tt.wantAvg)
+		}
+	}
+}
+
+func TestFormulaArithmetic(t *testing.T) {
+	glucose, _ := ParseFormula("C6H12O6")
+	water, _ := ParseFormula("H2O")
+
+	if got := glucose.Sub(water).String(); got != "C6H10O5" {
+		t.Errorf("Sub() = %s, want C6H10O5", got)
+	}
+	if got := glucose.Add(water).Mul(2).String(); got != "C12H28O14" {
+		t.Errorf("Add().Mul() = %s, want C12H28O14", got)
+	}
+	if got := glucose.Count("O"); got != 6 {
+		t.Errorf("Count(O) = %d, want 6", got)
+	}
+	if got := glucose.AverageMass(); math.Abs(got-180.156) > 0.01 {
+		t.Errorf("AverageMass() = %.3f, want 180.156", got)
+	}
+}
+
+func TestAdductMZ(t *testing.T) {
+	// Glucose, C6H12O6
+	const glucose = 180.063388
+
+	tests := []struct {
+		adduct     string
+		wantCharge int
+		wantMZ     float64
+	}{
+		{"[M+H]+", 1, 181.070665},
+		{"[M+Na]+", 1, 203.052609},
+		{"[M-H]-", -1, 179.056112},
+		{"[M+2H]2+", 2, 91.038971},
+		{"[M+NH4]+", 1, 198.097214},
+		{"[M+H-H2O]+", 1, 163.060100},
+		{"[2M+H]+", 1, 361.134053},
+		{"[M+Cl]-", -1, 215.032790},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.adduct, func(t *testing.T) {
+			a, err := ParseAdduct(tt.adduct)
+			if err != nil {
+				t.Fatalf("ParseAdduct() error = %v", err)
+			}
+			if a.Charge != tt.wantCharge {
+				t.Errorf("Charge = %d, want %d", a.Charge, tt.wantCharge)
+			}
+			mz := a.MZ(glucose)
+			if math.Abs(mz-tt.wantMZ) > 1e-4 {
+				t.Errorf("MZ() = %.6f, want %.6f", mz, tt.wantMZ)
+			}
+			if got := a.NeutralMass(mz); math.Abs(got-glucose) > 1e-9 {
+				t.Errorf("NeutralMass(MZ()) = %.6f, want %.6f", got, glucose)
+			}
+		})
+	}
+
+	for _, bad := range []string{"M+H", "[M+H]", "[M+Xy]+", "[H]+"} {
+		if _, err := ParseAdduct(bad); err == nil {
+			t.Errorf("ParseAdduct(%q) expected error", bad)
+		}
+	}
+}
+
+func TestProtonAdduct(t *testing.T) {
+	tests := []struct {
+		charge int
+		want   string
+	}{
+		{1, "[M+H]+"},
+		{2, "[M+2H]2+"},
+		{-1, "[M-H]-"},
+		{-3, "[M-3H]3-"},
+	}
+
+	for _, tt := range tests {
+		a := ProtonAdduct(tt.charge)
+		if a.Name != tt.want {
+			t.Errorf("ProtonAdduct(%d).Name = %s, want %s", tt.charge, a.Name, tt.want)
+		}
+		parsed, err := ParseAdduct(a.Name)
+		if err != nil {
+			t.Fatalf("ParseAdduct(%s) error = %v", a.Name, err)
+		}
+		if math.Abs(parsed.MZ(1000)-a.MZ(1000)) > 1e-9 {
+			t.Errorf("%s: parsed MZ = %.6f, want %.6f", a.Name, parsed.MZ(1000), a.MZ(1000))
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -67,6 +69,22 @@ func (db *ModDatabase) GetMass(name string) (float64, bool) {
 	return mass, ok
 }
 
+// NameForMass returns the modification whose mass shift is closest to mass,
+// within tolerance Da. Ties are broken by name.
+func (db *ModDatabase) NameForMass(mass, tolerance float64) (string, bool) {
+	best, bestDiff := "", math.Inf(1)
+	for name, m := range db.mods {
+		diff := math.Abs(m - mass)
+		if diff > tolerance {
+			continue
+		}
+		if diff < bestDiff || (diff == bestDiff && name < best) {
+			best, bestDiff = name, diff
+		}
+	}
+	return best, best != ""
+}
+
 // Add adds or updates a modification
 func (db *ModDatabase) Add(name string, mass float64) {
 	db.mods[name] = mass
@@ -207,3 +225,120 @@ func DefaultModDatabase() *ModDatabase {
 
 	return db
 }
+
+// unimodNames maps UniMod accession numbers to the names used by
+// DefaultModDatabase
+var unimodNames = map[int]string{
+	1:    "Acetyl",
+	2:    "Amidated",
+	3:    "Biotin",
+	4:    "Carbamidomethyl",
+	5:    "Carbamyl",
+	6:    "Carboxymethyl",
+	7:    "Deamidated",
+	10:   "Met->Hse",
+	11:   "Met->Hsl",
+	17:   "NIPCAM",
+	21:   "Phospho",
+	23:   "Dehydrated",
+	24:   "Propionamide",
+	26:   "Pyro-carbamidomethyl",
+	27:   "Glu->pyro-Glu",
+	28:   "Gln->pyro-Glu",
+	30:   "Cation:Na",
+	34:   "Methyl",
+	35:   "Oxidation",
+	36:   "Dimethyl",
+	37:   "Trimethyl",
+	39:   "Methylthio",
+	40:   "Sulfo",
+	41:   "Hex",
+	42:   "Lipoyl",
+	43:   "HexNAc",
+	44:   "Farnesyl",
+	45:   "Myristoyl",
+	46:   "PyridoxalPhosphate",
+	47:   "Palmitoyl",
+	48:   "GeranylGeranyl",
+	49:   "Phosphopantetheine",
+	50:   "FAD",
+	52:   "Guanidinyl",
+	53:   "HNE",
+	54:   "Glucuronyl",
+	55:   "Glutathione",
+	58:   "Propionyl",
+	214:  "iTRAQ4plex",
+	730:  "iTRAQ8plex",
+	737:  "TMT6plex",
+	2016: "TMTPro",
+}
+
+// Resolve returns the name and mass shift of a modification written as a
+// name ("Oxidation"), a name with a Spectronaut-style site ("Oxidation (M)"),
+// a UniMod accession ("UniMod:35") or a signed mass shift ("+15.9949").
+// Names and accessions are looked up in the database first, so a CSV can
+// define its own aliases.
+func (db *ModDatabase) Resolve(token string) (string, float64, bool) {
+	token = strings.TrimSpace(token)
+	if mass, ok := db.GetMass(token); ok {
+		return token, mass, true
+	}
+
+	// Mass shifts are signed to distinguish them from residue masses
+	if strings.HasPrefix(token, "+") || strings.HasPrefix(token, "-") {
+		mass, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return "", 0, false
+		}
+		if name, ok := db.NameForMass(mass, 0.01); ok {
+			return name, mass, true
+		}
+		return token, mass, true
+	}
+
+	if len(token) > 7 && strings.EqualFold(token[:7], "unimod:") {
+		id, err := strconv.Atoi(token[7:])
+		if err != nil {
+			return "", 0, false
+		}
+		name, ok := unimodNames[id]
+		if !ok {
+			return "", 0, false
+		}
+		mass, ok := db.GetMass(name)
+		return name, mass, ok
+	}
+
+	// "Acetyl (Protein N-term)" -> "Acetyl"
+	if i := strings.LastIndex(token, " ("); i > 0 && strings.HasSuffix(token, ")") {
+		name := token[:i]
+		if mass, ok := db.GetMass(name); ok {
+			return name, mass, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// UniModAccession returns the UniMod accession of a modification, matched by
+// name or, failing that, by the mass shift of a known accession within
+// 0.001 Da
+func (db *ModDatabase) UniModAccession(name string, mass float64) (int, bool) {
+	ids := make([]int, 0, len(unimodNames))
+	for id := range unimodNames {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if unimodNames[id] == name {
+			return id, true
+		}
+	}
+	for _, id := range ids {
+		if m, ok := db.GetMass(unimodNames[id]); ok && math.Abs(m-mass) <= 0.001 {
+			return id, true
+		}
+	}
+	return 0, false
+}
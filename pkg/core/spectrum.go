@@ -13,7 +13,7 @@ import (
 type Spectrum struct {
 	// Required fields
 	Sequence          string  // Peptide sequence
-	Charge            int     // Precursor charge state
+	Charge            int     // Precursor charge state, negative in negative mode
 	PrecursorMZ       float64 // Precursor m/z
 	Peaks             []Peak  // Fragment peaks
 	FragmentationMode string  // HCD, CID, etc.
@@ -24,8 +24,22 @@ type Spectrum struct {
 	CollisionEnergy *float64 // Normalized collision energy
 	Modifications   []Modification
 	Instrument      string
-	MassOffset      float64 // For massOffset CSV support
-	CompoundClass   string  // For compound class CSV support
+	Polarity        string   // PolarityPositive or PolarityNegative; derived from Charge if empty
+	IonizationMode  string   // ESI, APCI, etc.
+	MassOffset      float64  // For massOffset CSV support
+	CompoundClass   string   // For compound class CSV support
+	Proteins        []string // Protein accessions of the peptide
+	Decoy           bool     // Decoy precursor, e.g. from a reversed sequence
+
+	// Small molecule metadata. Spectra without a Sequence are identified by
+	// CompoundName.
+	CompoundName  string
+	Formula       string // Elemental formula (e.g. C6H12O6)
+	SMILES        string
+	InChIKey      string
+	CASNumber     string
+	PubChemID     string
+	PrecursorType string // Precursor ion type (e.g. [M+H]+)
 
 	// Internal tracking
 	SourceFile   string
@@ -47,6 +61,32 @@ type Modification struct {
 	Name     string // Modification name (e.g., "Carbamidomethyl", "Oxidation")
 }
 
+// Polarity values
+const (
+	PolarityPositive = "+"
+	PolarityNegative = "-"
+)
+
+// ParsePolarity parses a polarity such as "+", "positive", "N" or "negative"
+func ParsePolarity(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "+", "p", "pos", "positive":
+		return PolarityPositive, nil
+	case "-", "n", "neg", "negative":
+		return PolarityNegative, nil
+	default:
+		return "", fmt.Errorf("invalid polarity '%s', must be positive or negative", s)
+	}
+}
+
+// PolarityFromCharge returns the polarity implied by a signed charge
+func PolarityFromCharge(charge int) string {
+	if charge < 0 {
+		return PolarityNegative
+	}
+	return PolarityPositive
+}
+
 // ValidationError represents an error found during spectrum validation.
 type ValidationError struct {
 	Field   string
@@ -57,56 +97,117 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error in %s: %s", e.Field, e.Message)
 }
 
+// ValidationErrors lists every problem found while validating a spectrum.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual errors, so that errors.As still matches a
+// *ValidationError (the first failing field) as returned before
+// ValidationErrors was introduced.
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Fields returns the distinct fields that failed validation, in order.
+func (e ValidationErrors) Fields() []string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, err := range e {
+		if !seen[err.Field] {
+			seen[err.Field] = true
+			fields = append(fields, err.Field)
+		}
+	}
+	return fields
+}
+
+// ParseError is a malformed entry found while reading a library file.
+type ParseError struct {
+	Line int    // Line number where the error was found
+	Err  error  // Underlying error
+	Raw  string // Text of the entry as read, up to where reading stopped
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // Validate checks that a spectrum meets all requirements for processing.
+// The returned error is of type ValidationErrors; errors.As with a
+// *ValidationError target returns the first failing field.
 func (s *Spectrum) Validate() error {
-	var errs []string
+	var errs ValidationErrors
+	add := func(field, msg string) {
+		errs = append(errs, &ValidationError{Field: field, Message: msg})
+	}
 
 	// Required fields
-	if s.Sequence == "" {
-		errs = append(errs, "sequence is required")
+	if s.Sequence == "" && s.CompoundName == "" {
+		add("Sequence", "sequence or compound name is required")
 	}
-	if s.Charge <= 0 {
-		errs = append(errs, "charge must be positive")
+	if s.Charge == 0 {
+		add("Charge", "charge must be non-zero")
 	}
 	if s.PrecursorMZ <= 0 {
-		errs = append(errs, "precursor m/z must be positive")
+		add("PrecursorMZ", "precursor m/z must be positive")
 	}
 	if len(s.Peaks) == 0 {
-		errs = append(errs, "at least one peak is required")
+		add("Peaks", "at least one peak is required")
 	}
 	if s.FragmentationMode == "" {
-		errs = append(errs, "fragmentation mode is required")
+		add("FragmentationMode", "fragmentation mode is required")
 	}
 	if s.MassAnalyzer == "" {
-		errs = append(errs, "mass analyzer is required")
+		add("MassAnalyzer", "mass analyzer is required")
+	}
+	switch s.Polarity {
+	case "":
+	case PolarityPositive, PolarityNegative:
+		if s.Charge != 0 && s.Polarity != PolarityFromCharge(s.Charge) {
+			add("Polarity", fmt.Sprintf("polarity %s does not match charge %d", s.Polarity, s.Charge))
+		}
+	default:
+		add("Polarity", fmt.Sprintf("invalid polarity '%s'", s.Polarity))
 	}
 
 	// Validate peaks
 	for i, peak := range s.Peaks {
 		if math.IsNaN(peak.MZ) || math.IsInf(peak.MZ, 0) {
-			errs = append(errs, fmt.Sprintf("peak %d has invalid m/z", i))
+			add("Peaks", fmt.Sprintf("peak %d has invalid m/z", i))
 		}
 		if math.IsNaN(peak.Intensity) || math.IsInf(peak.Intensity, 0) {
-			errs = append(errs, fmt.Sprintf("peak %d has invalid intensity", i))
+			add("Peaks", fmt.Sprintf("peak %d has invalid intensity", i))
 		}
 		if peak.MZ <= 0 {
-			errs = append(errs, fmt.Sprintf("peak %d m/z must be positive", i))
+			add("Peaks", fmt.Sprintf("peak %d m/z must be positive", i))
 		}
 		if peak.Intensity < 0 {
-			errs = append(errs, fmt.Sprintf("peak %d intensity must be non-negative", i))
+			add("Peaks", fmt.Sprintf("peak %d intensity must be non-negative", i))
 		}
 	}
 
 	// Check if peaks are sorted
 	if !s.ArePeaksSorted() {
-		errs = append(errs, "peaks must be sorted by m/z")
+		add("Peaks", "peaks must be sorted by m/z")
 	}
 
 	if len(errs) > 0 {
-		return &ValidationError{
-			Field:   "Spectrum",
-			Message: strings.Join(errs, "; "),
-		}
+		return errs
 	}
 
 	return nil
@@ -138,20 +239,130 @@ func (s *Spectrum) TotalModMass() float64 {
 	return total
 }
 
-// ModString returns a string representation of modifications in format "mass@pos;mass@pos;..."
+// ModString returns a string representation of modifications in format
+// "mass@pos;mass@pos;...", ordered by position so that the same modified
+// peptide always gives the same string
 func (s *Spectrum) ModString() string {
 	if len(s.Modifications) == 0 {
 		return ""
 	}
 
-	var parts []string
-	for _, mod := range s.Modifications {
-		parts = append(parts, fmt.Sprintf("%.6f@%d", mod.Mass, mod.Position))
+	mods := make([]Modification, len(s.Modifications))
+	copy(mods, s.Modifications)
+	sort.SliceStable(mods, func(i, j int) bool {
+		return mods[i].Position < mods[j].Position
+	})
+
+	parts := make([]string, len(mods))
+	for i, mod := range mods {
+		parts[i] = fmt.Sprintf("%.6f@%d", mod.Mass, mod.Position)
 	}
 	return strings.Join(parts, ";")
 }
 
-// Name returns the spectrum name in format "Sequence/Charge"
+// ModifiedSequence returns the sequence with modification masses inlined in
+// brackets, e.g. "n[+304.2071]PEPTM[+15.9949]IDE". N-terminal modifications
+// are prefixed with "n" and C-terminal modifications are suffixed with "c".
+func (s *Spectrum) ModifiedSequence() string {
+	if len(s.Modifications) == 0 {
+		return s.Sequence
+	}
+
+	mods := make([]Modification, len(s.Modifications))
+	copy(mods, s.Modifications)
+	sort.SliceStable(mods, func(i, j int) bool {
+		return mods[i].Position < mods[j].Position
+	})
+
+	var b strings.Builder
+	next := 0
+	for _, mod := range mods {
+		pos := mod.Position
+		switch {
+		case pos < 0:
+			b.WriteString("n")
+		case pos >= len(s.Sequence):
+			b.WriteString(s.Sequence[next:])
+			next = len(s.Sequence)
+			b.WriteString("c")
+		default:
+			if pos >= next {
+				b.WriteString(s.Sequence[next : pos+1])
+				next = pos + 1
+			}
+		}
+		fmt.Fprintf(&b, "[%+.4f]", mod.Mass)
+	}
+	b.WriteString(s.Sequence[next:])
+
+	return b.String()
+}
+
+// Key returns the modified sequence and charge identifying a precursor,
+// in format "ModifiedSequence/Charge". Small molecules are identified by
+// InChIKey (or compound name) and precursor type.
+func (s *Spectrum) Key() string {
+	if s.IsSmallMolecule() {
+		id := s.InChIKey
+		if id == "" {
+			id = s.CompoundName
+		}
+		if s.PrecursorType != "" {
+			return id + "/" + s.PrecursorType
+		}
+		return fmt.Sprintf("%s/%d", id, s.Charge)
+	}
+	return fmt.Sprintf("%s/%d", s.ModifiedSequence(), s.Charge)
+}
+
+// Name returns the spectrum name in format "Sequence/Charge", or the
+// compound name for small molecules
 func (s *Spectrum) Name() string {
+	if s.IsSmallMolecule() {
+		return s.CompoundName
+	}
 	return fmt.Sprintf("%s/%d", s.Sequence, s.Charge)
 }
+
+// IsSmallMolecule reports whether the spectrum is a compound without a
+// peptide sequence
+func (s *Spectrum) IsSmallMolecule() bool {
+	return s.Sequence == "" && s.CompoundName != ""
+}
+
+// NeutralMass returns the neutral monoisotopic mass of the compound. Peptides
+// use the sequence and modifications; small molecules use the formula, or
+// the precursor m/z and ion type. It returns false if the mass cannot be
+// determined.
+func (s *Spectrum) NeutralMass() (float64, bool) {
+	if !s.IsSmallMolecule() {
+		return CalculateNeutralMass(s.Sequence, s.Modifications), s.Sequence != ""
+	}
+	if f, err := ParseFormula(s.Formula); err == nil && len(f) > 0 {
+		return f.MonoisotopicMass(), true
+	}
+	if a, err := ParseAdduct(s.PrecursorType); err == nil && s.PrecursorMZ > 0 {
+		return a.NeutralMass(s.PrecursorMZ), true
+	}
+	return 0, false
+}
+
+// IonPolarity returns the polarity, derived from the charge if not set
+func (s *Spectrum) IonPolarity() string {
+	if s.Polarity != "" {
+		return s.Polarity
+	}
+	return PolarityFromCharge(s.Charge)
+}
+
+// PrecursorIonType returns the precursor ion type, defaulting to the
+// protonated or deprotonated ion (e.g. "[M+2H]2+") for peptides
+func (s *Spectrum) PrecursorIonType() string {
+	if s.PrecursorType != "" {
+		return s.PrecursorType
+	}
+	if s.Charge != 0 && !s.IsSmallMolecule() {
+		return ProtonAdduct(s.Charge).Name
+	}
+	return ""
+}
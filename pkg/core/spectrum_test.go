@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"math"
 	"testing"
 )
@@ -106,6 +107,48 @@ func TestSpectrumValidation(t *testing.T) {
 	}
 }
 
+func TestValidationErrorFields(t *testing.T) {
+	spec := &Spectrum{
+		Sequence:          "PEPTIDE",
+		Charge:            0,
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Peaks: []Peak{
+			{MZ: 100.0, Intensity: -1.0},
+		},
+	}
+
+	err := spec.Validate()
+	verrs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Validate() error type = %T, want ValidationErrors", err)
+	}
+
+	// Callers matching the single error returned before still find the
+	// first failing field
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("errors.As(*ValidationError) failed for %v", err)
+	}
+	if verr.Field != "Charge" {
+		t.Errorf("errors.As() field = %s, want Charge", verr.Field)
+	}
+	if !errors.Is(err, verrs[1]) {
+		t.Errorf("errors.Is() did not match the PrecursorMZ error")
+	}
+
+	want := []string{"Charge", "PrecursorMZ", "Peaks"}
+	fields := verrs.Fields()
+	if len(fields) != len(want) {
+		t.Fatalf("Fields() = %v, want %v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("Fields()[%d] = %s, want %s", i, fields[i], want[i])
+		}
+	}
+}
+
 func TestSortPeaks(t *testing.T) {
 	spec := &Spectrum{
 		Peaks: []Peak{
@@ -158,6 +201,20 @@ func TestModString(t *testing.T) {
 	if modStr == "" {
 		t.Error("Expected non-empty mod string")
 	}
+
+	// Modifications listed in a different order give the same string
+	reordered := &Spectrum{
+		Modifications: []Modification{
+			{Mass: 15.994915, Position: 7},
+			{Mass: 57.021464, Position: 3},
+		},
+	}
+	if got := reordered.ModString(); got != modStr {
+		t.Errorf("ModString() = %s, want %s", got, modStr)
+	}
+	if want := "57.021464@3;15.994915@7"; modStr != want {
+		t.Errorf("ModString() = %s, want %s", modStr, want)
+	}
 }
 
 func TestSpectrumName(t *testing.T) {
@@ -173,3 +230,129 @@ func TestSpectrumName(t *testing.T) {
 		t.Errorf("Expected name %s, got %s", expected, name)
 	}
 }
+
+func TestModifiedSequence(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *Spectrum
+		want string
+	}{
+		{
+			name: "unmodified",
+			spec: &Spectrum{Sequence: "PEPTIDE"},
+			want: "PEPTIDE",
+		},
+		{
+			name: "n-term and residue",
+			spec: &Spectrum{
+				Sequence: "PEPTMIDE",
+				Modifications: []Modification{
+					{Mass: 15.994915, Position: 4},
+					{Mass: 304.207146, Position: -1},
+				},
+			},
+			want: "n[+304.2071]PEPTM[+15.9949]IDE",
+		},
+		{
+			name: "c-term",
+			spec: &Spectrum{
+				Sequence:      "PEPTIDE",
+				Modifications: []Modification{{Mass: -0.984016, Position: 7}},
+			},
+			want: "PEPTIDEc[-0.9840]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.ModifiedSequence(); got != tt.want {
+				t.Errorf("ModifiedSequence() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmallMoleculeSpectrum(t *testing.T) {
+	spec := &Spectrum{
+		CompoundName:      "Caffeine",
+		InChIKey:          "RYYVLZVUVIJVGH-UHFFFAOYSA-N",
+		PrecursorType:     "[M+H]+",
+		Charge:            1,
+		PrecursorMZ:       195.0877,
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Peaks:             []Peak{{MZ: 138.0662, Intensity: 100}},
+	}
+
+	if err := spec.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if got := spec.Name(); got != "Caffeine" {
+		t.Errorf("Name() = %s, want Caffeine", got)
+	}
+	if got, want := spec.Key(), "RYYVLZVUVIJVGH-UHFFFAOYSA-N/[M+H]+"; got != want {
+		t.Errorf("Key() = %s, want %s", got, want)
+	}
+}
+
+func TestPrecursorTypeCharge(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   int
+		wantOK bool
+	}{
+		{"[M+H]+", 1, true},
+		{"[M+2H]2+", 2, true},
+		{"[M-H]-", -1, true},
+		{"[M-2H]2-", -2, true},
+		{"M+H+", 1, true},
+		{"[M+H]", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := PrecursorTypeCharge(tt.input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("PrecursorTypeCharge(%q) = %d, %v, want %d, %v", tt.input, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPolarityValidation(t *testing.T) {
+	base := func() *Spectrum {
+		return &Spectrum{
+			Sequence:          "PEPTIDE",
+			Charge:            -2,
+			PrecursorMZ:       399.2,
+			FragmentationMode: "HCD",
+			MassAnalyzer:      "FT",
+			Peaks:             []Peak{{MZ: 100, Intensity: 1}},
+		}
+	}
+
+	spec := base()
+	if err := spec.Validate(); err != nil {
+		t.Errorf("negative charge: Validate() error = %v", err)
+	}
+	if got := spec.IonPolarity(); got != PolarityNegative {
+		t.Errorf("IonPolarity() = %s, want %s", got, PolarityNegative)
+	}
+	if got := spec.PrecursorIonType(); got != "[M-2H]2-" {
+		t.Errorf("PrecursorIonType() = %s, want [M-2H]2-", got)
+	}
+
+	spec = base()
+	spec.Polarity = PolarityPositive
+	if err := spec.Validate(); err == nil {
+		t.Error("mismatched polarity: expected validation error")
+	}
+
+	if p, err := ParsePolarity("Negative"); err != nil || p != PolarityNegative {
+		t.Errorf("ParsePolarity(Negative) = %s, %v", p, err)
+	}
+	if _, err := ParsePolarity("both"); err == nil {
+		t.Error("ParsePolarity(both) expected error")
+	}
+}
@@ -3,7 +3,6 @@ package filter
 
 import (
 	"fmt"
-	"regexp"
 	"sort"
 	"strings"
 
@@ -146,7 +145,7 @@ func (c *Config) adjustFragmentMasses(spec *core.Spectrum) error {
 		}
 
 		// Parse annotation to get ion type, position, and charge
-		ionInfo, err := parseIonAnnotation(peak.Annotation)
+		ionInfo, err := core.ParseAnnotation(peak.Annotation)
 		if err != nil {
 			// Skip peaks with unparseable annotations
 			continue
@@ -164,20 +163,20 @@ func (c *Config) adjustFragmentMasses(spec *core.Spectrum) error {
 			// y ions: add mass if modification is after the fragment position (from C-term)
 			shouldAdjust := false
 
-			if ionInfo.ionType == "b" && ionInfo.position >= mod.Position {
+			if ionInfo.Type == "b" && ionInfo.Number >= mod.Position {
 				shouldAdjust = true
-			} else if ionInfo.ionType == "y" {
+			} else if ionInfo.Type == "y" {
 				// Y ions count from C-terminus
 				seqLen := len(spec.Sequence)
 				modPosFromCTerm := seqLen - mod.Position - 1
-				if ionInfo.position >= modPosFromCTerm {
+				if ionInfo.Number >= modPosFromCTerm {
 					shouldAdjust = true
 				}
 			}
 
 			if shouldAdjust {
 				// Apply mass shift divided by fragment charge
-				peak.MZ += deltaMass / float64(ionInfo.charge)
+				peak.MZ += deltaMass / float64(ionInfo.Charge)
 			}
 		}
 	}
@@ -185,45 +184,6 @@ func (c *Config) adjustFragmentMasses(spec *core.Spectrum) error {
 	return nil
 }
 
-// ionAnnotationInfo stores parsed ion annotation
-type ionAnnotationInfo struct {
-	ionType  string
-	position int
-	charge   int
-}
-
-// parseIonAnnotation parses annotations like "y3", "b2^2", "y10^3"
-func parseIonAnnotation(annotation string) (*ionAnnotationInfo, error) {
-	// Pattern: (ion type)(number)[^(charge)]
-	re := regexp.MustCompile(`^([a-z])(\d+)(?:\^(\d+))?`)
-	matches := re.FindStringSubmatch(annotation)
-
-	if len(matches) < 3 {
-		return nil, fmt.Errorf("invalid ion annotation format: %s", annotation)
-	}
-
-	info := &ionAnnotationInfo{
-		ionType: matches[1],
-		charge:  1, // default charge
-	}
-
-	// Parse position
-	_, err := fmt.Sscanf(matches[2], "%d", &info.position)
-	if err != nil {
-		return nil, fmt.Errorf("invalid position in annotation %s: %w", annotation, err)
-	}
-
-	// Parse charge if present
-	if len(matches) > 3 && matches[3] != "" {
-		_, err := fmt.Sscanf(matches[3], "%d", &info.charge)
-		if err != nil {
-			return nil, fmt.Errorf("invalid charge in annotation %s: %w", annotation, err)
-		}
-	}
-
-	return info, nil
-}
-
 // RemoveZeroIntensityPeaks removes peaks with zero or negative intensity
 func RemoveZeroIntensityPeaks(spec *core.Spectrum) {
 	var filtered []core.Peak
@@ -0,0 +1,224 @@
+package ident
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// PSI-MS accessions read from mzIdentML
+const (
+	cvScanStartTime = "MS:1000016"
+	cvScanNumbers   = "MS:1001115"
+	unitSecond      = "UO:0000010"
+	unknownModName  = "unknown modification"
+)
+
+// mzidParam is a cvParam or userParam
+type mzidParam struct {
+	Accession     string `xml:"accession,attr"`
+	Name          string `xml:"name,attr"`
+	Value         string `xml:"value,attr"`
+	UnitAccession string `xml:"unitAccession,attr"`
+}
+
+// mzidPeptide is an mzIdentML <Peptide>
+type mzidPeptide struct {
+	ID            string `xml:"id,attr"`
+	Sequence      string `xml:"PeptideSequence"`
+	Modifications []struct {
+		Location int         `xml:"location,attr"`
+		Delta    string      `xml:"monoisotopicMassDelta,attr"`
+		CVParams []mzidParam `xml:"cvParam"`
+	} `xml:"Modification"`
+}
+
+// mzidResult is an mzIdentML <SpectrumIdentificationResult>
+type mzidResult struct {
+	SpectrumID  string      `xml:"spectrumID,attr"`
+	SpectraData string      `xml:"spectraData_ref,attr"`
+	CVParams    []mzidParam `xml:"cvParam"`
+	Items       []struct {
+		Charge     int         `xml:"chargeState,attr"`
+		Rank       int         `xml:"rank,attr"`
+		PeptideRef string      `xml:"peptide_ref,attr"`
+		CVParams   []mzidParam `xml:"cvParam"`
+		UserParams []mzidParam `xml:"userParam"`
+	} `xml:"SpectrumIdentificationItem"`
+}
+
+// ReadMzIdentML reads the rank 1 match of every spectrum identification
+// result in an mzIdentML file. Scores are named after their cvParam or
+// userParam names, e.g. "MS-GF:SpecEValue".
+func ReadMzIdentML(r io.Reader, modDB *core.ModDatabase) ([]*PSM, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+
+	dec := xml.NewDecoder(r)
+	peptides := make(map[string]*mzidPeptide)
+	runs := make(map[string]string) // SpectraData id -> run name
+	var psms []*PSM
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return psms, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mzIdentML: %w", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "Peptide":
+			var p mzidPeptide
+			if err := dec.DecodeElement(&p, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse mzIdentML: %w", err)
+			}
+			peptides[p.ID] = &p
+		case "SpectraData":
+			var id, location string
+			for _, attr := range start.Attr {
+				switch attr.Name.Local {
+				case "id":
+					id = attr.Value
+				case "location":
+					location = attr.Value
+				}
+			}
+			runs[id] = RunName(location)
+		case "SpectrumIdentificationResult":
+			var res mzidResult
+			if err := dec.DecodeElement(&res, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse mzIdentML: %w", err)
+			}
+			psm, err := convertMzidResult(&res, runs, peptides, modDB)
+			if err != nil {
+				return nil, fmt.Errorf("spectrum %s: %w", res.SpectrumID, err)
+			}
+			if psm != nil {
+				psms = append(psms, psm)
+			}
+		}
+	}
+}
+
+// convertMzidResult returns the rank 1 match of a result, or nil if it has
+// none
+func convertMzidResult(res *mzidResult, runs map[string]string, peptides map[string]*mzidPeptide, modDB *core.ModDatabase) (*PSM, error) {
+	for _, item := range res.Items {
+		if item.Rank > 1 {
+			continue
+		}
+
+		pep, ok := peptides[item.PeptideRef]
+		if !ok {
+			return nil, fmt.Errorf("unknown peptide_ref '%s'", item.PeptideRef)
+		}
+
+		psm := &PSM{
+			Run:        runs[res.SpectraData],
+			SpectrumID: res.SpectrumID,
+			Index:      -1,
+			Charge:     item.Charge,
+			Sequence:   pep.Sequence,
+			Scores:     make(map[string]float64),
+		}
+
+		// Spectrum IDs are native IDs ("... scan=123") or "index=5"
+		// references into the spectra file
+		for _, field := range strings.Fields(res.SpectrumID) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "scan":
+				psm.Scan = n
+			case "index":
+				psm.Index = n
+			}
+		}
+
+		for _, p := range res.CVParams {
+			switch p.Accession {
+			case cvScanStartTime:
+				if rt, err := strconv.ParseFloat(p.Value, 64); err == nil {
+					if p.UnitAccession == unitSecond {
+						rt /= 60
+					}
+					psm.RetentionTime = &rt
+				}
+			case cvScanNumbers:
+				if fields := strings.Fields(p.Value); len(fields) > 0 && psm.Scan == 0 {
+					psm.Scan, _ = strconv.Atoi(fields[0])
+				}
+			}
+		}
+
+		for _, params := range [][]mzidParam{item.CVParams, item.UserParams} {
+			for _, p := range params {
+				if v, err := strconv.ParseFloat(p.Value, 64); err == nil && p.Name != "" {
+					psm.Scores[p.Name] = v
+				}
+			}
+		}
+
+		for _, mod := range pep.Modifications {
+			name := ""
+			for _, p := range mod.CVParams {
+				if p.Name != "" && p.Name != unknownModName {
+					name = p.Name
+					break
+				}
+			}
+
+			var delta float64
+			if mod.Delta != "" {
+				var err error
+				if delta, err = strconv.ParseFloat(mod.Delta, 64); err != nil {
+					return nil, fmt.Errorf("invalid monoisotopicMassDelta '%s'", mod.Delta)
+				}
+			} else if mass, ok := modDB.GetMass(name); ok {
+				delta = mass
+			} else {
+				return nil, fmt.Errorf("modification '%s' has no mass", name)
+			}
+
+			// Location 0 is the N-terminus and length+1 the C-terminus
+			position := mod.Location - 1
+			switch {
+			case mod.Location <= 0:
+				position = -1
+			case mod.Location > len(pep.Sequence):
+				position = len(pep.Sequence)
+			}
+
+			if name == "" {
+				name = modificationName(modDB, delta)
+			}
+
+			psm.Modifications = append(psm.Modifications, core.Modification{
+				Mass:     delta,
+				Position: position,
+				Name:     name,
+			})
+		}
+
+		return psm, nil
+	}
+	return nil, nil
+}
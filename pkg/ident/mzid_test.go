@@ -0,0 +1,149 @@
+package ident
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testMzIdentML = `<?xml version="1.0" encoding="UTF-8"?>
+<MzIdentML>
+<SequenceCollection>
+<Peptide id="PEP_1">
+<PeptideSequence>PEPTMCK</PeptideSequence>
+<Modification location="0" monoisotopicMassDelta="42.010565"><cvParam accession="MS:1001460" name="unknown modification"/></Modification>
+<Modification location="5" monoisotopicMassDelta="15.994915"><cvParam accession="UNIMOD:35" name="Oxidation"/></Modification>
+</Peptide>
+<Peptide id="PEP_2">
+<PeptideSequence>AAAK</PeptideSequence>
+<Modification location="5"><cvParam accession="UNIMOD:4" name="Carbamidomethyl"/></Modification>
+</Peptide>
+</SequenceCollection>
+<DataCollection>
+<Inputs><SpectraData id="SD_1" location="file:///data/run01.mzML"/></Inputs>
+<AnalysisData><SpectrumIdentificationList>
+<SpectrumIdentificationResult spectrumID="controllerType=0 controllerNumber=1 scan=100" spectraData_ref="SD_1">
+<SpectrumIdentificationItem chargeState="2" rank="1" peptide_ref="PEP_1">
+<cvParam accession="MS:1002052" name="MS-GF:SpecEValue" value="1e-12"/>
+<cvParam accession="MS:1002054" name="MS-GF:QValue" value="0.001"/>
+<userParam name="IsotopeError" value="0"/>
+<userParam name="AssumedDissociationMethod" value="HCD"/>
+</SpectrumIdentificationItem>
+<SpectrumIdentificationItem chargeState="2" rank="2" peptide_ref="PEP_2"/>
+<cvParam accession="MS:1000016" name="scan start time" value="600" unitAccession="UO:0000010"/>
+</SpectrumIdentificationResult>
+<SpectrumIdentificationResult spectrumID="index=5" spectraData_ref="SD_1">
+<SpectrumIdentificationItem chargeState="3" rank="1" peptide_ref="PEP_2">
+<cvParam accession="MS:1002052" name="MS-GF:SpecEValue" value="1e-8"/>
+</SpectrumIdentificationItem>
+<cvParam accession="MS:1001115" name="scan number(s)" value="250"/>
+<cvParam accession="MS:1000016" name="scan start time" value="12.5" unitAccession="UO:0000031"/>
+</SpectrumIdentificationResult>
+</SpectrumIdentificationList></AnalysisData>
+</DataCollection>
+</MzIdentML>
+`
+
+func TestReadMzIdentML(t *testing.T) {
+	psms, err := ReadMzIdentML(strings.NewReader(testMzIdentML), nil)
+	if err != nil {
+		t.Fatalf("ReadMzIdentML() error = %v", err)
+	}
+	if len(psms) != 2 {
+		t.Fatalf("ReadMzIdentML() = %d matches, want 2", len(psms))
+	}
+
+	p := psms[0]
+	if p.Run != "run01" || p.Scan != 100 || p.Index != -1 || p.Charge != 2 || p.Sequence != "PEPTMCK" {
+		t.Errorf("first match = run %q scan %d index %d charge %d %s, want run01 100 -1 2 PEPTMCK",
+			p.Run, p.Scan, p.Index, p.Charge, p.Sequence)
+	}
+	if p.RetentionTime == nil || *p.RetentionTime != 10 {
+		t.Errorf("RetentionTime = %v, want 10 minutes", p.RetentionTime)
+	}
+	if p.Scores["MS-GF:SpecEValue"] != 1e-12 || p.Scores["IsotopeError"] != 0 {
+		t.Errorf("Scores = %v, want MS-GF:SpecEValue and IsotopeError", p.Scores)
+	}
+	if _, ok := p.Scores["AssumedDissociationMethod"]; ok {
+		t.Error("non-numeric userParam read as a score")
+	}
+	if q, ok := p.QValue(); !ok || q != 0.001 {
+		t.Errorf("QValue() = %v, %v, want 0.001, true", q, ok)
+	}
+
+	wantMods := []struct {
+		name     string
+		position int
+		mass     float64
+	}{
+		{"Acetyl", -1, 42.010565},
+		{"Oxidation", 4, 15.994915},
+	}
+	if len(p.Modifications) != len(wantMods) {
+		t.Fatalf("Modifications = %+v, want %d", p.Modifications, len(wantMods))
+	}
+	for i, want := range wantMods {
+		got := p.Modifications[i]
+		if got.Name != want.name || got.Position != want.position || math.Abs(got.Mass-want.mass) > 1e-6 {
+			t.Errorf("Modifications[%d] = %+v, want %s at %d (%.4f)", i, got, want.name, want.position, want.mass)
+		}
+	}
+
+	// An index reference with the scan number in a cvParam, and a
+	// C-terminal modification without a mass delta
+	p = psms[1]
+	if p.Index != 5 || p.Scan != 250 || p.Charge != 3 {
+		t.Errorf("second match = index %d scan %d charge %d, want 5 250 3", p.Index, p.Scan, p.Charge)
+	}
+	if p.RetentionTime == nil || *p.RetentionTime != 12.5 {
+		t.Errorf("RetentionTime = %v, want 12.5 minutes", p.RetentionTime)
+	}
+	if len(p.Modifications) != 1 {
+		t.Fatalf("second match Modifications = %+v, want 1", p.Modifications)
+	}
+	if mod := p.Modifications[0]; mod.Name != "Carbamidomethyl" || mod.Position != 4 || math.Abs(mod.Mass-57.021464) > 1e-6 {
+		t.Errorf("second match modification = %+v, want Carbamidomethyl at 4", mod)
+	}
+}
+
+func TestReadMzIdentMLErrors(t *testing.T) {
+	result := func(peptide, ref string) string {
+		return `<MzIdentML>` + peptide + `<SpectrumIdentificationResult spectrumID="scan=1" spectraData_ref="SD_1">
+<SpectrumIdentificationItem chargeState="2" rank="1" peptide_ref="` + ref + `"/></SpectrumIdentificationResult></MzIdentML>`
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "unknown peptide",
+			input:   result("", "PEP_1"),
+			wantErr: "spectrum scan=1: unknown peptide_ref 'PEP_1'",
+		},
+		{
+			name:    "invalid mass delta",
+			input:   result(`<Peptide id="PEP_1"><PeptideSequence>AAAK</PeptideSequence><Modification location="1" monoisotopicMassDelta="x"/></Peptide>`, "PEP_1"),
+			wantErr: "invalid monoisotopicMassDelta 'x'",
+		},
+		{
+			name:    "modification without mass",
+			input:   result(`<Peptide id="PEP_1"><PeptideSequence>AAAK</PeptideSequence><Modification location="1"><cvParam name="NoSuchMod"/></Modification></Peptide>`, "PEP_1"),
+			wantErr: "modification 'NoSuchMod' has no mass",
+		},
+		{
+			name:    "malformed XML",
+			input:   `<MzIdentML><Peptide>`,
+			wantErr: "failed to parse mzIdentML",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadMzIdentML(strings.NewReader(tt.input), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadMzIdentML() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
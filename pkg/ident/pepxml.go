@@ -0,0 +1,210 @@
+package ident
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// pepXMLQuery is the subset of a pepXML <spectrum_query> used by DBKey
+type pepXMLQuery struct {
+	Spectrum  string `xml:"spectrum,attr"`
+	NativeID  string `xml:"spectrumNativeID,attr"`
+	StartScan int    `xml:"start_scan,attr"`
+	Charge    int    `xml:"assumed_charge,attr"`
+	RTSeconds string `xml:"retention_time_sec,attr"`
+	Hits      []struct {
+		Rank    int    `xml:"hit_rank,attr"`
+		Peptide string `xml:"peptide,attr"`
+		ModInfo *struct {
+			NTermMass string `xml:"mod_nterm_mass,attr"`
+			CTermMass string `xml:"mod_cterm_mass,attr"`
+			Mods      []struct {
+				Position int     `xml:"position,attr"`
+				Mass     float64 `xml:"mass,attr"`
+				Variable string  `xml:"variable,attr"`
+				Static   string  `xml:"static,attr"`
+			} `xml:"mod_aminoacid_mass"`
+		} `xml:"modification_info"`
+		Scores []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"search_score"`
+		Analyses []struct {
+			PeptideProphet *struct {
+				Probability string `xml:"probability,attr"`
+			} `xml:"peptideprophet_result"`
+			InterProphet *struct {
+				Probability string `xml:"probability,attr"`
+			} `xml:"interprophet_result"`
+		} `xml:"analysis_result"`
+	} `xml:"search_result>search_hit"`
+}
+
+// ReadPepXML reads the top-ranked match of every spectrum query in a pepXML
+// file. PeptideProphet and iProphet probabilities are reported as the
+// "peptideprophet_probability" and "iprophet_probability" scores.
+func ReadPepXML(r io.Reader, modDB *core.ModDatabase) ([]*PSM, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+
+	dec := xml.NewDecoder(r)
+	var psms []*PSM
+	run := ""
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return psms, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pepXML: %w", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "msms_run_summary":
+			run = ""
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "base_name" {
+					run = RunName(attr.Value)
+				}
+			}
+		case "spectrum_query":
+			var q pepXMLQuery
+			if err := dec.DecodeElement(&q, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse pepXML: %w", err)
+			}
+			psm, err := convertPepXMLQuery(&q, run, modDB)
+			if err != nil {
+				return nil, fmt.Errorf("spectrum %s: %w", q.Spectrum, err)
+			}
+			if psm != nil {
+				psms = append(psms, psm)
+			}
+		}
+	}
+}
+
+// convertPepXMLQuery returns the top-ranked match of a query, or nil if it
+// has none
+func convertPepXMLQuery(q *pepXMLQuery, run string, modDB *core.ModDatabase) (*PSM, error) {
+	for _, hit := range q.Hits {
+		if hit.Rank > 1 {
+			continue
+		}
+
+		psm := &PSM{
+			Run:        run,
+			SpectrumID: q.NativeID,
+			Scan:       q.StartScan,
+			Index:      -1,
+			Charge:     q.Charge,
+			Sequence:   hit.Peptide,
+			Scores:     make(map[string]float64),
+		}
+		// Spectrum titles look like "run.01234.01234.2"
+		if psm.Run == "" {
+			if parts := strings.Split(q.Spectrum, "."); len(parts) > 3 {
+				psm.Run = strings.Join(parts[:len(parts)-3], ".")
+			}
+		}
+		if q.RTSeconds != "" {
+			if rt, err := strconv.ParseFloat(q.RTSeconds, 64); err == nil {
+				rt /= 60
+				psm.RetentionTime = &rt
+			}
+		}
+
+		for _, score := range hit.Scores {
+			if v, err := strconv.ParseFloat(score.Value, 64); err == nil {
+				psm.Scores[score.Name] = v
+			}
+		}
+		for _, a := range hit.Analyses {
+			if a.PeptideProphet != nil {
+				if v, err := strconv.ParseFloat(a.PeptideProphet.Probability, 64); err == nil {
+					psm.Scores["peptideprophet_probability"] = v
+				}
+			}
+			if a.InterProphet != nil {
+				if v, err := strconv.ParseFloat(a.InterProphet.Probability, 64); err == nil {
+					psm.Scores["iprophet_probability"] = v
+				}
+			}
+		}
+
+		if hit.ModInfo != nil {
+			if hit.ModInfo.NTermMass != "" {
+				mass, err := strconv.ParseFloat(hit.ModInfo.NTermMass, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid mod_nterm_mass '%s'", hit.ModInfo.NTermMass)
+				}
+				// Terminal masses include the terminal group
+				addPepXMLMod(psm, modDB, mass-core.MassH, -1)
+			}
+			for _, mod := range hit.ModInfo.Mods {
+				if mod.Position < 1 || mod.Position > len(hit.Peptide) {
+					return nil, fmt.Errorf("modification position %d outside peptide %s", mod.Position, hit.Peptide)
+				}
+				delta, err := pepXMLModDelta(hit.Peptide, mod.Position, mod.Mass, mod.Variable, mod.Static)
+				if err != nil {
+					return nil, err
+				}
+				addPepXMLMod(psm, modDB, delta, mod.Position-1)
+			}
+			if hit.ModInfo.CTermMass != "" {
+				mass, err := strconv.ParseFloat(hit.ModInfo.CTermMass, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid mod_cterm_mass '%s'", hit.ModInfo.CTermMass)
+				}
+				addPepXMLMod(psm, modDB, mass-core.MassO-core.MassH, len(hit.Peptide))
+			}
+		}
+
+		return psm, nil
+	}
+	return nil, nil
+}
+
+// pepXMLModDelta returns the mass shift of a modified residue, from the
+// variable or static attribute when present, otherwise from the modified
+// residue mass
+func pepXMLModDelta(peptide string, position int, mass float64, variable, static string) (float64, error) {
+	for _, v := range []string{variable, static} {
+		if v != "" {
+			delta, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid modification mass '%s'", v)
+			}
+			return delta, nil
+		}
+	}
+	residue, ok := core.ResidueMass(rune(peptide[position-1]))
+	if !ok {
+		return 0, fmt.Errorf("unknown residue '%c' at position %d", peptide[position-1], position)
+	}
+	return mass - residue, nil
+}
+
+// addPepXMLMod appends a modification, skipping zero mass shifts from
+// unmodified termini
+func addPepXMLMod(psm *PSM, modDB *core.ModDatabase, delta float64, position int) {
+	if delta > -modTolerance && delta < modTolerance {
+		return
+	}
+	psm.Modifications = append(psm.Modifications, core.Modification{
+		Mass:     delta,
+		Position: position,
+		Name:     modificationName(modDB, delta),
+	})
+}
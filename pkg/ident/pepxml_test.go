@@ -0,0 +1,157 @@
+package ident
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testPepXML = `<?xml version="1.0" encoding="UTF-8"?>
+<msms_pipeline_analysis>
+<msms_run_summary base_name="/data/run01.mzML">
+<spectrum_query spectrum="run01.00100.00100.2" spectrumNativeID="controllerType=0 controllerNumber=1 scan=100" start_scan="100" assumed_charge="2" retention_time_sec="600">
+<search_result>
+<search_hit hit_rank="1" peptide="PEPTMCK">
+<modification_info mod_nterm_mass="43.018390">
+<mod_aminoacid_mass position="5" mass="147.035400"/>
+<mod_aminoacid_mass position="6" mass="160.030649" static="57.021464"/>
+</modification_info>
+<search_score name="expect" value="1.5e-5"/>
+<search_score name="hyperscore" value="35.2"/>
+<analysis_result analysis="peptideprophet"><peptideprophet_result probability="0.99"/></analysis_result>
+<analysis_result analysis="interprophet"><interprophet_result probability="0.995"/></analysis_result>
+</search_hit>
+<search_hit hit_rank="2" peptide="AAAK">
+<search_score name="expect" value="3.1"/>
+</search_hit>
+</search_result>
+</spectrum_query>
+<spectrum_query spectrum="run01.00150.00150.3" start_scan="150" assumed_charge="3">
+<search_result/>
+</spectrum_query>
+</msms_run_summary>
+<msms_run_summary>
+<spectrum_query spectrum="run02.sample.00200.00200.3" start_scan="200" assumed_charge="3">
+<search_result>
+<search_hit hit_rank="1" peptide="LLLK">
+<modification_info mod_cterm_mass="17.002740"/>
+<search_score name="expect" value="0.01"/>
+</search_hit>
+</search_result>
+</spectrum_query>
+</msms_run_summary>
+</msms_pipeline_analysis>
+`
+
+func TestReadPepXML(t *testing.T) {
+	psms, err := ReadPepXML(strings.NewReader(testPepXML), nil)
+	if err != nil {
+		t.Fatalf("ReadPepXML() error = %v", err)
+	}
+	if len(psms) != 2 {
+		t.Fatalf("ReadPepXML() = %d matches, want 2", len(psms))
+	}
+
+	p := psms[0]
+	if p.Run != "run01" || p.Scan != 100 || p.Index != -1 || p.Charge != 2 || p.Sequence != "PEPTMCK" {
+		t.Errorf("first match = run %q scan %d index %d charge %d %s, want run01 100 -1 2 PEPTMCK",
+			p.Run, p.Scan, p.Index, p.Charge, p.Sequence)
+	}
+	if p.SpectrumID != "controllerType=0 controllerNumber=1 scan=100" {
+		t.Errorf("SpectrumID = %q", p.SpectrumID)
+	}
+	if p.RetentionTime == nil || *p.RetentionTime != 10 {
+		t.Errorf("RetentionTime = %v, want 10 minutes", p.RetentionTime)
+	}
+	wantScores := map[string]float64{
+		"expect":                     1.5e-5,
+		"hyperscore":                 35.2,
+		"peptideprophet_probability": 0.99,
+		"iprophet_probability":       0.995,
+	}
+	if len(p.Scores) != len(wantScores) {
+		t.Errorf("Scores = %v, want %v", p.Scores, wantScores)
+	}
+	for name, want := range wantScores {
+		if p.Scores[name] != want {
+			t.Errorf("Scores[%s] = %v, want %v", name, p.Scores[name], want)
+		}
+	}
+
+	wantMods := []struct {
+		name     string
+		position int
+		mass     float64
+	}{
+		{"Acetyl", -1, 42.010565},
+		{"Oxidation", 4, 15.994915},
+		{"Carbamidomethyl", 5, 57.021464},
+	}
+	if len(p.Modifications) != len(wantMods) {
+		t.Fatalf("Modifications = %+v, want %d", p.Modifications, len(wantMods))
+	}
+	for i, want := range wantMods {
+		got := p.Modifications[i]
+		if got.Name != want.name || got.Position != want.position || math.Abs(got.Mass-want.mass) > 1e-3 {
+			t.Errorf("Modifications[%d] = %+v, want %s at %d (%.4f)", i, got, want.name, want.position, want.mass)
+		}
+	}
+
+	// Without a base name the run comes from the spectrum title, and an
+	// unmodified C-terminus adds no modification
+	p = psms[1]
+	if p.Run != "run02.sample" || p.Scan != 200 || p.Sequence != "LLLK" {
+		t.Errorf("second match = run %q scan %d %s, want run02.sample 200 LLLK", p.Run, p.Scan, p.Sequence)
+	}
+	if len(p.Modifications) != 0 {
+		t.Errorf("second match Modifications = %+v, want none", p.Modifications)
+	}
+}
+
+func TestReadPepXMLErrors(t *testing.T) {
+	query := func(hit string) string {
+		return `<msms_pipeline_analysis><msms_run_summary base_name="run01">
+<spectrum_query spectrum="run01.00100.00100.2" start_scan="100" assumed_charge="2">
+<search_result>` + hit + `</search_result></spectrum_query></msms_run_summary></msms_pipeline_analysis>`
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "position outside peptide",
+			input:   query(`<search_hit hit_rank="1" peptide="AAAK"><modification_info><mod_aminoacid_mass position="5" mass="100"/></modification_info></search_hit>`),
+			wantErr: "modification position 5 outside peptide AAAK",
+		},
+		{
+			name:    "invalid variable mass",
+			input:   query(`<search_hit hit_rank="1" peptide="AAAK"><modification_info><mod_aminoacid_mass position="1" mass="100" variable="x"/></modification_info></search_hit>`),
+			wantErr: "invalid modification mass 'x'",
+		},
+		{
+			name:    "unknown residue",
+			input:   query(`<search_hit hit_rank="1" peptide="AAXK"><modification_info><mod_aminoacid_mass position="3" mass="100"/></modification_info></search_hit>`),
+			wantErr: "unknown residue 'X' at position 3",
+		},
+		{
+			name:    "invalid terminal mass",
+			input:   query(`<search_hit hit_rank="1" peptide="AAAK"><modification_info mod_nterm_mass="abc"/></search_hit>`),
+			wantErr: "invalid mod_nterm_mass 'abc'",
+		},
+		{
+			name:    "malformed XML",
+			input:   `<msms_pipeline_analysis><spectrum_query>`,
+			wantErr: "failed to parse pepXML",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPepXML(strings.NewReader(tt.input), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadPepXML() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
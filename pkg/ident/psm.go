@@ -0,0 +1,198 @@
+// Package ident reads peptide identifications from search engine results
+// (pepXML, mzIdentML) for building empirical spectral libraries.
+package ident
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// modTolerance is the mass tolerance in Da for naming modifications
+const modTolerance = 0.01
+
+// PSM is a peptide-spectrum match. Scores holds every score reported for
+// the match by name, e.g. "expect" or "MS-GF:SpecEValue".
+type PSM struct {
+	Run           string // Raw file base name without directory or extension
+	SpectrumID    string // Native ID of the spectrum, if reported
+	Scan          int    // Scan number, 0 if unknown
+	Index         int    // 0-based spectrum index, -1 if unknown
+	Charge        int
+	Sequence      string
+	Modifications []core.Modification
+	RetentionTime *float64 // Minutes
+	Scores        map[string]float64
+}
+
+// Key returns the modified sequence and charge identifying the precursor
+func (p *PSM) Key() string {
+	spec := core.Spectrum{Sequence: p.Sequence, Charge: p.Charge, Modifications: p.Modifications}
+	return spec.Key()
+}
+
+// QValue returns the q-value of the match, if one was reported
+func (p *PSM) QValue() (float64, bool) {
+	for _, name := range sortedScoreNames(p.Scores) {
+		if isQValue(name) {
+			return p.Scores[name], true
+		}
+	}
+	return 0, false
+}
+
+// isQValue reports whether a score name is a q-value
+func isQValue(name string) bool {
+	n := strings.ToLower(name)
+	return strings.Contains(n, "q-value") || strings.Contains(n, "qvalue")
+}
+
+// LowerIsBetter reports whether smaller values of the named score are
+// better, as for expectation values, q-values and posterior error
+// probabilities
+func LowerIsBetter(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range []string{"expect", "evalue", "e-value", "q-value", "qvalue", "posterior error", "posterior_error"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	// Posterior error probability, but not e.g. "peptideprophet_probability"
+	return n == "pep" || strings.HasSuffix(n, ":pep") || strings.HasSuffix(n, "_pep")
+}
+
+// preferredScores are chosen, in order, when no score name is given
+var preferredScores = []string{
+	"iprophet_probability",
+	"peptideprophet_probability",
+	"hyperscore",
+	"xcorr",
+	"MS-GF:SpecEValue",
+	"Mascot:score",
+	"expect",
+}
+
+// DefaultScore returns the score used to rank matches when none is given:
+// the first preferred score reported for every match, otherwise the first
+// score name in alphabetical order
+func DefaultScore(psms []*PSM) string {
+	if len(psms) == 0 {
+		return ""
+	}
+	for _, name := range preferredScores {
+		if hasScore(psms, name) {
+			return name
+		}
+	}
+	for _, name := range sortedScoreNames(psms[0].Scores) {
+		if !isQValue(name) && hasScore(psms, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// hasScore reports whether every match has the named score
+func hasScore(psms []*PSM, name string) bool {
+	for _, p := range psms {
+		if _, ok := p.Scores[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Better reports whether score a is better than score b for the named score
+func Better(name string, a, b float64) bool {
+	if LowerIsBetter(name) {
+		return a < b
+	}
+	return a > b
+}
+
+// Filter keeps matches whose q-value is at most maxQValue (when reported and
+// maxQValue >= 0) and whose score is at least as good as threshold (unless
+// threshold is NaN). Matches without the score are dropped.
+func Filter(psms []*PSM, score string, threshold, maxQValue float64) []*PSM {
+	var kept []*PSM
+	for _, p := range psms {
+		if q, ok := p.QValue(); ok && maxQValue >= 0 && q > maxQValue {
+			continue
+		}
+		if score != "" {
+			value, ok := p.Scores[score]
+			if !ok {
+				continue
+			}
+			if !math.IsNaN(threshold) && Better(score, threshold, value) {
+				continue
+			}
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// BestPerPrecursor keeps the best-scoring match for each modified sequence
+// and charge, preserving the order in which precursors were first seen.
+// Ties keep the earlier match.
+func BestPerPrecursor(psms []*PSM, score string) []*PSM {
+	best := make(map[string]int)
+	var kept []*PSM
+	for _, p := range psms {
+		key := p.Key()
+		i, ok := best[key]
+		if !ok {
+			best[key] = len(kept)
+			kept = append(kept, p)
+			continue
+		}
+		if score != "" && Better(score, p.Scores[score], kept[i].Scores[score]) {
+			kept[i] = p
+		}
+	}
+	return kept
+}
+
+// RunName returns the base name of a raw or peak list file path without its
+// directory and extensions, e.g. "/data/run01.mzML" -> "run01"
+func RunName(path string) string {
+	// Handle Windows paths and file URLs from other machines
+	path = strings.TrimPrefix(path, "file://")
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		path = path[i+1:]
+	}
+	path = filepath.Base(path)
+	for {
+		ext := filepath.Ext(path)
+		switch strings.ToLower(ext) {
+		case ".mzml", ".mzxml", ".mgf", ".raw", ".d", ".wiff", ".gz", ".pepxml", ".xml", ".pep", ".mzid":
+			path = strings.TrimSuffix(path, ext)
+		default:
+			return path
+		}
+	}
+}
+
+// sortedScoreNames returns score names in alphabetical order
+func sortedScoreNames(scores map[string]float64) []string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// modificationName names a mass shift from the modification database,
+// falling back to the formatted mass
+func modificationName(modDB *core.ModDatabase, mass float64) string {
+	if name, ok := modDB.NameForMass(mass, modTolerance); ok {
+		return name
+	}
+	return fmt.Sprintf("%+.4f", mass)
+}
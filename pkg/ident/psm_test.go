@@ -0,0 +1,196 @@
+package ident
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLowerIsBetter(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"expect", true},
+		{"MS-GF:SpecEValue", true},
+		{"MS-GF:EValue", true},
+		{"Mascot:expectation value", true},
+		{"PSM-level q-value", true},
+		{"MS-GF:QValue", true},
+		{"percolator_qvalue", true},
+		{"PSM-level e-value", true},
+		{"posterior error probability", true},
+		{"percolator_posterior_error_prob", true},
+		{"PEP", true},
+		{"percolator:PEP", true},
+		{"percolator_pep", true},
+		{"hyperscore", false},
+		{"xcorr", false},
+		{"peptideprophet_probability", false},
+		{"iprophet_probability", false},
+		{"Mascot:score", false},
+		{"pepscore", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LowerIsBetter(tt.name); got != tt.want {
+				t.Errorf("LowerIsBetter(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []map[string]float64
+		want   string
+	}{
+		{
+			name:   "first preferred score",
+			scores: []map[string]float64{{"expect": 1e-5, "hyperscore": 30}, {"expect": 1e-3, "hyperscore": 20}},
+			want:   "hyperscore",
+		},
+		{
+			name:   "preferred score missing from a match",
+			scores: []map[string]float64{{"expect": 1e-5, "hyperscore": 30}, {"expect": 1e-3}},
+			want:   "expect",
+		},
+		{
+			name:   "alphabetical fallback skips q-values",
+			scores: []map[string]float64{{"Andromeda": 80, "Byonic": 300, "PSM q-value": 0.01}, {"Byonic": 200, "PSM q-value": 0.02}},
+			want:   "Byonic",
+		},
+		{
+			name:   "no common score",
+			scores: []map[string]float64{{"a": 1}, {"b": 1}},
+			want:   "",
+		},
+		{
+			name: "no matches",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var psms []*PSM
+			for _, scores := range tt.scores {
+				psms = append(psms, &PSM{Scores: scores})
+			}
+			if got := DefaultScore(psms); got != tt.want {
+				t.Errorf("DefaultScore() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testPSMs returns matches named by sequence with the given scores
+func testPSMs() []*PSM {
+	return []*PSM{
+		{Sequence: "AAAK", Charge: 2, Scores: map[string]float64{"expect": 1e-6, "hyperscore": 40, "q-value": 0.001}},
+		{Sequence: "CCCK", Charge: 2, Scores: map[string]float64{"expect": 1e-2, "hyperscore": 15, "q-value": 0.05}},
+		{Sequence: "DDDK", Charge: 2, Scores: map[string]float64{"expect": 1e-4, "hyperscore": 25}},
+		{Sequence: "EEEK", Charge: 2, Scores: map[string]float64{"hyperscore": 30, "q-value": 0.005}},
+	}
+}
+
+// sequences joins the sequences of matches
+func sequences(psms []*PSM) string {
+	seqs := make([]string, len(psms))
+	for i, p := range psms {
+		seqs[i] = p.Sequence
+	}
+	return strings.Join(seqs, ",")
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		score     string
+		threshold float64
+		maxQValue float64
+		want      string
+	}{
+		{name: "q-value only", threshold: math.NaN(), maxQValue: 0.01, want: "AAAK,DDDK,EEEK"},
+		{name: "no q-value filter", threshold: math.NaN(), maxQValue: -1, want: "AAAK,CCCK,DDDK,EEEK"},
+		{name: "matches without the score dropped", score: "expect", threshold: math.NaN(), maxQValue: -1, want: "AAAK,CCCK,DDDK"},
+		{name: "lower is better threshold", score: "expect", threshold: 1e-4, maxQValue: -1, want: "AAAK,DDDK"},
+		{name: "higher is better threshold", score: "hyperscore", threshold: 25, maxQValue: -1, want: "AAAK,DDDK,EEEK"},
+		{name: "threshold and q-value", score: "hyperscore", threshold: 25, maxQValue: 0.002, want: "AAAK,DDDK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sequences(Filter(testPSMs(), tt.score, tt.threshold, tt.maxQValue)); got != tt.want {
+				t.Errorf("Filter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQValue(t *testing.T) {
+	p := &PSM{Scores: map[string]float64{"hyperscore": 30, "PSM-level q-value": 0.02}}
+	if q, ok := p.QValue(); !ok || q != 0.02 {
+		t.Errorf("QValue() = %v, %v, want 0.02, true", q, ok)
+	}
+	p = &PSM{Scores: map[string]float64{"hyperscore": 30}}
+	if _, ok := p.QValue(); ok {
+		t.Error("QValue() without a q-value reported one")
+	}
+}
+
+func TestBestPerPrecursor(t *testing.T) {
+	psms := []*PSM{
+		{Sequence: "AAAK", Charge: 2, Scan: 1, Scores: map[string]float64{"expect": 1e-3, "hyperscore": 20}},
+		{Sequence: "CCCK", Charge: 2, Scan: 2, Scores: map[string]float64{"expect": 1e-5, "hyperscore": 30}},
+		{Sequence: "AAAK", Charge: 2, Scan: 3, Scores: map[string]float64{"expect": 1e-6, "hyperscore": 10}},
+		{Sequence: "AAAK", Charge: 3, Scan: 4, Scores: map[string]float64{"expect": 1e-2, "hyperscore": 5}},
+		{Sequence: "CCCK", Charge: 2, Scan: 5, Scores: map[string]float64{"expect": 1e-5, "hyperscore": 30}},
+	}
+
+	tests := []struct {
+		name  string
+		score string
+		want  []int // Scans of the kept matches
+	}{
+		{name: "lower is better", score: "expect", want: []int{3, 2, 4}},
+		{name: "higher is better", score: "hyperscore", want: []int{1, 2, 4}},
+		{name: "no score keeps the first", score: "", want: []int{1, 2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := BestPerPrecursor(psms, tt.score)
+			if len(kept) != len(tt.want) {
+				t.Fatalf("BestPerPrecursor() kept %d matches, want %d", len(kept), len(tt.want))
+			}
+			for i, p := range kept {
+				if p.Scan != tt.want[i] {
+					t.Errorf("BestPerPrecursor()[%d] = scan %d, want scan %d", i, p.Scan, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"run01", "run01"},
+		{"/data/run01.mzML", "run01"},
+		{"run01.mzML.gz", "run01"},
+		{"interact-run01.pep.xml", "interact-run01"},
+		{"run01.pepXML", "run01"},
+		{`C:\data\run01.raw`, "run01"},
+		{"file:///data/run01.mzML", "run01"},
+		{"/data/sample.2024.mzML", "sample.2024"},
+		{"/data/run01.d", "run01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := RunName(tt.path); got != tt.want {
+				t.Errorf("RunName(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package encyclopedia provides readers for EncyclopeDIA DLIB and ELIB
+// spectral libraries
+package encyclopedia
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Reader provides streaming access to the entries of a DLIB or ELIB library
+type Reader struct {
+	db          *sql.DB
+	rows        *sql.Rows
+	modDB       *core.ModDatabase
+	currentSpec *core.Spectrum
+	err         error
+}
+
+// Open opens a DLIB or ELIB library for reading
+func Open(path string, modDB *core.ModDatabase) (*Reader, error) {
+	return OpenContext(context.Background(), path, modDB)
+}
+
+// OpenContext opens a DLIB or ELIB library for reading. The entry query is
+// interrupted if ctx is cancelled.
+func OpenContext(ctx context.Context, path string, modDB *core.ModDatabase) (*Reader, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+
+	db, err := sql.Open("sqlite3", sqliteuri.ReadOnly(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Protein accessions and decoy flags are optional
+	query := `
+		SELECT e.PeptideModSeq, e.PeptideSeq, e.PrecursorCharge, e.PrecursorMz,
+			e.RTInSeconds, e.SourceFile,
+			e.MassEncodedLength, e.MassArray, e.IntensityEncodedLength, e.IntensityArray,
+			NULL, NULL
+		FROM entries e
+		ORDER BY e.rowid
+	`
+	if hasTable(ctx, db, "peptidetoprotein") {
+		query = `
+			SELECT e.PeptideModSeq, e.PeptideSeq, e.PrecursorCharge, e.PrecursorMz,
+				e.RTInSeconds, e.SourceFile,
+				e.MassEncodedLength, e.MassArray, e.IntensityEncodedLength, e.IntensityArray,
+				(SELECT group_concat(p.ProteinAccession, ';') FROM peptidetoprotein p WHERE p.PeptideSeq = e.PeptideSeq),
+				(SELECT max(p.isDecoy) FROM peptidetoprotein p WHERE p.PeptideSeq = e.PeptideSeq)
+			FROM entries e
+			ORDER BY e.rowid
+		`
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to query entries: %w", err)
+	}
+
+	return &Reader{
+		db:    db,
+		rows:  rows,
+		modDB: modDB,
+	}, nil
+}
+
+// hasTable reports whether the database has a table
+func hasTable(ctx context.Context, db *sql.DB, name string) bool {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return err == nil && n > 0
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	if r.err != nil {
+		return false
+	}
+
+	if !r.rows.Next() {
+		r.err = r.rows.Err()
+		return false
+	}
+
+	spec, err := r.scanSpectrum()
+	if err != nil {
+		r.err = err
+		return false
+	}
+
+	r.currentSpec = spec
+	return true
+}
+
+// Spectrum returns the current spectrum
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close closes the database
+func (r *Reader) Close() error {
+	r.rows.Close()
+	return r.db.Close()
+}
+
+// scanSpectrum converts the current row to a spectrum
+func (r *Reader) scanSpectrum() (*core.Spectrum, error) {
+	var (
+		modSeq, seq, sourceFile sql.NullString
+		proteins                sql.NullString
+		charge                  int
+		precursorMZ             float64
+		rt                      sql.NullFloat64
+		massLength, intLength   int
+		massBlob, intBlob       []byte
+		decoy                   sql.NullBool
+	)
+
+	if err := r.rows.Scan(&modSeq, &seq, &charge, &precursorMZ,
+		&rt, &sourceFile,
+		&massLength, &massBlob, &intLength, &intBlob,
+		&proteins, &decoy); err != nil {
+		return nil, fmt.Errorf("failed to read entry row: %w", err)
+	}
+
+	spec := &core.Spectrum{
+		Charge:       charge,
+		PrecursorMZ:  precursorMZ,
+		SourceFile:   sourceFile.String,
+		SourceFormat: "encyclopedia",
+		Decoy:        decoy.Bool,
+	}
+
+	var err error
+	spec.Sequence, spec.Modifications, err = ParsePeptideModSeq(modSeq.String, r.modDB)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s/%d: %w", modSeq.String, charge, err)
+	}
+	if seq.Valid && seq.String != spec.Sequence {
+		return nil, fmt.Errorf("entry %s/%d: modified peptide does not match peptide '%s'", modSeq.String, charge, seq.String)
+	}
+
+	// Retention times are stored in minutes
+	if rt.Valid {
+		v := rt.Float64 / 60
+		spec.RetentionTime = &v
+	}
+	if proteins.Valid {
+		spec.Proteins = strings.Split(proteins.String, ";")
+	}
+
+	spec.Peaks, err = decodePeaks(massBlob, massLength, intBlob, intLength)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s/%d: %w", modSeq.String, charge, err)
+	}
+	if !spec.ArePeaksSorted() {
+		spec.SortPeaks()
+	}
+
+	return spec, nil
+}
+
+// ParsePeptideModSeq parses an EncyclopeDIA modified peptide, with the mass
+// shift of each modification in brackets after its residue, e.g.
+// "PEPT[+79.966331]IDE". A shift before the first residue is an N-terminal
+// modification. Modifications are named from the database by mass; other
+// shifts are named by their signed mass.
+func ParsePeptideModSeq(s string, modDB *core.ModDatabase) (string, []core.Modification, error) {
+	var seq strings.Builder
+	var mods []core.Modification
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '[' {
+			if c < 'A' || c > 'Z' {
+				return "", nil, fmt.Errorf("invalid residue '%c' in peptide '%s'", c, s)
+			}
+			seq.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], ']')
+		if end < 0 {
+			return "", nil, fmt.Errorf("unclosed modification in peptide '%s'", s)
+		}
+		token := s[i+1 : i+end]
+		i += end
+
+		name, mass, ok := modDB.Resolve(token)
+		if !ok {
+			return "", nil, fmt.Errorf("invalid modification '%s' in peptide '%s'", token, s)
+		}
+		mods = append(mods, core.Modification{
+			Mass:     mass,
+			Position: seq.Len() - 1, // -1 before the first residue
+			Name:     name,
+		})
+	}
+
+	return seq.String(), mods, nil
+}
+
+// decodePeaks decodes the zlib-compressed big-endian float64 m/z and float32
+// intensity arrays
+func decodePeaks(massBlob []byte, massLength int, intBlob []byte, intLength int) ([]core.Peak, error) {
+	masses, err := inflate(massBlob, massLength)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mass array: %w", err)
+	}
+	intensities, err := inflate(intBlob, intLength)
+	if err != nil {
+		return nil, fmt.Errorf("invalid intensity array: %w", err)
+	}
+	if len(masses)%8 != 0 || len(intensities) != len(masses)/2 {
+		return nil, fmt.Errorf("invalid peak arrays: %d mass bytes, %d intensity bytes", len(masses), len(intensities))
+	}
+
+	peaks := make([]core.Peak, len(masses)/8)
+	for i := range peaks {
+		peaks[i] = core.Peak{
+			MZ:        math.Float64frombits(binary.BigEndian.Uint64(masses[i*8:])),
+			Intensity: float64(math.Float32frombits(binary.BigEndian.Uint32(intensities[i*4:]))),
+		}
+	}
+	return peaks, nil
+}
+
+// inflate decompresses a zlib blob of the given uncompressed length
+func inflate(blob []byte, length int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,318 @@
+package encyclopedia
+
+import (
+	"bytes"
+	"compress/zlib"
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// compress zlib-compresses a peak array
+func compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeMasses encodes m/z values as big-endian float64
+func encodeMasses(values ...float64) []byte {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint64(data[i*8:], math.Float64bits(v))
+	}
+	return data
+}
+
+// encodeIntensities encodes intensities as big-endian float32
+func encodeIntensities(values ...float32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	return data
+}
+
+// testEntry is a row of the entries table
+type testEntry struct {
+	modSeq, seq string
+	charge      int
+	mz, rt      float64
+	masses      []byte
+	intensities []byte
+}
+
+// writeTestLibrary writes a library with the given entries and, when
+// proteins is set, a peptidetoprotein table
+func writeTestLibrary(t *testing.T, entries []testEntry, proteins bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "library.dlib")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE entries (
+		PrecursorMz REAL, PrecursorCharge INT, PeptideModSeq TEXT, PeptideSeq TEXT,
+		Copies INT, RTInSeconds REAL, Score REAL,
+		MassEncodedLength INT, MassArray BLOB, IntensityEncodedLength INT, IntensityArray BLOB,
+		SourceFile TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if _, err := db.Exec(`INSERT INTO entries VALUES (?, ?, ?, ?, 1, ?, 0, ?, ?, ?, ?, 'run.mzML')`,
+			e.mz, e.charge, e.modSeq, e.seq, e.rt,
+			len(e.masses), compress(t, e.masses), len(e.intensities), compress(t, e.intensities)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if proteins {
+		if _, err := db.Exec(`
+			CREATE TABLE peptidetoprotein (PeptideSeq TEXT, isDecoy BOOLEAN, ProteinAccession TEXT);
+			INSERT INTO peptidetoprotein VALUES ('PEPTIDEK', 0, 'P1'), ('PEPTIDEK', 0, 'P2'), ('KEDITPEP', 1, 'DECOY_P1');
+		`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// testEntries are a phosphopeptide with unsorted peaks and a decoy
+func testEntries() []testEntry {
+	return []testEntry{
+		{
+			modSeq: "PEPT[+79.966331]IDEK", seq: "PEPTIDEK", charge: 2, mz: 505.2, rt: 600,
+			masses: encodeMasses(400.2, 175.119), intensities: encodeIntensities(50, 100),
+		},
+		{
+			modSeq: "KEDITPEP", seq: "KEDITPEP", charge: 3, mz: 310.5, rt: 90,
+			masses: encodeMasses(200.1), intensities: encodeIntensities(10),
+		},
+	}
+}
+
+func TestReader(t *testing.T) {
+	for _, proteins := range []bool{true, false} {
+		name := "without proteins"
+		if proteins {
+			name = "with proteins"
+		}
+		t.Run(name, func(t *testing.T) {
+			r, err := Open(writeTestLibrary(t, testEntries(), proteins), nil)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer r.Close()
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			spec := r.Spectrum()
+			if spec.Sequence != "PEPTIDEK" || spec.Charge != 2 || spec.PrecursorMZ != 505.2 {
+				t.Errorf("precursor = %s/%d %v, want PEPTIDEK/2 505.2", spec.Sequence, spec.Charge, spec.PrecursorMZ)
+			}
+			if spec.RetentionTime == nil || *spec.RetentionTime != 10 {
+				t.Errorf("RetentionTime = %v, want 10 minutes", spec.RetentionTime)
+			}
+			if spec.SourceFile != "run.mzML" || spec.SourceFormat != "encyclopedia" {
+				t.Errorf("source = %q %q, want run.mzML encyclopedia", spec.SourceFile, spec.SourceFormat)
+			}
+			if len(spec.Modifications) != 1 || spec.Modifications[0].Position != 3 || spec.Modifications[0].Name != "Phospho" {
+				t.Errorf("Modifications = %+v, want Phospho at 3", spec.Modifications)
+			}
+			want := []core.Peak{{MZ: 175.119, Intensity: 100}, {MZ: 400.2, Intensity: 50}}
+			if len(spec.Peaks) != len(want) {
+				t.Fatalf("Peaks = %+v, want %+v", spec.Peaks, want)
+			}
+			for i := range want {
+				if spec.Peaks[i] != want[i] {
+					t.Errorf("Peaks[%d] = %+v, want %+v", i, spec.Peaks[i], want[i])
+				}
+			}
+			wantProteins := ""
+			if proteins {
+				wantProteins = "P1;P2"
+			}
+			if got := strings.Join(spec.Proteins, ";"); got != wantProteins {
+				t.Errorf("Proteins = %q, want %q", got, wantProteins)
+			}
+			if spec.Decoy {
+				t.Error("Decoy = true for a target")
+			}
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			spec = r.Spectrum()
+			if spec.Sequence != "KEDITPEP" || spec.Decoy != proteins {
+				t.Errorf("second entry = %s decoy %v, want KEDITPEP decoy %v", spec.Sequence, spec.Decoy, proteins)
+			}
+
+			if r.Next() {
+				t.Error("Next() = true after the last entry")
+			}
+			if r.Err() != nil {
+				t.Errorf("Err() = %v", r.Err())
+			}
+		})
+	}
+}
+
+func TestOpenRelativePath(t *testing.T) {
+	path := writeTestLibrary(t, testEntries(), false)
+	t.Chdir(filepath.Dir(path))
+
+	r, err := Open(filepath.Base(path), nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+	n := 0
+	for r.Next() {
+		n++
+	}
+	if r.Err() != nil || n != 2 {
+		t.Errorf("read %d entries, err = %v, want 2", n, r.Err())
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	peaks := func(e testEntry) testEntry {
+		e.masses = encodeMasses(200.1)
+		e.intensities = encodeIntensities(10)
+		return e
+	}
+	tests := []struct {
+		name    string
+		entry   testEntry
+		wantErr string
+	}{
+		{
+			name:    "mismatched peptide",
+			entry:   peaks(testEntry{modSeq: "PEPTIDEK", seq: "PEPTIDER", charge: 2}),
+			wantErr: "does not match peptide 'PEPTIDER'",
+		},
+		{
+			name:    "invalid modification",
+			entry:   peaks(testEntry{modSeq: "PEPT[phospho?]IDEK", seq: "PEPTIDEK", charge: 2}),
+			wantErr: "invalid modification 'phospho?'",
+		},
+		{
+			name: "short intensity array",
+			entry: testEntry{modSeq: "PEPTIDEK", seq: "PEPTIDEK", charge: 2,
+				masses: encodeMasses(200.1, 300.2), intensities: encodeIntensities(10)},
+			wantErr: "invalid peak arrays: 16 mass bytes, 4 intensity bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Open(writeTestLibrary(t, []testEntry{tt.entry}, false), nil)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer r.Close()
+			if r.Next() {
+				t.Fatal("Next() = true, want an error")
+			}
+			if r.Err() == nil || !strings.Contains(r.Err().Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", r.Err(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePeptideModSeq(t *testing.T) {
+	modDB := core.DefaultModDatabase()
+	tests := []struct {
+		input    string
+		wantSeq  string
+		wantMods []core.Modification
+		wantErr  bool
+	}{
+		{input: "PEPTIDEK", wantSeq: "PEPTIDEK"},
+		{
+			input:    "PEPTM[+15.994915]IDEK",
+			wantSeq:  "PEPTMIDEK",
+			wantMods: []core.Modification{{Mass: 15.994915, Position: 4, Name: "Oxidation"}},
+		},
+		{
+			input:    "[+42.010565]PEPTIDEK",
+			wantSeq:  "PEPTIDEK",
+			wantMods: []core.Modification{{Mass: 42.010565, Position: -1, Name: "Acetyl"}},
+		},
+		{
+			input:    "PEPTIDEK[+1.5]",
+			wantSeq:  "PEPTIDEK",
+			wantMods: []core.Modification{{Mass: 1.5, Position: 7, Name: "+1.5"}},
+		},
+		{input: "PEPT[+79.966331IDEK", wantErr: true},
+		{input: "pePTIDEK", wantErr: true},
+		{input: "PEPT[x]IDEK", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			seq, mods, err := ParsePeptideModSeq(tt.input, modDB)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePeptideModSeq(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if seq != tt.wantSeq {
+				t.Errorf("ParsePeptideModSeq(%q) sequence = %q, want %q", tt.input, seq, tt.wantSeq)
+			}
+			if len(mods) != len(tt.wantMods) {
+				t.Fatalf("ParsePeptideModSeq(%q) mods = %+v, want %+v", tt.input, mods, tt.wantMods)
+			}
+			for i := range mods {
+				if mods[i] != tt.wantMods[i] {
+					t.Errorf("mods[%d] = %+v, want %+v", i, mods[i], tt.wantMods[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodePeaks(t *testing.T) {
+	masses := encodeMasses(100.5, 200.25)
+	intensities := encodeIntensities(1000, 0.5)
+
+	peaks, err := decodePeaks(compress(t, masses), len(masses), compress(t, intensities), len(intensities))
+	if err != nil {
+		t.Fatalf("decodePeaks() error = %v", err)
+	}
+	want := []core.Peak{{MZ: 100.5, Intensity: 1000}, {MZ: 200.25, Intensity: 0.5}}
+	if len(peaks) != len(want) || peaks[0] != want[0] || peaks[1] != want[1] {
+		t.Errorf("decodePeaks() = %+v, want %+v", peaks, want)
+	}
+
+	tests := []struct {
+		name       string
+		massBlob   []byte
+		massLength int
+		wantErr    string
+	}{
+		{name: "not compressed", massBlob: masses, massLength: len(masses), wantErr: "invalid mass array"},
+		{name: "longer than the blob", massBlob: compress(t, masses), massLength: len(masses) + 8, wantErr: "invalid mass array"},
+		{name: "partial value", massBlob: compress(t, masses[:12]), massLength: 12, wantErr: "invalid peak arrays"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePeaks(tt.massBlob, tt.massLength, compress(t, intensities), len(intensities))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodePeaks() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package jsonl reads and defines the JSON Lines serialization of the DBKey
+// intermediate representation, one spectrum record per line
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// maxLineSize is the longest record line that can be read
+const maxLineSize = 64 * 1024 * 1024
+
+// Reader provides streaming access to JSON Lines spectrum files
+type Reader struct {
+	scanner     *bufio.Scanner
+	lineNum     int
+	currentSpec *core.Spectrum
+	raw         string // Line of the current record as read
+	offset      int64  // Bytes consumed by the scanner
+	maxErrors   int    // Malformed records to skip before failing, 0 = strict, -1 = unlimited
+	parseErrors []*core.ParseError
+	err         error
+}
+
+// NewReader creates a new JSON Lines reader
+func NewReader(r io.Reader) *Reader {
+	rd := &Reader{scanner: bufio.NewScanner(r)}
+	rd.scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	rd.scanner.Split(rd.scanLines)
+	return rd
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+// Blank lines are ignored. Malformed records are skipped and recorded when
+// SetMaxErrors allows it.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	if r.err != nil {
+		return false
+	}
+
+	for r.scanner.Scan() {
+		r.lineNum++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.raw = r.scanner.Text() + "\n"
+
+		spec, err := parseRecord(line)
+		if err == nil {
+			r.currentSpec = spec
+			return true
+		}
+
+		perr := &core.ParseError{Line: r.lineNum, Err: err, Raw: r.raw}
+		if r.maxErrors == 0 {
+			r.err = perr
+			return false
+		}
+		if r.maxErrors > 0 && len(r.parseErrors) >= r.maxErrors {
+			r.err = fmt.Errorf("too many parse errors (limit %d): %w", r.maxErrors, perr)
+			return false
+		}
+		r.parseErrors = append(r.parseErrors, perr)
+	}
+
+	r.raw = ""
+	if err := r.scanner.Err(); err != nil {
+		r.err = &core.ParseError{Line: r.lineNum + 1, Err: err}
+	}
+	return false
+}
+
+// parseRecord decodes a record line into a spectrum
+func parseRecord(line []byte) (*core.Spectrum, error) {
+	var rec Record
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rec); err != nil {
+		return nil, fmt.Errorf("invalid record: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid record: unexpected data after the record")
+	}
+	if rec.Version > Version {
+		return nil, fmt.Errorf("record version %d is newer than the supported version %d", rec.Version, Version)
+	}
+
+	spec := rec.Spectrum()
+	if spec.SourceFormat == "" {
+		spec.SourceFormat = "jsonl"
+	}
+	return spec, nil
+}
+
+// scanLines splits lines like bufio.ScanLines while counting the bytes
+// consumed, so that Offset stays exact for CRLF line endings
+func (r *Reader) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	r.offset += int64(advance)
+	return advance, token, err
+}
+
+// Offset returns the byte offset in the input just after the current record
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// SetMaxErrors sets how many malformed records are skipped before reading
+// fails. 0 (the default) fails on the first error, -1 skips any number.
+func (r *Reader) SetMaxErrors(n int) {
+	r.maxErrors = n
+}
+
+// ParseErrors returns the malformed records skipped so far
+func (r *Reader) ParseErrors() []*core.ParseError {
+	return r.parseErrors
+}
+
+// Spectrum returns the current spectrum
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Raw returns the line of the current record as it appeared in the input
+func (r *Reader) Raw() string {
+	return r.raw
+}
@@ -0,0 +1,130 @@
+package jsonl
+
+import (
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Version is the version of the record layout written to every record.
+// Records of a later version are refused; records without a version are
+// read as the current version.
+const Version = 1
+
+// Record is the JSON layout of a spectrum, one record per line
+type Record struct {
+	Version           int      `json:"version"`
+	Sequence          string   `json:"sequence,omitempty"`
+	Charge            int      `json:"charge"`
+	PrecursorMZ       float64  `json:"precursor_mz"`
+	Modifications     []Mod    `json:"modifications,omitempty"`
+	RetentionTime     *float64 `json:"retention_time,omitempty"`
+	CollisionEnergy   *float64 `json:"collision_energy,omitempty"`
+	FragmentationMode string   `json:"fragmentation_mode,omitempty"`
+	MassAnalyzer      string   `json:"mass_analyzer,omitempty"`
+	Instrument        string   `json:"instrument,omitempty"`
+	Polarity          string   `json:"polarity,omitempty"`
+	IonizationMode    string   `json:"ionization_mode,omitempty"`
+	MassOffset        float64  `json:"mass_offset,omitempty"`
+	CompoundClass     string   `json:"compound_class,omitempty"`
+	Proteins          []string `json:"proteins,omitempty"`
+	Decoy             bool     `json:"decoy,omitempty"`
+	CompoundName      string   `json:"compound_name,omitempty"`
+	Formula           string   `json:"formula,omitempty"`
+	SMILES            string   `json:"smiles,omitempty"`
+	InChIKey          string   `json:"inchikey,omitempty"`
+	CASNumber         string   `json:"cas,omitempty"`
+	PubChemID         string   `json:"pubchem_id,omitempty"`
+	PrecursorType     string   `json:"precursor_type,omitempty"`
+	SourceFile        string   `json:"source_file,omitempty"`
+	SourceFormat      string   `json:"source_format,omitempty"`
+	Peaks             []Peak   `json:"peaks"`
+}
+
+// Mod is the JSON layout of a modification
+type Mod struct {
+	Mass     float64 `json:"mass"`
+	Position int     `json:"position"` // 0-based; -1 for N-term, len(sequence) for C-term
+	Name     string  `json:"name,omitempty"`
+}
+
+// Peak is the JSON layout of a peak
+type Peak struct {
+	MZ         float64 `json:"mz"`
+	Intensity  float64 `json:"intensity"`
+	Annotation string  `json:"annotation,omitempty"`
+	Charge     int     `json:"charge,omitempty"`
+}
+
+// NewRecord returns the record of a spectrum
+func NewRecord(spec *core.Spectrum) *Record {
+	rec := &Record{
+		Version:           Version,
+		Sequence:          spec.Sequence,
+		Charge:            spec.Charge,
+		PrecursorMZ:       spec.PrecursorMZ,
+		RetentionTime:     spec.RetentionTime,
+		CollisionEnergy:   spec.CollisionEnergy,
+		FragmentationMode: spec.FragmentationMode,
+		MassAnalyzer:      spec.MassAnalyzer,
+		Instrument:        spec.Instrument,
+		Polarity:          spec.Polarity,
+		IonizationMode:    spec.IonizationMode,
+		MassOffset:        spec.MassOffset,
+		CompoundClass:     spec.CompoundClass,
+		Proteins:          spec.Proteins,
+		Decoy:             spec.Decoy,
+		CompoundName:      spec.CompoundName,
+		Formula:           spec.Formula,
+		SMILES:            spec.SMILES,
+		InChIKey:          spec.InChIKey,
+		CASNumber:         spec.CASNumber,
+		PubChemID:         spec.PubChemID,
+		PrecursorType:     spec.PrecursorType,
+		SourceFile:        spec.SourceFile,
+		SourceFormat:      spec.SourceFormat,
+		Peaks:             make([]Peak, len(spec.Peaks)),
+	}
+	for _, mod := range spec.Modifications {
+		rec.Modifications = append(rec.Modifications, Mod(mod))
+	}
+	for i, peak := range spec.Peaks {
+		rec.Peaks[i] = Peak(peak)
+	}
+	return rec
+}
+
+// Spectrum returns the spectrum of a record
+func (rec *Record) Spectrum() *core.Spectrum {
+	spec := &core.Spectrum{
+		Sequence:          rec.Sequence,
+		Charge:            rec.Charge,
+		PrecursorMZ:       rec.PrecursorMZ,
+		RetentionTime:     rec.RetentionTime,
+		CollisionEnergy:   rec.CollisionEnergy,
+		FragmentationMode: rec.FragmentationMode,
+		MassAnalyzer:      rec.MassAnalyzer,
+		Instrument:        rec.Instrument,
+		Polarity:          rec.Polarity,
+		IonizationMode:    rec.IonizationMode,
+		MassOffset:        rec.MassOffset,
+		CompoundClass:     rec.CompoundClass,
+		Proteins:          rec.Proteins,
+		Decoy:             rec.Decoy,
+		CompoundName:      rec.CompoundName,
+		Formula:           rec.Formula,
+		SMILES:            rec.SMILES,
+		InChIKey:          rec.InChIKey,
+		CASNumber:         rec.CASNumber,
+		PubChemID:         rec.PubChemID,
+		PrecursorType:     rec.PrecursorType,
+		SourceFile:        rec.SourceFile,
+		SourceFormat:      rec.SourceFormat,
+		Peaks:             make([]core.Peak, len(rec.Peaks)),
+	}
+	for _, mod := range rec.Modifications {
+		spec.Modifications = append(spec.Modifications, core.Modification(mod))
+	}
+	for i, peak := range rec.Peaks {
+		spec.Peaks[i] = core.Peak(peak)
+	}
+	return spec
+}
@@ -0,0 +1,221 @@
+// Package mgf provides streaming readers for MGF (Mascot Generic Format) experimental spectra
+package mgf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Reader provides streaming access to MGF format files
+type Reader struct {
+	scanner      *bufio.Scanner
+	lineNum      int
+	currentSpec  *core.Spectrum
+	currentTitle string
+	err          error
+}
+
+// NewReader creates a new MGF reader
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	return &Reader{
+		scanner: scanner,
+	}
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	r.currentTitle = ""
+
+	spec, title, err := r.readSpectrum()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+
+	r.currentSpec = spec
+	r.currentTitle = title
+	return true
+}
+
+// Spectrum returns the current spectrum. Sequence is only set when the entry
+// carries a SEQ field; Charge is 0 when the entry has no CHARGE field.
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// Title returns the TITLE of the current spectrum
+func (r *Reader) Title() string {
+	return r.currentTitle
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// readSpectrum reads a single BEGIN IONS ... END IONS block
+func (r *Reader) readSpectrum() (*core.Spectrum, string, error) {
+	var spec *core.Spectrum
+	var title string
+
+	for r.scanner.Scan() {
+		r.lineNum++
+		line := strings.TrimSpace(r.scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if spec == nil {
+			if line == "BEGIN IONS" {
+				spec = &core.Spectrum{
+					SourceFormat: "mgf",
+					Peaks:        []core.Peak{},
+				}
+			}
+			// Global parameters before the first block are ignored
+			continue
+		}
+
+		if line == "END IONS" {
+			spec.SortPeaks()
+			return spec, title, nil
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok && !isNumericStart(line) {
+			if err := r.parseHeader(spec, &title, strings.ToUpper(key), value); err != nil {
+				return nil, "", fmt.Errorf("line %d: %w", r.lineNum, err)
+			}
+			continue
+		}
+
+		peak, err := parsePeak(line)
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: %w", r.lineNum, err)
+		}
+		spec.Peaks = append(spec.Peaks, peak)
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if spec != nil {
+		return nil, "", fmt.Errorf("line %d: unterminated BEGIN IONS block", r.lineNum)
+	}
+
+	return nil, "", io.EOF
+}
+
+// parseHeader parses a KEY=value line inside an ions block
+func (r *Reader) parseHeader(spec *core.Spectrum, title *string, key, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case "TITLE":
+		*title = value
+
+	case "PEPMASS":
+		// Format: "mz [intensity]"
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return fmt.Errorf("empty PEPMASS")
+		}
+		mz, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return fmt.Errorf("invalid PEPMASS '%s': %w", value, err)
+		}
+		spec.PrecursorMZ = mz
+
+	case "CHARGE":
+		charge, err := parseCharge(value)
+		if err != nil {
+			return err
+		}
+		spec.Charge = charge
+
+	case "RTINSECONDS":
+		rt, err := strconv.ParseFloat(strings.Fields(value + " ")[0], 64)
+		if err == nil {
+			rt = rt / 60
+			spec.RetentionTime = &rt
+		}
+
+	case "SEQ":
+		spec.Sequence = value
+	}
+
+	return nil
+}
+
+// parseCharge parses charge values like "2+", "3-", "2" or "2+ and 3+".
+// Only the first charge is used.
+func parseCharge(value string) (int, error) {
+	fields := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	str := fields[0]
+	sign := 1
+	if strings.HasSuffix(str, "-") {
+		sign = -1
+	}
+	str = strings.TrimRight(str, "+-")
+	str = strings.TrimLeft(str, "+-")
+
+	charge, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CHARGE '%s': %w", value, err)
+	}
+	return sign * charge, nil
+}
+
+// parsePeak parses a peak line (format: "mz intensity [charge]")
+func parsePeak(line string) (core.Peak, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return core.Peak{}, fmt.Errorf("invalid peak format, expected at least 2 fields")
+	}
+
+	mz, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return core.Peak{}, fmt.Errorf("invalid m/z value: %w", err)
+	}
+
+	intensity, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return core.Peak{}, fmt.Errorf("invalid intensity value: %w", err)
+	}
+
+	peak := core.Peak{
+		MZ:        mz,
+		Intensity: intensity,
+	}
+
+	if len(fields) >= 3 {
+		if charge, err := parseCharge(fields[2]); err == nil {
+			peak.Charge = charge
+		}
+	}
+
+	return peak, nil
+}
+
+// isNumericStart reports whether a line starts like a peak line
+func isNumericStart(line string) bool {
+	c := line[0]
+	return (c >= '0' && c <= '9') || c == '.'
+}
@@ -0,0 +1,150 @@
+package mgf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+const testMGF = `COM=global parameters are ignored
+CHARGE=3+
+
+BEGIN IONS
+TITLE=scan=1
+PEPMASS=464.7348 12000
+CHARGE=2+
+RTINSECONDS=90
+SEQ=PEPTIDEK
+# comment
+300.1 50
+175.119 100 1+
+END IONS
+
+BEGIN IONS
+TITLE=scan=2
+PEPMASS=500.25
+END IONS
+`
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(testMGF))
+
+	if !r.Next() {
+		t.Fatalf("Next() = false, err = %v", r.Err())
+	}
+	spec := r.Spectrum()
+	if r.Title() != "scan=1" {
+		t.Errorf("Title() = %q, want scan=1", r.Title())
+	}
+	if spec.PrecursorMZ != 464.7348 || spec.Charge != 2 || spec.Sequence != "PEPTIDEK" {
+		t.Errorf("precursor = %v %d %q, want 464.7348 2 PEPTIDEK", spec.PrecursorMZ, spec.Charge, spec.Sequence)
+	}
+	if spec.RetentionTime == nil || *spec.RetentionTime != 1.5 {
+		t.Errorf("RetentionTime = %v, want 1.5 minutes", spec.RetentionTime)
+	}
+	if spec.SourceFormat != "mgf" {
+		t.Errorf("SourceFormat = %q, want mgf", spec.SourceFormat)
+	}
+	want := []core.Peak{{MZ: 175.119, Intensity: 100, Charge: 1}, {MZ: 300.1, Intensity: 50}}
+	if len(spec.Peaks) != len(want) {
+		t.Fatalf("Peaks = %+v, want %+v", spec.Peaks, want)
+	}
+	for i := range want {
+		if spec.Peaks[i] != want[i] {
+			t.Errorf("Peaks[%d] = %+v, want %+v", i, spec.Peaks[i], want[i])
+		}
+	}
+
+	if !r.Next() {
+		t.Fatalf("Next() = false, err = %v", r.Err())
+	}
+	spec = r.Spectrum()
+	if r.Title() != "scan=2" || spec.PrecursorMZ != 500.25 {
+		t.Errorf("second spectrum = %q %v, want scan=2 500.25", r.Title(), spec.PrecursorMZ)
+	}
+	// The global CHARGE does not apply to the block
+	if spec.Charge != 0 || spec.RetentionTime != nil || len(spec.Peaks) != 0 {
+		t.Errorf("second spectrum charge, RT, peaks = %d, %v, %d, want 0, nil, 0", spec.Charge, spec.RetentionTime, len(spec.Peaks))
+	}
+
+	if r.Next() {
+		t.Error("Next() = true after the last spectrum")
+	}
+	if r.Err() != nil {
+		t.Errorf("Err() = %v", r.Err())
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "invalid peak",
+			input:   "BEGIN IONS\nPEPMASS=500\n100 abc\nEND IONS\n",
+			wantErr: "line 3: invalid intensity value",
+		},
+		{
+			name:    "single-field peak",
+			input:   "BEGIN IONS\n100\nEND IONS\n",
+			wantErr: "line 2: invalid peak format",
+		},
+		{
+			name:    "invalid PEPMASS",
+			input:   "\nBEGIN IONS\nPEPMASS=abc\nEND IONS\n",
+			wantErr: "line 3: invalid PEPMASS",
+		},
+		{
+			name:    "invalid CHARGE",
+			input:   "BEGIN IONS\nCHARGE=x+\nEND IONS\n",
+			wantErr: "line 2: invalid CHARGE",
+		},
+		{
+			name:    "unterminated block",
+			input:   "BEGIN IONS\nPEPMASS=500\n100 10\n",
+			wantErr: "line 3: unterminated BEGIN IONS block",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			if r.Next() {
+				t.Fatal("Next() = true, want an error")
+			}
+			if r.Err() == nil || !strings.Contains(r.Err().Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", r.Err(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCharge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "2+", want: 2},
+		{value: "3-", want: -3},
+		{value: "2", want: 2},
+		{value: "+2", want: 2},
+		{value: "2+ and 3+", want: 2},
+		{value: "2+,3+", want: 2},
+		{value: "", want: 0},
+		{value: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseCharge(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCharge(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCharge(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package msp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -17,6 +18,16 @@ type Reader struct {
 	modDB       *core.ModDatabase
 	lineNum     int
 	currentSpec *core.Spectrum
+	raw         []string // Lines of the current entry as read
+	pending     string   // Line pushed back to be read again
+	hasPending  bool
+	pendingLen  int64 // Bytes of the pushed back line
+	offset      int64 // Bytes consumed by the scanner
+	lineLen     int64 // Bytes of the last scanned line, including its line ending
+	maxErrors   int   // Malformed entries to skip before failing, 0 = strict, -1 = unlimited
+	dialect     Dialect
+	parseErrors []*core.ParseError
+	unknownMods []string // Unknown modification names in the current entry
 	err         error
 }
 
@@ -26,26 +37,65 @@ func NewReader(r io.Reader, modDB *core.ModDatabase) *Reader {
 		modDB = core.DefaultModDatabase()
 	}
 
-	return &Reader{
+	rd := &Reader{
 		scanner: bufio.NewScanner(r),
 		modDB:   modDB,
+		dialect: DialectPeptide,
 	}
+	rd.scanner.Split(rd.scanLines)
+	return rd
+}
+
+// SetDialect selects how header fields are interpreted
+func (r *Reader) SetDialect(d Dialect) {
+	r.dialect = d
 }
 
 // Next advances to the next spectrum. Returns false when no more spectra or error.
+// Malformed entries are skipped and recorded when SetMaxErrors allows it.
 func (r *Reader) Next() bool {
 	r.currentSpec = nil
 
-	spec, err := r.readSpectrum()
-	if err != nil {
-		if err != io.EOF {
+	for {
+		spec, err := r.readSpectrum()
+		if err == nil {
+			r.currentSpec = spec
+			return true
+		}
+		if err == io.EOF {
+			return false
+		}
+
+		var perr *core.ParseError
+		if !errors.As(err, &perr) || r.maxErrors == 0 {
+			if perr != nil {
+				perr.Raw = r.Raw()
+			}
 			r.err = err
+			return false
 		}
-		return false
+		if r.maxErrors > 0 && len(r.parseErrors) >= r.maxErrors {
+			perr.Raw = r.Raw()
+			r.err = fmt.Errorf("too many parse errors (limit %d): %w", r.maxErrors, perr)
+			return false
+		}
+
+		// Resynchronize on the next entry and keep going
+		r.skipToNextEntry()
+		perr.Raw = r.Raw()
+		r.parseErrors = append(r.parseErrors, perr)
 	}
+}
 
-	r.currentSpec = spec
-	return true
+// SetMaxErrors sets how many malformed entries are skipped before reading
+// fails. 0 (the default) fails on the first error, -1 skips any number.
+func (r *Reader) SetMaxErrors(n int) {
+	r.maxErrors = n
+}
+
+// ParseErrors returns the malformed entries skipped so far
+func (r *Reader) ParseErrors() []*core.ParseError {
+	return r.parseErrors
 }
 
 // Spectrum returns the current spectrum
@@ -58,6 +108,96 @@ func (r *Reader) Err() error {
 	return r.err
 }
 
+// Raw returns the text of the current entry as it appeared in the input,
+// or of the partially read entry if reading failed
+func (r *Reader) Raw() string {
+	if len(r.raw) == 0 {
+		return ""
+	}
+	return strings.Join(r.raw, "\n") + "\n"
+}
+
+// UnknownMods returns the modification names in the current entry that were
+// not found in the modification database
+func (r *Reader) UnknownMods() []string {
+	return r.unknownMods
+}
+
+// addUnknownMod records an unknown modification name once per entry
+func (r *Reader) addUnknownMod(name string) {
+	for _, m := range r.unknownMods {
+		if m == name {
+			return
+		}
+	}
+	r.unknownMods = append(r.unknownMods, name)
+}
+
+// scanLines splits lines like bufio.ScanLines while counting the bytes
+// consumed, so that Offset stays exact for CRLF line endings
+func (r *Reader) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	r.offset += int64(advance)
+	if token != nil {
+		r.lineLen = int64(advance)
+	}
+	return advance, token, err
+}
+
+// Offset returns the byte offset in the input where reading would continue,
+// i.e. just after the current spectrum and any lines skipped with it
+func (r *Reader) Offset() int64 {
+	if r.hasPending {
+		return r.offset - r.pendingLen
+	}
+	return r.offset
+}
+
+// nextLine returns the next input line, including a pushed back one
+func (r *Reader) nextLine() (string, bool) {
+	if r.hasPending {
+		r.hasPending = false
+		return r.pending, true
+	}
+	if !r.scanner.Scan() {
+		return "", false
+	}
+	r.lineNum++
+	return r.scanner.Text(), true
+}
+
+// unreadLine pushes back the line just read, to be returned by the next
+// call to nextLine
+func (r *Reader) unreadLine(text string) {
+	r.pending = text
+	r.pendingLen = r.lineLen
+	r.hasPending = true
+}
+
+// parseError wraps an error with the current line number
+func (r *Reader) parseError(err error) error {
+	return &core.ParseError{Line: r.lineNum, Err: err}
+}
+
+// skipToNextEntry discards lines up to the next "Name: " record, keeping
+// them in the raw text of the malformed entry
+func (r *Reader) skipToNextEntry() {
+	for {
+		text, ok := r.nextLine()
+		if !ok {
+			return
+		}
+		line := strings.TrimSpace(text)
+		if isNameLine(line) {
+			r.unreadLine(text)
+			return
+		}
+		if line != "" {
+			r.raw = append(r.raw, text)
+		}
+	}
+}
+
 // readSpectrum reads a single spectrum entry from the MSP file
 func (r *Reader) readSpectrum() (*core.Spectrum, error) {
 	spec := &core.Spectrum{
@@ -68,40 +208,62 @@ func (r *Reader) readSpectrum() (*core.Spectrum, error) {
 	var numPeaks int
 	inPeaks := false
 	peaksRead := 0
+	r.raw = r.raw[:0]
+	r.unknownMods = nil
 
-	for r.scanner.Scan() {
-		r.lineNum++
-		line := strings.TrimSpace(r.scanner.Text())
+	for {
+		text, ok := r.nextLine()
+		if !ok {
+			break
+		}
+		line := strings.TrimSpace(text)
 
 		// Skip empty lines between entries
-		if line == "" && spec.Sequence == "" {
+		if line == "" && len(r.raw) == 0 {
 			continue
 		}
 
 		// If we've read all peaks, we're done with this entry
 		if inPeaks && peaksRead >= numPeaks {
+			r.unreadLine(text)
 			return spec, nil
 		}
 
-		if !inPeaks {
+		// A new entry before all peaks were read means a truncated peak list
+		if inPeaks && isNameLine(line) {
+			r.unreadLine(text)
+			return nil, r.parseError(fmt.Errorf("expected %d peaks, found %d", numPeaks, peaksRead))
+		}
+		r.raw = append(r.raw, text)
+
+		if !inPeaks && r.dialect == DialectSmallMolecule {
+			n, err := r.parseSmallMoleculeHeader(spec, line)
+			if err != nil {
+				return nil, r.parseError(err)
+			}
+			if n >= 0 {
+				numPeaks = n
+				inPeaks = true
+			}
+		} else if !inPeaks {
 			// Parse header fields
 			if strings.HasPrefix(line, "Name: ") {
 				name := strings.TrimPrefix(line, "Name: ")
 				if err := r.parseName(spec, name); err != nil {
-					return nil, fmt.Errorf("line %d: %w", r.lineNum, err)
+					return nil, r.parseError(err)
 				}
 			} else if strings.HasPrefix(line, "MW: ") {
 				// Skip MW, we'll recalculate
 			} else if strings.HasPrefix(line, "Comment: ") {
 				comment := strings.TrimPrefix(line, "Comment: ")
 				if err := r.parseComment(spec, comment); err != nil {
-					return nil, fmt.Errorf("line %d: %w", r.lineNum, err)
+					return nil, r.parseError(err)
 				}
 			} else if strings.HasPrefix(line, "Num peaks: ") {
 				numPeaksStr := strings.TrimPrefix(line, "Num peaks: ")
 				n, err := strconv.Atoi(numPeaksStr)
 				if err != nil {
-					return nil, fmt.Errorf("line %d: invalid num peaks: %w", r.lineNum, err)
+					return nil, r.parseError(fmt.Errorf("invalid num peaks: %w", err))
 				}
 				numPeaks = n
 				inPeaks = true
@@ -110,7 +272,7 @@ func (r *Reader) readSpectrum() (*core.Spectrum, error) {
 			// Parse peak line
 			peak, err := r.parsePeak(line)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: %w", r.lineNum, err)
+				return nil, r.parseError(err)
 			}
 			spec.Peaks = append(spec.Peaks, peak)
 			peaksRead++
@@ -127,13 +289,19 @@ func (r *Reader) readSpectrum() (*core.Spectrum, error) {
 	}
 
 	// If we have a partially read spectrum, return it
-	if spec.Sequence != "" {
+	if spec.Sequence != "" || spec.CompoundName != "" {
 		return spec, nil
 	}
 
 	return nil, io.EOF
 }
 
+// isNameLine reports whether a line starts a new entry. Small molecule
+// dialects write the key in upper case.
+func isNameLine(line string) bool {
+	return len(line) >= 5 && strings.EqualFold(line[:5], "name:")
+}
+
 // parseName extracts sequence and charge from Name field (format: "SEQUENCE/CHARGE")
 func (r *Reader) parseName(spec *core.Spectrum, name string) error {
 	parts := strings.Split(name, "/")
@@ -272,13 +440,15 @@ func (r *Reader) parseModString(spec *core.Spectrum, modString string) error {
 		}
 
 		mass, ok := r.modDB.GetMass(modName)
-		if ok {
-			spec.Modifications = append(spec.Modifications, core.Modification{
-				Mass:     mass,
-				Position: pos,
-				Name:     modName,
-			})
+		if !ok {
+			r.addUnknownMod(modName)
+			continue
 		}
+		spec.Modifications = append(spec.Modifications, core.Modification{
+			Mass:     mass,
+			Position: pos,
+			Name:     modName,
+		})
 	}
 
 	return nil
@@ -0,0 +1,150 @@
+package msp
+
+import (
+	"strings"
+	"testing"
+)
+
+// malformedMSP has a valid entry, one with an invalid peak count, one with a
+// truncated peak list and a final valid entry
+const malformedMSP = `Name: AAAK/2
+Comment: Parent=200.5
+Num peaks: 1
+175.119	100	"y1/0.1ppm"
+
+Name: BADK/2
+Comment: Parent=300.5
+Num peaks: abc
+100	10
+
+Name: CCCK/2
+Comment: Parent=400.5
+Num peaks: 2
+175.119	100	"y1/0.1ppm"
+Name: DDDK/2
+Comment: Parent=500.5
+Num peaks: 1
+175.119	100	"y1/0.1ppm"
+`
+
+func TestReaderMaxErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxErrors int
+		wantSpecs []string
+		wantLines []int // Lines of the skipped entries' errors
+		wantErr   string
+	}{
+		{name: "strict", maxErrors: 0, wantSpecs: []string{"AAAK/2"}, wantErr: "line 8: invalid num peaks"},
+		{name: "limit reached", maxErrors: 1, wantSpecs: []string{"AAAK/2"}, wantLines: []int{8},
+			wantErr: "too many parse errors (limit 1): line 15: expected 2 peaks, found 1"},
+		{name: "within limit", maxErrors: 2, wantSpecs: []string{"AAAK/2", "DDDK/2"}, wantLines: []int{8, 15}},
+		{name: "unlimited", maxErrors: -1, wantSpecs: []string{"AAAK/2", "DDDK/2"}, wantLines: []int{8, 15}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(malformedMSP), nil)
+			r.SetMaxErrors(tt.maxErrors)
+			var specs []string
+			for r.Next() {
+				specs = append(specs, r.Spectrum().Key())
+			}
+			if strings.Join(specs, ",") != strings.Join(tt.wantSpecs, ",") {
+				t.Errorf("spectra = %v, want %v", specs, tt.wantSpecs)
+			}
+
+			if tt.wantErr == "" {
+				if r.Err() != nil {
+					t.Errorf("Err() = %v", r.Err())
+				}
+			} else if r.Err() == nil || !strings.HasPrefix(r.Err().Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", r.Err(), tt.wantErr)
+			}
+
+			var lines []int
+			for _, perr := range r.ParseErrors() {
+				lines = append(lines, perr.Line)
+			}
+			if len(lines) != len(tt.wantLines) {
+				t.Fatalf("ParseErrors() lines = %v, want %v", lines, tt.wantLines)
+			}
+			for i := range lines {
+				if lines[i] != tt.wantLines[i] {
+					t.Errorf("ParseErrors() lines = %v, want %v", lines, tt.wantLines)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderParseErrorRaw(t *testing.T) {
+	r := NewReader(strings.NewReader(malformedMSP), nil)
+	r.SetMaxErrors(-1)
+	for r.Next() {
+	}
+	perrs := r.ParseErrors()
+	if len(perrs) != 2 {
+		t.Fatalf("ParseErrors() = %d, want 2", len(perrs))
+	}
+
+	// Lines skipped while resynchronizing are kept with the malformed entry
+	want := "Name: BADK/2\nComment: Parent=300.5\nNum peaks: abc\n100\t10\n"
+	if perrs[0].Raw != want {
+		t.Errorf("ParseErrors()[0].Raw = %q, want %q", perrs[0].Raw, want)
+	}
+	// A truncated entry stops before the next entry's name
+	want = "Name: CCCK/2\nComment: Parent=400.5\nNum peaks: 2\n175.119\t100\t\"y1/0.1ppm\"\n"
+	if perrs[1].Raw != want {
+		t.Errorf("ParseErrors()[1].Raw = %q, want %q", perrs[1].Raw, want)
+	}
+
+	strict := NewReader(strings.NewReader(malformedMSP), nil)
+	for strict.Next() {
+	}
+	if !strings.Contains(strict.Raw(), "Num peaks: abc") {
+		t.Errorf("Raw() after a strict error = %q, want the malformed entry", strict.Raw())
+	}
+}
+
+func TestReaderOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+	}{
+		{name: "LF", newline: "\n"},
+		{name: "CRLF", newline: "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.ReplaceAll(malformedMSP, "\n", tt.newline)
+			r := NewReader(strings.NewReader(input), nil)
+			r.SetMaxErrors(-1)
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			first := strings.Join(strings.SplitAfter(input, tt.newline)[:4], "")
+			if got := r.Offset(); got != int64(len(first)) {
+				t.Errorf("Offset() after first entry = %d, want %d", got, len(first))
+			}
+
+			// Reading from the offset continues with the remaining entries
+			rest := NewReader(strings.NewReader(input[r.Offset():]), nil)
+			rest.SetMaxErrors(-1)
+			var keys []string
+			for rest.Next() {
+				keys = append(keys, rest.Spectrum().Key())
+			}
+			if strings.Join(keys, ",") != "DDDK/2" || len(rest.ParseErrors()) != 2 {
+				t.Errorf("resumed spectra = %v with %d parse errors, want [DDDK/2] with 2", keys, len(rest.ParseErrors()))
+			}
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			if got := r.Offset(); got != int64(len(input)) {
+				t.Errorf("Offset() after last entry = %d, want %d", got, len(input))
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package msp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Dialect selects how MSP header fields are interpreted
+type Dialect string
+
+const (
+	// DialectPeptide is the Prosit/NIST peptide dialect with "SEQUENCE/CHARGE"
+	// names and key=value comments (default)
+	DialectPeptide Dialect = "peptide"
+	// DialectSmallMolecule is the MS-DIAL, MoNA and NIST metabolite dialect
+	// with one "KEY: value" header per line
+	DialectSmallMolecule Dialect = "small-molecule"
+)
+
+// ParseDialect parses a dialect name
+func ParseDialect(s string) (Dialect, error) {
+	switch Dialect(strings.ToLower(strings.TrimSpace(s))) {
+	case "", DialectPeptide:
+		return DialectPeptide, nil
+	case DialectSmallMolecule:
+		return DialectSmallMolecule, nil
+	default:
+		return "", fmt.Errorf("invalid MSP dialect '%s', must be peptide or small-molecule", s)
+	}
+}
+
+// numberPattern matches the first number in free-text values such as
+// "NCE=35%" or "40 eV"
+var numberPattern = regexp.MustCompile(`[-+]?\d+(?:\.\d+)?`)
+
+// headerKey normalizes a header key so that PRECURSORMZ, PrecursorMZ and
+// Precursor_mz are equivalent
+func headerKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.ReplaceAll(key, "_", "")
+	return strings.ReplaceAll(key, " ", "")
+}
+
+// parseSmallMoleculeHeader parses one "KEY: value" header line. It returns
+// the peak count for the "Num Peaks" header and -1 for any other header.
+func (r *Reader) parseSmallMoleculeHeader(spec *core.Spectrum, line string) (int, error) {
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return -1, nil
+	}
+	value = strings.TrimSpace(value)
+
+	switch headerKey(key) {
+	case "name":
+		spec.CompoundName = value
+
+	case "precursormz":
+		mz, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return -1, fmt.Errorf("invalid precursor m/z '%s': %w", value, err)
+		}
+		spec.PrecursorMZ = mz
+
+	case "precursortype":
+		spec.PrecursorType = value
+		if charge, ok := core.PrecursorTypeCharge(value); ok {
+			spec.Charge = charge
+		}
+
+	case "charge":
+		// Only used when the precursor type has no charge suffix, e.g. "1" or "2-"
+		if spec.Charge == 0 {
+			if n, err := strconv.Atoi(strings.TrimRight(value, "+-")); err == nil {
+				if strings.HasSuffix(value, "-") {
+					n = -n
+				}
+				spec.Charge = n
+			}
+		}
+
+	case "ionmode":
+		if polarity, err := core.ParsePolarity(value); err == nil {
+			spec.Polarity = polarity
+		}
+
+	case "formula":
+		spec.Formula = value
+
+	case "smiles":
+		spec.SMILES = value
+
+	case "inchikey":
+		spec.InChIKey = value
+
+	case "cas", "casno", "cas#":
+		// NIST writes "CAS#: 50-00-0; NIST#: 1234"
+		cas, _, _ := strings.Cut(value, ";")
+		spec.CASNumber = strings.TrimSpace(cas)
+
+	case "pubchem", "pubchemcid", "pubchemid":
+		spec.PubChemID = value
+
+	case "collisionenergy":
+		if m := numberPattern.FindString(value); m != "" {
+			if ce, err := strconv.ParseFloat(m, 64); err == nil {
+				spec.CollisionEnergy = &ce
+			}
+		}
+
+	case "retentiontime", "rt", "rtinminutes":
+		if rt, err := strconv.ParseFloat(value, 64); err == nil {
+			spec.RetentionTime = &rt
+		}
+
+	case "instrument":
+		spec.Instrument = value
+
+	case "ontology", "compoundclass":
+		spec.CompoundClass = value
+
+	case "numpeaks":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return -1, fmt.Errorf("invalid num peaks: %w", err)
+		}
+		finishSmallMoleculeHeader(spec)
+		return n, nil
+	}
+
+	return -1, nil
+}
+
+// finishSmallMoleculeHeader resolves the precursor charge once all headers
+// have been read. Without a precursor type or charge a singly charged ion in
+// the entry's ion mode is assumed.
+func finishSmallMoleculeHeader(spec *core.Spectrum) {
+	if spec.Charge == 0 {
+		spec.Charge = 1
+	}
+	if spec.Polarity == core.PolarityNegative && spec.Charge > 0 {
+		spec.Charge = -spec.Charge
+	}
+	if spec.Polarity == "" {
+		spec.Polarity = core.PolarityFromCharge(spec.Charge)
+	}
+}
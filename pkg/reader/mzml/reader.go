@@ -0,0 +1,331 @@
+// Package mzml provides streaming readers for mzML experimental spectra
+package mzml
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// PSI-MS controlled vocabulary accessions used by the reader
+const (
+	cvMSLevel          = "MS:1000511"
+	cvScanStartTime    = "MS:1000016"
+	cvSelectedIonMZ    = "MS:1000744"
+	cvChargeState      = "MS:1000041"
+	cvCollisionEnergy  = "MS:1000045"
+	cvCID              = "MS:1000133"
+	cvHCD              = "MS:1000422"
+	cvETD              = "MS:1000598"
+	cvMZArray          = "MS:1000514"
+	cvIntensityArray   = "MS:1000515"
+	cvFloat32          = "MS:1000521"
+	cvZlib             = "MS:1000574"
+	unitSecond         = "UO:0000010"
+	numpressAccessions = "MS:1002312 MS:1002313 MS:1002314 MS:1002746 MS:1002747 MS:1002748"
+)
+
+// cvParam is a controlled vocabulary parameter
+type cvParam struct {
+	Accession     string `xml:"accession,attr"`
+	Value         string `xml:"value,attr"`
+	UnitAccession string `xml:"unitAccession,attr"`
+}
+
+// paramGroup is any element holding cvParams
+type paramGroup struct {
+	CVParams []cvParam `xml:"cvParam"`
+}
+
+// find returns the cvParam with the given accession
+func (g paramGroup) find(accession string) (cvParam, bool) {
+	for _, p := range g.CVParams {
+		if p.Accession == accession {
+			return p, true
+		}
+	}
+	return cvParam{}, false
+}
+
+// has reports whether the group contains the accession
+func (g paramGroup) has(accession string) bool {
+	_, ok := g.find(accession)
+	return ok
+}
+
+// xmlSpectrum is the subset of an mzML <spectrum> element used by DBKey
+type xmlSpectrum struct {
+	paramGroup
+	ID    string `xml:"id,attr"`
+	Index int    `xml:"index,attr"`
+	Scans []struct {
+		paramGroup
+	} `xml:"scanList>scan"`
+	Precursors []struct {
+		SelectedIons []struct {
+			paramGroup
+		} `xml:"selectedIonList>selectedIon"`
+		Activation paramGroup `xml:"activation"`
+	} `xml:"precursorList>precursor"`
+	BinaryDataArrays []struct {
+		paramGroup
+		Binary string `xml:"binary"`
+	} `xml:"binaryDataArrayList>binaryDataArray"`
+}
+
+// Reader provides streaming access to the spectra of an mzML file
+type Reader struct {
+	dec         *xml.Decoder
+	msLevel     int // Only return spectra of this MS level, 0 for all
+	currentSpec *core.Spectrum
+	id          string
+	index       int
+	scan        int
+	level       int
+	err         error
+}
+
+// NewReader creates a new mzML reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		dec: xml.NewDecoder(r),
+	}
+}
+
+// SetMSLevel restricts the reader to spectra of one MS level; 0 reads all.
+// Peaks of other spectra are not decoded.
+func (r *Reader) SetMSLevel(level int) {
+	r.msLevel = level
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	if r.err != nil {
+		return false
+	}
+
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				r.err = fmt.Errorf("failed to parse mzML: %w", err)
+			}
+			return false
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "spectrum" {
+			continue
+		}
+
+		var xs xmlSpectrum
+		if err := r.dec.DecodeElement(&xs, &start); err != nil {
+			r.err = fmt.Errorf("failed to parse mzML: %w", err)
+			return false
+		}
+
+		level := 0
+		if p, ok := xs.find(cvMSLevel); ok {
+			level, _ = strconv.Atoi(p.Value)
+		}
+		if r.msLevel != 0 && level != r.msLevel {
+			continue
+		}
+
+		spec, err := convertSpectrum(&xs)
+		if err != nil {
+			r.err = fmt.Errorf("spectrum %s: %w", xs.ID, err)
+			return false
+		}
+
+		r.currentSpec = spec
+		r.id = xs.ID
+		r.index = xs.Index
+		r.scan = ScanNumber(xs.ID)
+		r.level = level
+		return true
+	}
+}
+
+// Spectrum returns the current spectrum. Sequence is never set; Charge and
+// PrecursorMZ come from the first selected ion, if any.
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// ID returns the native ID of the current spectrum
+func (r *Reader) ID() string {
+	return r.id
+}
+
+// Index returns the 0-based index of the current spectrum in the file
+func (r *Reader) Index() int {
+	return r.index
+}
+
+// ScanNumber returns the scan number of the current spectrum, or 0 if its
+// native ID has none
+func (r *Reader) ScanNumber() int {
+	return r.scan
+}
+
+// MSLevel returns the MS level of the current spectrum
+func (r *Reader) MSLevel() int {
+	return r.level
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// ScanNumber extracts the scan number from a native ID such as
+// "controllerType=0 controllerNumber=1 scan=1234" or "scan=1234". It
+// returns 0 if the ID has no scan number.
+func ScanNumber(nativeID string) int {
+	for _, field := range strings.Fields(nativeID) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "scan", "scanId", "scanNumber":
+			if n, err := strconv.Atoi(value); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// convertSpectrum builds a core.Spectrum from a decoded mzML spectrum
+func convertSpectrum(xs *xmlSpectrum) (*core.Spectrum, error) {
+	spec := &core.Spectrum{
+		SourceFormat: "mzml",
+	}
+
+	if len(xs.Scans) > 0 {
+		scan := xs.Scans[0]
+		if p, ok := scan.find(cvScanStartTime); ok {
+			if rt, err := strconv.ParseFloat(p.Value, 64); err == nil {
+				// Retention times are stored in minutes
+				if p.UnitAccession == unitSecond {
+					rt /= 60
+				}
+				spec.RetentionTime = &rt
+			}
+		}
+	}
+
+	if len(xs.Precursors) > 0 {
+		prec := xs.Precursors[0]
+		if len(prec.SelectedIons) > 0 {
+			ion := prec.SelectedIons[0]
+			if p, ok := ion.find(cvSelectedIonMZ); ok {
+				spec.PrecursorMZ, _ = strconv.ParseFloat(p.Value, 64)
+			}
+			if p, ok := ion.find(cvChargeState); ok {
+				spec.Charge, _ = strconv.Atoi(p.Value)
+			}
+		}
+
+		switch {
+		case prec.Activation.has(cvETD):
+			spec.FragmentationMode = "ETD"
+		case prec.Activation.has(cvHCD):
+			spec.FragmentationMode = "HCD"
+		case prec.Activation.has(cvCID):
+			spec.FragmentationMode = "CID"
+		}
+		if p, ok := prec.Activation.find(cvCollisionEnergy); ok {
+			if ce, err := strconv.ParseFloat(p.Value, 64); err == nil {
+				spec.CollisionEnergy = &ce
+			}
+		}
+	}
+
+	var mzs, intensities []float64
+	for _, array := range xs.BinaryDataArrays {
+		values, err := decodeBinary(array.paramGroup, array.Binary)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case array.has(cvMZArray):
+			mzs = values
+		case array.has(cvIntensityArray):
+			intensities = values
+		}
+	}
+	if len(mzs) != len(intensities) {
+		return nil, fmt.Errorf("m/z array has %d values but intensity array has %d", len(mzs), len(intensities))
+	}
+
+	spec.Peaks = make([]core.Peak, len(mzs))
+	for i := range mzs {
+		spec.Peaks[i] = core.Peak{MZ: mzs[i], Intensity: intensities[i]}
+	}
+	if !spec.ArePeaksSorted() {
+		spec.SortPeaks()
+	}
+
+	return spec, nil
+}
+
+// decodeBinary decodes a base64 binary data array, optionally zlib
+// compressed, of 32 or 64-bit (default) little-endian floats
+func decodeBinary(params paramGroup, text string) ([]float64, error) {
+	for _, accession := range strings.Fields(numpressAccessions) {
+		if params.has(accession) {
+			return nil, fmt.Errorf("numpress compression is not supported")
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 data: %w", err)
+	}
+
+	if params.has(cvZlib) {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zlib data: %w", err)
+		}
+		data, err = io.ReadAll(zr)
+		zr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid zlib data: %w", err)
+		}
+	}
+
+	switch {
+	case params.has(cvFloat32):
+		if len(data)%4 != 0 {
+			return nil, fmt.Errorf("32-bit array has %d bytes", len(data))
+		}
+		values := make([]float64, len(data)/4)
+		for i := range values {
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		}
+		return values, nil
+	default:
+		if len(data)%8 != 0 {
+			return nil, fmt.Errorf("64-bit array has %d bytes", len(data))
+		}
+		values := make([]float64, len(data)/8)
+		for i := range values {
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+		}
+		return values, nil
+	}
+}
@@ -0,0 +1,231 @@
+package mzml
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// encodeArray encodes values as a base64 binary data array of 64-bit or,
+// with single, 32-bit floats, optionally zlib compressed
+func encodeArray(t *testing.T, values []float64, single, compress bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, v := range values {
+		if single {
+			binary.Write(&buf, binary.LittleEndian, math.Float32bits(float32(v)))
+		} else {
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		}
+	}
+	data := buf.Bytes()
+	if compress {
+		var zbuf bytes.Buffer
+		zw := zlib.NewWriter(&zbuf)
+		if _, err := zw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = zbuf.Bytes()
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// binaryArray returns a binaryDataArray element
+func binaryArray(kind, precision, compression, data string) string {
+	return fmt.Sprintf(`<binaryDataArray><cvParam accession="%s"/><cvParam accession="%s"/><cvParam accession="%s"/><binary>%s</binary></binaryDataArray>`,
+		precision, compression, kind, data)
+}
+
+// testMzML returns an mzML document with an MS1 scan and two MS2 scans
+func testMzML(t *testing.T) string {
+	t.Helper()
+	const noCompression = "MS:1000576"
+	const float64Array = "MS:1000523"
+	return `<?xml version="1.0" encoding="utf-8"?>
+<mzML><run id="run01"><spectrumList count="3">
+<spectrum index="0" id="controllerType=0 controllerNumber=1 scan=1">
+<cvParam accession="MS:1000511" value="1"/>
+<binaryDataArrayList>` +
+		binaryArray(cvMZArray, float64Array, noCompression, encodeArray(t, []float64{400, 500}, false, false)) +
+		binaryArray(cvIntensityArray, float64Array, noCompression, encodeArray(t, []float64{10, 20}, false, false)) + `
+</binaryDataArrayList>
+</spectrum>
+<spectrum index="1" id="controllerType=0 controllerNumber=1 scan=2">
+<cvParam accession="MS:1000511" value="2"/>
+<scanList><scan><cvParam accession="MS:1000016" value="600" unitAccession="UO:0000010"/></scan></scanList>
+<precursorList><precursor>
+<selectedIonList><selectedIon><cvParam accession="MS:1000744" value="464.7348"/><cvParam accession="MS:1000041" value="2"/></selectedIon></selectedIonList>
+<activation><cvParam accession="MS:1000422"/><cvParam accession="MS:1000045" value="27"/></activation>
+</precursor></precursorList>
+<binaryDataArrayList>` +
+		binaryArray(cvMZArray, float64Array, noCompression, encodeArray(t, []float64{300.5, 175.119}, false, false)) +
+		binaryArray(cvIntensityArray, float64Array, noCompression, encodeArray(t, []float64{50, 100}, false, false)) + `
+</binaryDataArrayList>
+</spectrum>
+<spectrum index="2" id="controllerType=0 controllerNumber=1 scan=3">
+<cvParam accession="MS:1000511" value="2"/>
+<scanList><scan><cvParam accession="MS:1000016" value="12.5" unitAccession="UO:0000031"/></scan></scanList>
+<precursorList><precursor>
+<selectedIonList><selectedIon><cvParam accession="MS:1000744" value="500.25"/></selectedIon></selectedIonList>
+<activation><cvParam accession="MS:1000133"/></activation>
+</precursor></precursorList>
+<binaryDataArrayList>` +
+		binaryArray(cvMZArray, cvFloat32, cvZlib, encodeArray(t, []float64{150.5, 250.25}, true, true)) +
+		binaryArray(cvIntensityArray, cvFloat32, cvZlib, encodeArray(t, []float64{5, 7}, true, true)) + `
+</binaryDataArrayList>
+</spectrum>
+</spectrumList></run></mzML>
+`
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(testMzML(t)))
+
+	var levels []int
+	for r.Next() {
+		levels = append(levels, r.MSLevel())
+	}
+	if r.Err() != nil {
+		t.Fatalf("Err() = %v", r.Err())
+	}
+	if len(levels) != 3 || levels[0] != 1 || levels[1] != 2 || levels[2] != 2 {
+		t.Errorf("MS levels = %v, want [1 2 2]", levels)
+	}
+}
+
+func TestReaderMSLevel(t *testing.T) {
+	r := NewReader(strings.NewReader(testMzML(t)))
+	r.SetMSLevel(2)
+
+	if !r.Next() {
+		t.Fatalf("Next() = false, err = %v", r.Err())
+	}
+	spec := r.Spectrum()
+	if r.ID() != "controllerType=0 controllerNumber=1 scan=2" || r.Index() != 1 || r.ScanNumber() != 2 {
+		t.Errorf("ID, Index, ScanNumber = %q, %d, %d", r.ID(), r.Index(), r.ScanNumber())
+	}
+	if spec.PrecursorMZ != 464.7348 || spec.Charge != 2 || spec.FragmentationMode != "HCD" {
+		t.Errorf("precursor = %v %d %s, want 464.7348 2 HCD", spec.PrecursorMZ, spec.Charge, spec.FragmentationMode)
+	}
+	if spec.CollisionEnergy == nil || *spec.CollisionEnergy != 27 {
+		t.Errorf("CollisionEnergy = %v, want 27", spec.CollisionEnergy)
+	}
+	if spec.RetentionTime == nil || *spec.RetentionTime != 10 {
+		t.Errorf("RetentionTime = %v, want 10 minutes", spec.RetentionTime)
+	}
+	want := []core.Peak{{MZ: 175.119, Intensity: 100}, {MZ: 300.5, Intensity: 50}}
+	if len(spec.Peaks) != 2 || spec.Peaks[0] != want[0] || spec.Peaks[1] != want[1] {
+		t.Errorf("Peaks = %+v, want sorted %+v", spec.Peaks, want)
+	}
+
+	if !r.Next() {
+		t.Fatalf("Next() = false, err = %v", r.Err())
+	}
+	spec = r.Spectrum()
+	if r.ScanNumber() != 3 || spec.Charge != 0 || spec.FragmentationMode != "CID" || spec.CollisionEnergy != nil {
+		t.Errorf("second MS2 scan %d charge %d %s CE %v, want 3 0 CID nil",
+			r.ScanNumber(), spec.Charge, spec.FragmentationMode, spec.CollisionEnergy)
+	}
+	if spec.RetentionTime == nil || *spec.RetentionTime != 12.5 {
+		t.Errorf("RetentionTime = %v, want 12.5 minutes", spec.RetentionTime)
+	}
+	want = []core.Peak{{MZ: 150.5, Intensity: 5}, {MZ: 250.25, Intensity: 7}}
+	if len(spec.Peaks) != 2 || spec.Peaks[0] != want[0] || spec.Peaks[1] != want[1] {
+		t.Errorf("zlib 32-bit Peaks = %+v, want %+v", spec.Peaks, want)
+	}
+
+	if r.Next() {
+		t.Error("Next() = true after the last MS2 spectrum")
+	}
+	if r.Err() != nil {
+		t.Errorf("Err() = %v", r.Err())
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	spectrum := func(arrays string) string {
+		return `<mzML><spectrum index="0" id="scan=7"><cvParam accession="MS:1000511" value="2"/><binaryDataArrayList>` +
+			arrays + `</binaryDataArrayList></spectrum></mzML>`
+	}
+	mz := encodeArray(t, []float64{100, 200}, false, false)
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "array length mismatch",
+			input:   spectrum(binaryArray(cvMZArray, "", "", mz) + binaryArray(cvIntensityArray, "", "", encodeArray(t, []float64{1}, false, false))),
+			wantErr: "spectrum scan=7: m/z array has 2 values but intensity array has 1",
+		},
+		{
+			name:    "numpress",
+			input:   spectrum(binaryArray(cvMZArray, "MS:1002312", "", mz)),
+			wantErr: "numpress compression is not supported",
+		},
+		{
+			name:    "invalid base64",
+			input:   spectrum(binaryArray(cvMZArray, "", "", "not base64!")),
+			wantErr: "invalid base64 data",
+		},
+		{
+			name:    "invalid zlib",
+			input:   spectrum(binaryArray(cvMZArray, "", cvZlib, mz)),
+			wantErr: "invalid zlib data",
+		},
+		{
+			name:    "truncated 64-bit array",
+			input:   spectrum(binaryArray(cvMZArray, "", "", base64.StdEncoding.EncodeToString(make([]byte, 12)))),
+			wantErr: "64-bit array has 12 bytes",
+		},
+		{
+			name:    "malformed XML",
+			input:   `<mzML><spectrum index="0" id="scan=7">`,
+			wantErr: "failed to parse mzML",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			if r.Next() {
+				t.Fatal("Next() = true, want an error")
+			}
+			if r.Err() == nil || !strings.Contains(r.Err().Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", r.Err(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScanNumber(t *testing.T) {
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{"controllerType=0 controllerNumber=1 scan=1234", 1234},
+		{"scan=7", 7},
+		{"scanId=42", 42},
+		{"merged=1 scanNumber=99", 99},
+		{"index=5", 0},
+		{"scan=abc", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if got := ScanNumber(tt.id); got != tt.want {
+				t.Errorf("ScanNumber(%q) = %d, want %d", tt.id, got, tt.want)
+			}
+		})
+	}
+}
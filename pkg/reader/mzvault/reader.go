@@ -0,0 +1,277 @@
+// Package mzvault provides readers for SQLite spectral libraries written by DBKey
+// in the RTLS/mzVault schema
+package mzvault
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Reader provides streaming access to DBKey SQLite databases
+type Reader struct {
+	db          *sql.DB
+	modDB       *core.ModDatabase
+	rows        *sql.Rows
+	currentSpec *core.Spectrum
+	err         error
+}
+
+// Open opens a DBKey SQLite database for reading. Modification names are
+// looked up by mass in modDB (the default database if nil).
+func Open(path string, modDB *core.ModDatabase) (*Reader, error) {
+	return OpenContext(context.Background(), path, modDB)
+}
+
+// OpenContext opens a DBKey SQLite database for reading. The spectrum query
+// is interrupted if ctx is cancelled.
+func OpenContext(ctx context.Context, path string, modDB *core.ModDatabase) (*Reader, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+
+	db, err := sql.Open("sqlite3", sqliteuri.ReadOnly(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.Name, c.Sequence, c.Formula, c.Tag, c.CompoundClass,
+			c.CASId, c.PubChemId, c.SmilesDescription, c.InChiKey, s.PrecursorIonType,
+			s.RetentionTime, s.PrecursorMass, s.CollisionEnergy,
+			s.FragmentationMode, s.MassAnalyzer, s.InstrumentName, s.RawFileURL,
+			s.Polarity, s.IonizationMode,
+			s.blobMass, s.blobIntensity
+		FROM SpectrumTable s
+		JOIN CompoundTable c ON c.CompoundId = s.CompoundId
+		ORDER BY s.SpectrumId
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to query spectra: %w", err)
+	}
+
+	return &Reader{
+		db:    db,
+		modDB: modDB,
+		rows:  rows,
+	}, nil
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	if r.err != nil {
+		return false
+	}
+
+	if !r.rows.Next() {
+		r.err = r.rows.Err()
+		return false
+	}
+
+	spec, err := r.scanSpectrum()
+	if err != nil {
+		r.err = err
+		return false
+	}
+
+	r.currentSpec = spec
+	return true
+}
+
+// Spectrum returns the current spectrum
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close closes the database
+func (r *Reader) Close() error {
+	r.rows.Close()
+	return r.db.Close()
+}
+
+// scanSpectrum converts the current row to a spectrum
+func (r *Reader) scanSpectrum() (*core.Spectrum, error) {
+	var (
+		name, sequence, formula, tag, class sql.NullString
+		fragmentation, analyzer, instrument sql.NullString
+		rawFile                             sql.NullString
+		cas, pubchem, smiles, inchikey      sql.NullString
+		precursorType                       sql.NullString
+		polarity, ionization                sql.NullString
+		rt, precursorMZ, ce                 sql.NullFloat64
+		mzBlob, intBlob                     []byte
+	)
+
+	if err := r.rows.Scan(&name, &sequence, &formula, &tag, &class,
+		&cas, &pubchem, &smiles, &inchikey, &precursorType,
+		&rt, &precursorMZ, &ce,
+		&fragmentation, &analyzer, &instrument, &rawFile,
+		&polarity, &ionization,
+		&mzBlob, &intBlob); err != nil {
+		return nil, fmt.Errorf("failed to read spectrum row: %w", err)
+	}
+
+	spec := &core.Spectrum{
+		Sequence:          sequence.String,
+		PrecursorMZ:       precursorMZ.Float64,
+		FragmentationMode: fragmentation.String,
+		MassAnalyzer:      analyzer.String,
+		Instrument:        instrument.String,
+		CompoundClass:     class.String,
+		SourceFile:        rawFile.String,
+		SourceFormat:      "db",
+		CASNumber:         cas.String,
+		PubChemID:         pubchem.String,
+		SMILES:            smiles.String,
+		InChIKey:          inchikey.String,
+		PrecursorType:     precursorType.String,
+		Polarity:          polarity.String,
+		IonizationMode:    ionization.String,
+	}
+
+	// Small molecules are stored by compound name with their elemental
+	// formula, peptides by "SEQUENCE/CHARGE" with modifications. Without a
+	// precursor ion type a small molecule is singly charged, with the sign
+	// of its polarity.
+	if spec.Sequence == "" {
+		spec.CompoundName = name.String
+		spec.Formula = formula.String
+		spec.Charge = 1
+		if p, err := core.ParsePolarity(polarity.String); err == nil && p == core.PolarityNegative {
+			spec.Charge = -1
+		}
+		if charge, ok := core.PrecursorTypeCharge(spec.PrecursorType); ok {
+			spec.Charge = charge
+		}
+	} else {
+		charge, err := parseCharge(name.String)
+		if err != nil {
+			return nil, err
+		}
+		spec.Charge = charge
+
+		spec.Modifications, err = parseModString(formula.String, r.modDB)
+		if err != nil {
+			return nil, fmt.Errorf("spectrum %s: %w", name.String, err)
+		}
+	}
+
+	if rt.Valid {
+		v := rt.Float64
+		spec.RetentionTime = &v
+	}
+	if ce.Valid {
+		v := ce.Float64
+		spec.CollisionEnergy = &v
+	}
+
+	spec.MassOffset = parseMassOffset(tag.String)
+
+	peaks, err := decodePeaks(mzBlob, intBlob)
+	if err != nil {
+		return nil, fmt.Errorf("spectrum %s: %w", name.String, err)
+	}
+	spec.Peaks = peaks
+
+	return spec, nil
+}
+
+// parseCharge extracts the charge from a Name column value ("SEQUENCE/CHARGE")
+func parseCharge(name string) (int, error) {
+	idx := strings.LastIndex(name, "/")
+	if idx < 0 {
+		return 0, fmt.Errorf("invalid name format '%s', expected 'SEQUENCE/CHARGE'", name)
+	}
+
+	charge, err := strconv.Atoi(name[idx+1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid charge in name '%s': %w", name, err)
+	}
+	return charge, nil
+}
+
+// modTolerance is the mass tolerance (Da) for naming stored modifications
+const modTolerance = 0.01
+
+// parseModString parses modifications written by core.Spectrum.ModString
+// (format "mass@pos;mass@pos", positions already 0-based). Names are looked
+// up by mass in modDB, falling back to the signed mass.
+func parseModString(modStr string, modDB *core.ModDatabase) ([]core.Modification, error) {
+	if modStr == "" {
+		return nil, nil
+	}
+
+	var mods []core.Modification
+	for _, part := range strings.Split(modStr, ";") {
+		atParts := strings.Split(part, "@")
+		if len(atParts) != 2 {
+			return nil, fmt.Errorf("invalid modification format '%s', expected 'mass@position'", part)
+		}
+
+		mass, err := strconv.ParseFloat(atParts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modification mass '%s': %w", atParts[0], err)
+		}
+		pos, err := strconv.Atoi(atParts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid modification position '%s': %w", atParts[1], err)
+		}
+
+		name, ok := modDB.NameForMass(mass, modTolerance)
+		if !ok {
+			name = fmt.Sprintf("%+.4f", mass)
+		}
+		mods = append(mods, core.Modification{
+			Mass:     mass,
+			Position: pos,
+			Name:     name,
+		})
+	}
+
+	return mods, nil
+}
+
+// parseMassOffset extracts the mass offset from a Tag column value
+// (format "mods:... massOffset:1.234")
+func parseMassOffset(tag string) float64 {
+	for _, field := range strings.Fields(tag) {
+		if strings.HasPrefix(field, "massOffset:") {
+			offset, err := strconv.ParseFloat(strings.TrimPrefix(field, "massOffset:"), 64)
+			if err == nil {
+				return offset
+			}
+		}
+	}
+	return 0
+}
+
+// decodePeaks decodes little-endian float64 m/z and intensity blobs
+func decodePeaks(mzBlob, intBlob []byte) ([]core.Peak, error) {
+	if len(mzBlob)%8 != 0 || len(intBlob) != len(mzBlob) {
+		return nil, fmt.Errorf("invalid peak blobs: %d m/z bytes, %d intensity bytes", len(mzBlob), len(intBlob))
+	}
+
+	peaks := make([]core.Peak, len(mzBlob)/8)
+	for i := range peaks {
+		peaks[i] = core.Peak{
+			MZ:        math.Float64frombits(binary.LittleEndian.Uint64(mzBlob[i*8:])),
+			Intensity: math.Float64frombits(binary.LittleEndian.Uint64(intBlob[i*8:])),
+		}
+	}
+	return peaks, nil
+}
@@ -0,0 +1,166 @@
+package mzvault
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+// roundTrip writes spectra to a new library and reads them back
+func roundTrip(t *testing.T, specs ...*core.Spectrum) []*core.Spectrum {
+	t.Helper()
+	return roundTripPath(t, filepath.Join(t.TempDir(), "library.db"), nil, specs...)
+}
+
+// roundTripPath writes spectra to a library at path and reads them back,
+// naming modifications from modDB
+func roundTripPath(t *testing.T, path string, modDB *core.ModDatabase, specs ...*core.Spectrum) []*core.Spectrum {
+	t.Helper()
+	w, err := sqlite.NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+
+	r, err := Open(path, modDB)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+	var read []*core.Spectrum
+	for r.Next() {
+		read = append(read, r.Spectrum())
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if len(read) != len(specs) {
+		t.Fatalf("read %d spectra, want %d", len(read), len(specs))
+	}
+	return read
+}
+
+func TestRoundTripNegativeCompound(t *testing.T) {
+	tests := []struct {
+		name          string
+		polarity      string
+		charge        int
+		precursorType string
+		wantCharge    int
+	}{
+		{name: "negative without ion type", polarity: core.PolarityNegative, charge: -1, wantCharge: -1},
+		{name: "positive without ion type", polarity: core.PolarityPositive, charge: 1, wantCharge: 1},
+		{name: "negative with ion type", polarity: core.PolarityNegative, charge: -2, precursorType: "[M-2H]2-", wantCharge: -2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &core.Spectrum{
+				CompoundName:      "Citric acid",
+				Formula:           "C6H8O7",
+				Charge:            tt.charge,
+				Polarity:          tt.polarity,
+				PrecursorType:     tt.precursorType,
+				PrecursorMZ:       191.0197,
+				Peaks:             []core.Peak{{MZ: 111.0088, Intensity: 100}, {MZ: 173.0092, Intensity: 40}},
+				FragmentationMode: "HCD",
+				MassAnalyzer:      "FT",
+			}
+			got := roundTrip(t, spec)[0]
+
+			if got.CompoundName != spec.CompoundName || got.Formula != spec.Formula {
+				t.Errorf("compound = %s %s, want %s %s", got.CompoundName, got.Formula, spec.CompoundName, spec.Formula)
+			}
+			if got.Charge != tt.wantCharge {
+				t.Errorf("Charge = %d, want %d", got.Charge, tt.wantCharge)
+			}
+			if got.Polarity != tt.polarity {
+				t.Errorf("Polarity = %s, want %s", got.Polarity, tt.polarity)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenEscapedPath(t *testing.T) {
+	tests := []string{"my library.db", "library?mode=rw.db", "library#1.db", "100%.db"}
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			spec := &core.Spectrum{Sequence: "PEPTIDEK", Charge: 2, PrecursorMZ: 464.73,
+				Peaks: []core.Peak{{MZ: 175.119, Intensity: 100}}, FragmentationMode: "HCD", MassAnalyzer: "FT"}
+			read := roundTripPath(t, path, nil, spec)
+			if read[0].Sequence != "PEPTIDEK" {
+				t.Errorf("Sequence = %s, want PEPTIDEK", read[0].Sequence)
+			}
+		})
+	}
+}
+
+func TestOpenRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"library.db", "./library2.db", filepath.Join("sub", "library.db")} {
+		t.Run(path, func(t *testing.T) {
+			spec := &core.Spectrum{Sequence: "PEPTIDEK", Charge: 2, PrecursorMZ: 464.73,
+				Peaks: []core.Peak{{MZ: 175.119, Intensity: 100}}, FragmentationMode: "HCD", MassAnalyzer: "FT"}
+			read := roundTripPath(t, path, nil, spec)
+			if read[0].Sequence != "PEPTIDEK" {
+				t.Errorf("Sequence = %s, want PEPTIDEK", read[0].Sequence)
+			}
+			if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+				t.Errorf("library not written relative to the working directory: %v", err)
+			}
+		})
+	}
+}
+
+func TestRoundTripModificationNames(t *testing.T) {
+	modDB := core.DefaultModDatabase()
+	modDB.Add("Custom", 123.4567)
+	spec := &core.Spectrum{
+		Sequence:          "PCPTMIDEK",
+		Charge:            2,
+		PrecursorMZ:       600,
+		Peaks:             []core.Peak{{MZ: 175.119, Intensity: 100}},
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Modifications: []core.Modification{
+			{Mass: 57.021464, Position: 1, Name: "Carbamidomethyl"},
+			{Mass: 15.994915, Position: 4, Name: "Oxidation"},
+			{Mass: 123.4567, Position: 6, Name: "Custom"},
+			{Mass: 12.3456, Position: 8, Name: "Unknown"},
+		},
+	}
+
+	read := roundTripPath(t, filepath.Join(t.TempDir(), "library.db"), modDB, spec)
+	want := []core.Modification{
+		{Mass: 57.021464, Position: 1, Name: "Carbamidomethyl"},
+		{Mass: 15.994915, Position: 4, Name: "Oxidation"},
+		{Mass: 123.4567, Position: 6, Name: "Custom"},
+		{Mass: 12.3456, Position: 8, Name: "+12.3456"},
+	}
+	got := read[0].Modifications
+	if len(got) != len(want) {
+		t.Fatalf("Modifications = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Modifications[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package reader provides a common interface over the format-specific
+// spectral library readers.
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/encyclopedia"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/jsonl"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/msp"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/mzvault"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/sptxt"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/tsv"
+)
+
+// Reader is implemented by all streaming spectrum readers
+type Reader interface {
+	// Next advances to the next spectrum. Returns false when no more spectra or error.
+	Next() bool
+	// Spectrum returns the current spectrum
+	Spectrum() *core.Spectrum
+	// Err returns any error encountered during reading
+	Err() error
+}
+
+// Formats lists the input formats that can be opened
+var Formats = []string{"msp", "sptxt", "tsv", "db", "dlib", "elib", "jsonl"}
+
+// DetectFormat returns the input format for a file based on its extension
+func DetectFormat(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".msp":
+		return "msp", nil
+	case ".sptxt":
+		return "sptxt", nil
+	case ".tsv":
+		return "tsv", nil
+	case ".blib":
+		return "blib", nil
+	case ".dlib":
+		return "dlib", nil
+	case ".elib":
+		return "elib", nil
+	case ".jsonl", ".ndjson":
+		return "jsonl", nil
+	case ".db", ".db3", ".sqlite":
+		return "db", nil
+	default:
+		return "", fmt.Errorf("cannot auto-detect format from extension '%s'", ext)
+	}
+}
+
+// File is a Reader over an opened input file. Spectra returned by a File
+// have SourceFile set to the input path.
+type File struct {
+	Reader
+	Path   string
+	Format string
+	ctx    context.Context
+	ctxErr error // Set when Next stopped because ctx was cancelled
+	base   int64 // Offset passed to SeekOffset
+	closer io.Closer
+}
+
+// Open opens an input file with the reader for the given format.
+// If format is empty it is detected from the file extension.
+func Open(path, format string, modDB *core.ModDatabase) (*File, error) {
+	return OpenContext(context.Background(), path, format, modDB)
+}
+
+// OpenContext opens an input file like Open. Reading stops once ctx is
+// cancelled: Next returns false and Err returns ctx.Err().
+func OpenContext(ctx context.Context, path, format string, modDB *core.ModDatabase) (*File, error) {
+	if format == "" {
+		var err error
+		format, err = DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	format = strings.ToLower(format)
+
+	// SQLite based formats and TSV libraries, which may need sorting, are
+	// opened by path
+	switch format {
+	case "db":
+		r, err := mzvault.OpenContext(ctx, path, modDB)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Reader: r, Path: path, Format: format, ctx: ctx, closer: r}, nil
+	case "dlib", "elib":
+		r, err := encyclopedia.OpenContext(ctx, path, modDB)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Reader: r, Path: path, Format: format, ctx: ctx, closer: r}, nil
+	case "tsv":
+		r, err := tsv.OpenContext(ctx, path, modDB)
+		if err != nil {
+			return nil, err
+		}
+		return &File{Reader: r, Path: path, Format: format, ctx: ctx, closer: r}, nil
+	case "blib":
+		return nil, fmt.Errorf("format 'blib' is not yet implemented")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	var r Reader
+	switch format {
+	case "msp":
+		r = msp.NewReader(f, modDB)
+	case "sptxt":
+		r = sptxt.NewReader(f, modDB)
+	case "jsonl":
+		r = jsonl.NewReader(f)
+	default:
+		f.Close()
+		return nil, fmt.Errorf("unsupported format '%s', must be one of %s", format, strings.Join(Formats, ", "))
+	}
+
+	return &File{
+		Reader: r,
+		Path:   path,
+		Format: format,
+		ctx:    ctx,
+		closer: f,
+	}, nil
+}
+
+// Next advances to the next spectrum unless the context has been cancelled
+func (f *File) Next() bool {
+	if err := f.ctx.Err(); err != nil {
+		f.ctxErr = err
+		return false
+	}
+	return f.Reader.Next()
+}
+
+// Err returns the context error if reading was cancelled, or the reader error
+func (f *File) Err() error {
+	if f.ctxErr != nil {
+		return f.ctxErr
+	}
+	return f.Reader.Err()
+}
+
+// Spectrum returns the current spectrum with its source file recorded
+func (f *File) Spectrum() *core.Spectrum {
+	spec := f.Reader.Spectrum()
+	if spec != nil && spec.SourceFile == "" {
+		spec.SourceFile = f.Path
+	}
+	return spec
+}
+
+// Close closes the underlying file
+func (f *File) Close() error {
+	return f.closer.Close()
+}
+
+// Raw returns the original text of the current entry for readers that keep
+// it, or an empty string
+func (f *File) Raw() string {
+	if r, ok := f.Reader.(interface{ Raw() string }); ok {
+		return r.Raw()
+	}
+	return ""
+}
+
+// UnknownMods returns the unknown modification names in the current entry
+// for readers that resolve modifications by name
+func (f *File) UnknownMods() []string {
+	if r, ok := f.Reader.(interface{ UnknownMods() []string }); ok {
+		return r.UnknownMods()
+	}
+	return nil
+}
+
+// SetMaxErrors sets how many malformed entries text readers skip before
+// failing. 0 fails on the first error, -1 skips any number. Readers that
+// cannot resynchronize ignore it.
+func (f *File) SetMaxErrors(n int) {
+	if r, ok := f.Reader.(interface{ SetMaxErrors(int) }); ok {
+		r.SetMaxErrors(n)
+	}
+}
+
+// ParseErrors returns the malformed entries skipped so far
+func (f *File) ParseErrors() []*core.ParseError {
+	if r, ok := f.Reader.(interface{ ParseErrors() []*core.ParseError }); ok {
+		return r.ParseErrors()
+	}
+	return nil
+}
+
+// Offset returns the byte offset after the current spectrum for text
+// formats, for use with SeekOffset. It returns false for other formats.
+func (f *File) Offset() (int64, bool) {
+	r, ok := f.Reader.(interface{ Offset() int64 })
+	if !ok {
+		return 0, false
+	}
+	return f.base + r.Offset(), true
+}
+
+// SeekOffset continues reading a text format at an offset returned by Offset.
+// It must be called before the first call to Next. Line numbers in parse
+// errors are counted from the offset.
+func (f *File) SeekOffset(offset int64) error {
+	seeker, ok := f.closer.(io.Seeker)
+	if _, hasOffset := f.Reader.(interface{ Offset() int64 }); !ok || !hasOffset {
+		return fmt.Errorf("format '%s' does not support seeking", f.Format)
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek input file: %w", err)
+	}
+	f.base = offset
+	return nil
+}
+
+// SetMSPDialect selects the MSP dialect; other formats ignore it
+func (f *File) SetMSPDialect(d msp.Dialect) {
+	if r, ok := f.Reader.(*msp.Reader); ok {
+		r.SetDialect(d)
+	}
+}
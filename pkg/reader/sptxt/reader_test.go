@@ -0,0 +1,144 @@
+package sptxt
+
+import (
+	"strings"
+	"testing"
+)
+
+// malformedSPTXT has a valid entry, one with an invalid peak count, one with
+// a truncated peak list and a final valid entry
+const malformedSPTXT = `### SpectraST library
+Name: AAAK/2
+PrecursorMZ: 200.5
+NumPeaks: 1
+175.119	100	y1/0.00
+
+Name: BADK/2
+PrecursorMZ: 300.5
+NumPeaks: abc
+100	10
+
+Name: CCCK/2
+PrecursorMZ: 400.5
+NumPeaks: 2
+175.119	100	y1/0.00
+
+Name: DDDK/2
+PrecursorMZ: 500.5
+NumPeaks: 1
+175.119	100	y1/0.00
+`
+
+func TestReaderMaxErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxErrors int
+		wantSpecs []string
+		wantLines []int // Lines of the skipped entries' errors
+		wantErr   string
+	}{
+		{name: "strict", maxErrors: 0, wantSpecs: []string{"AAAK/2"}, wantErr: "line 9: invalid num peaks"},
+		{name: "limit reached", maxErrors: 1, wantSpecs: []string{"AAAK/2"}, wantLines: []int{9},
+			wantErr: "too many parse errors (limit 1): line 17: expected 2 peaks, found 1"},
+		{name: "within limit", maxErrors: 2, wantSpecs: []string{"AAAK/2", "DDDK/2"}, wantLines: []int{9, 17}},
+		{name: "unlimited", maxErrors: -1, wantSpecs: []string{"AAAK/2", "DDDK/2"}, wantLines: []int{9, 17}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(malformedSPTXT), nil)
+			r.SetMaxErrors(tt.maxErrors)
+			var specs []string
+			for r.Next() {
+				specs = append(specs, r.Spectrum().Key())
+			}
+			if strings.Join(specs, ",") != strings.Join(tt.wantSpecs, ",") {
+				t.Errorf("spectra = %v, want %v", specs, tt.wantSpecs)
+			}
+
+			if tt.wantErr == "" {
+				if r.Err() != nil {
+					t.Errorf("Err() = %v", r.Err())
+				}
+			} else if r.Err() == nil || !strings.HasPrefix(r.Err().Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %q", r.Err(), tt.wantErr)
+			}
+
+			var lines []int
+			for _, perr := range r.ParseErrors() {
+				lines = append(lines, perr.Line)
+			}
+			if len(lines) != len(tt.wantLines) {
+				t.Fatalf("ParseErrors() lines = %v, want %v", lines, tt.wantLines)
+			}
+			for i := range lines {
+				if lines[i] != tt.wantLines[i] {
+					t.Errorf("ParseErrors() lines = %v, want %v", lines, tt.wantLines)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderParseErrorRaw(t *testing.T) {
+	r := NewReader(strings.NewReader(malformedSPTXT), nil)
+	r.SetMaxErrors(-1)
+	for r.Next() {
+	}
+	perrs := r.ParseErrors()
+	if len(perrs) != 2 {
+		t.Fatalf("ParseErrors() = %d, want 2", len(perrs))
+	}
+
+	// Lines skipped while resynchronizing are kept with the malformed entry
+	want := "Name: BADK/2\nPrecursorMZ: 300.5\nNumPeaks: abc\n100\t10\n"
+	if perrs[0].Raw != want {
+		t.Errorf("ParseErrors()[0].Raw = %q, want %q", perrs[0].Raw, want)
+	}
+	want = "Name: CCCK/2\nPrecursorMZ: 400.5\nNumPeaks: 2\n175.119\t100\ty1/0.00\n"
+	if perrs[1].Raw != want {
+		t.Errorf("ParseErrors()[1].Raw = %q, want %q", perrs[1].Raw, want)
+	}
+}
+
+func TestReaderOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+	}{
+		{name: "LF", newline: "\n"},
+		{name: "CRLF", newline: "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.ReplaceAll(malformedSPTXT, "\n", tt.newline)
+			r := NewReader(strings.NewReader(input), nil)
+			r.SetMaxErrors(-1)
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			first := strings.Join(strings.SplitAfter(input, tt.newline)[:5], "")
+			if got := r.Offset(); got != int64(len(first)) {
+				t.Errorf("Offset() after first entry = %d, want %d", got, len(first))
+			}
+
+			// Reading from the offset continues with the remaining entries
+			rest := NewReader(strings.NewReader(input[r.Offset():]), nil)
+			rest.SetMaxErrors(-1)
+			var keys []string
+			for rest.Next() {
+				keys = append(keys, rest.Spectrum().Key())
+			}
+			if strings.Join(keys, ",") != "DDDK/2" || len(rest.ParseErrors()) != 2 {
+				t.Errorf("resumed spectra = %v with %d parse errors, want [DDDK/2] with 2", keys, len(rest.ParseErrors()))
+			}
+
+			if !r.Next() {
+				t.Fatalf("Next() = false, err = %v", r.Err())
+			}
+			if got := r.Offset(); got != int64(len(input)) {
+				t.Errorf("Offset() after last entry = %d, want %d", got, len(input))
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+// Package tsv provides streaming readers for transition-level TSV spectral
+// libraries as written by DIA-NN, Spectronaut and OpenSWATH
+package tsv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// ErrNotGrouped is returned when the rows of a precursor are not adjacent.
+// OpenContext sorts such files before reading them.
+var ErrNotGrouped = errors.New("rows are not grouped by precursor")
+
+// Column roles, each matched by the first header found in columnAliases
+const (
+	colPrecursorMZ = iota
+	colModifiedPeptide
+	colStrippedPeptide
+	colPrecursorCharge
+	colFragmentMZ
+	colIntensity
+	colFragmentType
+	colFragmentNumber
+	colFragmentCharge
+	colFragmentLoss
+	colAnnotation
+	colRetentionTime
+	colCollisionEnergy
+	colDecoy
+	colProtein
+	numColumns
+)
+
+// columnAliases lists the header names of each column in DIA-NN, Spectronaut
+// and OpenSWATH libraries, in order of preference. Headers are matched
+// case-insensitively.
+var columnAliases = [numColumns][]string{
+	colPrecursorMZ:     {"PrecursorMz", "Q1"},
+	colModifiedPeptide: {"ModifiedPeptide", "ModifiedPeptideSequence", "FullUniModPeptideName", "FullPeptideName", "ModifiedSequence"},
+	colStrippedPeptide: {"StrippedPeptide", "PeptideSequence", "Sequence"},
+	colPrecursorCharge: {"PrecursorCharge", "Charge"},
+	colFragmentMZ:      {"FragmentMz", "ProductMz", "Q3"},
+	colIntensity:       {"RelativeIntensity", "LibraryIntensity", "RelativeFragmentIntensity"},
+	colFragmentType:    {"FragmentType"},
+	colFragmentNumber:  {"FragmentSeriesNumber", "FragmentNumber"},
+	colFragmentCharge:  {"FragmentCharge", "ProductCharge"},
+	colFragmentLoss:    {"FragmentLossType"},
+	colAnnotation:      {"Annotation"},
+	colRetentionTime:   {"iRT", "NormalizedRetentionTime", "Tr_recalibrated", "RetentionTime"},
+	colCollisionEnergy: {"CollisionEnergy"},
+	colDecoy:           {"Decoy"},
+	colProtein:         {"ProteinId", "UniprotID", "UniProtIds", "ProteinGroup", "ProteinGroups", "ProteinName"},
+}
+
+// header holds the index of each column role, -1 if absent
+type header struct {
+	index [numColumns]int
+	delim rune
+}
+
+// parseHeader maps the header line to column roles. The delimiter is a tab
+// unless the line has none and contains commas.
+func parseHeader(line string) (*header, error) {
+	line = strings.TrimPrefix(strings.TrimRight(line, "\r\n"), "\ufeff")
+	h := &header{delim: '\t'}
+	if !strings.Contains(line, "\t") && strings.Contains(line, ",") {
+		h.delim = ','
+	}
+
+	positions := make(map[string]int)
+	for i, name := range strings.Split(line, string(h.delim)) {
+		name = strings.ToLower(strings.Trim(strings.TrimSpace(name), `"`))
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+
+	for col, aliases := range columnAliases {
+		h.index[col] = -1
+		for _, alias := range aliases {
+			if i, ok := positions[strings.ToLower(alias)]; ok {
+				h.index[col] = i
+				break
+			}
+		}
+	}
+
+	var missing []string
+	for _, col := range []int{colPrecursorCharge, colFragmentMZ, colIntensity} {
+		if h.index[col] < 0 {
+			missing = append(missing, columnAliases[col][0])
+		}
+	}
+	if h.index[colModifiedPeptide] < 0 && h.index[colStrippedPeptide] < 0 {
+		missing = append(missing, columnAliases[colModifiedPeptide][0])
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required columns: %s", strings.Join(missing, ", "))
+	}
+	return h, nil
+}
+
+// field returns the trimmed value of a column, or "" if absent
+func (h *header) field(row []string, col int) string {
+	i := h.index[col]
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// key returns the peptide and charge identifying the precursor of a row
+func (h *header) key(row []string) string {
+	peptide := h.field(row, colModifiedPeptide)
+	if peptide == "" {
+		peptide = h.field(row, colStrippedPeptide)
+	}
+	return peptide + "/" + h.field(row, colPrecursorCharge)
+}
+
+// decoy reports whether a row belongs to a decoy precursor
+func (h *header) decoy(row []string) bool {
+	switch strings.ToLower(h.field(row, colDecoy)) {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// newCSVReader returns a reader for the rows after the header
+func (h *header) newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = h.delim
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	return cr
+}
+
+// Reader provides streaming access to transition-level TSV libraries. Rows
+// are grouped into one spectrum per precursor (modified peptide and charge)
+// and must be adjacent; decoy rows are skipped.
+type Reader struct {
+	br          *bufio.Reader
+	rows        *csv.Reader
+	header      *header
+	modDB       *core.ModDatabase
+	pending     []string // First row of the next precursor
+	pendingLine int
+	seen        map[string]bool // Precursors already returned
+	currentSpec *core.Spectrum
+	file        *os.File // Set by OpenContext
+	tempPath    string   // Sorted copy of the input, removed by Close
+	err         error
+}
+
+// NewReader creates a new TSV library reader. The delimiter (tab or comma)
+// is detected from the header line.
+func NewReader(r io.Reader, modDB *core.ModDatabase) *Reader {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+
+	return &Reader{
+		br:    bufio.NewReader(r),
+		modDB: modDB,
+		seen:  make(map[string]bool),
+	}
+}
+
+// Next advances to the next spectrum. Returns false when no more spectra or error.
+func (r *Reader) Next() bool {
+	r.currentSpec = nil
+	if r.err != nil {
+		return false
+	}
+
+	if r.header == nil {
+		line, err := r.br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err != io.EOF {
+				r.err = fmt.Errorf("failed to read header: %w", err)
+			}
+			return false
+		}
+		h, err := parseHeader(line)
+		if err != nil {
+			r.err = err
+			return false
+		}
+		r.header = h
+		r.rows = h.newCSVReader(r.br)
+	}
+
+	spec, err := r.readPrecursor()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	r.currentSpec = spec
+	return true
+}
+
+// Spectrum returns the current spectrum
+func (r *Reader) Spectrum() *core.Spectrum {
+	return r.currentSpec
+}
+
+// Err returns any error encountered during reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close closes the file opened by OpenContext and removes its sorted copy
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	if r.tempPath != "" {
+		os.Remove(r.tempPath)
+	}
+	return err
+}
+
+// readRow returns the next target row and its line number
+func (r *Reader) readRow() ([]string, int, error) {
+	for {
+		row, err := r.rows.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil, 0, io.EOF
+			}
+			return nil, 0, fmt.Errorf("failed to parse TSV: %w", err)
+		}
+		if r.header.decoy(row) {
+			continue
+		}
+		line, _ := r.rows.FieldPos(0)
+		// Line numbers count the header read before the CSV reader
+		return row, line + 1, nil
+	}
+}
+
+// readPrecursor reads the rows of one precursor into a spectrum
+func (r *Reader) readPrecursor() (*core.Spectrum, error) {
+	row, line := r.pending, r.pendingLine
+	r.pending = nil
+	if row == nil {
+		var err error
+		if row, line, err = r.readRow(); err != nil {
+			return nil, err
+		}
+	}
+
+	key := r.header.key(row)
+	if r.seen[key] {
+		return nil, &core.ParseError{Line: line, Err: fmt.Errorf("precursor %s: %w", key, ErrNotGrouped)}
+	}
+	r.seen[key] = true
+
+	spec, err := r.parsePrecursor(row)
+	if err != nil {
+		return nil, &core.ParseError{Line: line, Err: err}
+	}
+
+	for {
+		if err := r.addPeak(spec, row); err != nil {
+			return nil, &core.ParseError{Line: line, Err: err}
+		}
+
+		var err error
+		row, line, err = r.readRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.header.key(row) != key {
+			r.pending, r.pendingLine = row, line
+			break
+		}
+	}
+
+	if !spec.ArePeaksSorted() {
+		spec.SortPeaks()
+	}
+	return spec, nil
+}
+
+// parsePrecursor reads the precursor fields shared by all rows of a
+// precursor
+func (r *Reader) parsePrecursor(row []string) (*core.Spectrum, error) {
+	h := r.header
+	spec := &core.Spectrum{
+		SourceFormat: "tsv",
+	}
+
+	charge, err := strconv.Atoi(h.field(row, colPrecursorCharge))
+	if err != nil {
+		return nil, fmt.Errorf("invalid precursor charge '%s'", h.field(row, colPrecursorCharge))
+	}
+	spec.Charge = charge
+
+	if modified := h.field(row, colModifiedPeptide); modified != "" {
+		spec.Sequence, spec.Modifications, err = ParseModifiedPeptide(modified, r.modDB)
+		if err != nil {
+			return nil, err
+		}
+		if stripped := h.field(row, colStrippedPeptide); stripped != "" && stripped != spec.Sequence {
+			return nil, fmt.Errorf("modified peptide '%s' does not match peptide '%s'", modified, stripped)
+		}
+	} else {
+		spec.Sequence = h.field(row, colStrippedPeptide)
+	}
+
+	if v := h.field(row, colPrecursorMZ); v != "" {
+		if spec.PrecursorMZ, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid precursor m/z '%s'", v)
+		}
+	}
+	if v := h.field(row, colRetentionTime); v != "" {
+		rt, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention time '%s'", v)
+		}
+		spec.RetentionTime = &rt
+	}
+	if v := h.field(row, colCollisionEnergy); v != "" {
+		ce, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collision energy '%s'", v)
+		}
+		spec.CollisionEnergy = &ce
+	}
+	// Protein groups list accessions separated by semicolons
+	for _, protein := range strings.Split(h.field(row, colProtein), ";") {
+		if protein = strings.TrimSpace(protein); protein != "" {
+			spec.Proteins = append(spec.Proteins, protein)
+		}
+	}
+
+	return spec, nil
+}
+
+// addPeak appends the fragment of a row to the spectrum
+func (r *Reader) addPeak(spec *core.Spectrum, row []string) error {
+	h := r.header
+
+	mz, err := strconv.ParseFloat(h.field(row, colFragmentMZ), 64)
+	if err != nil {
+		return fmt.Errorf("invalid fragment m/z '%s'", h.field(row, colFragmentMZ))
+	}
+	intensity, err := strconv.ParseFloat(h.field(row, colIntensity), 64)
+	if err != nil {
+		return fmt.Errorf("invalid fragment intensity '%s'", h.field(row, colIntensity))
+	}
+	peak := core.Peak{MZ: mz, Intensity: intensity}
+
+	if v := h.field(row, colFragmentCharge); v != "" {
+		if peak.Charge, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid fragment charge '%s'", v)
+		}
+	}
+
+	ionType := strings.ToLower(h.field(row, colFragmentType))
+	number := h.field(row, colFragmentNumber)
+	switch {
+	case ionType != "" && number != "":
+		// SpectraST style annotation, e.g. "y7", "b3^2", "y5-H2O"
+		peak.Annotation = ionType + number
+		switch loss := h.field(row, colFragmentLoss); strings.ToLower(loss) {
+		case "", "noloss", "none":
+		default:
+			peak.Annotation += "-" + loss
+		}
+		if peak.Charge > 1 {
+			peak.Annotation += "^" + strconv.Itoa(peak.Charge)
+		}
+	default:
+		peak.Annotation = h.field(row, colAnnotation)
+	}
+
+	spec.Peaks = append(spec.Peaks, peak)
+	return nil
+}
+
+// ParseModifiedPeptide parses a modified peptide in DIA-NN, Spectronaut or
+// OpenSWATH notation into its sequence and modifications. Modifications
+// follow the residue they modify in brackets or parentheses and are
+// resolved with the ModDatabase:
+//
+//	_[Acetyl (Protein N-term)]M[Oxidation (M)]PEPTIDEK_   Spectronaut
+//	(UniMod:1)PEPTM(UniMod:35)IDEK                       DIA-NN
+//	.(UniMod:1)PEPTIDEK.(UniMod:2)                       OpenSWATH
+//	n[+42.0106]PEPTC[+57.0215]IDEK                       mass shifts
+//
+// Modifications before the first residue are N-terminal. Those after a
+// trailing "." or "c", or whose site names the C-terminus, are C-terminal.
+func ParseModifiedPeptide(s string, modDB *core.ModDatabase) (string, []core.Modification, error) {
+	var seq strings.Builder
+	var mods []core.Modification
+	cterm := false
+
+	body := strings.Trim(strings.TrimSpace(s), "_")
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			if cterm {
+				return "", nil, fmt.Errorf("residue after C-terminus in '%s'", s)
+			}
+			seq.WriteByte(c)
+		case c == 'n' && seq.Len() == 0:
+			// N-terminus marker
+		case c == '.' || c == 'c':
+			// Terminus markers, C-terminal after the residues
+			if seq.Len() > 0 {
+				cterm = true
+			}
+		case c == '[' || c == '(':
+			end := closingBracket(body, i)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unbalanced brackets in '%s'", s)
+			}
+			token := body[i+1 : end]
+			i = end
+
+			name, mass, ok := modDB.Resolve(token)
+			if !ok {
+				return "", nil, fmt.Errorf("unknown modification '%s' in '%s'", token, s)
+			}
+			position := seq.Len() - 1
+			switch {
+			case cterm || strings.Contains(token, "C-term"):
+				position = len(body) // Fixed below once the sequence is known
+			case strings.Contains(token, "N-term"):
+				position = -1
+			}
+			mods = append(mods, core.Modification{Mass: mass, Position: position, Name: name})
+		default:
+			return "", nil, fmt.Errorf("unexpected character '%c' in '%s'", c, s)
+		}
+	}
+
+	sequence := seq.String()
+	if sequence == "" {
+		return "", nil, fmt.Errorf("no residues in '%s'", s)
+	}
+	for i := range mods {
+		if mods[i].Position >= len(sequence) {
+			mods[i].Position = len(sequence)
+		}
+	}
+	sort.SliceStable(mods, func(i, j int) bool {
+		return mods[i].Position < mods[j].Position
+	})
+	return sequence, mods, nil
+}
+
+// closingBracket returns the index of the bracket closing the one at start,
+// allowing nested parentheses as in "[Oxidation (M)]", or -1
+func closingBracket(s string, start int) int {
+	opening, closing := s[start], byte(']')
+	if opening == '(' {
+		closing = ')'
+	}
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case opening:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,356 @@
+package tsv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// DIA-NN library with a decoy precursor
+const diannLibrary = `PrecursorMz	ProductMz	Tr_recalibrated	LibraryIntensity	decoy	PeptideSequence	UniprotID	FullUniModPeptideName	PrecursorCharge	FragmentType	FragmentCharge	FragmentSeriesNumber	FragmentLossType
+464.7348	703.3569	35.2	1	0	PEPTIDEK	P12345	PEPTIDEK	2	y	1	6	noloss
+464.7348	147.1128	35.2	0.5	0	PEPTIDEK	P12345	PEPTIDEK	2	y	1	1	noloss
+464.7348	300.1	35.2	0.4	1	KEDITPEP	P12345	KEDITPEP	2	y	1	2	noloss
+372.6712	400.2	41.0	1	0	PEPTMK	P12345;P67890	PEPTM(UniMod:35)K	2	b	2	6	H2O
+`
+
+// Spectronaut library with an N-terminal and a residue modification
+const spectronautLibrary = `PrecursorCharge	ModifiedPeptide	StrippedPeptide	iRT	PrecursorMz	FragmentLossType	FragmentNumber	FragmentType	FragmentCharge	FragmentMz	RelativeIntensity	ProteinGroups
+2	_[Acetyl (Protein N-term)]M[Oxidation (M)]PEPTIDEK_	MPEPTIDEK	-12.5	561.26	noloss	3	b	1	420.15	25	Q99999
+2	_[Acetyl (Protein N-term)]M[Oxidation (M)]PEPTIDEK_	MPEPTIDEK	-12.5	561.26	noloss	4	y	1	504.27	100	Q99999
+3	_LLLK_	LLLK	20	167.79	NH3	2	y	2	130.09	60	Q11111
+`
+
+// OpenSWATH transition list with annotations and a decoy
+const openswathLibrary = `PrecursorMz	ProductMz	PrecursorCharge	ProductCharge	LibraryIntensity	NormalizedRetentionTime	PeptideSequence	ModifiedPeptideSequence	ProteinId	Annotation	Decoy
+523.7	632.3	2	1	80	55.5	PEPTCIDEK	.(UniMod:1)PEPTC(UniMod:4)IDEK	P00001	y5	0
+523.7	400.1	2	1	100	55.5	PEPTCIDEK	.(UniMod:1)PEPTC(UniMod:4)IDEK	P00001	b4	0
+523.7	500.1	2	1	100	55.5	KEDICTPEP	.(UniMod:1)KEDIC(UniMod:4)TPEP	DECOY_P00001	y4	1
+`
+
+// wantSpectrum summarizes an expected spectrum
+type wantSpectrum struct {
+	key         string
+	precursorMZ float64
+	rt          float64
+	annotations string // Peak annotations in m/z order
+	proteins    string
+}
+
+func TestReaderLayouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []wantSpectrum
+	}{
+		{
+			name:  "DIA-NN",
+			input: diannLibrary,
+			want: []wantSpectrum{
+				{key: "PEPTIDEK/2", precursorMZ: 464.7348, rt: 35.2, annotations: "y1,y6", proteins: "P12345"},
+				{key: "PEPTM[+15.9949]K/2", precursorMZ: 372.6712, rt: 41, annotations: "b6-H2O^2", proteins: "P12345,P67890"},
+			},
+		},
+		{
+			name:  "Spectronaut",
+			input: spectronautLibrary,
+			want: []wantSpectrum{
+				{key: "n[+42.0106]M[+15.9949]PEPTIDEK/2", precursorMZ: 561.26, rt: -12.5, annotations: "b3,y4", proteins: "Q99999"},
+				{key: "LLLK/3", precursorMZ: 167.79, rt: 20, annotations: "y2-NH3^2", proteins: "Q11111"},
+			},
+		},
+		{
+			name:  "OpenSWATH",
+			input: openswathLibrary,
+			want: []wantSpectrum{
+				{key: "n[+42.0106]PEPTC[+57.0215]IDEK/2", precursorMZ: 523.7, rt: 55.5, annotations: "b4,y5", proteins: "P00001"},
+			},
+		},
+		{
+			name:  "comma separated",
+			input: strings.ReplaceAll(openswathLibrary, "\t", ","),
+			want: []wantSpectrum{
+				{key: "n[+42.0106]PEPTC[+57.0215]IDEK/2", precursorMZ: 523.7, rt: 55.5, annotations: "b4,y5", proteins: "P00001"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input), nil)
+			var got []*core.Spectrum
+			for r.Next() {
+				got = append(got, r.Spectrum())
+			}
+			if r.Err() != nil {
+				t.Fatalf("Err() = %v", r.Err())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("read %d spectra, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				spec := got[i]
+				var annotations []string
+				for _, p := range spec.Peaks {
+					annotations = append(annotations, p.Annotation)
+				}
+				if spec.Key() != want.key || spec.PrecursorMZ != want.precursorMZ {
+					t.Errorf("spectrum %d = %s at %v, want %s at %v", i, spec.Key(), spec.PrecursorMZ, want.key, want.precursorMZ)
+				}
+				if spec.RetentionTime == nil || *spec.RetentionTime != want.rt {
+					t.Errorf("spectrum %d RetentionTime = %v, want %v", i, spec.RetentionTime, want.rt)
+				}
+				if got := strings.Join(annotations, ","); got != want.annotations {
+					t.Errorf("spectrum %d annotations = %s, want %s", i, got, want.annotations)
+				}
+				if got := strings.Join(spec.Proteins, ","); got != want.proteins {
+					t.Errorf("spectrum %d proteins = %s, want %s", i, got, want.proteins)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantCols map[int]int // Column role -> index
+		delim    rune
+		wantErr  string
+	}{
+		{
+			name:     "DIA-NN",
+			line:     strings.SplitN(diannLibrary, "\n", 2)[0],
+			wantCols: map[int]int{colPrecursorMZ: 0, colFragmentMZ: 1, colRetentionTime: 2, colIntensity: 3, colDecoy: 4, colModifiedPeptide: 7, colProtein: 6},
+			delim:    '\t',
+		},
+		{
+			name:     "Spectronaut",
+			line:     strings.SplitN(spectronautLibrary, "\n", 2)[0],
+			wantCols: map[int]int{colPrecursorCharge: 0, colModifiedPeptide: 1, colStrippedPeptide: 2, colRetentionTime: 3, colFragmentMZ: 9, colDecoy: -1},
+			delim:    '\t',
+		},
+		{
+			name:     "OpenSWATH with BOM and CRLF",
+			line:     "\ufeff" + strings.SplitN(openswathLibrary, "\n", 2)[0] + "\r\n",
+			wantCols: map[int]int{colPrecursorMZ: 0, colFragmentCharge: 3, colRetentionTime: 5, colAnnotation: 9, colFragmentType: -1},
+			delim:    '\t',
+		},
+		{
+			name:     "comma separated and quoted",
+			line:     `"ModifiedPeptide","PrecursorCharge","FragmentMz","RelativeIntensity"`,
+			wantCols: map[int]int{colModifiedPeptide: 0, colPrecursorCharge: 1},
+			delim:    ',',
+		},
+		{
+			name:    "missing columns",
+			line:    "PeptideSequence\tPrecursorMz",
+			wantErr: "missing required columns: PrecursorCharge, FragmentMz, RelativeIntensity",
+		},
+		{
+			name:    "missing peptide",
+			line:    "PrecursorCharge\tFragmentMz\tRelativeIntensity",
+			wantErr: "missing required columns: ModifiedPeptide",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseHeader(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("parseHeader() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeader() error = %v", err)
+			}
+			if h.delim != tt.delim {
+				t.Errorf("delimiter = %q, want %q", h.delim, tt.delim)
+			}
+			for col, want := range tt.wantCols {
+				if h.index[col] != want {
+					t.Errorf("column %s at %d, want %d", columnAliases[col][0], h.index[col], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	header := "ModifiedPeptide\tPrecursorCharge\tFragmentMz\tRelativeIntensity\n"
+	tests := []struct {
+		name     string
+		rows     string
+		wantLine int
+		wantErr  string
+	}{
+		{name: "not grouped", rows: "AAAK\t2\t100\t1\nCCCK\t2\t100\t1\nAAAK\t2\t200\t1\n", wantLine: 4, wantErr: "precursor AAAK/2: rows are not grouped by precursor"},
+		{name: "invalid charge", rows: "AAAK\tx\t100\t1\n", wantLine: 2, wantErr: "invalid precursor charge 'x'"},
+		{name: "invalid fragment", rows: "AAAK\t2\t100\t1\nAAAK\t2\tabc\t1\n", wantLine: 3, wantErr: "invalid fragment m/z 'abc'"},
+		{name: "unknown modification", rows: "AAAK\t2\t100\t1\nAA[Nonsense]AK\t2\t100\t1\n", wantLine: 3, wantErr: "unknown modification 'Nonsense'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(header+tt.rows), nil)
+			for r.Next() {
+			}
+			var perr *core.ParseError
+			if !errors.As(r.Err(), &perr) {
+				t.Fatalf("Err() = %v, want a *core.ParseError", r.Err())
+			}
+			if perr.Line != tt.wantLine || !strings.Contains(perr.Error(), tt.wantErr) {
+				t.Errorf("Err() = %v, want line %d: %s", perr, tt.wantLine, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenContextSorts(t *testing.T) {
+	// Precursor rows interleaved, with a decoy between them
+	input := "ModifiedPeptide\tPrecursorCharge\tFragmentMz\tRelativeIntensity\tAnnotation\tDecoy\n" +
+		"LLLK\t2\t300\t1\ty2\t0\n" +
+		"AAAK\t2\t100\t1\ty1\t0\n" +
+		"KLLL\t2\t300\t1\ty2\t1\n" +
+		"LLLK\t2\t200\t1\ty1\t0\n" +
+		"AAAK\t3\t150\t1\ty1\t0\n" +
+		"AAAK\t2\t250\t1\ty2\t0\n"
+	path := filepath.Join(t.TempDir(), "library.tsv")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenContext(context.Background(), path, nil)
+	if err != nil {
+		t.Fatalf("OpenContext() error = %v", err)
+	}
+	if r.tempPath == "" {
+		t.Error("ungrouped library read without sorting")
+	}
+	var got []string
+	for r.Next() {
+		spec := r.Spectrum()
+		var annotations []string
+		for _, p := range spec.Peaks {
+			annotations = append(annotations, p.Annotation)
+		}
+		got = append(got, spec.Key()+" "+strings.Join(annotations, ","))
+	}
+	if r.Err() != nil {
+		t.Fatalf("Err() = %v", r.Err())
+	}
+	want := []string{"AAAK/2 y1,y2", "AAAK/3 y1", "LLLK/2 y1,y2"}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("spectra = %v, want %v", got, want)
+	}
+
+	tempPath := r.tempPath
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Errorf("sorted copy %s left after Close", tempPath)
+	}
+}
+
+func TestOpenContextGrouped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.tsv")
+	if err := os.WriteFile(path, []byte(diannLibrary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenContext(context.Background(), path, nil)
+	if err != nil {
+		t.Fatalf("OpenContext() error = %v", err)
+	}
+	defer r.Close()
+	// The decoy between target rows does not break the grouping
+	if r.tempPath != "" {
+		t.Error("grouped library was sorted")
+	}
+	n := 0
+	for r.Next() {
+		n++
+	}
+	if r.Err() != nil || n != 2 {
+		t.Errorf("read %d spectra, err = %v, want 2", n, r.Err())
+	}
+}
+
+func TestMergeRunsStable(t *testing.T) {
+	h, err := parseHeader("ModifiedPeptide\tPrecursorCharge\tFragmentMz\tRelativeIntensity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each run is sorted; equal keys must come from the earlier run first
+	chunks := [][]sortedRow{
+		{{key: "AAAK/2", row: []string{"AAAK", "2", "1", "1"}}, {key: "CCCK/2", row: []string{"CCCK", "2", "1", "1"}}},
+		{{key: "AAAK/2", row: []string{"AAAK", "2", "2", "1"}}, {key: "BBBK/2", row: []string{"BBBK", "2", "1", "1"}}},
+	}
+	dir := t.TempDir()
+	var runs []*os.File
+	for _, chunk := range chunks {
+		run, err := os.CreateTemp(dir, "run-*.tsv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer run.Close()
+		if err := writeRows(run, h.delim, chunk); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := run.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, run)
+	}
+
+	var out strings.Builder
+	if err := mergeRuns(context.Background(), &out, h, runs); err != nil {
+		t.Fatalf("mergeRuns() error = %v", err)
+	}
+	want := "AAAK\t2\t1\t1\nAAAK\t2\t2\t1\nBBBK\t2\t1\t1\nCCCK\t2\t1\t1\n"
+	if out.String() != want {
+		t.Errorf("mergeRuns() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestParseModifiedPeptide(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string // Modified sequence
+		wantErr bool
+	}{
+		{input: "PEPTIDEK", want: "PEPTIDEK"},
+		{input: "_[Acetyl (Protein N-term)]M[Oxidation (M)]PEPTIDEK_", want: "n[+42.0106]M[+15.9949]PEPTIDEK"},
+		{input: "(UniMod:1)PEPTM(UniMod:35)IDEK", want: "n[+42.0106]PEPTM[+15.9949]IDEK"},
+		{input: ".(UniMod:1)PEPTIDEK.(UniMod:2)", want: "n[+42.0106]PEPTIDEKc[-0.9840]"},
+		{input: "n[+42.0106]PEPTC[+57.0215]IDEK", want: "n[+42.0106]PEPTC[+57.0215]IDEK"},
+		{input: "PEPTIDEK[Amidated (Protein C-term)]", want: "PEPTIDEKc[-0.9840]"},
+		{input: "PEP[Unknown]K", wantErr: true},
+		{input: "PEP[+15.99K", wantErr: true},
+		{input: "PEPTIDEK.A", wantErr: true},
+		{input: "__", wantErr: true},
+		{input: "PEP-K", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			seq, mods, err := ParseModifiedPeptide(tt.input, core.DefaultModDatabase())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseModifiedPeptide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			spec := core.Spectrum{Sequence: seq, Modifications: mods}
+			if got := spec.ModifiedSequence(); got != tt.want {
+				t.Errorf("ParseModifiedPeptide() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package tsv
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// sortChunkRows is the number of rows sorted in memory at a time when a
+// library has to be sorted
+const sortChunkRows = 1 << 20
+
+// OpenContext opens a TSV library by path. Libraries whose rows are not
+// grouped by precursor are first sorted by precursor into a temporary file,
+// in chunks of at most sortChunkRows rows, which Close removes. Sorting keeps
+// the order of rows within a precursor; line numbers in errors then refer to
+// the sorted file.
+func OpenContext(ctx context.Context, path string, modDB *core.ModDatabase) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	grouped, err := checkGrouped(ctx, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if grouped {
+		rd := NewReader(f, modDB)
+		rd.file = f
+		return rd, nil
+	}
+
+	sorted, err := sortByPrecursor(ctx, f, "")
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort %s by precursor: %w", path, err)
+	}
+
+	rd := NewReader(sorted, modDB)
+	rd.file = sorted
+	rd.tempPath = sorted.Name()
+	return rd, nil
+}
+
+// checkGrouped reports whether the rows of each precursor are adjacent
+func checkGrouped(ctx context.Context, r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return true, nil // Empty input, reported when reading
+	}
+	h, err := parseHeader(line)
+	if err != nil {
+		return false, err
+	}
+
+	rows := h.newCSVReader(br)
+	rows.ReuseRecord = true
+	seen := make(map[string]bool)
+	last := ""
+	for n := 0; ; n++ {
+		if n%100000 == 0 && ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		row, err := rows.Read()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to parse TSV: %w", err)
+		}
+		if h.decoy(row) {
+			continue
+		}
+		key := h.key(row)
+		if key == last {
+			continue
+		}
+		if seen[key] {
+			return false, nil
+		}
+		seen[key] = true
+		last = key
+	}
+}
+
+// sortedRow is a row with its precursor key
+type sortedRow struct {
+	key string
+	row []string
+}
+
+// sortByPrecursor writes the header and rows of a library, stably sorted by
+// precursor, to a temporary file in dir (the system default if empty) and
+// returns it rewound. Decoy rows are dropped.
+func sortByPrecursor(ctx context.Context, r io.Reader, dir string) (f *os.File, err error) {
+	br := bufio.NewReader(r)
+	headerLine, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	h, err := parseHeader(headerLine)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort chunks into temporary run files
+	var runs []*os.File
+	defer func() {
+		for _, run := range runs {
+			run.Close()
+			os.Remove(run.Name())
+		}
+	}()
+
+	rows := h.newCSVReader(br)
+	for done := false; !done; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var chunk []sortedRow
+		for len(chunk) < sortChunkRows {
+			row, err := rows.Read()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse TSV: %w", err)
+			}
+			if !h.decoy(row) {
+				chunk = append(chunk, sortedRow{key: h.key(row), row: row})
+			}
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		sort.SliceStable(chunk, func(i, j int) bool {
+			return chunk[i].key < chunk[j].key
+		})
+
+		run, err := os.CreateTemp(dir, ".dbkey-sort-*.tsv")
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+		if err := writeRows(run, h.delim, chunk); err != nil {
+			return nil, err
+		}
+		if _, err := run.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := os.CreateTemp(dir, ".dbkey-sorted-*.tsv")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(out)
+	if _, err := bw.WriteString(headerLine); err != nil {
+		return nil, err
+	}
+	if len(headerLine) > 0 && headerLine[len(headerLine)-1] != '\n' {
+		bw.WriteByte('\n')
+	}
+	if err := mergeRuns(ctx, bw, h, runs); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// writeRows writes rows with the library delimiter
+func writeRows(w io.Writer, delim rune, rows []sortedRow) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = delim
+	for _, r := range rows {
+		if err := cw.Write(r.row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// mergeRuns merges sorted run files. Equal keys are taken from earlier runs
+// first so the merge is stable.
+func mergeRuns(ctx context.Context, w io.Writer, h *header, runs []*os.File) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = h.delim
+
+	q := &runQueue{}
+	for i, run := range runs {
+		rows := h.newCSVReader(bufio.NewReader(run))
+		rr := &runReader{rows: rows, index: i, header: h}
+		if err := rr.advance(); err != nil {
+			return err
+		}
+		if rr.row != nil {
+			heap.Push(q, rr)
+		}
+	}
+
+	for n := 0; q.Len() > 0; n++ {
+		if n%100000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rr := (*q)[0]
+		if err := cw.Write(rr.row); err != nil {
+			return err
+		}
+		if err := rr.advance(); err != nil {
+			return err
+		}
+		if rr.row == nil {
+			heap.Pop(q)
+		} else {
+			heap.Fix(q, 0)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// runReader reads the rows of a sorted run file
+type runReader struct {
+	rows   *csv.Reader
+	header *header
+	index  int
+	row    []string
+	key    string
+}
+
+// advance reads the next row, leaving row nil at the end of the run
+func (rr *runReader) advance() error {
+	row, err := rr.rows.Read()
+	if err == io.EOF {
+		rr.row = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rr.row, rr.key = row, rr.header.key(row)
+	return nil
+}
+
+// runQueue is a min-heap of run readers by key, then run index
+type runQueue []*runReader
+
+func (q runQueue) Len() int { return len(q) }
+func (q runQueue) Less(i, j int) bool {
+	if q[i].key != q[j].key {
+		return q[i].key < q[j].key
+	}
+	return q[i].index < q[j].index
+}
+func (q runQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *runQueue) Push(x interface{}) { *q = append(*q, x.(*runReader)) }
+func (q *runQueue) Pop() interface{} {
+	old := *q
+	rr := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return rr
+}
@@ -0,0 +1,246 @@
+// Package similarity provides peak matching and spectral similarity metrics
+// for comparing spectra in the intermediate representation.
+package similarity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Tolerance is a peak matching tolerance in ppm or Daltons
+type Tolerance struct {
+	Value float64
+	PPM   bool // true = parts per million, false = Daltons
+}
+
+// PPM returns a tolerance in parts per million
+func PPM(value float64) Tolerance {
+	return Tolerance{Value: value, PPM: true}
+}
+
+// Da returns an absolute tolerance in Daltons
+func Da(value float64) Tolerance {
+	return Tolerance{Value: value}
+}
+
+// ParseTolerance parses a tolerance string like "20ppm", "0.02da" or "0.02".
+// A bare number is interpreted as Daltons.
+func ParseTolerance(s string) (Tolerance, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	ppm := false
+	switch {
+	case strings.HasSuffix(str, "ppm"):
+		ppm = true
+		str = strings.TrimSuffix(str, "ppm")
+	case strings.HasSuffix(str, "da"):
+		str = strings.TrimSuffix(str, "da")
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return Tolerance{}, fmt.Errorf("invalid tolerance '%s': %w", s, err)
+	}
+	if value < 0 {
+		return Tolerance{}, fmt.Errorf("invalid tolerance '%s': must be non-negative", s)
+	}
+
+	return Tolerance{Value: value, PPM: ppm}, nil
+}
+
+// Window returns the absolute m/z window for the tolerance at a given m/z
+func (t Tolerance) Window(mz float64) float64 {
+	if t.PPM {
+		return mz * t.Value * 1e-6
+	}
+	return t.Value
+}
+
+// Matches reports whether two m/z values are within tolerance of each other.
+// ppm tolerances are evaluated relative to the first value.
+func (t Tolerance) Matches(mz1, mz2 float64) bool {
+	return math.Abs(mz1-mz2) <= t.Window(mz1)
+}
+
+// String returns the tolerance in the format accepted by ParseTolerance
+func (t Tolerance) String() string {
+	if t.PPM {
+		return fmt.Sprintf("%gppm", t.Value)
+	}
+	return fmt.Sprintf("%gDa", t.Value)
+}
+
+// PeakPair is one row of an alignment between two spectra. Unmatched peaks
+// are paired with a zero-intensity counterpart and an index of -1.
+type PeakPair struct {
+	QueryIndex     int // Index into the query peaks (-1 if unmatched)
+	ReferenceIndex int // Index into the reference peaks (-1 if unmatched)
+	QueryMZ        float64
+	ReferenceMZ    float64
+	QueryIntensity float64
+	RefIntensity   float64
+}
+
+// Matched reports whether both sides of the pair are present
+func (p PeakPair) Matched() bool {
+	return p.QueryIndex >= 0 && p.ReferenceIndex >= 0
+}
+
+// Alignment is the result of matching the peaks of two spectra
+type Alignment struct {
+	Pairs []PeakPair // Matched and unmatched pairs together, ordered by m/z only
+}
+
+// MatchedCount returns the number of matched peak pairs
+func (a *Alignment) MatchedCount() int {
+	n := 0
+	for _, p := range a.Pairs {
+		if p.Matched() {
+			n++
+		}
+	}
+	return n
+}
+
+// Matched returns only the pairs where both peaks are present
+func (a *Alignment) Matched() []PeakPair {
+	var matched []PeakPair
+	for _, p := range a.Pairs {
+		if p.Matched() {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
+
+// Vectors returns the aligned query and reference intensity vectors,
+// including zero entries for unmatched peaks
+func (a *Alignment) Vectors() ([]float64, []float64) {
+	q := make([]float64, len(a.Pairs))
+	r := make([]float64, len(a.Pairs))
+	for i, p := range a.Pairs {
+		q[i] = p.QueryIntensity
+		r[i] = p.RefIntensity
+	}
+	return q, r
+}
+
+// MatchSpectra aligns the peaks of two spectra within the given tolerance
+func MatchSpectra(query, reference *core.Spectrum, tol Tolerance) *Alignment {
+	return MatchPeaks(query.Peaks, reference.Peaks, tol)
+}
+
+// MatchPeaks aligns two peak lists within the given tolerance. Each peak is
+// matched at most once; candidate pairs are accepted in order of increasing
+// m/z error, with ties broken by higher combined intensity.
+func MatchPeaks(query, reference []core.Peak, tol Tolerance) *Alignment {
+	qIdx := sortedIndex(query)
+	rIdx := sortedIndex(reference)
+
+	type candidate struct {
+		q, r  int
+		err   float64
+		inten float64
+	}
+
+	// Collect all candidate pairs using a sliding window over reference peaks
+	var candidates []candidate
+	start := 0
+	for _, qi := range qIdx {
+		qmz := query[qi].MZ
+		window := tol.Window(qmz)
+		for start < len(rIdx) && reference[rIdx[start]].MZ < qmz-window {
+			start++
+		}
+		for j := start; j < len(rIdx); j++ {
+			ri := rIdx[j]
+			if reference[ri].MZ > qmz+window {
+				break
+			}
+			candidates = append(candidates, candidate{
+				q:     qi,
+				r:     ri,
+				err:   math.Abs(reference[ri].MZ - qmz),
+				inten: query[qi].Intensity + reference[ri].Intensity,
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].err != candidates[j].err {
+			return candidates[i].err < candidates[j].err
+		}
+		return candidates[i].inten > candidates[j].inten
+	})
+
+	qUsed := make([]bool, len(query))
+	rUsed := make([]bool, len(reference))
+	var pairs []PeakPair
+	for _, c := range candidates {
+		if qUsed[c.q] || rUsed[c.r] {
+			continue
+		}
+		qUsed[c.q] = true
+		rUsed[c.r] = true
+		pairs = append(pairs, PeakPair{
+			QueryIndex:     c.q,
+			ReferenceIndex: c.r,
+			QueryMZ:        query[c.q].MZ,
+			ReferenceMZ:    reference[c.r].MZ,
+			QueryIntensity: query[c.q].Intensity,
+			RefIntensity:   reference[c.r].Intensity,
+		})
+	}
+
+	// Append unmatched peaks from both sides
+	for i, peak := range query {
+		if !qUsed[i] {
+			pairs = append(pairs, PeakPair{
+				QueryIndex:     i,
+				ReferenceIndex: -1,
+				QueryMZ:        peak.MZ,
+				QueryIntensity: peak.Intensity,
+			})
+		}
+	}
+	for i, peak := range reference {
+		if !rUsed[i] {
+			pairs = append(pairs, PeakPair{
+				QueryIndex:     -1,
+				ReferenceIndex: i,
+				ReferenceMZ:    peak.MZ,
+				RefIntensity:   peak.Intensity,
+			})
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].mz() < pairs[j].mz()
+	})
+
+	return &Alignment{Pairs: pairs}
+}
+
+// mz returns the representative m/z of a pair for ordering
+func (p PeakPair) mz() float64 {
+	if p.QueryIndex >= 0 {
+		return p.QueryMZ
+	}
+	return p.ReferenceMZ
+}
+
+// sortedIndex returns peak indices ordered by m/z without modifying the input
+func sortedIndex(peaks []core.Peak) []int {
+	idx := make([]int, len(peaks))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return peaks[idx[i]].MZ < peaks[idx[j]].MZ
+	})
+	return idx
+}
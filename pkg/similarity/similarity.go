@@ -0,0 +1,223 @@
+package similarity
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Metric identifies a spectral similarity metric
+type Metric string
+
+// Supported similarity metrics
+const (
+	MetricDotProduct      Metric = "dot"
+	MetricContrastAngle   Metric = "angle"
+	MetricEntropy         Metric = "entropy"
+	MetricWeightedEntropy Metric = "weighted-entropy"
+	MetricPearson         Metric = "pearson"
+
+	DefaultMetric = MetricDotProduct
+)
+
+// entropyWeightThreshold is the spectral entropy above which no weighting is applied
+const entropyWeightThreshold = 3.0
+
+// Metrics lists all supported metrics in display order
+var Metrics = []Metric{
+	MetricDotProduct,
+	MetricContrastAngle,
+	MetricEntropy,
+	MetricWeightedEntropy,
+	MetricPearson,
+}
+
+// ParseMetric parses a metric name (case-insensitive)
+func ParseMetric(name string) (Metric, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, m := range Metrics {
+		if string(m) == name {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown similarity metric '%s', must be one of %s", name, metricNames())
+}
+
+func metricNames() string {
+	names := make([]string, len(Metrics))
+	for i, m := range Metrics {
+		names[i] = string(m)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Score matches two spectra and computes the requested metric
+func Score(metric Metric, query, reference *core.Spectrum, tol Tolerance) (float64, error) {
+	al := MatchSpectra(query, reference, tol)
+	return al.Score(metric)
+}
+
+// Score computes the requested metric on an existing alignment
+func (a *Alignment) Score(metric Metric) (float64, error) {
+	switch metric {
+	case MetricDotProduct:
+		return a.DotProduct(), nil
+	case MetricContrastAngle:
+		return a.SpectralContrastAngle(), nil
+	case MetricEntropy:
+		return a.EntropySimilarity(), nil
+	case MetricWeightedEntropy:
+		return a.WeightedEntropySimilarity(), nil
+	case MetricPearson:
+		return a.Pearson(), nil
+	default:
+		return 0, fmt.Errorf("unknown similarity metric '%s'", metric)
+	}
+}
+
+// DotProduct returns the normalized dot product (cosine similarity) of the
+// aligned intensity vectors, in the range [0, 1]
+func (a *Alignment) DotProduct() float64 {
+	q, r := a.Vectors()
+	return cosine(q, r)
+}
+
+// SpectralContrastAngle returns the normalized spectral contrast angle
+// 1 - 2*acos(dot)/pi, in the range [0, 1]
+func (a *Alignment) SpectralContrastAngle() float64 {
+	dot := a.DotProduct()
+	if dot > 1 {
+		dot = 1
+	}
+	return 1 - 2*math.Acos(dot)/math.Pi
+}
+
+// EntropySimilarity returns the unweighted spectral entropy similarity
+// (Li et al., Nat. Methods 2021), in the range [0, 1]
+func (a *Alignment) EntropySimilarity() float64 {
+	q, r := a.Vectors()
+	return entropySimilarity(q, r)
+}
+
+// WeightedEntropySimilarity returns the entropy similarity after applying
+// the entropy-based intensity weighting to each spectrum
+func (a *Alignment) WeightedEntropySimilarity() float64 {
+	q, r := a.Vectors()
+	return entropySimilarity(entropyWeight(q), entropyWeight(r))
+}
+
+// Pearson returns the Pearson correlation of the intensities of matched
+// peaks. Returns 0 when fewer than two peaks are matched.
+func (a *Alignment) Pearson() float64 {
+	matched := a.Matched()
+	if len(matched) < 2 {
+		return 0
+	}
+
+	n := float64(len(matched))
+	var sumQ, sumR float64
+	for _, p := range matched {
+		sumQ += p.QueryIntensity
+		sumR += p.RefIntensity
+	}
+	meanQ, meanR := sumQ/n, sumR/n
+
+	var cov, varQ, varR float64
+	for _, p := range matched {
+		dq := p.QueryIntensity - meanQ
+		dr := p.RefIntensity - meanR
+		cov += dq * dr
+		varQ += dq * dq
+		varR += dr * dr
+	}
+
+	if varQ == 0 || varR == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varQ*varR)
+}
+
+// cosine computes the normalized dot product of two vectors
+func cosine(q, r []float64) float64 {
+	var dot, normQ, normR float64
+	for i := range q {
+		dot += q[i] * r[i]
+		normQ += q[i] * q[i]
+		normR += r[i] * r[i]
+	}
+	if normQ == 0 || normR == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normQ*normR)
+}
+
+// normalize scales a vector to sum to 1
+func normalize(v []float64) []float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	out := make([]float64, len(v))
+	if sum == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = x / sum
+	}
+	return out
+}
+
+// entropy computes the Shannon entropy of a normalized vector
+func entropy(v []float64) float64 {
+	s := 0.0
+	for _, x := range v {
+		if x > 0 {
+			s -= x * math.Log(x)
+		}
+	}
+	return s
+}
+
+// entropyWeight applies the weighting used by weighted entropy similarity:
+// spectra with entropy below 3 have intensities raised to 0.25 + 0.25*S
+func entropyWeight(v []float64) []float64 {
+	p := normalize(v)
+	s := entropy(p)
+	if s >= entropyWeightThreshold {
+		return p
+	}
+
+	w := 0.25 + s*0.25
+	out := make([]float64, len(p))
+	for i, x := range p {
+		if x > 0 {
+			out[i] = math.Pow(x, w)
+		}
+	}
+	return normalize(out)
+}
+
+// entropySimilarity computes 1 - (2*S_AB - S_A - S_B)/ln(4)
+func entropySimilarity(q, r []float64) float64 {
+	pq := normalize(q)
+	pr := normalize(r)
+
+	sumQ, sumR := 0.0, 0.0
+	for i := range pq {
+		sumQ += pq[i]
+		sumR += pr[i]
+	}
+	if sumQ == 0 || sumR == 0 {
+		return 0
+	}
+
+	merged := make([]float64, len(pq))
+	for i := range pq {
+		merged[i] = (pq[i] + pr[i]) / 2
+	}
+
+	sim := 1 - (2*entropy(merged)-entropy(pq)-entropy(pr))/math.Log(4)
+	return math.Max(0, math.Min(1, sim))
+}
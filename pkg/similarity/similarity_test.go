@@ -0,0 +1,119 @@
+package similarity
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+func TestParseTolerance(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Tolerance
+		wantErr bool
+	}{
+		{"20ppm", PPM(20), false},
+		{"0.02Da", Da(0.02), false},
+		{"0.5", Da(0.5), false},
+		{"abc", Tolerance{}, true},
+		{"-1ppm", Tolerance{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTolerance(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTolerance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseTolerance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPeaks(t *testing.T) {
+	query := []core.Peak{
+		{MZ: 100.000, Intensity: 10},
+		{MZ: 200.000, Intensity: 20},
+		{MZ: 300.000, Intensity: 30},
+	}
+	reference := []core.Peak{
+		{MZ: 100.001, Intensity: 15},
+		{MZ: 200.050, Intensity: 25},
+		{MZ: 299.999, Intensity: 35},
+		{MZ: 300.002, Intensity: 5},
+	}
+
+	al := MatchPeaks(query, reference, PPM(20))
+
+	if got := al.MatchedCount(); got != 2 {
+		t.Fatalf("MatchedCount() = %d, want 2", got)
+	}
+	// 3 query + 4 reference peaks with 2 matches = 5 aligned rows
+	if len(al.Pairs) != 5 {
+		t.Errorf("len(Pairs) = %d, want 5", len(al.Pairs))
+	}
+	for _, p := range al.Matched() {
+		if p.QueryIndex == 2 && p.ReferenceIndex != 2 {
+			t.Errorf("query peak 2 matched reference %d, want closest reference 2", p.ReferenceIndex)
+		}
+	}
+}
+
+func TestMetricsIdentical(t *testing.T) {
+	spec := &core.Spectrum{Peaks: []core.Peak{
+		{MZ: 100, Intensity: 10},
+		{MZ: 200, Intensity: 50},
+		{MZ: 300, Intensity: 100},
+	}}
+
+	for _, metric := range Metrics {
+		t.Run(string(metric), func(t *testing.T) {
+			got, err := Score(metric, spec, spec, Da(0.01))
+			if err != nil {
+				t.Fatalf("Score() error = %v", err)
+			}
+			if math.Abs(got-1) > 1e-6 {
+				t.Errorf("Score() = %.6f, want 1", got)
+			}
+		})
+	}
+}
+
+func TestMetricsDisjoint(t *testing.T) {
+	a := &core.Spectrum{Peaks: []core.Peak{{MZ: 100, Intensity: 10}, {MZ: 200, Intensity: 20}}}
+	b := &core.Spectrum{Peaks: []core.Peak{{MZ: 150, Intensity: 10}, {MZ: 250, Intensity: 20}}}
+
+	for _, metric := range []Metric{MetricDotProduct, MetricContrastAngle, MetricEntropy, MetricWeightedEntropy, MetricPearson} {
+		t.Run(string(metric), func(t *testing.T) {
+			got, err := Score(metric, a, b, Da(0.01))
+			if err != nil {
+				t.Fatalf("Score() error = %v", err)
+			}
+			if math.Abs(got) > 1e-6 {
+				t.Errorf("Score() = %.6f, want 0", got)
+			}
+		})
+	}
+}
+
+func TestDotProductPartial(t *testing.T) {
+	a := &core.Spectrum{Peaks: []core.Peak{{MZ: 100, Intensity: 1}, {MZ: 200, Intensity: 1}}}
+	b := &core.Spectrum{Peaks: []core.Peak{{MZ: 100, Intensity: 1}, {MZ: 300, Intensity: 1}}}
+
+	got := MatchSpectra(a, b, Da(0.01)).DotProduct()
+	if math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("DotProduct() = %.6f, want 0.5", got)
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	if _, err := ParseMetric("Entropy"); err != nil {
+		t.Errorf("ParseMetric(Entropy) error = %v", err)
+	}
+	if _, err := ParseMetric("bogus"); err == nil {
+		t.Error("ParseMetric(bogus) expected error")
+	}
+}
@@ -0,0 +1,41 @@
+// Package sqliteuri builds the SQLite URI filenames used to open database
+// files
+package sqliteuri
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// File returns the SQLite URI of a database file. The path is made absolute
+// and written without an authority, so that relative paths and Windows
+// drive letters are not read as a host, and characters such as '?', '#' and
+// '%' are escaped.
+func File(path string) string {
+	return uri(path, "")
+}
+
+// ReadOnly returns the SQLite URI of a database file opened read-only
+func ReadOnly(path string) string {
+	return uri(path, "mode=ro")
+}
+
+// uri returns the "file:" URI of path with an optional query
+func uri(path, query string) string {
+	// A path that cannot be made absolute is used as given
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	// Windows drive paths are written as "/C:/library.db"
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	s := "file:" + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		s += "?" + query
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package sqliteuri
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	abs := filepath.ToSlash(dir)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "library.db", want: "file:" + abs + "/library.db"},
+		{path: "./sub/library.db", want: "file:" + abs + "/sub/library.db"},
+		{path: "/data/library.db", want: "file:/data/library.db"},
+		{path: "/data/a?b#c%d e.db", want: "file:/data/a%3Fb%23c%25d%20e.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := File(tt.path); got != tt.want {
+				t.Errorf("File(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := ReadOnly("/data/library.db"), "file:/data/library.db?mode=ro"; got != want {
+		t.Errorf("ReadOnly() = %q, want %q", got, want)
+	}
+}
+
+func TestOpenRelative(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"library.db", "sub/a?b#c%d.db"} {
+		db, err := sql.Open("sqlite3", File(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`CREATE TABLE t (n INTEGER); INSERT INTO t VALUES (1)`); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		db.Close()
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("database not created at %s: %v", path, err)
+		}
+
+		ro, err := sql.Open("sqlite3", ReadOnly(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := ro.QueryRow(`SELECT n FROM t`).Scan(&n); err != nil || n != 1 {
+			t.Errorf("%s read-only: n = %d, err = %v", path, n, err)
+		}
+		if _, err := ro.Exec(`INSERT INTO t VALUES (2)`); err == nil {
+			t.Errorf("%s: write through the read-only URI succeeded", path)
+		}
+		ro.Close()
+	}
+}
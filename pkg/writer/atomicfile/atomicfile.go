@@ -0,0 +1,102 @@
+// Package atomicfile writes an output through a temporary file next to it,
+// so the output is only replaced, in one rename, once it is complete
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrExists is returned by Create when the output exists and may not be
+// overwritten
+var ErrExists = errors.New("output file already exists")
+
+// File is an output being written to a temporary file. The embedded
+// *os.File is the open temporary file.
+type File struct {
+	*os.File
+	path     string
+	tempPath string
+	closed   bool // The temporary file handle is closed
+	done     bool // Committed or aborted
+}
+
+// Create creates a temporary file in the directory of path for an output
+// that replaces path when committed. An existing path is refused with
+// ErrExists unless overwrite is set.
+func Create(path string, overwrite bool) (*File, error) {
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return nil, fmt.Errorf("%w: %s", ErrExists, path)
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &File{File: f, path: path, tempPath: f.Name()}, nil
+}
+
+// Resume returns a File for an output already being built in tempPath,
+// e.g. a partial file kept to resume an interrupted run. The file is not
+// opened; Commit moves it to path.
+func Resume(path, tempPath string) *File {
+	return &File{path: path, tempPath: tempPath, closed: true}
+}
+
+// Path returns the output path
+func (f *File) Path() string {
+	return f.path
+}
+
+// TempPath returns the path of the temporary file
+func (f *File) TempPath() string {
+	return f.tempPath
+}
+
+// Close closes the temporary file handle, leaving the file in place for
+// writers that reopen it by path. It may be called more than once.
+func (f *File) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.File.Close()
+}
+
+// Commit closes the temporary file and renames it to the output path. The
+// temporary file is removed if either step fails.
+func (f *File) Commit() error {
+	if f.done {
+		return fmt.Errorf("%s already committed or aborted", f.path)
+	}
+	f.done = true
+	if err := f.Close(); err != nil {
+		os.Remove(f.tempPath)
+		return fmt.Errorf("failed to close %s: %w", f.tempPath, err)
+	}
+	if err := os.Rename(f.tempPath, f.path); err != nil {
+		os.Remove(f.tempPath)
+		return fmt.Errorf("failed to move %s to %s: %w", f.tempPath, f.path, err)
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, leaving any existing output
+// untouched. It does nothing after Commit or a previous Abort.
+func (f *File) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	err := f.Close()
+	if rmErr := os.Remove(f.tempPath); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// dirEntries returns the names in dir, to check no temporary file is left
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestCreateExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	writeFile(t, path, "old")
+
+	if _, err := Create(path, false); !errors.Is(err, ErrExists) {
+		t.Fatalf("Create() error = %v, want %v", err, ErrExists)
+	}
+	if got := readFile(t, path); got != "old" {
+		t.Errorf("output = %q, want %q", got, "old")
+	}
+}
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+	}{
+		{"new output", false},
+		{"overwrite", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.txt")
+			if tt.existing {
+				writeFile(t, path, "old")
+			}
+
+			f, err := Create(path, true)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if filepath.Dir(f.TempPath()) != dir {
+				t.Errorf("TempPath() = %s, want a file in %s", f.TempPath(), dir)
+			}
+			if _, err := f.WriteString("new"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing {
+				if got := readFile(t, path); got != "old" {
+					t.Errorf("output before Commit = %q, want %q", got, "old")
+				}
+			}
+
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			if got := readFile(t, path); got != "new" {
+				t.Errorf("output = %q, want %q", got, "new")
+			}
+			if got := dirEntries(t, dir); len(got) != 1 {
+				t.Errorf("directory = %v, want only out.txt", got)
+			}
+			if err := f.Abort(); err != nil {
+				t.Errorf("Abort() after Commit error = %v", err)
+			}
+			if got := readFile(t, path); got != "new" {
+				t.Errorf("output after Abort = %q, want %q", got, "new")
+			}
+			if err := f.Commit(); err == nil {
+				t.Error("second Commit() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	writeFile(t, path, "old")
+
+	f, err := Create(path, true)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	if err := f.Abort(); err != nil {
+		t.Errorf("second Abort() error = %v", err)
+	}
+
+	if got := readFile(t, path); got != "old" {
+		t.Errorf("output = %q, want %q", got, "old")
+	}
+	if got := dirEntries(t, dir); len(got) != 1 {
+		t.Errorf("directory = %v, want only out.txt", got)
+	}
+	if err := f.Commit(); err == nil {
+		t.Error("Commit() after Abort error = nil, want error")
+	}
+}
+
+func TestCloseThenCommit(t *testing.T) {
+	// Writers that reopen the temporary file by path close the handle first
+	path := filepath.Join(t.TempDir(), "out.db")
+	f, err := Create(path, false)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	writeFile(t, f.TempPath(), "reopened")
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if got := readFile(t, path); got != "reopened" {
+		t.Errorf("output = %q, want %q", got, "reopened")
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.db")
+	partial := path + ".partial"
+	writeFile(t, partial, "resumed")
+
+	if err := Resume(path, partial).Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if got := readFile(t, path); got != "resumed" {
+		t.Errorf("output = %q, want %q", got, "resumed")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("partial file still exists: %v", err)
+	}
+}
+
+func TestCreateRelative(t *testing.T) {
+	t.Chdir(t.TempDir())
+	f, err := Create("out.txt", false)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if _, err := os.Stat("out.txt"); err != nil {
+		t.Errorf("output missing: %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+// Package blib writes spectral libraries as BiblioSpec BLIB libraries read
+// by Skyline
+package blib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// BiblioSpec schema version written to LibInfo
+const (
+	MajorVersion = 1
+	MinorVersion = 10
+)
+
+// lsidPrefix is the BiblioSpec LSID of a non-redundant library, followed by
+// the library name
+const lsidPrefix = "urn:lsid:proteome.gs.washington.edu:spectral_library:bibliospec:nr:"
+
+// createTimeFormat is the LibInfo.createTime format written by BlibBuild
+const createTimeFormat = "Mon Jan _2 15:04:05 2006"
+
+// Options configures the BLIB writer
+type Options struct {
+	// BatchSize is the number of spectra committed per transaction
+	// (0 = sqlite.DefaultBatchSize)
+	BatchSize int
+	// Overwrite allows replacing an existing file
+	Overwrite bool
+}
+
+// Writer writes spectra as a non-redundant BiblioSpec library, one
+// RefSpectra row per spectrum. Peptides are identified by sequence and
+// modifications, small molecules by name, formula, adduct and InChIKey.
+type Writer struct {
+	db     *sql.DB
+	out    *atomicfile.File // Library being written, committed by Finalize
+	closed bool
+	batch  *sqlite.Batch
+	stmts  statements
+
+	sourceFiles map[string]int // Source file -> SpectrumSourceFiles.id
+	proteins    map[string]int // Accession -> Proteins.id
+	written     int
+}
+
+// statements are the prepared insert statements
+type statements struct {
+	spectrum, peaks, modification, retentionTime *sql.Stmt
+	sourceFile, protein, spectrumProtein         *sql.Stmt
+}
+
+// schema creates the BiblioSpec tables read by Skyline
+const schema = `
+	CREATE TABLE LibInfo (
+		libLSID TEXT,
+		createTime TEXT,
+		numSpecs INTEGER,
+		majorVersion INTEGER,
+		minorVersion INTEGER
+	);
+	CREATE TABLE RefSpectra (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		peptideSeq VARCHAR(150),
+		precursorMZ REAL,
+		precursorCharge INTEGER,
+		peptideModSeq VARCHAR(200),
+		prevAA CHAR(1),
+		nextAA CHAR(1),
+		copies INTEGER,
+		numPeaks INTEGER,
+		ionMobility REAL,
+		collisionalCrossSectionSqA REAL,
+		ionMobilityHighEnergyOffset REAL,
+		ionMobilityType TINYINT,
+		retentionTime REAL,
+		startTime REAL,
+		endTime REAL,
+		totalIonCurrent REAL,
+		moleculeName VARCHAR(128),
+		chemicalFormula VARCHAR(128),
+		precursorAdduct VARCHAR(128),
+		inchiKey VARCHAR(128),
+		otherKeys VARCHAR(128),
+		fileID INTEGER,
+		SpecIDinFile VARCHAR(256),
+		score REAL,
+		scoreType TINYINT
+	);
+	CREATE TABLE Modifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		RefSpectraID INTEGER,
+		position INTEGER,
+		mass REAL
+	);
+	CREATE TABLE RefSpectraPeaks (
+		RefSpectraID INTEGER,
+		peakMZ BLOB,
+		peakIntensity BLOB
+	);
+	CREATE TABLE RefSpectraPeakAnnotations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		RefSpectraID INTEGER NOT NULL,
+		peakIndex INTEGER NOT NULL,
+		name VARCHAR(256),
+		formula VARCHAR(256),
+		inchiKey VARCHAR(256),
+		otherKeys VARCHAR(256),
+		charge INTEGER,
+		adduct VARCHAR(256),
+		comment VARCHAR(256),
+		mzTheoretical REAL NOT NULL,
+		mzObserved REAL NOT NULL
+	);
+	CREATE TABLE RetentionTimes (
+		RefSpectraID INTEGER,
+		RedundantRefSpectraID INTEGER,
+		SpectrumSourceID INTEGER,
+		ionMobility REAL,
+		collisionalCrossSectionSqA REAL,
+		ionMobilityHighEnergyOffset REAL,
+		ionMobilityType TINYINT,
+		retentionTime REAL,
+		startTime REAL,
+		endTime REAL,
+		score REAL,
+		bestSpectrum INTEGER
+	);
+	CREATE TABLE SpectrumSourceFiles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		fileName VARCHAR(512),
+		idFileName VARCHAR(512),
+		cutoffScore REAL,
+		workflowType TINYINT
+	);
+	CREATE TABLE Proteins (
+		id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		accession VARCHAR(200)
+	);
+	CREATE TABLE RefSpectraProteins (
+		RefSpectraId INTEGER NOT NULL,
+		ProteinId INTEGER NOT NULL
+	);
+	CREATE TABLE ScoreTypes (
+		id INTEGER PRIMARY KEY,
+		scoreType VARCHAR(128),
+		probabilityType VARCHAR(128)
+	);
+	CREATE TABLE IonMobilityTypes (
+		id INTEGER PRIMARY KEY,
+		ionMobilityType VARCHAR(128)
+	);
+	INSERT INTO ScoreTypes (id, scoreType, probabilityType) VALUES (0, 'UNKNOWN', 'NOT_A_PROBABILITY_VALUE');
+	INSERT INTO IonMobilityTypes (id, ionMobilityType) VALUES
+		(0, 'none'), (1, 'driftTime(msec)'), (2, 'inverseK0(Vsec/cm^2)'), (3, 'compensation(V)');
+`
+
+// indexes are created by Finalize, after the spectra are inserted
+const indexes = `
+	CREATE INDEX idxPeptide ON RefSpectra (peptideSeq, precursorCharge);
+	CREATE INDEX idxPeptideMod ON RefSpectra (peptideModSeq, precursorCharge);
+	CREATE INDEX idxMoleculeName ON RefSpectra (moleculeName, precursorAdduct);
+	CREATE INDEX idxInChiKey ON RefSpectra (inchiKey, precursorAdduct);
+	CREATE INDEX idxRefIdPeaks ON RefSpectraPeaks (RefSpectraID);
+	CREATE INDEX idxRefIdPeakAnnotations ON RefSpectraPeakAnnotations (RefSpectraID);
+`
+
+// NewWriter creates a BLIB writer. The library is written to a temporary
+// file next to outputPath, which only replaces outputPath when Finalize
+// succeeds.
+func NewWriter(outputPath string, opts Options) (*Writer, error) {
+	out, err := atomicfile.Create(outputPath, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+
+	db, err := sql.Open("sqlite3", sqliteuri.File(out.TempPath()))
+	if err != nil {
+		out.Abort()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	w := &Writer{
+		db:          db,
+		out:         out,
+		batch:       sqlite.NewBatch(db, opts.BatchSize),
+		sourceFiles: make(map[string]int),
+		proteins:    make(map[string]int),
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+	if err := w.prepareStatements(); err != nil {
+		w.Abort()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// prepareStatements prepares the insert statement of each table
+func (w *Writer) prepareStatements() error {
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.stmts.spectrum, `
+			INSERT INTO RefSpectra (
+				id, peptideSeq, precursorMZ, precursorCharge, peptideModSeq,
+				prevAA, nextAA, copies, numPeaks, ionMobilityType, retentionTime,
+				totalIonCurrent, moleculeName, chemicalFormula, precursorAdduct,
+				inchiKey, otherKeys, fileID, SpecIDinFile, score, scoreType
+			) VALUES (?, ?, ?, ?, ?, '-', '-', 1, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0)`},
+		{&w.stmts.peaks, `INSERT INTO RefSpectraPeaks (RefSpectraID, peakMZ, peakIntensity) VALUES (?, ?, ?)`},
+		{&w.stmts.modification, `INSERT INTO Modifications (RefSpectraID, position, mass) VALUES (?, ?, ?)`},
+		{&w.stmts.retentionTime, `
+			INSERT INTO RetentionTimes (
+				RefSpectraID, RedundantRefSpectraID, SpectrumSourceID, ionMobilityType,
+				retentionTime, score, bestSpectrum
+			) VALUES (?, 0, ?, 0, ?, 0, 1)`},
+		{&w.stmts.sourceFile, `INSERT INTO SpectrumSourceFiles (id, fileName, idFileName, workflowType) VALUES (?, ?, ?, 0)`},
+		{&w.stmts.protein, `INSERT INTO Proteins (id, accession) VALUES (?, ?)`},
+		{&w.stmts.spectrumProtein, `INSERT INTO RefSpectraProteins (RefSpectraId, ProteinId) VALUES (?, ?)`},
+	}
+	for _, q := range queries {
+		stmt, err := w.db.Prepare(q.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return nil
+}
+
+// WriteSpectrum writes a spectrum to the library
+func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes a spectrum to the library. Spectra are
+// committed in batches of Options.BatchSize; if ctx is cancelled the
+// spectrum is not written and ctx.Err() is returned.
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := w.batch.Begin(); err != nil {
+		return err
+	}
+
+	if !spec.ArePeaksSorted() {
+		spec.SortPeaks()
+	}
+
+	fileID, err := w.sourceFile(ctx, spec.SourceFile)
+	if err != nil {
+		return err
+	}
+
+	var rt any
+	if spec.RetentionTime != nil {
+		rt = *spec.RetentionTime
+	}
+	tic := 0.0
+	for _, peak := range spec.Peaks {
+		tic += peak.Intensity
+	}
+
+	// Small molecules have no peptide sequence and are identified by their
+	// molecule columns instead
+	var seq, modSeq, name, formula, adduct, inchiKey, otherKeys string
+	if spec.IsSmallMolecule() {
+		name = spec.CompoundName
+		formula = spec.Formula
+		adduct = spec.PrecursorIonType()
+		inchiKey = spec.InChIKey
+		otherKeys = OtherKeys(spec)
+	} else {
+		seq = spec.Sequence
+		modSeq = ModifiedSequence(spec)
+	}
+
+	id := w.written + 1
+	if _, err := w.batch.Stmt(w.stmts.spectrum).ExecContext(ctx,
+		id,
+		seq,
+		spec.PrecursorMZ,
+		spec.Charge,
+		modSeq,
+		len(spec.Peaks),
+		rt,
+		tic,
+		name,
+		formula,
+		adduct,
+		inchiKey,
+		otherKeys,
+		fileID,
+		spec.Name(),
+	); err != nil {
+		return fmt.Errorf("failed to insert spectrum: %w", err)
+	}
+
+	mzBlob, intBlob := EncodePeaks(spec.Peaks)
+	if _, err := w.batch.Stmt(w.stmts.peaks).ExecContext(ctx, id, mzBlob, intBlob); err != nil {
+		return fmt.Errorf("failed to insert peaks: %w", err)
+	}
+
+	if !spec.IsSmallMolecule() {
+		for _, mod := range modificationSites(spec) {
+			if _, err := w.batch.Stmt(w.stmts.modification).ExecContext(ctx, id, mod.Position, mod.Mass); err != nil {
+				return fmt.Errorf("failed to insert modification: %w", err)
+			}
+		}
+	}
+
+	if _, err := w.batch.Stmt(w.stmts.retentionTime).ExecContext(ctx, id, fileID, rt); err != nil {
+		return fmt.Errorf("failed to insert retention time: %w", err)
+	}
+
+	for _, accession := range spec.Proteins {
+		proteinID, ok := w.proteins[accession]
+		if !ok {
+			proteinID = len(w.proteins) + 1
+			if _, err := w.batch.Stmt(w.stmts.protein).ExecContext(ctx, proteinID, accession); err != nil {
+				return fmt.Errorf("failed to insert protein: %w", err)
+			}
+			w.proteins[accession] = proteinID
+		}
+		if _, err := w.batch.Stmt(w.stmts.spectrumProtein).ExecContext(ctx, id, proteinID); err != nil {
+			return fmt.Errorf("failed to insert protein mapping: %w", err)
+		}
+	}
+
+	w.written++
+	w.batch.Add()
+	return nil
+}
+
+// sourceFile returns the SpectrumSourceFiles id of a source file, inserting
+// it on first use
+func (w *Writer) sourceFile(ctx context.Context, path string) (int, error) {
+	if id, ok := w.sourceFiles[path]; ok {
+		return id, nil
+	}
+	id := len(w.sourceFiles) + 1
+	if _, err := w.batch.Stmt(w.stmts.sourceFile).ExecContext(ctx, id, path, path); err != nil {
+		return 0, fmt.Errorf("failed to insert source file: %w", err)
+	}
+	w.sourceFiles[path] = id
+	return id, nil
+}
+
+// modificationSites returns the modifications of a peptide by 1-based
+// residue, with terminal modifications on the first and last residue and
+// modifications of the same residue combined
+func modificationSites(spec *core.Spectrum) []core.Modification {
+	n := len(spec.Sequence)
+	masses := make(map[int]float64)
+	for _, mod := range spec.Modifications {
+		pos := mod.Position + 1
+		if pos < 1 {
+			pos = 1
+		}
+		if pos > n {
+			pos = n
+		}
+		masses[pos] += mod.Mass
+	}
+
+	sites := make([]core.Modification, 0, len(masses))
+	for pos, mass := range masses {
+		sites = append(sites, core.Modification{Position: pos, Mass: mass})
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Position < sites[j].Position
+	})
+	return sites
+}
+
+// ModifiedSequence returns the peptide in BiblioSpec notation, with the
+// combined mass shift of each residue in brackets after it to one decimal,
+// e.g. "PEPTM[+16.0]IDE". Terminal modifications are placed on the first
+// and last residue.
+func ModifiedSequence(spec *core.Spectrum) string {
+	sites := modificationSites(spec)
+	var b strings.Builder
+	next := 0
+	for _, site := range sites {
+		b.WriteString(spec.Sequence[next:site.Position])
+		next = site.Position
+		fmt.Fprintf(&b, "[%+.1f]", site.Mass)
+	}
+	b.WriteString(spec.Sequence[next:])
+	return b.String()
+}
+
+// OtherKeys returns the tab-separated CAS and PubChem identifiers of a small
+// molecule in BiblioSpec otherKeys notation, e.g. "cas:50-99-7"
+func OtherKeys(spec *core.Spectrum) string {
+	var keys []string
+	if spec.CASNumber != "" {
+		keys = append(keys, "cas:"+spec.CASNumber)
+	}
+	if spec.PubChemID != "" {
+		keys = append(keys, "pubchem:"+spec.PubChemID)
+	}
+	return strings.Join(keys, "\t")
+}
+
+// EncodePeaks encodes the peaks as little-endian float64 m/z and float32
+// intensity arrays. Each array is zlib-compressed if that makes it smaller;
+// readers tell the two apart by comparing the blob size with numPeaks.
+func EncodePeaks(peaks []core.Peak) (mzBlob, intBlob []byte) {
+	mz := make([]byte, len(peaks)*8)
+	intensity := make([]byte, len(peaks)*4)
+	for i, peak := range peaks {
+		binary.LittleEndian.PutUint64(mz[i*8:], math.Float64bits(peak.MZ))
+		binary.LittleEndian.PutUint32(intensity[i*4:], math.Float32bits(float32(peak.Intensity)))
+	}
+	return compress(mz), compress(intensity)
+}
+
+// compress returns the zlib-compressed data if it is smaller, otherwise the
+// data itself
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return data
+	}
+	if err := zw.Close(); err != nil || buf.Len() >= len(data) {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// Written returns the number of spectra written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Finalize commits the last batch, writes LibInfo and the indexes, closes
+// the database and moves it to the output path
+func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := w.batch.Commit(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(w.out.Path()), filepath.Ext(w.out.Path()))
+	if _, err := w.db.Exec(`
+		INSERT INTO LibInfo (libLSID, createTime, numSpecs, majorVersion, minorVersion)
+		VALUES (?, ?, ?, ?, ?)
+	`, lsidPrefix+name, time.Now().Format(createTimeFormat), w.written, MajorVersion, MinorVersion); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to insert library info: %w", err)
+	}
+	if _, err := w.db.Exec(indexes); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to create indexes: %w", err)
+	}
+
+	if err := w.closeDB(); err != nil {
+		w.out.Abort()
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return w.out.Commit()
+}
+
+// Close finalizes the writer (alias for Finalize)
+func (w *Writer) Close() error {
+	return w.Finalize()
+}
+
+// Abort rolls back the open batch and deletes the unfinished library; an
+// existing BLIB at the output path is kept. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	err := w.batch.Rollback()
+	if dbErr := w.closeDB(); err == nil {
+		err = dbErr
+	}
+	if rmErr := w.out.Abort(); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// closeDB closes prepared statements and the database connection
+func (w *Writer) closeDB() error {
+	w.closed = true
+
+	for _, stmt := range []*sql.Stmt{
+		w.stmts.spectrum, w.stmts.peaks, w.stmts.modification, w.stmts.retentionTime,
+		w.stmts.sourceFile, w.stmts.protein, w.stmts.spectrumProtein,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+
+	return w.db.Close()
+}
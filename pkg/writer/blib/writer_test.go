@@ -0,0 +1,324 @@
+package blib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+)
+
+// testPeptide returns a peptide spectrum with unsorted peaks
+func testPeptide(seq string, charge int, mods ...core.Modification) *core.Spectrum {
+	rt := 12.5
+	return &core.Spectrum{
+		Sequence:      seq,
+		Charge:        charge,
+		PrecursorMZ:   500.25,
+		RetentionTime: &rt,
+		Modifications: mods,
+		Proteins:      []string{"P1", "P2"},
+		SourceFile:    "run1.raw",
+		Peaks:         []core.Peak{{MZ: 300.1, Intensity: 50}, {MZ: 175.119, Intensity: 100}},
+	}
+}
+
+// decodeArray inflates a peak blob unless it already has the uncompressed
+// size, as BiblioSpec readers do
+func decodeArray(t *testing.T, blob []byte, size int) []byte {
+	t.Helper()
+	if len(blob) == size {
+		return blob
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatalf("zlib.NewReader() error = %v", err)
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != size {
+		t.Fatalf("inflated %d bytes, want %d", len(data), size)
+	}
+	return data
+}
+
+// decodePeaks decodes the peak blobs of n peaks
+func decodePeaks(t *testing.T, mzBlob, intBlob []byte, n int) []core.Peak {
+	t.Helper()
+	mz := decodeArray(t, mzBlob, n*8)
+	intensity := decodeArray(t, intBlob, n*4)
+	peaks := make([]core.Peak, n)
+	for i := range peaks {
+		peaks[i] = core.Peak{
+			MZ:        math.Float64frombits(binary.LittleEndian.Uint64(mz[i*8:])),
+			Intensity: float64(math.Float32frombits(binary.LittleEndian.Uint32(intensity[i*4:]))),
+		}
+	}
+	return peaks
+}
+
+func TestEncodePeaks(t *testing.T) {
+	// Repeated values compress, a single peak does not
+	many := make([]core.Peak, 50)
+	for i := range many {
+		many[i] = core.Peak{MZ: 100, Intensity: 10}
+	}
+	tests := []struct {
+		name           string
+		peaks          []core.Peak
+		wantCompressed bool
+	}{
+		{name: "single peak", peaks: []core.Peak{{MZ: 175.119, Intensity: 100.5}}},
+		{name: "compressible", peaks: many, wantCompressed: true},
+		{name: "no peaks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mzBlob, intBlob := EncodePeaks(tt.peaks)
+			n := len(tt.peaks)
+			if compressed := len(mzBlob) < n*8; compressed != tt.wantCompressed {
+				t.Errorf("m/z blob of %d bytes for %d peaks, compressed = %v, want %v", len(mzBlob), n, compressed, tt.wantCompressed)
+			}
+			got := decodePeaks(t, mzBlob, intBlob, n)
+			for i := range tt.peaks {
+				if got[i] != tt.peaks[i] {
+					t.Errorf("peak %d = %+v, want %+v", i, got[i], tt.peaks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestModifiedSequence(t *testing.T) {
+	tests := []struct {
+		name string
+		mods []core.Modification
+		want string
+	}{
+		{name: "unmodified", want: "PEPTMIDEK"},
+		{name: "residue", mods: []core.Modification{{Mass: 15.994915, Position: 4}}, want: "PEPTM[+16.0]IDEK"},
+		{
+			name: "terminal",
+			mods: []core.Modification{{Mass: 42.010565, Position: -1}, {Mass: 0.984, Position: 9}},
+			want: "P[+42.0]EPTMIDEK[+1.0]",
+		},
+		{
+			name: "combined on the first residue",
+			mods: []core.Modification{{Mass: 42.010565, Position: -1}, {Mass: 79.966331, Position: 0}},
+			want: "P[+122.0]EPTMIDEK",
+		},
+		{name: "negative", mods: []core.Modification{{Mass: -17.026549, Position: 0}}, want: "P[-17.0]EPTMIDEK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &core.Spectrum{Sequence: "PEPTMIDEK", Modifications: tt.mods}
+			if got := ModifiedSequence(spec); got != tt.want {
+				t.Errorf("ModifiedSequence() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.blib")
+	w, err := NewWriter(path, Options{BatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	phospho := testPeptide("PEPTIDEK", 2,
+		core.Modification{Mass: 42.010565, Position: -1},
+		core.Modification{Mass: 79.966331, Position: 3})
+	molecule := &core.Spectrum{
+		CompoundName:  "Glucose",
+		Formula:       "C6H12O6",
+		InChIKey:      "WQZGKKKJIJFFOK-GASJEMHNSA-N",
+		CASNumber:     "50-99-7",
+		PubChemID:     "5793",
+		PrecursorType: "[M+Na]+",
+		Charge:        1,
+		PrecursorMZ:   203.0526,
+		SourceFile:    "run2.raw",
+		Peaks:         []core.Peak{{MZ: 185.04, Intensity: 10}},
+	}
+	second := testPeptide("AAAK", 2)
+	for _, spec := range []*core.Spectrum{phospho, molecule, second} {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if w.Written() != 3 {
+		t.Errorf("Written() = %d, want 3", w.Written())
+	}
+
+	db := sqltest.Open(t, path)
+	var (
+		lsid         string
+		numSpecs     int
+		major, minor int
+	)
+	if err := db.QueryRow(`SELECT libLSID, numSpecs, majorVersion, minorVersion FROM LibInfo`).
+		Scan(&lsid, &numSpecs, &major, &minor); err != nil {
+		t.Fatal(err)
+	}
+	if lsid != lsidPrefix+"library" || numSpecs != 3 || major != MajorVersion || minor != MinorVersion {
+		t.Errorf("LibInfo = %s %d %d.%d, want %slibrary 3 %d.%d", lsid, numSpecs, major, minor, lsidPrefix, MajorVersion, MinorVersion)
+	}
+	for _, index := range []string{"idxPeptide", "idxPeptideMod", "idxMoleculeName", "idxInChiKey", "idxRefIdPeaks"} {
+		if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?`, index); got != 1 {
+			t.Errorf("index %s missing", index)
+		}
+	}
+
+	var (
+		seq, modSeq, specID string
+		charge, numPeaks    int
+		fileID              int
+		rt, tic             float64
+	)
+	if err := db.QueryRow(`
+		SELECT peptideSeq, peptideModSeq, precursorCharge, numPeaks, retentionTime, totalIonCurrent, fileID, SpecIDinFile
+		FROM RefSpectra WHERE id = 1`).Scan(&seq, &modSeq, &charge, &numPeaks, &rt, &tic, &fileID, &specID); err != nil {
+		t.Fatal(err)
+	}
+	if seq != "PEPTIDEK" || modSeq != "P[+42.0]EPT[+80.0]IDEK" || charge != 2 || numPeaks != 2 {
+		t.Errorf("peptide = %s %s %d %d peaks, want PEPTIDEK P[+42.0]EPT[+80.0]IDEK 2 2 peaks", seq, modSeq, charge, numPeaks)
+	}
+	if rt != 12.5 || tic != 150 || fileID != 1 || specID != "PEPTIDEK/2" {
+		t.Errorf("peptide RT, TIC, file, id = %v %v %d %s, want 12.5 150 1 PEPTIDEK/2", rt, tic, fileID, specID)
+	}
+
+	rows, err := db.Query(`SELECT position, mass FROM Modifications WHERE RefSpectraID = 1 ORDER BY position`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mods []core.Modification
+	for rows.Next() {
+		var mod core.Modification
+		if err := rows.Scan(&mod.Position, &mod.Mass); err != nil {
+			t.Fatal(err)
+		}
+		mods = append(mods, mod)
+	}
+	rows.Close()
+	want := []core.Modification{{Position: 1, Mass: 42.010565}, {Position: 4, Mass: 79.966331}}
+	if len(mods) != len(want) || mods[0] != want[0] || mods[1] != want[1] {
+		t.Errorf("Modifications = %+v, want %+v", mods, want)
+	}
+
+	var (
+		name, formula, adduct, inchiKey, otherKeys string
+		molSeq                                     string
+	)
+	if err := db.QueryRow(`
+		SELECT peptideSeq, moleculeName, chemicalFormula, precursorAdduct, inchiKey, otherKeys, fileID
+		FROM RefSpectra WHERE id = 2`).Scan(&molSeq, &name, &formula, &adduct, &inchiKey, &otherKeys, &fileID); err != nil {
+		t.Fatal(err)
+	}
+	if molSeq != "" || name != "Glucose" || formula != "C6H12O6" || adduct != "[M+Na]+" || fileID != 2 {
+		t.Errorf("molecule = %q %s %s %s file %d, want Glucose C6H12O6 [M+Na]+ file 2", molSeq, name, formula, adduct, fileID)
+	}
+	if inchiKey != molecule.InChIKey || otherKeys != "cas:50-99-7\tpubchem:5793" {
+		t.Errorf("molecule keys = %s %q, want %s cas:50-99-7\\tpubchem:5793", inchiKey, otherKeys, molecule.InChIKey)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM Modifications WHERE RefSpectraID = 2`); got != 0 {
+		t.Errorf("molecule modifications = %d, want 0", got)
+	}
+
+	// Peaks are stored sorted by m/z
+	var mzBlob, intBlob []byte
+	if err := db.QueryRow(`SELECT peakMZ, peakIntensity FROM RefSpectraPeaks WHERE RefSpectraID = 1`).Scan(&mzBlob, &intBlob); err != nil {
+		t.Fatal(err)
+	}
+	peaks := decodePeaks(t, mzBlob, intBlob, 2)
+	if peaks[0] != (core.Peak{MZ: 175.119, Intensity: 100}) || peaks[1] != (core.Peak{MZ: 300.1, Intensity: 50}) {
+		t.Errorf("peaks = %+v, want sorted by m/z", peaks)
+	}
+
+	counts := []struct {
+		query string
+		want  int
+	}{
+		{`SELECT COUNT(*) FROM SpectrumSourceFiles`, 2},
+		{`SELECT COUNT(*) FROM RetentionTimes`, 3},
+		{`SELECT COUNT(*) FROM Proteins`, 2},
+		{`SELECT COUNT(*) FROM RefSpectraProteins`, 4},
+		{`SELECT COUNT(*) FROM RefSpectraProteins WHERE RefSpectraId = 3`, 2},
+	}
+	for _, c := range counts {
+		if got := sqltest.QueryInt(t, db, c.query); got != c.want {
+			t.Errorf("%s = %d, want %d", c.query, got, c.want)
+		}
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM RetentionTimes WHERE RefSpectraID = 2 AND retentionTime IS NULL`); got != 1 {
+		t.Error("molecule without RT has a retention time")
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.blib")
+	if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWriter(path, Options{}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("NewWriter() error = %v, want already exists", err)
+	}
+
+	w, err := NewWriter(path, Options{Overwrite: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+
+	// The existing output is untouched and the temporary file removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files after Abort = %v, want the existing output only", entries)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "existing" {
+		t.Errorf("output after Abort = %q, want existing", data)
+	}
+	if err := w.Finalize(); err == nil {
+		t.Error("Finalize() after Abort succeeded")
+	}
+}
+
+func TestWriterRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	w, err := NewWriter("library.blib", Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if got := sqltest.QueryInt(t, sqltest.Open(t, filepath.Join(dir, "library.blib")), `SELECT COUNT(*) FROM RefSpectra`); got != 1 {
+		t.Errorf("spectra = %d, want 1", got)
+	}
+}
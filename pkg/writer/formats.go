@@ -0,0 +1,140 @@
+package writer
+
+import (
+	"context"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/blib"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/jsonl"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/parquet"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/pqp"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/shard"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+)
+
+// Built-in output formats
+const (
+	FormatDB      = "db"
+	FormatBLIB    = "blib"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+	FormatPQP     = "pqp"
+)
+
+func init() {
+	Register(FormatDB, func(ctx context.Context, path string, cfg Config) (Writer, error) {
+		opts := cfg.Library
+		opts.BatchSize = cfg.BatchSize
+		opts.Overwrite = cfg.Overwrite
+		if cfg.Shard.Enabled() {
+			return shard.NewWriter(ctx, path, cfg.Shard, opts)
+		}
+		w, err := sqlite.NewWriterWithOptions(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, w), nil
+	}, ".db", ".db3", ".sqlite")
+
+	Register(FormatBLIB, func(ctx context.Context, path string, cfg Config) (Writer, error) {
+		w, err := blib.NewWriter(path, blib.Options{BatchSize: cfg.BatchSize, Overwrite: cfg.Overwrite})
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, w), nil
+	}, ".blib")
+
+	Register(FormatJSONL, func(ctx context.Context, path string, cfg Config) (Writer, error) {
+		w, err := jsonl.NewWriter(path, jsonl.Options{Overwrite: cfg.Overwrite})
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, w), nil
+	}, ".jsonl", ".ndjson")
+
+	Register(FormatParquet, func(ctx context.Context, path string, cfg Config) (Writer, error) {
+		w, err := parquet.NewWriter(path, parquet.Options{RowGroupRows: cfg.RowGroupRows, Overwrite: cfg.Overwrite})
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, w), nil
+	}, ".parquet")
+
+	Register(FormatPQP, func(ctx context.Context, path string, cfg Config) (Writer, error) {
+		w, err := pqp.NewWriter(path, pqp.Options{
+			Selection: cfg.Selection,
+			BatchSize: cfg.BatchSize,
+			Overwrite: cfg.Overwrite,
+		}, cfg.ModDB)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(ctx, w), nil
+	}, ".pqp")
+
+	// Skyline lists are the only comma-separated layout; the TSV layouts
+	// must be named
+	for _, layout := range transition.Layouts {
+		var extensions []string
+		if layout == transition.LayoutSkyline {
+			extensions = []string{".csv"}
+		}
+		Register(string(layout), func(ctx context.Context, path string, cfg Config) (Writer, error) {
+			w, err := transition.NewWriter(path, transition.Options{
+				Layout:    layout,
+				Selection: cfg.Selection,
+				Overwrite: cfg.Overwrite,
+			}, cfg.ModDB)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(ctx, w), nil
+		}, extensions...)
+	}
+}
+
+// spectrumWriter is implemented by the format-specific writers
+type spectrumWriter interface {
+	WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error
+	Finalize() error
+	Abort() error
+	Written() int
+}
+
+// adapter binds a format-specific writer to the context it writes under
+type adapter struct {
+	ctx context.Context
+	w   spectrumWriter
+}
+
+// wrap adapts a format-specific writer to Writer
+func wrap(ctx context.Context, w spectrumWriter) *adapter {
+	return &adapter{ctx: ctx, w: w}
+}
+
+// Write writes a spectrum unless the context has been cancelled
+func (a *adapter) Write(spec *core.Spectrum) error {
+	return a.w.WriteSpectrumContext(a.ctx, spec)
+}
+
+// Close finalizes the output
+func (a *adapter) Close() error {
+	return a.w.Finalize()
+}
+
+// Abort discards the unfinalized output
+func (a *adapter) Abort() error {
+	return a.w.Abort()
+}
+
+// Written returns the number of spectra written
+func (a *adapter) Written() int {
+	return a.w.Written()
+}
+
+// Unwrap returns the format-specific writer, e.g. a *sqlite.Writer for
+// access to checkpoints
+func (a *adapter) Unwrap() any {
+	return a.w
+}
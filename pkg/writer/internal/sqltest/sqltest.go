@@ -0,0 +1,32 @@
+// Package sqltest holds the helpers the writer tests use to inspect the
+// SQLite files they write
+package sqltest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open opens a written database, closing it when the test ends
+func Open(t testing.TB, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", sqliteuri.File(path))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// QueryInt runs a query returning a single integer
+func QueryInt(t testing.TB, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+// Package jsonl writes spectra as JSON Lines records of the DBKey
+// intermediate representation
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/jsonl"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+)
+
+// Options configures the JSON Lines writer
+type Options struct {
+	// Overwrite allows replacing an existing file
+	Overwrite bool
+}
+
+// Writer writes one jsonl.Record per line, in the layout read back by
+// jsonl.Reader
+type Writer struct {
+	out     *atomicfile.File
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	closed  bool
+	written int
+}
+
+// NewWriter creates a JSON Lines writer. The records are written to a
+// temporary file next to outputPath, which only replaces outputPath when
+// Finalize succeeds.
+func NewWriter(outputPath string, opts Options) (*Writer, error) {
+	out, err := atomicfile.Create(outputPath, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		out: out,
+		buf: bufio.NewWriter(out),
+	}
+	w.enc = json.NewEncoder(w.buf)
+	w.enc.SetEscapeHTML(false)
+	return w, nil
+}
+
+// WriteSpectrum writes a spectrum as one record line
+func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes a spectrum as one record line. If ctx is
+// cancelled the spectrum is not written and ctx.Err() is returned.
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := w.enc.Encode(jsonl.NewRecord(spec)); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	w.written++
+	return nil
+}
+
+// Written returns the number of spectra written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Finalize flushes and closes the file and moves it to the output path
+func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write %s: %w", w.out.Path(), err)
+	}
+	w.closed = true
+	return w.out.Commit()
+}
+
+// Close finalizes the writer (alias for Finalize)
+func (w *Writer) Close() error {
+	return w.Finalize()
+}
+
+// Abort removes the records written so far without touching an existing
+// file at the output path. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.out.Abort()
+}
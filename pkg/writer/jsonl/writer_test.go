@@ -0,0 +1,157 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/reader/jsonl"
+)
+
+// fullSpectrum returns a spectrum with every field set
+func fullSpectrum() *core.Spectrum {
+	rt, ce := 12.5, 27.0
+	return &core.Spectrum{
+		Sequence:          "PEPTMIDEK",
+		Charge:            -2,
+		PrecursorMZ:       533.251234567,
+		RetentionTime:     &rt,
+		CollisionEnergy:   &ce,
+		Modifications:     []core.Modification{{Mass: 42.010565, Position: -1, Name: "Acetyl"}, {Mass: 15.994915, Position: 4}},
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Instrument:        "Orbitrap <Exploris>",
+		Polarity:          core.PolarityNegative,
+		IonizationMode:    "ESI",
+		MassOffset:        -0.25,
+		CompoundClass:     "Lipid & sugar",
+		Proteins:          []string{"P1", "sp|P2|HUMAN"},
+		Decoy:             true,
+		CompoundName:      "Glucosé",
+		Formula:           "C6H12O6",
+		SMILES:            "OCC1OC(O)C(O)C(O)C1O",
+		InChIKey:          "WQZGKKKJIJFFOK-GASJEMHNSA-N",
+		CASNumber:         "50-99-7",
+		PubChemID:         "5793",
+		PrecursorType:     "[M-H]-",
+		SourceFile:        "runs/run1.msp",
+		SourceFormat:      "msp",
+		Peaks: []core.Peak{
+			{MZ: 175.119, Intensity: 100, Annotation: "y1", Charge: 1},
+			{MZ: 300.1, Intensity: 0.001},
+			{MZ: 400.2, Intensity: 25, Annotation: "b4-H2O^2", Charge: 2},
+		},
+	}
+}
+
+// roundTrip writes spectra and reads them back
+func roundTrip(t *testing.T, specs ...*core.Spectrum) ([]*core.Spectrum, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "library.jsonl")
+	w, err := NewWriter(path, Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if w.Written() != len(specs) {
+		t.Errorf("Written() = %d, want %d", w.Written(), len(specs))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := jsonl.NewReader(strings.NewReader(string(data)))
+	var got []*core.Spectrum
+	for r.Next() {
+		got = append(got, r.Spectrum())
+	}
+	if r.Err() != nil {
+		t.Fatalf("Err() = %v", r.Err())
+	}
+	return got, string(data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	full := fullSpectrum()
+
+	// Every field is set, so a field missing from the record fails the test
+	v := reflect.ValueOf(*full)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Fatalf("fullSpectrum() leaves %s unset", v.Type().Field(i).Name)
+		}
+	}
+
+	zero := 0.0
+	zeroRT := &core.Spectrum{Sequence: "AAAK", Charge: 2, PrecursorMZ: 200, RetentionTime: &zero, SourceFormat: "mgf",
+		Peaks: []core.Peak{{MZ: 100, Intensity: 1}}}
+	noRT := &core.Spectrum{CompoundName: "Caffeine", Charge: 1, PrecursorMZ: 195.0877, SourceFormat: "msp",
+		Peaks: []core.Peak{{MZ: 138.066, Intensity: 100}}}
+
+	specs := []*core.Spectrum{full, zeroRT, noRT}
+	got, data := roundTrip(t, specs...)
+	if len(got) != len(specs) {
+		t.Fatalf("read %d spectra, want %d", len(got), len(specs))
+	}
+	for i := range specs {
+		if !reflect.DeepEqual(got[i], specs[i]) {
+			t.Errorf("spectrum %d = %+v, want %+v", i, got[i], specs[i])
+		}
+	}
+
+	// A zero RT is written, a missing one is left out
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if !strings.Contains(lines[1], `"retention_time":0`) {
+		t.Errorf("zero RT record = %s, want retention_time 0", lines[1])
+	}
+	if strings.Contains(lines[2], "retention_time") || strings.Contains(lines[2], "collision_energy") {
+		t.Errorf("record without RT and CE = %s", lines[2])
+	}
+	if !strings.Contains(lines[0], "Orbitrap <Exploris>") || !strings.Contains(lines[0], `"version":1`) {
+		t.Errorf("record = %s, want unescaped HTML characters and version 1", lines[0])
+	}
+}
+
+func TestRoundTripSourceFormat(t *testing.T) {
+	// Spectra without a source format are read back as jsonl
+	got, _ := roundTrip(t, &core.Spectrum{Sequence: "AAAK", Charge: 2, PrecursorMZ: 200})
+	if len(got) != 1 || got[0].SourceFormat != "jsonl" {
+		t.Fatalf("read %+v, want one spectrum from jsonl", got)
+	}
+	if got[0].Peaks == nil || len(got[0].Peaks) != 0 {
+		t.Errorf("Peaks = %#v, want empty", got[0].Peaks)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.jsonl")
+	w, err := NewWriter(path, Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(fullSpectrum()); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files left after Abort: %v", entries)
+	}
+}
@@ -0,0 +1,301 @@
+// Package parquet writes spectral libraries as Parquet tables for analysis
+// with tools such as DuckDB, pandas and Arrow
+package parquet
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// DefaultRowGroupRows is the number of rows per row group when
+// Options.RowGroupRows is not set
+const DefaultRowGroupRows = 100000
+
+// Table file suffixes appended to the output prefix
+const (
+	PrecursorSuffix = ".precursors.parquet"
+	PeakSuffix      = ".peaks.parquet"
+)
+
+// Options configures the Parquet writer
+type Options struct {
+	// RowGroupRows is the number of rows buffered before a row group is
+	// written, bounding memory use (0 = DefaultRowGroupRows). It applies to
+	// each table separately.
+	RowGroupRows int
+	// Overwrite allows replacing existing files
+	Overwrite bool
+}
+
+// PrecursorRow is a row of the precursor table, one per spectrum
+type PrecursorRow struct {
+	SpectrumID        int64    `parquet:"name=spectrum_id, type=INT64"`
+	Name              string   `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sequence          string   `parquet:"name=sequence, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ModifiedSequence  string   `parquet:"name=modified_sequence, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Modifications     string   `parquet:"name=modifications, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Charge            int32    `parquet:"name=charge, type=INT32"`
+	PrecursorMZ       float64  `parquet:"name=precursor_mz, type=DOUBLE"`
+	NeutralMass       *float64 `parquet:"name=neutral_mass, type=DOUBLE, repetitiontype=OPTIONAL"`
+	RetentionTime     *float64 `parquet:"name=retention_time, type=DOUBLE, repetitiontype=OPTIONAL"`
+	CollisionEnergy   *float64 `parquet:"name=collision_energy, type=DOUBLE, repetitiontype=OPTIONAL"`
+	FragmentationMode string   `parquet:"name=fragmentation_mode, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MassAnalyzer      string   `parquet:"name=mass_analyzer, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Instrument        string   `parquet:"name=instrument, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Polarity          string   `parquet:"name=polarity, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	IonizationMode    string   `parquet:"name=ionization_mode, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MassOffset        float64  `parquet:"name=mass_offset, type=DOUBLE"`
+	CompoundClass     string   `parquet:"name=compound_class, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Proteins          string   `parquet:"name=proteins, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Decoy             bool     `parquet:"name=decoy, type=BOOLEAN"`
+	CompoundName      string   `parquet:"name=compound_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Formula           string   `parquet:"name=formula, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SMILES            string   `parquet:"name=smiles, type=BYTE_ARRAY, convertedtype=UTF8"`
+	InChIKey          string   `parquet:"name=inchikey, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CASNumber         string   `parquet:"name=cas, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PubChemID         string   `parquet:"name=pubchem_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PrecursorType     string   `parquet:"name=precursor_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	NumPeaks          int32    `parquet:"name=num_peaks, type=INT32"`
+	SourceFile        string   `parquet:"name=source_file, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SourceFormat      string   `parquet:"name=source_format, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// PeakRow is a row of the peak table, one per peak, joined to the
+// precursor table on spectrum_id
+type PeakRow struct {
+	SpectrumID int64   `parquet:"name=spectrum_id, type=INT64"`
+	PeakIndex  int32   `parquet:"name=peak_index, type=INT32"`
+	MZ         float64 `parquet:"name=mz, type=DOUBLE"`
+	Intensity  float64 `parquet:"name=intensity, type=DOUBLE"`
+	Annotation string  `parquet:"name=annotation, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Charge     int32   `parquet:"name=charge, type=INT32"`
+}
+
+// Writer writes spectra as two Parquet files sharing an output prefix: a
+// precursor table with the spectrum metadata and an exploded peak table
+type Writer struct {
+	prefix string
+	closed bool
+	opts   Options
+
+	precursors *table
+	peaks      *table
+	written    int
+}
+
+// table is a Parquet file being written to a temporary path
+type table struct {
+	out   *atomicfile.File
+	buf   *bufio.Writer
+	pw    *writer.ParquetWriter
+	rows  int // Rows in the open row group
+	limit int
+}
+
+// NewWriter creates a Parquet writer for prefix+PrecursorSuffix and
+// prefix+PeakSuffix. A trailing ".parquet" is removed from the prefix. Both
+// files are written to temporary files, which only replace the outputs when
+// Finalize succeeds.
+func NewWriter(prefix string, opts Options) (*Writer, error) {
+	prefix = strings.TrimSuffix(prefix, ".parquet")
+	if opts.RowGroupRows <= 0 {
+		opts.RowGroupRows = DefaultRowGroupRows
+	}
+
+	w := &Writer{prefix: prefix, opts: opts}
+	var err error
+	if w.precursors, err = newTable(prefix+PrecursorSuffix, new(PrecursorRow), opts); err != nil {
+		return nil, err
+	}
+	if w.peaks, err = newTable(prefix+PeakSuffix, new(PeakRow), opts); err != nil {
+		w.precursors.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// newTable creates a temporary file next to outputPath with the schema of
+// the row type
+func newTable(outputPath string, row any, opts Options) (*table, error) {
+	out, err := atomicfile.Create(outputPath, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &table{
+		out:   out,
+		buf:   bufio.NewWriter(out),
+		limit: opts.RowGroupRows,
+	}
+	t.pw, err = writer.NewParquetWriterFromWriter(t.buf, row, 1)
+	if err != nil {
+		t.abort()
+		return nil, fmt.Errorf("failed to create Parquet schema: %w", err)
+	}
+	t.pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return t, nil
+}
+
+// write adds a row, writing the row group once it is full
+func (t *table) write(row any) error {
+	if err := t.pw.Write(row); err != nil {
+		return fmt.Errorf("failed to write row: %w", err)
+	}
+	t.rows++
+	if t.rows >= t.limit {
+		if err := t.pw.Flush(true); err != nil {
+			return fmt.Errorf("failed to write row group: %w", err)
+		}
+		t.rows = 0
+	}
+	return nil
+}
+
+// finish writes the last row group and the footer
+func (t *table) finish() error {
+	if err := t.pw.WriteStop(); err != nil {
+		return fmt.Errorf("failed to write Parquet footer: %w", err)
+	}
+	if err := t.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", t.out.Path(), err)
+	}
+	return nil
+}
+
+// abort removes the temporary file
+func (t *table) abort() error {
+	return t.out.Abort()
+}
+
+// WriteSpectrum writes a spectrum to the precursor and peak tables
+func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes a spectrum to the precursor and peak tables.
+// If ctx is cancelled the spectrum is not written and ctx.Err() is returned.
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	id := int64(w.written + 1)
+	if err := w.precursors.write(precursorRow(id, spec)); err != nil {
+		return err
+	}
+	for i, peak := range spec.Peaks {
+		if err := w.peaks.write(&PeakRow{
+			SpectrumID: id,
+			PeakIndex:  int32(i),
+			MZ:         peak.MZ,
+			Intensity:  peak.Intensity,
+			Annotation: peak.Annotation,
+			Charge:     int32(peak.Charge),
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.written++
+	return nil
+}
+
+// precursorRow returns the precursor table row of a spectrum
+func precursorRow(id int64, spec *core.Spectrum) *PrecursorRow {
+	row := &PrecursorRow{
+		SpectrumID:        id,
+		Name:              spec.Name(),
+		Sequence:          spec.Sequence,
+		Modifications:     spec.ModString(),
+		Charge:            int32(spec.Charge),
+		PrecursorMZ:       spec.PrecursorMZ,
+		RetentionTime:     spec.RetentionTime,
+		CollisionEnergy:   spec.CollisionEnergy,
+		FragmentationMode: spec.FragmentationMode,
+		MassAnalyzer:      spec.MassAnalyzer,
+		Instrument:        spec.Instrument,
+		Polarity:          spec.IonPolarity(),
+		IonizationMode:    spec.IonizationMode,
+		MassOffset:        spec.MassOffset,
+		CompoundClass:     spec.CompoundClass,
+		Proteins:          strings.Join(spec.Proteins, ";"),
+		Decoy:             spec.Decoy,
+		CompoundName:      spec.CompoundName,
+		Formula:           spec.Formula,
+		SMILES:            spec.SMILES,
+		InChIKey:          spec.InChIKey,
+		CASNumber:         spec.CASNumber,
+		PubChemID:         spec.PubChemID,
+		PrecursorType:     spec.PrecursorIonType(),
+		NumPeaks:          int32(len(spec.Peaks)),
+		SourceFile:        spec.SourceFile,
+		SourceFormat:      spec.SourceFormat,
+	}
+	if !spec.IsSmallMolecule() {
+		row.ModifiedSequence = spec.ModifiedSequence()
+	}
+	if mass, ok := spec.NeutralMass(); ok {
+		row.NeutralMass = &mass
+	}
+	return row
+}
+
+// Written returns the number of spectra written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Paths returns the precursor and peak table files
+func (w *Writer) Paths() (precursors, peaks string) {
+	return w.precursors.out.Path(), w.peaks.out.Path()
+}
+
+// Finalize writes the last row groups and footers and moves both files to
+// their output paths
+func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	for _, t := range []*table{w.precursors, w.peaks} {
+		if err := t.finish(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	w.closed = true
+
+	if err := w.precursors.out.Commit(); err != nil {
+		w.peaks.abort()
+		return err
+	}
+	return w.peaks.out.Commit()
+}
+
+// Close finalizes the writer (alias for Finalize)
+func (w *Writer) Close() error {
+	return w.Finalize()
+}
+
+// Abort removes both unfinished tables; existing tables at the output paths
+// are kept. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.precursors.abort()
+	if peakErr := w.peaks.abort(); err == nil {
+		err = peakErr
+	}
+	return err
+}
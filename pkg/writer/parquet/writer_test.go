@@ -0,0 +1,181 @@
+package parquet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// readTable reads all rows of a Parquet file into rows, a pointer to a
+// slice of the row type
+func readTable(t *testing.T, path string, row any, rows any) {
+	t.Helper()
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatalf("NewLocalFileReader() error = %v", err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, row, 1)
+	if err != nil {
+		t.Fatalf("NewParquetReader(%s) error = %v", path, err)
+	}
+	defer pr.ReadStop()
+
+	v := reflect.ValueOf(rows).Elem()
+	v.Set(reflect.MakeSlice(v.Type(), int(pr.GetNumRows()), int(pr.GetNumRows())))
+	if err := pr.Read(rows); err != nil {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+}
+
+// testSpectra are a modified peptide and a small molecule without RT
+func testSpectra() []*core.Spectrum {
+	rt, ce := 12.5, 27.0
+	return []*core.Spectrum{
+		{
+			Sequence:          "PEPTMIDEK",
+			Charge:            2,
+			PrecursorMZ:       533.25,
+			RetentionTime:     &rt,
+			CollisionEnergy:   &ce,
+			Modifications:     []core.Modification{{Mass: 15.994915, Position: 4, Name: "Oxidation"}},
+			FragmentationMode: "HCD",
+			MassAnalyzer:      "FT",
+			Instrument:        "Orbitrap",
+			Proteins:          []string{"P1", "P2"},
+			Decoy:             true,
+			SourceFile:        "run1.msp",
+			SourceFormat:      "msp",
+			Peaks: []core.Peak{
+				{MZ: 175.119, Intensity: 100, Annotation: "y1", Charge: 1},
+				{MZ: 300.1, Intensity: 50},
+				{MZ: 400.2, Intensity: 25, Annotation: "b4^2", Charge: 2},
+			},
+		},
+		{
+			CompoundName:   "Glucose",
+			Formula:        "C6H12O6",
+			SMILES:         "OCC1OC(O)C(O)C(O)C1O",
+			InChIKey:       "WQZGKKKJIJFFOK-GASJEMHNSA-N",
+			CASNumber:      "50-99-7",
+			PubChemID:      "5793",
+			PrecursorType:  "[M+Na]+",
+			Charge:         1,
+			PrecursorMZ:    203.0526,
+			IonizationMode: "ESI",
+			MassOffset:     0.5,
+			CompoundClass:  "Sugar",
+			Peaks:          []core.Peak{{MZ: 185.04, Intensity: 10}},
+		},
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "library.parquet")
+	// Row groups of two rows split the peak table
+	w, err := NewWriter(prefix, Options{RowGroupRows: 2})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	specs := testSpectra()
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if w.Written() != 2 {
+		t.Errorf("Written() = %d, want 2", w.Written())
+	}
+
+	precursorPath, peakPath := w.Paths()
+	base := filepath.Join(filepath.Dir(prefix), "library")
+	if precursorPath != base+PrecursorSuffix || peakPath != base+PeakSuffix {
+		t.Errorf("Paths() = %s, %s, want %s and %s", precursorPath, peakPath, base+PrecursorSuffix, base+PeakSuffix)
+	}
+
+	var precursors []PrecursorRow
+	readTable(t, precursorPath, new(PrecursorRow), &precursors)
+	if len(precursors) != len(specs) {
+		t.Fatalf("precursor rows = %d, want %d", len(precursors), len(specs))
+	}
+	for i, spec := range specs {
+		if want := precursorRow(int64(i+1), spec); !reflect.DeepEqual(precursors[i], *want) {
+			t.Errorf("precursor row %d = %+v, want %+v", i, precursors[i], *want)
+		}
+	}
+
+	peptide := precursors[0]
+	if peptide.ModifiedSequence != "PEPTM[+15.9949]IDEK" || peptide.Modifications != "15.994915@4" {
+		t.Errorf("peptide modifications = %s %s, want PEPTM[+15.9949]IDEK 15.994915@4", peptide.ModifiedSequence, peptide.Modifications)
+	}
+	if peptide.Name != "PEPTMIDEK/2" || peptide.Proteins != "P1;P2" || !peptide.Decoy || peptide.NumPeaks != 3 {
+		t.Errorf("peptide row = %s %s decoy %v %d peaks, want PEPTMIDEK/2 P1;P2 decoy true 3 peaks",
+			peptide.Name, peptide.Proteins, peptide.Decoy, peptide.NumPeaks)
+	}
+	if peptide.RetentionTime == nil || *peptide.RetentionTime != 12.5 || peptide.CollisionEnergy == nil || *peptide.CollisionEnergy != 27 {
+		t.Errorf("peptide RT, CE = %v, %v, want 12.5, 27", peptide.RetentionTime, peptide.CollisionEnergy)
+	}
+	if peptide.NeutralMass == nil {
+		t.Error("peptide neutral_mass is null")
+	}
+	molecule := precursors[1]
+	if molecule.Name != "Glucose" || molecule.Sequence != "" || molecule.ModifiedSequence != "" || molecule.PrecursorType != "[M+Na]+" {
+		t.Errorf("molecule row = %q %q %q %q, want Glucose with no sequence and [M+Na]+",
+			molecule.Name, molecule.Sequence, molecule.ModifiedSequence, molecule.PrecursorType)
+	}
+	if molecule.RetentionTime != nil || molecule.CollisionEnergy != nil {
+		t.Errorf("molecule RT, CE = %v, %v, want null", molecule.RetentionTime, molecule.CollisionEnergy)
+	}
+
+	var peaks []PeakRow
+	readTable(t, peakPath, new(PeakRow), &peaks)
+	want := []PeakRow{
+		{SpectrumID: 1, PeakIndex: 0, MZ: 175.119, Intensity: 100, Annotation: "y1", Charge: 1},
+		{SpectrumID: 1, PeakIndex: 1, MZ: 300.1, Intensity: 50},
+		{SpectrumID: 1, PeakIndex: 2, MZ: 400.2, Intensity: 25, Annotation: "b4^2", Charge: 2},
+		{SpectrumID: 2, PeakIndex: 0, MZ: 185.04, Intensity: 10},
+	}
+	if !reflect.DeepEqual(peaks, want) {
+		t.Errorf("peak rows = %+v, want %+v", peaks, want)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "library")
+	if err := os.WriteFile(prefix+PeakSuffix, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWriter(prefix, Options{}); err == nil {
+		t.Fatal("NewWriter() replaced an existing peak table without Overwrite")
+	}
+
+	w, err := NewWriter(prefix, Options{Overwrite: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testSpectra()[0]); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "library"+PeakSuffix {
+		t.Errorf("files after Abort = %v, want the existing peak table only", entries)
+	}
+	if err := w.WriteSpectrum(testSpectra()[0]); err == nil {
+		t.Error("WriteSpectrum() after Abort succeeded")
+	}
+}
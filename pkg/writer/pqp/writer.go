@@ -0,0 +1,381 @@
+// Package pqp writes spectral libraries as OpenSWATH PQP assay libraries
+package pqp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Version is the PQP schema version written to the VERSION table
+const Version = 3
+
+// intensityScale is the library intensity of the most intense transition of
+// a precursor, as in OpenSWATH TSV assay libraries
+const intensityScale = 100
+
+// Options selects the transitions written for each precursor
+type Options struct {
+	transition.Selection
+	// BatchSize is the number of precursors committed per transaction
+	// (0 = sqlite.DefaultBatchSize)
+	BatchSize int
+	// Overwrite allows replacing an existing file
+	Overwrite bool
+}
+
+// Writer writes spectra as an OpenSWATH PQP file. Peptides are shared by
+// their precursors and proteins by their peptides; only annotated fragments
+// are written as transitions and precursors without a selected transition
+// are skipped.
+type Writer struct {
+	db     *sql.DB
+	out    *atomicfile.File // Library being written, committed by Finalize
+	closed bool
+	opts   Options
+	modDB  *core.ModDatabase
+	batch  *sqlite.Batch
+	stmts  statements
+
+	peptides    map[peptideKey]int
+	proteins    map[proteinKey]int
+	written     int // Precursors written
+	transitions int
+	skipped     int
+}
+
+// statements are the prepared insert statements
+type statements struct {
+	protein, peptide, precursor, transition *sql.Stmt
+	peptideProtein, precursorPeptide        *sql.Stmt
+	transitionPrecursor, transitionPeptide  *sql.Stmt
+}
+
+// peptideKey identifies a PEPTIDE row
+type peptideKey struct {
+	modified string
+	decoy    bool
+}
+
+// proteinKey identifies a PROTEIN row
+type proteinKey struct {
+	accession string
+	decoy     bool
+}
+
+// schema creates the PQP tables read by OpenSWATH and PyProphet
+const schema = `
+	CREATE TABLE VERSION (ID INT NOT NULL);
+	CREATE TABLE PROTEIN (
+		ID INT PRIMARY KEY NOT NULL,
+		PROTEIN_ACCESSION TEXT NOT NULL,
+		DECOY INT NOT NULL
+	);
+	CREATE TABLE PEPTIDE_PROTEIN_MAPPING (
+		PEPTIDE_ID INT NOT NULL,
+		PROTEIN_ID INT NOT NULL
+	);
+	CREATE TABLE PEPTIDE (
+		ID INT PRIMARY KEY NOT NULL,
+		UNMODIFIED_SEQUENCE TEXT NOT NULL,
+		MODIFIED_SEQUENCE TEXT NOT NULL,
+		DECOY INT NOT NULL
+	);
+	CREATE TABLE PRECURSOR_PEPTIDE_MAPPING (
+		PRECURSOR_ID INT NOT NULL,
+		PEPTIDE_ID INT NOT NULL
+	);
+	CREATE TABLE PRECURSOR (
+		ID INT PRIMARY KEY NOT NULL,
+		TRAML_ID TEXT NULL,
+		GROUP_LABEL TEXT NULL,
+		PRECURSOR_MZ REAL NOT NULL,
+		CHARGE INT NULL,
+		LIBRARY_INTENSITY REAL NULL,
+		LIBRARY_RT REAL NULL,
+		LIBRARY_DRIFT_TIME REAL NULL,
+		DECOY INT NOT NULL
+	);
+	CREATE TABLE TRANSITION_PRECURSOR_MAPPING (
+		TRANSITION_ID INT NOT NULL,
+		PRECURSOR_ID INT NOT NULL
+	);
+	CREATE TABLE TRANSITION_PEPTIDE_MAPPING (
+		TRANSITION_ID INT NOT NULL,
+		PEPTIDE_ID INT NOT NULL
+	);
+	CREATE TABLE TRANSITION (
+		ID INT PRIMARY KEY NOT NULL,
+		TRAML_ID TEXT NULL,
+		PRODUCT_MZ REAL NOT NULL,
+		CHARGE INT NULL,
+		TYPE CHAR(255) NULL,
+		ANNOTATION TEXT NULL,
+		ORDINAL INT NULL,
+		DETECTING INT NOT NULL,
+		IDENTIFYING INT NOT NULL,
+		QUANTIFYING INT NOT NULL,
+		LIBRARY_INTENSITY REAL NULL,
+		DECOY INT NOT NULL
+	);
+`
+
+// NewWriter creates a PQP writer. The library is written to a temporary
+// file next to outputPath, which only replaces outputPath when Finalize
+// succeeds.
+func NewWriter(outputPath string, opts Options, modDB *core.ModDatabase) (*Writer, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+	out, err := atomicfile.Create(outputPath, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+
+	db, err := sql.Open("sqlite3", sqliteuri.File(out.TempPath()))
+	if err != nil {
+		out.Abort()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	w := &Writer{
+		db:       db,
+		out:      out,
+		opts:     opts,
+		modDB:    modDB,
+		batch:    sqlite.NewBatch(db, opts.BatchSize),
+		peptides: make(map[peptideKey]int),
+		proteins: make(map[proteinKey]int),
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+	if _, err := db.Exec(`INSERT INTO VERSION (ID) VALUES (?)`, Version); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to insert version: %w", err)
+	}
+	if err := w.prepareStatements(); err != nil {
+		w.Abort()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// prepareStatements prepares the insert statement of each table
+func (w *Writer) prepareStatements() error {
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.stmts.protein, `INSERT INTO PROTEIN (ID, PROTEIN_ACCESSION, DECOY) VALUES (?, ?, ?)`},
+		{&w.stmts.peptide, `INSERT INTO PEPTIDE (ID, UNMODIFIED_SEQUENCE, MODIFIED_SEQUENCE, DECOY) VALUES (?, ?, ?, ?)`},
+		{&w.stmts.precursor, `
+			INSERT INTO PRECURSOR (ID, TRAML_ID, PRECURSOR_MZ, CHARGE, LIBRARY_RT, DECOY)
+			VALUES (?, ?, ?, ?, ?, ?)`},
+		{&w.stmts.transition, `
+			INSERT INTO TRANSITION (
+				ID, TRAML_ID, PRODUCT_MZ, CHARGE, TYPE, ANNOTATION, ORDINAL,
+				DETECTING, IDENTIFYING, QUANTIFYING, LIBRARY_INTENSITY, DECOY
+			) VALUES (?, ?, ?, ?, ?, ?, ?, 1, 0, 1, ?, ?)`},
+		{&w.stmts.peptideProtein, `INSERT INTO PEPTIDE_PROTEIN_MAPPING (PEPTIDE_ID, PROTEIN_ID) VALUES (?, ?)`},
+		{&w.stmts.precursorPeptide, `INSERT INTO PRECURSOR_PEPTIDE_MAPPING (PRECURSOR_ID, PEPTIDE_ID) VALUES (?, ?)`},
+		{&w.stmts.transitionPrecursor, `INSERT INTO TRANSITION_PRECURSOR_MAPPING (TRANSITION_ID, PRECURSOR_ID) VALUES (?, ?)`},
+		{&w.stmts.transitionPeptide, `INSERT INTO TRANSITION_PEPTIDE_MAPPING (TRANSITION_ID, PEPTIDE_ID) VALUES (?, ?)`},
+	}
+	for _, q := range queries {
+		stmt, err := w.db.Prepare(q.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*q.stmt = stmt
+	}
+	return nil
+}
+
+// WriteSpectrum writes a spectrum as a precursor and its transitions
+func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes a spectrum as a precursor and its transitions.
+// Precursors are committed in batches of Options.BatchSize; if ctx is
+// cancelled the spectrum is not written and ctx.Err() is returned.
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	fragments := w.opts.Select(spec, true)
+	if len(fragments) == 0 {
+		w.skipped++
+		return nil
+	}
+
+	if err := w.batch.Begin(); err != nil {
+		return err
+	}
+
+	peptideID, err := w.peptide(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	precursorID := w.written
+	groupID := fmt.Sprintf("%d_%s_%d", precursorID, spec.Sequence, spec.Charge)
+	var rt any
+	if spec.RetentionTime != nil {
+		rt = *spec.RetentionTime
+	}
+	if _, err := w.batch.Stmt(w.stmts.precursor).ExecContext(ctx,
+		precursorID, groupID, spec.PrecursorMZ, spec.Charge, rt, spec.Decoy); err != nil {
+		return fmt.Errorf("failed to insert precursor: %w", err)
+	}
+	if _, err := w.batch.Stmt(w.stmts.precursorPeptide).ExecContext(ctx, precursorID, peptideID); err != nil {
+		return fmt.Errorf("failed to insert precursor mapping: %w", err)
+	}
+
+	for i, f := range fragments {
+		id := w.transitions + i
+		if _, err := w.batch.Stmt(w.stmts.transition).ExecContext(ctx,
+			id,
+			fmt.Sprintf("%s_%d", groupID, i),
+			f.Peak.MZ,
+			f.Ion.Charge,
+			f.Ion.Type,
+			f.Ion.String(),
+			f.Ion.Number,
+			core.RoundFloat(f.Intensity*intensityScale, 4),
+			spec.Decoy,
+		); err != nil {
+			return fmt.Errorf("failed to insert transition: %w", err)
+		}
+		if _, err := w.batch.Stmt(w.stmts.transitionPrecursor).ExecContext(ctx, id, precursorID); err != nil {
+			return fmt.Errorf("failed to insert transition mapping: %w", err)
+		}
+		if _, err := w.batch.Stmt(w.stmts.transitionPeptide).ExecContext(ctx, id, peptideID); err != nil {
+			return fmt.Errorf("failed to insert transition mapping: %w", err)
+		}
+	}
+
+	w.written++
+	w.transitions += len(fragments)
+	w.batch.Add()
+	return nil
+}
+
+// peptide returns the id of the PEPTIDE row of a spectrum, inserting it and
+// its proteins on first use
+func (w *Writer) peptide(ctx context.Context, spec *core.Spectrum) (int, error) {
+	modified := transition.UniModSequence(w.modDB, spec)
+	key := peptideKey{modified, spec.Decoy}
+	if id, ok := w.peptides[key]; ok {
+		return id, nil
+	}
+
+	id := len(w.peptides)
+	if _, err := w.batch.Stmt(w.stmts.peptide).ExecContext(ctx, id, spec.Sequence, modified, spec.Decoy); err != nil {
+		return 0, fmt.Errorf("failed to insert peptide: %w", err)
+	}
+	w.peptides[key] = id
+
+	for _, accession := range spec.Proteins {
+		pkey := proteinKey{accession, spec.Decoy}
+		proteinID, ok := w.proteins[pkey]
+		if !ok {
+			proteinID = len(w.proteins)
+			if _, err := w.batch.Stmt(w.stmts.protein).ExecContext(ctx, proteinID, accession, spec.Decoy); err != nil {
+				return 0, fmt.Errorf("failed to insert protein: %w", err)
+			}
+			w.proteins[pkey] = proteinID
+		}
+		if _, err := w.batch.Stmt(w.stmts.peptideProtein).ExecContext(ctx, id, proteinID); err != nil {
+			return 0, fmt.Errorf("failed to insert protein mapping: %w", err)
+		}
+	}
+	return id, nil
+}
+
+// Written returns the number of precursors written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Transitions returns the number of transitions written
+func (w *Writer) Transitions() int {
+	return w.transitions
+}
+
+// Skipped returns the number of spectra without any selected transition
+func (w *Writer) Skipped() int {
+	return w.skipped
+}
+
+// Finalize commits the last batch, closes the database and moves it to the
+// output path
+func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := w.batch.Commit(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	if err := w.closeDB(); err != nil {
+		w.out.Abort()
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return w.out.Commit()
+}
+
+// Close finalizes the writer (alias for Finalize)
+func (w *Writer) Close() error {
+	return w.Finalize()
+}
+
+// Abort rolls back the open batch and deletes the unfinished PQP, keeping
+// any existing file at the output path. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	err := w.batch.Rollback()
+	if dbErr := w.closeDB(); err == nil {
+		err = dbErr
+	}
+	if rmErr := w.out.Abort(); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// closeDB closes prepared statements and the database connection
+func (w *Writer) closeDB() error {
+	w.closed = true
+
+	for _, stmt := range []*sql.Stmt{
+		w.stmts.protein, w.stmts.peptide, w.stmts.precursor, w.stmts.transition,
+		w.stmts.peptideProtein, w.stmts.precursorPeptide,
+		w.stmts.transitionPrecursor, w.stmts.transitionPeptide,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+
+	return w.db.Close()
+}
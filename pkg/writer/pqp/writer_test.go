@@ -0,0 +1,229 @@
+package pqp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+)
+
+// testPeptide returns an annotated peptide spectrum
+func testPeptide(seq string, charge int, decoy bool, proteins ...string) *core.Spectrum {
+	rt := 30.5
+	return &core.Spectrum{
+		Sequence:      seq,
+		Charge:        charge,
+		PrecursorMZ:   500.25,
+		RetentionTime: &rt,
+		Proteins:      proteins,
+		Decoy:         decoy,
+		Peaks: []core.Peak{
+			{MZ: 175.119, Intensity: 40, Annotation: "y1"},
+			{MZ: 300.1, Intensity: 50, Annotation: "b3"},
+			{MZ: 400.2, Intensity: 100, Annotation: "y3"},
+			{MZ: 450.2, Intensity: 80},
+			{MZ: 600.3, Intensity: 25, Annotation: "y5^2"},
+		},
+	}
+}
+
+// writeLibrary writes spectra to path and finalizes the writer
+func writeLibrary(t *testing.T, path string, opts Options, specs ...*core.Spectrum) *Writer {
+	t.Helper()
+	w, err := NewWriter(path, opts, nil)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	return w
+}
+
+func TestWriterTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.pqp")
+	oxidized := testPeptide("PEPTMIDEK", 2, false, "P1")
+	oxidized.Modifications = []core.Modification{{Mass: 15.994915, Position: 4}}
+	w := writeLibrary(t, path, Options{Selection: transition.DefaultSelection, BatchSize: 2},
+		testPeptide("PEPTIDEK", 2, false, "P1", "P2"),
+		testPeptide("PEPTIDEK", 3, false, "P1", "P2"),
+		oxidized,
+		testPeptide("KEDITPEP", 2, true, "P1"),
+		&core.Spectrum{Sequence: "AAAK", Charge: 2, PrecursorMZ: 300, Peaks: []core.Peak{{MZ: 200, Intensity: 100}}},
+	)
+
+	if w.Written() != 4 || w.Transitions() != 12 || w.Skipped() != 1 {
+		t.Errorf("Written, Transitions, Skipped = %d, %d, %d, want 4, 12, 1", w.Written(), w.Transitions(), w.Skipped())
+	}
+
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT ID FROM VERSION`); got != Version {
+		t.Errorf("VERSION = %d, want %d", got, Version)
+	}
+
+	counts := []struct {
+		table string
+		want  int
+	}{
+		// P1 and P2, and the decoy P1
+		{"PROTEIN", 3},
+		{"PEPTIDE", 3},
+		{"PEPTIDE_PROTEIN_MAPPING", 4},
+		{"PRECURSOR", 4},
+		{"PRECURSOR_PEPTIDE_MAPPING", 4},
+		{"TRANSITION", 12},
+		{"TRANSITION_PRECURSOR_MAPPING", 12},
+		{"TRANSITION_PEPTIDE_MAPPING", 12},
+	}
+	for _, c := range counts {
+		if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM `+c.table); got != c.want {
+			t.Errorf("%s rows = %d, want %d", c.table, got, c.want)
+		}
+	}
+
+	// Both charge states share the unmodified peptide
+	if got := sqltest.QueryInt(t, db, `
+		SELECT COUNT(DISTINCT PEPTIDE_ID) FROM PRECURSOR_PEPTIDE_MAPPING
+		JOIN PRECURSOR ON PRECURSOR.ID = PRECURSOR_ID
+		WHERE TRAML_ID LIKE '%_PEPTIDEK_%'`); got != 1 {
+		t.Errorf("PEPTIDEK precursors map to %d peptides, want 1", got)
+	}
+	var modified string
+	if err := db.QueryRow(`SELECT MODIFIED_SEQUENCE FROM PEPTIDE WHERE ID = 1`).Scan(&modified); err != nil {
+		t.Fatal(err)
+	}
+	if modified != "PEPTM(UniMod:35)IDEK" {
+		t.Errorf("MODIFIED_SEQUENCE = %q, want PEPTM(UniMod:35)IDEK", modified)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM PROTEIN WHERE PROTEIN_ACCESSION = 'P1' AND DECOY = 1`); got != 1 {
+		t.Errorf("decoy P1 proteins = %d, want 1", got)
+	}
+
+	var (
+		tramlID string
+		mz, rt  float64
+		charge  int
+		decoy   bool
+	)
+	if err := db.QueryRow(`SELECT TRAML_ID, PRECURSOR_MZ, CHARGE, LIBRARY_RT, DECOY FROM PRECURSOR WHERE ID = 3`).
+		Scan(&tramlID, &mz, &charge, &rt, &decoy); err != nil {
+		t.Fatal(err)
+	}
+	if tramlID != "3_KEDITPEP_2" || mz != 500.25 || charge != 2 || rt != 30.5 || !decoy {
+		t.Errorf("decoy precursor = %s %v %d %v %v, want 3_KEDITPEP_2 500.25 2 30.5 true", tramlID, mz, charge, rt, decoy)
+	}
+
+	// y1 is below the minimum ion number, the unannotated peak is left out
+	// and transitions are listed most intense first
+	rows, err := db.Query(`
+		SELECT TRAML_ID, PRODUCT_MZ, CHARGE, TYPE, ANNOTATION, ORDINAL, LIBRARY_INTENSITY
+		FROM TRANSITION JOIN TRANSITION_PRECURSOR_MAPPING ON TRANSITION_ID = ID
+		WHERE PRECURSOR_ID = 0 ORDER BY ID`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var (
+			id, ionType, annotation string
+			productMZ, intensity    float64
+			ionCharge, ordinal      int
+		)
+		if err := rows.Scan(&id, &productMZ, &ionCharge, &ionType, &annotation, &ordinal, &intensity); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %g %d %d %g", id, annotation, ionType, productMZ, ionCharge, ordinal, intensity))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"0_PEPTIDEK_2_0 y3 y 400.2 1 3 100",
+		"0_PEPTIDEK_2_1 b3 b 300.1 1 3 50",
+		"0_PEPTIDEK_2_2 y5^2 y 600.3 2 5 25",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("transitions =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWriterZeroSelection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.pqp")
+	w := writeLibrary(t, path, Options{}, testPeptide("PEPTIDEK", 2, false))
+
+	// Every annotated peak is a transition, including y1
+	if w.Transitions() != 4 {
+		t.Errorf("Transitions() = %d, want 4", w.Transitions())
+	}
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT ORDINAL FROM TRANSITION WHERE ANNOTATION = 'y1'`); got != 1 {
+		t.Errorf("y1 ordinal = %d, want 1", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM PROTEIN`); got != 0 {
+		t.Errorf("proteins = %d, want 0", got)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.pqp")
+	w, err := NewWriter(path, Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2, false)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files left after Abort: %v", entries)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2, false)); err == nil {
+		t.Error("WriteSpectrum() after Abort succeeded")
+	}
+}
+
+func TestWriterOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.pqp")
+	if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWriter(path, Options{}, nil); err == nil {
+		t.Fatal("NewWriter() replaced an existing file without Overwrite")
+	}
+
+	w := writeLibrary(t, path, Options{Overwrite: true}, testPeptide("PEPTIDEK", 2, false))
+	if w.Written() != 1 {
+		t.Errorf("Written() = %d, want 1", w.Written())
+	}
+	if got := sqltest.QueryInt(t, sqltest.Open(t, path), `SELECT COUNT(*) FROM PRECURSOR`); got != 1 {
+		t.Errorf("precursors = %d, want 1", got)
+	}
+}
+
+func TestWriterRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	writeLibrary(t, "library.pqp", Options{}, testPeptide("PEPTIDEK", 2, false))
+	if got := sqltest.QueryInt(t, sqltest.Open(t, filepath.Join(dir, "library.pqp")), `SELECT COUNT(*) FROM PRECURSOR`); got != 1 {
+		t.Errorf("precursors = %d, want 1", got)
+	}
+}
@@ -0,0 +1,99 @@
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+)
+
+// ManifestVersion is the version of the manifest layout
+const ManifestVersion = 1
+
+// Manifest lists the shards of a library and the spectra in each
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Library is the output file the shards were named after
+	Library string  `json:"library"`
+	Options Options `json:"sharding"`
+	Spectra int     `json:"spectra"`
+	Shards  []Shard `json:"shards"`
+}
+
+// Shard describes one database of a sharded library
+type Shard struct {
+	// Path is the database file, relative to the manifest
+	Path string `json:"path"`
+	// Part numbers the shards of a group when splitting by count or size
+	Part int `json:"part,omitempty"`
+	// MZWindow is the precursor m/z window of the shard
+	MZWindow *Window `json:"mz_window,omitempty"`
+	// OutsideWindows marks the shards of spectra outside every m/z window
+	OutsideWindows bool `json:"outside_windows,omitempty"`
+	// CompoundClass is the compound class of the shard when sharding by
+	// class, empty for spectra without one
+	CompoundClass *string `json:"compound_class,omitempty"`
+	Spectra       int     `json:"spectra"`
+	Bytes         int64   `json:"bytes"`
+	// PrecursorMZ is the precursor m/z range of the spectra in the shard
+	PrecursorMZ Range `json:"precursor_mz"`
+}
+
+// Range is the lowest and highest precursor m/z in a shard
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// add extends the range to include mz
+func (r *Range) add(mz float64, first bool) {
+	if first || mz < r.Min {
+		r.Min = mz
+	}
+	if first || mz > r.Max {
+		r.Max = mz
+	}
+}
+
+// ManifestPath returns the manifest written for a sharded output, e.g.
+// library.manifest.json for library.db
+func ManifestPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".manifest.json"
+}
+
+// readManifest reads the manifest of an earlier sharded output
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// save writes the manifest to a temporary file and moves it to path
+func (m *Manifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	f, err := atomicfile.Create(path, true)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return f.Commit()
+}
@@ -0,0 +1,125 @@
+package shard
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options selects how a library is split into shards. Spectra are grouped
+// by precursor m/z window and compound class, and a group is continued in a
+// new shard once its shard reaches MaxSpectra or MaxBytes.
+type Options struct {
+	// MaxSpectra is the maximum number of spectra per shard (0 = no limit)
+	MaxSpectra int `json:"max_spectra,omitempty"`
+	// MaxBytes is the database size at which a shard is closed (0 = no
+	// limit). A shard may exceed it by one spectrum and the header tables.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MZWindows groups spectra by precursor m/z; spectra outside every
+	// window are written to their own shards
+	MZWindows []Window `json:"mz_windows,omitempty"`
+	// ByClass groups spectra by compound class
+	ByClass bool `json:"compound_class,omitempty"`
+}
+
+// Enabled reports whether any sharding is configured
+func (o Options) Enabled() bool {
+	return o.MaxSpectra > 0 || o.MaxBytes > 0 || len(o.MZWindows) > 0 || o.ByClass
+}
+
+// limited reports whether shards are split by count or size, and so
+// numbered
+func (o Options) limited() bool {
+	return o.MaxSpectra > 0 || o.MaxBytes > 0
+}
+
+// Window is a precursor m/z range, including Min and excluding Max
+type Window struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Contains reports whether a precursor m/z falls in the window
+func (w Window) Contains(mz float64) bool {
+	return mz >= w.Min && mz < w.Max
+}
+
+// String returns the window as "min-max"
+func (w Window) String() string {
+	return strconv.FormatFloat(w.Min, 'f', -1, 64) + "-" + strconv.FormatFloat(w.Max, 'f', -1, 64)
+}
+
+// ParseWindows parses a comma-separated list of m/z windows such as
+// "400-600,600-800". The windows are returned sorted and must not overlap.
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid m/z window '%s', expected min-max", part)
+		}
+		min, err := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid m/z window '%s': %w", part, err)
+		}
+		max, err := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid m/z window '%s': %w", part, err)
+		}
+		if min < 0 || max <= min {
+			return nil, fmt.Errorf("invalid m/z window '%s', min must be below max", part)
+		}
+		windows = append(windows, Window{Min: min, Max: max})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no m/z windows in '%s'", s)
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Min < windows[j].Min })
+	for i := 1; i < len(windows); i++ {
+		if windows[i].Min < windows[i-1].Max {
+			return nil, fmt.Errorf("m/z windows %s and %s overlap", windows[i-1], windows[i])
+		}
+	}
+	return windows, nil
+}
+
+// sizeUnits are the multipliers of the size suffixes accepted by ParseSize
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses a file size in bytes with an optional K, M, G or T
+// suffix, e.g. "500M", "2GB" or "1.5GiB". Suffixes are powers of 1024; "B"
+// may follow a unit or stand alone, "iB" only follows a unit.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	binary := strings.HasSuffix(str, "IB")
+	if binary {
+		str = str[:len(str)-2]
+	} else {
+		str = strings.TrimSuffix(str, "B")
+	}
+	unit := ""
+	if n := len(str); n > 0 && str[n-1] >= 'A' && str[n-1] <= 'Z' {
+		unit, str = str[n-1:], str[:n-1]
+	}
+	mult, ok := sizeUnits[unit]
+	if !ok || (binary && unit == "") {
+		return 0, fmt.Errorf("invalid size '%s', unknown unit", s)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected a positive number with an optional K, M, G or T suffix", s)
+	}
+	return int64(value * float64(mult)), nil
+}
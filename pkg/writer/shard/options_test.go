@@ -0,0 +1,99 @@
+package shard
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []Window
+		wantErr string
+	}{
+		{input: "400-600", want: []Window{{400, 600}}},
+		{input: "600-800, 400-600", want: []Window{{400, 600}, {600, 800}}},
+		{input: "400.5-600.25,,", want: []Window{{400.5, 600.25}}},
+		{input: " 0 - 100 ", want: []Window{{0, 100}}},
+		{input: "400", wantErr: "expected min-max"},
+		{input: "abc-600", wantErr: "invalid m/z window 'abc-600'"},
+		{input: "400-abc", wantErr: "invalid m/z window '400-abc'"},
+		{input: "600-400", wantErr: "min must be below max"},
+		{input: "400-400", wantErr: "min must be below max"},
+		{input: "-100-200", wantErr: "invalid m/z window"},
+		{input: "400-600,500-700", wantErr: "m/z windows 400-600 and 500-700 overlap"},
+		{input: " , ", wantErr: "no m/z windows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseWindows(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseWindows(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWindows(%q) error = %v", tt.input, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseWindows(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ParseWindows(%q)[%d] = %v, want %v", tt.input, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	w := Window{Min: 400, Max: 600}
+	for mz, want := range map[float64]bool{399.99: false, 400: true, 599.99: true, 600: false} {
+		if got := w.Contains(mz); got != want {
+			t.Errorf("Contains(%v) = %v, want %v", mz, got, want)
+		}
+	}
+	if s := (Window{Min: 400.5, Max: 600}).String(); s != "400.5-600" {
+		t.Errorf("String() = %q, want 400.5-600", s)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "100", want: 100},
+		{input: "100B", want: 100},
+		{input: "2K", want: 2 << 10},
+		{input: "500m", want: 500 << 20},
+		{input: "2GB", want: 2 << 30},
+		{input: "1.5GiB", want: 3 << 29},
+		{input: "1T", want: 1 << 40},
+		{input: " 5 M ", want: 5 << 20},
+		{input: "5I", wantErr: true},
+		{input: "5iB", wantErr: true},
+		{input: "5BB", wantErr: true},
+		{input: "5KI", wantErr: true},
+		{input: "5X", wantErr: true},
+		{input: "5MG", wantErr: true},
+		{input: "M", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "-1G", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+// Package shard splits a spectral library into several mzVault databases,
+// by spectrum count, file size, precursor m/z window or compound class, and
+// writes a manifest listing the shards
+package shard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+// unclassified names the shards of spectra without a compound class
+const unclassified = "unclassified"
+
+// Writer writes spectra to shards named after the output path, e.g.
+// library.mz400-600.001.db, each a complete mzVault database with its own
+// HeaderTable and MaintenanceTable. A shard is finalized as soon as it is
+// full but kept under a temporary name until Close moves every shard into
+// place and writes the manifest, so an existing library is only replaced
+// by a complete one.
+type Writer struct {
+	ctx        context.Context
+	outputPath string
+	opts       Options
+	library    sqlite.Options
+	groups     map[string]*group
+	labels     map[string]bool // Group labels in use
+	shards     []*shard        // In creation order
+	closed     bool
+	written    int
+	manifest   *Manifest
+	replaced   []string // Shards of the manifest being overwritten
+}
+
+// group is the spectra of one m/z window and compound class
+type group struct {
+	label   string
+	info    Shard // Window and class of the group's shards
+	parts   int
+	current *shard
+}
+
+// shard is an open or finalized shard database
+type shard struct {
+	path string
+	info Shard
+	out  *atomicfile.File // Staged shard, moved to path by Close
+	w    *sqlite.Writer   // Writes out's temporary file; nil once finalized
+}
+
+// NewWriter creates a sharded writer. library holds the metadata written
+// to every shard; sharding only creates new databases, so updates and
+// checkpoints are not supported.
+func NewWriter(ctx context.Context, outputPath string, opts Options, library sqlite.Options) (*Writer, error) {
+	if !opts.Enabled() {
+		return nil, fmt.Errorf("no sharding configured")
+	}
+	if library.Mode != sqlite.ModeCreate {
+		return nil, fmt.Errorf("sharded output cannot be updated in %s mode", library.Mode)
+	}
+	if library.Checkpoint || library.Resume {
+		return nil, fmt.Errorf("sharded output does not support checkpoints")
+	}
+	w := &Writer{
+		ctx:        ctx,
+		outputPath: outputPath,
+		opts:       opts,
+		library:    library,
+		groups:     make(map[string]*group),
+		labels:     make(map[string]bool),
+	}
+
+	// Shards of an overwritten library that are not written again are
+	// removed by Close
+	if old, err := readManifest(ManifestPath(outputPath)); err == nil {
+		if !library.Overwrite {
+			return nil, fmt.Errorf("%w: %s", sqlite.ErrOutputExists, ManifestPath(outputPath))
+		}
+		for _, s := range old.Shards {
+			w.replaced = append(w.replaced, filepath.Join(filepath.Dir(outputPath), filepath.Base(s.Path)))
+		}
+	} else if !os.IsNotExist(err) && !library.Overwrite {
+		return nil, fmt.Errorf("%w: %s", sqlite.ErrOutputExists, ManifestPath(outputPath))
+	}
+
+	return w, nil
+}
+
+// Write writes a spectrum to the shard of its group, starting a new shard
+// when the group has none or its shard is full
+func (w *Writer) Write(spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+
+	g := w.group(spec)
+	if g.current != nil {
+		full, err := w.full(g.current)
+		if err != nil {
+			return err
+		}
+		if full {
+			if err := g.current.finalize(); err != nil {
+				return err
+			}
+			g.current = nil
+		}
+	}
+	if g.current == nil {
+		s, err := w.newShard(g)
+		if err != nil {
+			return err
+		}
+		g.current = s
+	}
+
+	s := g.current
+	if err := s.w.WriteSpectrumContext(w.ctx, spec); err != nil {
+		return err
+	}
+	s.info.PrecursorMZ.add(spec.PrecursorMZ, s.info.Spectra == 0)
+	s.info.Spectra++
+	w.written++
+	return nil
+}
+
+// group returns the group of a spectrum, creating it on first use
+func (w *Writer) group(spec *core.Spectrum) *group {
+	var info Shard
+	var parts []string
+	if len(w.opts.MZWindows) > 0 {
+		for i, window := range w.opts.MZWindows {
+			if window.Contains(spec.PrecursorMZ) {
+				info.MZWindow = &w.opts.MZWindows[i]
+				parts = append(parts, "mz"+window.String())
+				break
+			}
+		}
+		if info.MZWindow == nil {
+			info.OutsideWindows = true
+			parts = append(parts, "mz-other")
+		}
+	}
+	if w.opts.ByClass {
+		class := spec.CompoundClass
+		info.CompoundClass = &class
+		parts = append(parts, classLabel(class))
+	}
+
+	key := strings.Join(parts, "\x00")
+	if info.CompoundClass != nil {
+		// Classes that differ only in characters replaced in file names
+		// are kept apart by the exact class
+		key += "\x00" + *info.CompoundClass
+	}
+	g, ok := w.groups[key]
+	if ok {
+		return g
+	}
+
+	label := strings.Join(parts, ".")
+	for n := 2; w.labels[label]; n++ {
+		label = strings.Join(parts, ".") + "_" + strconv.Itoa(n)
+	}
+	w.labels[label] = true
+	g = &group{label: label, info: info}
+	w.groups[key] = g
+	return g
+}
+
+// classLabel turns a compound class into a file name part
+func classLabel(class string) string {
+	label := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '+' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(class))
+	if strings.Trim(label, "_") == "" {
+		return unclassified
+	}
+	return label
+}
+
+// full reports whether a shard has reached the spectrum count or size limit
+func (w *Writer) full(s *shard) (bool, error) {
+	if w.opts.MaxSpectra > 0 && s.info.Spectra >= w.opts.MaxSpectra {
+		return true, nil
+	}
+	if w.opts.MaxBytes > 0 {
+		size, err := s.w.Size()
+		if err != nil {
+			return false, err
+		}
+		return size >= w.opts.MaxBytes, nil
+	}
+	return false, nil
+}
+
+// newShard creates the next shard database of a group
+func (w *Writer) newShard(g *group) (*shard, error) {
+	g.parts++
+	info := g.info
+	name := g.label
+	if w.opts.limited() {
+		info.Part = g.parts
+		part := fmt.Sprintf("%03d", g.parts)
+		if name == "" {
+			name = part
+		} else {
+			name += "." + part
+		}
+	}
+
+	ext := filepath.Ext(w.outputPath)
+	path := strings.TrimSuffix(w.outputPath, ext) + "." + name + ext
+	out, err := atomicfile.Create(path, w.library.Overwrite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shard %s: %w", path, err)
+	}
+	out.Close()
+
+	// The shard database replaces the empty staging file when finalized
+	library := w.library
+	library.Overwrite = true
+	db, err := sqlite.NewWriterWithOptions(out.TempPath(), library)
+	if err != nil {
+		out.Abort()
+		return nil, fmt.Errorf("failed to create shard %s: %w", path, err)
+	}
+	info.Path = filepath.Base(path)
+	s := &shard{path: path, info: info, out: out, w: db}
+	w.shards = append(w.shards, s)
+	return s, nil
+}
+
+// finalize writes the header tables of a shard, which stays under its
+// temporary name
+func (s *shard) finalize() error {
+	if err := s.w.Finalize(); err != nil {
+		return fmt.Errorf("failed to finalize shard %s: %w", s.path, err)
+	}
+	s.w = nil
+	if fi, err := os.Stat(s.out.TempPath()); err == nil {
+		s.info.Bytes = fi.Size()
+	}
+	return nil
+}
+
+// Written returns the number of spectra written to all shards
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Close finalizes the open shards, moves all shards to their paths and
+// writes the manifest last. If a shard cannot be finalized, the new shards
+// are removed and an existing library is left as it was.
+func (w *Writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+
+	m := &Manifest{
+		Version: ManifestVersion,
+		Created: time.Now(),
+		Library: filepath.Base(w.outputPath),
+		Options: w.opts,
+		Spectra: w.written,
+		Shards:  make([]Shard, 0, len(w.shards)),
+	}
+	for _, s := range w.shards {
+		if s.w != nil {
+			if err := s.finalize(); err != nil {
+				w.Abort()
+				return err
+			}
+		}
+		m.Shards = append(m.Shards, s.info)
+	}
+	for _, s := range w.shards {
+		if err := s.out.Commit(); err != nil {
+			w.Abort()
+			return fmt.Errorf("failed to move shard %s into place: %w", s.path, err)
+		}
+	}
+	if err := m.save(ManifestPath(w.outputPath)); err != nil {
+		w.Abort()
+		return err
+	}
+
+	w.closed = true
+	w.manifest = m
+
+	written := make(map[string]bool, len(w.shards))
+	for _, s := range w.shards {
+		written[s.path] = true
+	}
+	for _, path := range w.replaced {
+		if !written[path] {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// Abort discards the open shards and the staged ones, so neither a partial
+// library nor a damaged earlier one is left behind. It does nothing after
+// Close.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var err error
+	for _, s := range w.shards {
+		if s.w != nil {
+			if closeErr := s.w.Abort(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		if rmErr := s.out.Abort(); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// Manifest returns the manifest written by Close, or nil before
+func (w *Writer) Manifest() *Manifest {
+	return w.manifest
+}
+
+// ManifestPath returns the path of the manifest
+func (w *Writer) ManifestPath() string {
+	return ManifestPath(w.outputPath)
+}
@@ -0,0 +1,301 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+)
+
+// testSpectrum returns a peptide spectrum with the given precursor m/z and
+// compound class
+func testSpectrum(mz float64, class string) *core.Spectrum {
+	return &core.Spectrum{
+		Sequence:          "PEPTIDEK",
+		Charge:            2,
+		PrecursorMZ:       mz,
+		Peaks:             []core.Peak{{MZ: 200, Intensity: 100}},
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		CompoundClass:     class,
+	}
+}
+
+// writeShards writes spectra to a sharded library in dir and closes it
+func writeShards(t *testing.T, dir string, opts Options, specs ...*core.Spectrum) *Writer {
+	t.Helper()
+	w, err := NewWriter(context.Background(), filepath.Join(dir, "library.db"), opts, sqlite.Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.Write(spec); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return w
+}
+
+// shardSummary lists the path and spectrum count of each manifest shard
+func shardSummary(m *Manifest) []string {
+	var got []string
+	for _, s := range m.Shards {
+		got = append(got, fmt.Sprintf("%s %d", s.Path, s.Spectra))
+	}
+	return got
+}
+
+func TestWriterRollover(t *testing.T) {
+	specs := []*core.Spectrum{
+		testSpectrum(450, ""), testSpectrum(500, ""), testSpectrum(550, ""),
+		testSpectrum(650, ""), testSpectrum(700, ""),
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "by count",
+			opts: Options{MaxSpectra: 2},
+			want: []string{"library.001.db 2", "library.002.db 2", "library.003.db 1"},
+		},
+		{
+			// Any database exceeds one byte, so every spectrum starts a shard
+			name: "by size",
+			opts: Options{MaxBytes: 1},
+			want: []string{"library.001.db 1", "library.002.db 1", "library.003.db 1", "library.004.db 1", "library.005.db 1"},
+		},
+		{
+			name: "size limit not reached",
+			opts: Options{MaxBytes: 1 << 30},
+			want: []string{"library.001.db 5"},
+		},
+		{
+			name: "by window and count",
+			opts: Options{MaxSpectra: 2, MZWindows: []Window{{400, 600}}},
+			want: []string{"library.mz400-600.001.db 2", "library.mz400-600.002.db 1", "library.mz-other.001.db 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := writeShards(t, dir, tt.opts, specs...)
+			if w.Written() != len(specs) {
+				t.Errorf("Written() = %d, want %d", w.Written(), len(specs))
+			}
+			got := shardSummary(w.Manifest())
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("shards = %v, want %v", got, tt.want)
+			}
+			for _, s := range w.Manifest().Shards {
+				db := sqltest.Open(t, filepath.Join(dir, s.Path))
+				if n := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); n != s.Spectra {
+					t.Errorf("shard %s holds %d spectra, manifest lists %d", s.Path, n, s.Spectra)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterManifest(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MZWindows: []Window{{400, 600}}, ByClass: true}
+	w := writeShards(t, dir, opts,
+		testSpectrum(450, "Lipid"),
+		testSpectrum(550, "Lipid"),
+		testSpectrum(500, ""),
+		testSpectrum(700, "Lipid/A"),
+		testSpectrum(750, "Lipid?A"),
+	)
+
+	// The manifest on disk is the one returned by Manifest
+	path := w.ManifestPath()
+	if path != filepath.Join(dir, "library.manifest.json") {
+		t.Errorf("ManifestPath() = %s, want library.manifest.json", path)
+	}
+	m, err := readManifest(path)
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	if m.Version != ManifestVersion || m.Library != "library.db" || m.Spectra != 5 {
+		t.Errorf("manifest = version %d, library %s, %d spectra, want %d, library.db, 5", m.Version, m.Library, m.Spectra, ManifestVersion)
+	}
+	if len(m.Options.MZWindows) != 1 || !m.Options.ByClass {
+		t.Errorf("manifest options = %+v, want %+v", m.Options, opts)
+	}
+
+	// Classes that map to the same file name are kept apart
+	want := []string{
+		"library.mz400-600.Lipid.db 2",
+		"library.mz400-600.unclassified.db 1",
+		"library.mz-other.Lipid_A.db 1",
+		"library.mz-other.Lipid_A_2.db 1",
+	}
+	if got := shardSummary(m); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("shards = %v, want %v", got, want)
+	}
+
+	lipid := m.Shards[0]
+	if lipid.MZWindow == nil || *lipid.MZWindow != (Window{400, 600}) || lipid.OutsideWindows {
+		t.Errorf("Lipid shard window = %v outside %v, want 400-600", lipid.MZWindow, lipid.OutsideWindows)
+	}
+	if lipid.CompoundClass == nil || *lipid.CompoundClass != "Lipid" || lipid.Part != 0 {
+		t.Errorf("Lipid shard class, part = %v, %d, want Lipid, 0", lipid.CompoundClass, lipid.Part)
+	}
+	if lipid.PrecursorMZ != (Range{Min: 450, Max: 550}) {
+		t.Errorf("Lipid shard precursor range = %+v, want 450-550", lipid.PrecursorMZ)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, lipid.Path)); err != nil || lipid.Bytes != fi.Size() {
+		t.Errorf("Lipid shard bytes = %d, want the file size", lipid.Bytes)
+	}
+	if c := m.Shards[1].CompoundClass; c == nil || *c != "" {
+		t.Errorf("unclassified shard class = %v, want empty", c)
+	}
+	other := m.Shards[3]
+	if other.MZWindow != nil || !other.OutsideWindows || *other.CompoundClass != "Lipid?A" {
+		t.Errorf("outside shard = %v outside %v class %v, want no window, outside, Lipid?A",
+			other.MZWindow, other.OutsideWindows, *other.CompoundClass)
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(context.Background(), filepath.Join(dir, "library.db"), Options{MaxSpectra: 1}, sqlite.Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	// The first shard is finalized when the second is started
+	for _, mz := range []float64{450, 500} {
+		if err := w.Write(testSpectrum(mz, "")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files left after Abort: %v", entries)
+	}
+	if err := w.Write(testSpectrum(450, "")); err == nil {
+		t.Error("Write() after Abort succeeded")
+	}
+}
+
+func TestWriterAbortOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "library.db")
+	writeShards(t, dir, Options{MaxSpectra: 1}, testSpectrum(450, ""), testSpectrum(500, ""))
+
+	before := make(map[string][]byte)
+	for _, name := range []string{"library.001.db", "library.002.db", "library.manifest.json"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[name] = data
+	}
+
+	// A cancelled rerun finalizes library.001.db before it is aborted
+	w, err := NewWriter(context.Background(), output, Options{MaxSpectra: 1}, sqlite.Options{Overwrite: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, mz := range []float64{600, 650, 700} {
+		if err := w.Write(testSpectrum(mz, "")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(before) {
+		var files []string
+		for _, e := range entries {
+			files = append(files, e.Name())
+		}
+		t.Errorf("files after Abort = %v, want the earlier library only", files)
+	}
+	for name, want := range before {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s after Abort: %v", name, err)
+			continue
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s changed by Abort", name)
+		}
+	}
+}
+
+func TestWriterOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "library.db")
+	writeShards(t, dir, Options{MaxSpectra: 1}, testSpectrum(450, ""), testSpectrum(500, ""), testSpectrum(550, ""))
+
+	if _, err := NewWriter(context.Background(), output, Options{MaxSpectra: 1}, sqlite.Options{}); !errors.Is(err, sqlite.ErrOutputExists) {
+		t.Fatalf("NewWriter() error = %v, want ErrOutputExists", err)
+	}
+
+	// Shards of the earlier library that are not written again are removed
+	w, err := NewWriter(context.Background(), output, Options{MaxSpectra: 1}, sqlite.Options{Overwrite: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.Write(testSpectrum(450, "")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	if want := "library.001.db,library.manifest.json"; strings.Join(files, ",") != want {
+		t.Errorf("files = %v, want %s", files, want)
+	}
+}
+
+func TestNewWriterOptions(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "library.db")
+	tests := []struct {
+		name    string
+		opts    Options
+		library sqlite.Options
+	}{
+		{name: "no sharding"},
+		{name: "append", opts: Options{MaxSpectra: 1}, library: sqlite.Options{Mode: sqlite.ModeAppend}},
+		{name: "checkpoint", opts: Options{MaxSpectra: 1}, library: sqlite.Options{Checkpoint: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWriter(context.Background(), output, tt.opts, tt.library); err == nil {
+				t.Error("NewWriter() succeeded")
+			}
+		})
+	}
+}
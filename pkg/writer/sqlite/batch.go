@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DefaultBatchSize is the number of spectra committed per transaction when
+// Options.BatchSize is not set
+const DefaultBatchSize = 10000
+
+// Batch groups the writes of a SQLite library writer into transactions of a
+// fixed number of spectra, binding prepared statements to the open
+// transaction. It is shared by the writers of SQLite-based formats.
+type Batch struct {
+	db    *sql.DB
+	limit int // Spectra per transaction
+	tx    *sql.Tx
+	stmts map[*sql.Stmt]*sql.Stmt // Prepared statement -> bound to tx
+	size  int                     // Spectra written in this transaction
+
+	// BeforeCommit, if set, is called with the open transaction before each
+	// commit, e.g. to store a checkpoint with the committed spectra
+	BeforeCommit func(tx *sql.Tx) error
+}
+
+// NewBatch creates a batch of size spectra per transaction on db, or
+// DefaultBatchSize if size is not positive
+func NewBatch(db *sql.DB, size int) *Batch {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	return &Batch{db: db, limit: size}
+}
+
+// Begin starts the next transaction, first committing the open one if it
+// holds a full batch. Committing before the next spectrum rather than after
+// the last one keeps state set in between, such as a checkpoint, with the
+// spectra it describes.
+func (b *Batch) Begin() error {
+	if b.tx != nil && b.size >= b.limit {
+		if err := b.Commit(); err != nil {
+			return err
+		}
+	}
+	if b.tx != nil {
+		return nil
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	b.tx = tx
+	b.stmts = make(map[*sql.Stmt]*sql.Stmt)
+	b.size = 0
+	return nil
+}
+
+// Stmt returns a prepared statement bound to the open transaction
+func (b *Batch) Stmt(stmt *sql.Stmt) *sql.Stmt {
+	bound, ok := b.stmts[stmt]
+	if !ok {
+		bound = b.tx.Stmt(stmt)
+		b.stmts[stmt] = bound
+	}
+	return bound
+}
+
+// Tx returns the open transaction, or nil
+func (b *Batch) Tx() *sql.Tx {
+	return b.tx
+}
+
+// Add counts a spectrum written in the open transaction
+func (b *Batch) Add() {
+	b.size++
+}
+
+// Commit commits the open transaction, if any
+func (b *Batch) Commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx := b.tx
+	b.tx = nil
+	if b.BeforeCommit != nil {
+		if err := b.BeforeCommit(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the spectra written since the last commit
+func (b *Batch) Rollback() error {
+	if b.tx == nil {
+		return nil
+	}
+	tx := b.tx
+	b.tx = nil
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// Flush commits the spectra written since the last commit, together with
+// the checkpoint if checkpointing
+func (w *Writer) Flush() error {
+	return w.batch.Commit()
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+)
+
+// openBatchDB opens a database with a table of numbered rows
+func openBatchDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "batch.db")
+	db, err := sql.Open("sqlite3", sqliteuri.File(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE Rows (N INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+func TestBatchCommits(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		writes      int
+		wantCommits int // Commits before the final Commit
+	}{
+		{name: "one per transaction", size: 1, writes: 5, wantCommits: 4},
+		{name: "partial last batch", size: 2, writes: 5, wantCommits: 2},
+		{name: "exact batches", size: 5, writes: 10, wantCommits: 1},
+		{name: "default size", size: 0, writes: 5, wantCommits: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openBatchDB(t)
+			insert, err := db.Prepare(`INSERT INTO Rows (N) VALUES (?)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer insert.Close()
+
+			b := NewBatch(db, tt.size)
+			commits := 0
+			b.BeforeCommit = func(tx *sql.Tx) error {
+				commits++
+				return nil
+			}
+			for i := 0; i < tt.writes; i++ {
+				if err := b.Begin(); err != nil {
+					t.Fatalf("Begin() error = %v", err)
+				}
+				if b.Tx() == nil {
+					t.Fatal("Tx() = nil after Begin")
+				}
+				if b.Stmt(insert) != b.Stmt(insert) {
+					t.Error("Stmt() bound the statement twice in one transaction")
+				}
+				if _, err := b.Stmt(insert).Exec(i); err != nil {
+					t.Fatalf("Exec() error = %v", err)
+				}
+				b.Add()
+			}
+			if commits != tt.wantCommits {
+				t.Errorf("commits before Commit = %d, want %d", commits, tt.wantCommits)
+			}
+
+			// Rows of committed batches are visible to other connections
+			other := sqltest.Open(t, path)
+			size := tt.size
+			if size <= 0 {
+				size = DefaultBatchSize
+			}
+			if got := sqltest.QueryInt(t, other, `SELECT COUNT(*) FROM Rows`); got != tt.wantCommits*size {
+				t.Errorf("committed rows = %d, want %d", got, tt.wantCommits*size)
+			}
+
+			if err := b.Commit(); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			if b.Tx() != nil {
+				t.Error("Tx() != nil after Commit")
+			}
+			if got := sqltest.QueryInt(t, other, `SELECT COUNT(*) FROM Rows`); got != tt.writes {
+				t.Errorf("rows after Commit = %d, want %d", got, tt.writes)
+			}
+		})
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	db, _ := openBatchDB(t)
+	b := NewBatch(db, 2)
+	for i := 0; i < 3; i++ {
+		if err := b.Begin(); err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		if _, err := b.Tx().Exec(`INSERT INTO Rows (N) VALUES (?)`, i); err != nil {
+			t.Fatal(err)
+		}
+		b.Add()
+	}
+
+	// The third row is in the open transaction
+	if err := b.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM Rows`); got != 2 {
+		t.Errorf("rows after Rollback = %d, want 2", got)
+	}
+	if err := b.Rollback(); err != nil {
+		t.Errorf("Rollback() without a transaction error = %v", err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Errorf("Commit() without a transaction error = %v", err)
+	}
+}
+
+func TestBatchBeforeCommitError(t *testing.T) {
+	db, _ := openBatchDB(t)
+	b := NewBatch(db, 1)
+	errHook := errors.New("hook failed")
+	b.BeforeCommit = func(tx *sql.Tx) error { return errHook }
+
+	if err := b.Begin(); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if _, err := b.Tx().Exec(`INSERT INTO Rows (N) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	b.Add()
+
+	if err := b.Begin(); !errors.Is(err, errHook) {
+		t.Errorf("Begin() error = %v, want %v", err, errHook)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM Rows`); got != 0 {
+		t.Errorf("rows after failed commit = %d, want 0", got)
+	}
+}
@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoCheckpoint is returned when resuming without a partial output or a
+// checkpoint inside it
+var ErrNoCheckpoint = errors.New("no checkpoint to resume from")
+
+// Checkpoint records how far a conversion has progressed. It is stored in
+// the partial output with every commit, so it always matches the spectra
+// committed so far.
+type Checkpoint struct {
+	InputFingerprint string // Identifies the input file
+	SettingsHash     string // Identifies the conversion settings
+	Offset           int64  // Input offset to continue reading from
+	State            string // Caller state restored on resume, e.g. counts as JSON
+
+	// Set by the writer
+	Written        int // Spectra written
+	Replaced       int // Existing spectra replaced in upsert mode
+	LastCompoundID int // Last compound ID written
+}
+
+// PartialPath returns the path of the partial output kept for resuming
+func PartialPath(outputPath string) string {
+	return outputPath + ".partial"
+}
+
+// SetCheckpointFunc sets the function called before each commit for the
+// checkpoint stored with it, so that the caller only builds its state when
+// it is stored. The checkpoint must describe the input up to the last
+// spectrum written; the writer fills in its own counts and IDs. An error
+// fails the commit.
+func (w *Writer) SetCheckpointFunc(f func() (Checkpoint, error)) {
+	w.checkpointFunc = f
+}
+
+// Resumed returns the checkpoint a resumed writer continues from, or nil
+func (w *Writer) Resumed() *Checkpoint {
+	return w.resumed
+}
+
+// createCheckpointTable creates the table holding the checkpoint row
+func (w *Writer) createCheckpointTable() error {
+	_, err := w.db.Exec(`
+		CREATE TABLE IF NOT EXISTS DBKeyCheckpoint (
+			Id INTEGER PRIMARY KEY CHECK (Id = 1),
+			InputFingerprint TEXT,
+			SettingsHash TEXT,
+			InputOffset INTEGER,
+			State TEXT,
+			SpectraWritten INTEGER,
+			SpectraReplaced INTEGER,
+			LastCompoundId INTEGER,
+			CreationDate TEXT,
+			UpdatedAt TEXT
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+	return nil
+}
+
+// writeCheckpoint stores the current checkpoint in the open transaction
+func (w *Writer) writeCheckpoint(tx *sql.Tx) error {
+	if !w.opts.Checkpoint {
+		return nil
+	}
+	if w.checkpointFunc != nil {
+		cp, err := w.checkpointFunc()
+		if err != nil {
+			return fmt.Errorf("failed to build checkpoint: %w", err)
+		}
+		w.checkpoint = &cp
+	}
+	if w.checkpoint == nil {
+		return nil
+	}
+	cp := w.checkpoint
+	_, err := tx.Exec(`
+		INSERT OR REPLACE INTO DBKeyCheckpoint (
+			Id, InputFingerprint, SettingsHash, InputOffset, State,
+			SpectraWritten, SpectraReplaced, LastCompoundId, CreationDate, UpdatedAt
+		) VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, cp.InputFingerprint, cp.SettingsHash, cp.Offset, cp.State,
+		w.written, w.replaced, w.compoundID-1,
+		w.created.Format(time.RFC3339Nano), time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// loadCheckpoint restores the writer state from the checkpoint of a
+// partial output
+func (w *Writer) loadCheckpoint() error {
+	var cp Checkpoint
+	var created string
+	err := w.db.QueryRow(`
+		SELECT InputFingerprint, SettingsHash, InputOffset, State,
+			SpectraWritten, SpectraReplaced, LastCompoundId, CreationDate
+		FROM DBKeyCheckpoint WHERE Id = 1
+	`).Scan(&cp.InputFingerprint, &cp.SettingsHash, &cp.Offset, &cp.State,
+		&cp.Written, &cp.Replaced, &cp.LastCompoundID, &created)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s has no checkpoint", ErrNoCheckpoint, w.out.TempPath())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, created); err == nil {
+		w.created = t
+	}
+	w.written = cp.Written
+	w.replaced = cp.Replaced
+	w.compoundID = cp.LastCompoundID + 1
+	w.resumed = &cp
+	w.checkpoint = &cp
+	return nil
+}
+
+// dropCheckpoint removes the checkpoint table from a finished library
+func (w *Writer) dropCheckpoint() error {
+	if _, err := w.db.Exec(`DROP TABLE IF EXISTS DBKeyCheckpoint`); err != nil {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
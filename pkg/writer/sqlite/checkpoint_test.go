@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	opts := Options{Checkpoint: true, BatchSize: 2}
+
+	w, err := NewWriterWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions() error = %v", err)
+	}
+	calls := 0
+	offset := int64(0)
+	w.SetCheckpointFunc(func() (Checkpoint, error) {
+		calls++
+		return Checkpoint{InputFingerprint: "input", SettingsHash: "settings", Offset: offset, State: "state"}, nil
+	})
+	for i, seq := range []string{"PEPTIDEK", "AAAK", "LLLK"} {
+		if err := w.WriteSpectrum(testPeptide(seq, 2, 200)); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+		offset = int64(i+1) * 100
+	}
+
+	// The first batch of two spectra was committed before the third
+	if calls != 1 {
+		t.Errorf("checkpoint built %d times, want 1", calls)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	if _, err := os.Stat(PartialPath(path)); err != nil {
+		t.Fatalf("partial output removed by Abort: %v", err)
+	}
+
+	w, err = NewWriterWithOptions(path, Options{Resume: true, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions() resume error = %v", err)
+	}
+	cp := w.Resumed()
+	if cp == nil {
+		t.Fatal("Resumed() = nil")
+	}
+	want := Checkpoint{InputFingerprint: "input", SettingsHash: "settings", Offset: 200, State: "state",
+		Written: 2, LastCompoundID: 2}
+	if *cp != want {
+		t.Errorf("Resumed() = %+v, want %+v", *cp, want)
+	}
+	if w.Written() != 2 {
+		t.Errorf("Written() = %d, want 2", w.Written())
+	}
+
+	if err := w.WriteSpectrum(testPeptide("LLLK", 2, 200)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if _, err := os.Stat(PartialPath(path)); !os.IsNotExist(err) {
+		t.Errorf("partial output left after Finalize")
+	}
+
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT MAX(CompoundId) FROM CompoundTable WHERE Name = 'LLLK/2'`); got != 3 {
+		t.Errorf("resumed CompoundId = %d, want 3", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); got != 3 {
+		t.Errorf("spectra = %d, want 3", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'DBKeyCheckpoint'`); got != 0 {
+		t.Error("DBKeyCheckpoint table left in finalized library")
+	}
+}
+
+func TestCheckpointFuncError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	w, err := NewWriterWithOptions(path, Options{Checkpoint: true, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions() error = %v", err)
+	}
+	defer w.Abort()
+
+	errState := errors.New("state unavailable")
+	w.SetCheckpointFunc(func() (Checkpoint, error) { return Checkpoint{}, errState })
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2, 200)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testPeptide("AAAK", 2, 200)); !errors.Is(err, errState) {
+		t.Errorf("WriteSpectrum() error = %v, want %v", err, errState)
+	}
+}
+
+func TestResumeWithoutPartialOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	if _, err := NewWriterWithOptions(path, Options{Resume: true}); !errors.Is(err, ErrNoCheckpoint) {
+		t.Errorf("NewWriterWithOptions() error = %v, want ErrNoCheckpoint", err)
+	}
+}
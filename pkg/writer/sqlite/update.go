@@ -0,0 +1,276 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Mode selects how a writer treats an existing database
+type Mode int
+
+const (
+	// ModeCreate writes a new library (default)
+	ModeCreate Mode = iota
+	// ModeAppend adds spectra after the existing ones
+	ModeAppend
+	// ModeUpsert replaces existing spectra for the same precursor and adds
+	// the rest
+	ModeUpsert
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeAppend:
+		return "append"
+	case ModeUpsert:
+		return "upsert"
+	default:
+		return "create"
+	}
+}
+
+// Written returns the number of spectra written so far
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Replaced returns the number of existing spectra replaced in upsert mode
+func (w *Writer) Replaced() int {
+	return w.replaced
+}
+
+// Size returns the current size of the database in bytes, including the
+// spectra written since the last commit
+func (w *Writer) Size() (int64, error) {
+	if w.closed {
+		return 0, fmt.Errorf("writer already closed")
+	}
+	var pages, pageSize int64
+	query := func(q string, dest *int64) error {
+		if tx := w.batch.Tx(); tx != nil {
+			return tx.QueryRow(q).Scan(dest)
+		}
+		return w.db.QueryRow(q).Scan(dest)
+	}
+	if err := query(`PRAGMA page_count`, &pages); err != nil {
+		return 0, fmt.Errorf("failed to read database size: %w", err)
+	}
+	if err := query(`PRAGMA page_size`, &pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read database size: %w", err)
+	}
+	return pages * pageSize, nil
+}
+
+// openExisting continues IDs after the highest compound or spectrum ID
+// already in the database
+func (w *Writer) openExisting() error {
+	var maxID int
+	err := w.db.QueryRow(`
+		SELECT MAX(
+			(SELECT COALESCE(MAX(CompoundId), 0) FROM CompoundTable),
+			(SELECT COALESCE(MAX(SpectrumId), 0) FROM SpectrumTable)
+		)
+	`).Scan(&maxID)
+	if err != nil {
+		return fmt.Errorf("failed to read existing IDs: %w", err)
+	}
+	w.compoundID = maxID + 1
+	return nil
+}
+
+// upsertIndexes speed up finding existing spectra when upserting. They are
+// not part of the mzVault schema, so Finalize drops them again.
+var upsertIndexes = map[string]string{
+	"DBKeyUpsertCompoundName":     "CompoundTable(Name)",
+	"DBKeyUpsertSpectrumCompound": "SpectrumTable(CompoundId)",
+}
+
+// prepareUpsertStatements indexes the columns used to find existing spectra
+// and prepares the statements used to find and remove them
+func (w *Writer) prepareUpsertStatements() error {
+	for name, on := range upsertIndexes {
+		if _, err := w.db.Exec(`CREATE INDEX IF NOT EXISTS ` + name + ` ON ` + on); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	var err error
+
+	// Peptide names include the charge and the Formula column holds the
+	// modifications, which are compared by removeExisting; small molecules
+	// also differ by precursor ion type
+	w.findStmt, err = w.db.Prepare(`
+		SELECT c.CompoundId, COALESCE(c.Formula, ''), COALESCE(s.PrecursorIonType, '')
+		FROM CompoundTable c
+		LEFT JOIN SpectrumTable s ON s.CompoundId = c.CompoundId
+		WHERE c.Name = ? AND COALESCE(c.Sequence, '') = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare find statement: %w", err)
+	}
+
+	w.deleteSpectrumStmt, err = w.db.Prepare(`DELETE FROM SpectrumTable WHERE CompoundId = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare delete statement: %w", err)
+	}
+
+	w.deleteCompoundStmt, err = w.db.Prepare(`DELETE FROM CompoundTable WHERE CompoundId = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare delete statement: %w", err)
+	}
+
+	return nil
+}
+
+// dropUpsertIndexes restores the mzVault schema after upserting
+func (w *Writer) dropUpsertIndexes() error {
+	for name := range upsertIndexes {
+		if _, err := w.db.Exec(`DROP INDEX IF EXISTS ` + name); err != nil {
+			return fmt.Errorf("failed to drop index: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeExisting deletes spectra for the same precursor as spec. Each
+// replaced precursor counts once, even if it was stored more than once.
+func (w *Writer) removeExisting(ctx context.Context, spec *core.Spectrum, formula string) error {
+	ionType := ""
+	if spec.IsSmallMolecule() {
+		ionType = spec.PrecursorIonType()
+	} else {
+		formula = sortModString(formula)
+	}
+
+	rows, err := w.batch.Stmt(w.findStmt).QueryContext(ctx, spec.Name(), spec.Sequence)
+	if err != nil {
+		return fmt.Errorf("failed to find existing spectrum: %w", err)
+	}
+	var ids []int
+	seen := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		var storedFormula, storedIonType string
+		if err := rows.Scan(&id, &storedFormula, &storedIonType); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to find existing spectrum: %w", err)
+		}
+		if !spec.IsSmallMolecule() {
+			// Libraries written by earlier versions list modifications
+			// in input order
+			storedFormula = sortModString(storedFormula)
+		}
+		if storedFormula != formula || (ionType != "" && storedIonType != ionType) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to find existing spectrum: %w", err)
+	}
+
+	for _, id := range ids {
+		if _, err := w.batch.Stmt(w.deleteSpectrumStmt).ExecContext(ctx, id); err != nil {
+			return fmt.Errorf("failed to remove existing spectrum: %w", err)
+		}
+		if _, err := w.batch.Stmt(w.deleteCompoundStmt).ExecContext(ctx, id); err != nil {
+			return fmt.Errorf("failed to remove existing compound: %w", err)
+		}
+	}
+	if len(ids) > 0 {
+		w.replaced++
+	}
+	return nil
+}
+
+// sortModString orders a "mass@pos;mass@pos" modification string by
+// position, as written by core.Spectrum.ModString
+func sortModString(mods string) string {
+	if mods == "" {
+		return ""
+	}
+	parts := strings.Split(mods, ";")
+	position := func(part string) int {
+		_, pos, _ := strings.Cut(part, "@")
+		n, _ := strconv.Atoi(pos)
+		return n
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return position(parts[i]) < position(parts[j])
+	})
+	return strings.Join(parts, ";")
+}
+
+// writeHeader inserts the HeaderTable row, or updates the existing row when
+// appending to a library
+func (w *Writer) writeHeader(now time.Time) error {
+	if w.opts.Mode != ModeCreate {
+		var rows int
+		if err := w.db.QueryRow(`SELECT COUNT(*) FROM HeaderTable`).Scan(&rows); err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+		if rows > 0 {
+			return w.updateHeader(now)
+		}
+	}
+
+	_, err := w.db.Exec(`
+		INSERT INTO HeaderTable (version, CreationDate, LastModifiedDate, Description, Company, ReadOnly, UserAccess, PartialEdits)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, headerVersion, w.created.Format(headerDateFormat), now.Format(headerDateFormat),
+		w.opts.Description, w.opts.Company, w.opts.ReadOnly, "", false)
+	if err != nil {
+		return fmt.Errorf("failed to insert header: %w", err)
+	}
+	return nil
+}
+
+// updateHeader sets the modification date of an existing library, and any
+// metadata given in the options
+func (w *Writer) updateHeader(now time.Time) error {
+	sets := []string{"LastModifiedDate = ?"}
+	args := []interface{}{now.Format(headerDateFormat)}
+	if w.opts.Description != "" {
+		sets = append(sets, "Description = ?")
+		args = append(args, w.opts.Description)
+	}
+	if w.opts.Company != "" {
+		sets = append(sets, "Company = ?")
+		args = append(args, w.opts.Company)
+	}
+	if w.opts.ReadOnly {
+		sets = append(sets, "ReadOnly = ?")
+		args = append(args, true)
+	}
+
+	if _, err := w.db.Exec("UPDATE HeaderTable SET "+strings.Join(sets, ", "), args...); err != nil {
+		return fmt.Errorf("failed to update header: %w", err)
+	}
+	return nil
+}
+
+// maintenanceDescription describes this write in the MaintenanceTable
+func (w *Writer) maintenanceDescription() string {
+	var description string
+	switch w.opts.Mode {
+	case ModeAppend:
+		description = fmt.Sprintf("Appended %d compounds", w.written)
+	case ModeUpsert:
+		description = fmt.Sprintf("Upserted %d compounds (%d replaced, %d added)",
+			w.written, w.replaced, w.written-w.replaced)
+	default:
+		description = "Library created"
+	}
+	if w.opts.Curator != "" {
+		description += " by " + w.opts.Curator
+	}
+	return description
+}
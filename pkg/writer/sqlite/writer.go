@@ -2,13 +2,18 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
+	"os"
 	"time"
 
 	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/sqliteuri"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -17,43 +22,199 @@ const (
 	headerDateFormat = "2006-01-02"
 	// Date format for MaintenanceTable (space-separated, matches R implementation)
 	maintenanceDateFormat = "2006 01 02"
+	// Date format for SpectrumTable.CreationDate
+	spectrumDateFormat = "2006-01-02 15:04:05"
+
+	// headerVersion is the mzVault schema version written to HeaderTable
+	headerVersion = 5
+	// spectrumVersion is the version of newly created spectra
+	spectrumVersion = 1
 )
 
+// Options holds the library metadata shown by mzVault
+type Options struct {
+	Description  string `json:"description"`   // HeaderTable.Description
+	Company      string `json:"company"`       // HeaderTable.Company
+	ReadOnly     bool   `json:"read_only"`     // HeaderTable.ReadOnly
+	Curator      string `json:"curator"`       // SpectrumTable.Curator
+	CurationType string `json:"curation_type"` // SpectrumTable.CurationType
+
+	// Mode selects how an existing database is updated
+	Mode Mode `json:"-"`
+	// Overwrite allows replacing an existing file in ModeCreate
+	Overwrite bool `json:"-"`
+	// BatchSize is the number of spectra committed per transaction
+	// (default DefaultBatchSize)
+	BatchSize int `json:"-"`
+	// Checkpoint builds the library in PartialPath(outputPath), stores a
+	// checkpoint with every commit and keeps the partial output if the
+	// writer is closed without Finalize
+	Checkpoint bool `json:"-"`
+	// Resume continues the partial output from its checkpoint; it implies
+	// Checkpoint
+	Resume bool `json:"-"`
+}
+
+// ErrOutputExists is returned when creating a library over an existing
+// file without Options.Overwrite
+var ErrOutputExists = atomicfile.ErrExists
+
 // Writer handles writing spectra to SQLite database files
 type Writer struct {
-	db           *sql.DB
-	outputPath   string
-	compoundStmt *sql.Stmt
-	spectrumStmt *sql.Stmt
-	compoundID   int
+	db             *sql.DB
+	out            *atomicfile.File // Library being built, committed by Finalize
+	closed         bool
+	opts           Options
+	created        time.Time
+	compoundStmt   *sql.Stmt
+	spectrumStmt   *sql.Stmt
+	compoundID     int
+	written        int // Spectra written by this writer
+	replaced       int // Existing spectra replaced in upsert mode
+	batch          *Batch
+	checkpoint     *Checkpoint // Last checkpoint stored
+	checkpointFunc func() (Checkpoint, error)
+	resumed        *Checkpoint
+
+	// Upsert statements
+	findStmt           *sql.Stmt
+	deleteCompoundStmt *sql.Stmt
+	deleteSpectrumStmt *sql.Stmt
 }
 
-// NewWriter creates a new SQLite writer
+// NewWriter creates a new SQLite writer with empty library metadata
 func NewWriter(outputPath string) (*Writer, error) {
-	db, err := sql.Open("sqlite3", outputPath)
+	return NewWriterWithOptions(outputPath, Options{})
+}
+
+// NewWriterWithOptions creates a new SQLite writer with library metadata.
+// The library is built in a temporary file next to outputPath, which only
+// replaces outputPath when Finalize succeeds.
+func NewWriterWithOptions(outputPath string, opts Options) (*Writer, error) {
+	_, statErr := os.Stat(outputPath)
+	exists := statErr == nil
+	if exists && opts.Mode == ModeCreate && !opts.Overwrite {
+		return nil, fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+	}
+	if opts.Resume {
+		opts.Checkpoint = true
+	}
+
+	out, err := openTempFile(outputPath, opts)
 	if err != nil {
+		return nil, err
+	}
+
+	// Updates start from a copy of the existing library
+	if exists && opts.Mode != ModeCreate && !opts.Resume {
+		if err := copyFile(outputPath, out.TempPath()); err != nil {
+			out.Abort()
+			return nil, fmt.Errorf("failed to copy existing database: %w", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", sqliteuri.File(out.TempPath()))
+	if err != nil {
+		out.Abort()
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	w := &Writer{
 		db:         db,
-		outputPath: outputPath,
+		out:        out,
+		opts:       opts,
+		created:    time.Now(),
 		compoundID: 1,
 	}
+	w.batch = NewBatch(db, opts.BatchSize)
+	w.batch.BeforeCommit = w.writeCheckpoint
 
 	if err := w.createTables(); err != nil {
-		db.Close()
+		w.Abort()
 		return nil, err
 	}
 
+	if opts.Checkpoint {
+		if err := w.createCheckpointTable(); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	}
+
+	switch {
+	case opts.Resume:
+		if err := w.loadCheckpoint(); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	case opts.Mode != ModeCreate:
+		if err := w.openExisting(); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	}
+
 	if err := w.prepareStatements(); err != nil {
-		db.Close()
+		w.Abort()
 		return nil, err
 	}
 
 	return w, nil
 }
 
+// openTempFile returns the file the library is built in: the partial output
+// when checkpointing, otherwise a new temporary file. The existing output
+// has already been checked against the mode.
+func openTempFile(outputPath string, opts Options) (*atomicfile.File, error) {
+	if !opts.Checkpoint {
+		out, err := atomicfile.Create(outputPath, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			out.Abort()
+			return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		return out, nil
+	}
+
+	partial := PartialPath(outputPath)
+	_, err := os.Stat(partial)
+	switch {
+	case opts.Resume:
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s does not exist", ErrNoCheckpoint, partial)
+		}
+		return atomicfile.Resume(outputPath, partial), nil
+	case err == nil && !opts.Overwrite:
+		return nil, fmt.Errorf("%w: %s", ErrOutputExists, partial)
+	}
+
+	if err := os.WriteFile(partial, nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create partial output: %w", err)
+	}
+	return atomicfile.Resume(outputPath, partial), nil
+}
+
+// copyFile copies the contents of src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 // createTables creates the required database schema
 func (w *Writer) createTables() error {
 	schema := `
@@ -164,11 +325,31 @@ func (w *Writer) prepareStatements() error {
 		return fmt.Errorf("failed to prepare spectrum statement: %w", err)
 	}
 
+	if w.opts.Mode == ModeUpsert {
+		return w.prepareUpsertStatements()
+	}
+
 	return nil
 }
 
 // WriteSpectrum writes a single spectrum to the database
 func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes a single spectrum to the database. Spectra are
+// committed in batches of Options.BatchSize; if ctx is cancelled the spectrum
+// is not written and ctx.Err() is returned.
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// A full batch is committed before starting the next, so that a
+	// checkpoint set after the previous spectrum is committed with it
+	if err := w.batch.Begin(); err != nil {
+		return err
+	}
+
 	// Ensure peaks are sorted
 	if !spec.ArePeaksSorted() {
 		spec.SortPeaks()
@@ -180,24 +361,37 @@ func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
 		tag = fmt.Sprintf("%s massOffset:%.6f", tag, spec.MassOffset)
 	}
 
+	// Peptides reuse the Formula column for modifications
+	formula := spec.ModString()
+	if spec.IsSmallMolecule() {
+		formula = spec.Formula
+	}
+
+	// Replace an existing spectrum for the same precursor
+	if w.opts.Mode == ModeUpsert {
+		if err := w.removeExisting(ctx, spec, formula); err != nil {
+			return err
+		}
+	}
+
 	// Insert into CompoundTable
-	_, err := w.compoundStmt.Exec(
+	_, err := w.batch.Stmt(w.compoundStmt).ExecContext(ctx,
 		w.compoundID,       // CompoundId
-		spec.ModString(),   // Formula (reuse for mods)
+		formula,            // Formula
 		spec.Name(),        // Name
 		"",                 // Synonyms
 		tag,                // Tag
 		spec.Sequence,      // Sequence
-		"",                 // CASId
+		spec.CASNumber,     // CASId
 		"",                 // ChemSpiderId
 		"",                 // HMDBId
 		"",                 // KEGGId
-		"",                 // PubChemId
+		spec.PubChemID,     // PubChemId
 		"",                 // Structure
 		nil,                // mzCloudId
 		spec.CompoundClass, // CompoundClass
-		"",                 // SmilesDescription
-		"",                 // InChiKey
+		spec.SMILES,        // SmilesDescription
+		spec.InChIKey,      // InChiKey
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert compound: %w", err)
@@ -207,8 +401,11 @@ func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
 	mzBlob := encodePeaksFloat64(spec.Peaks, true)   // m/z values
 	intBlob := encodePeaksFloat64(spec.Peaks, false) // intensity values
 
-	// Calculate neutral mass
-	neutralMass := core.CalculateNeutralMass(spec.Sequence, spec.Modifications)
+	// Calculate neutral mass from the sequence or formula
+	var neutralMass interface{} = nil
+	if mass, ok := spec.NeutralMass(); ok {
+		neutralMass = mass
+	}
 
 	// Handle optional retention time
 	var rt interface{} = nil
@@ -216,6 +413,11 @@ func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
 		rt = *spec.RetentionTime
 	}
 
+	ionization := spec.IonizationMode
+	if ionization == "" {
+		ionization = "ESI"
+	}
+
 	// Handle optional collision energy
 	var ce interface{} = nil
 	if spec.CollisionEnergy != nil {
@@ -223,42 +425,45 @@ func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
 	}
 
 	// Insert into SpectrumTable
-	_, err = w.spectrumStmt.Exec(
-		w.compoundID,           // SpectrumId (same as CompoundId for 1:1 mapping)
-		w.compoundID,           // CompoundId
-		"",                     // mzCloudURL
-		"",                     // ScanFilter
-		rt,                     // RetentionTime
-		0,                      // ScanNumber
-		spec.PrecursorMZ,       // PrecursorMass
-		neutralMass,            // NeutralMass
-		ce,                     // CollisionEnergy
-		"+",                    // Polarity
-		spec.FragmentationMode, // FragmentationMode
-		"ESI",                  // IonizationMode
-		spec.MassAnalyzer,      // MassAnalyzer
-		spec.Instrument,        // InstrumentName
-		"",                     // InstrumentOperator
-		"",                     // RawFileURL
-		mzBlob,                 // blobMass
-		intBlob,                // blobIntensity
-		nil,                    // blobAccuracy
-		nil,                    // blobResolution
-		nil,                    // blobNoises
-		nil,                    // blobFlags
-		nil,                    // blobTopPeaks
-		nil,                    // Version
-		nil,                    // CreationDate
-		"",                     // Curator
-		"",                     // CurationType
-		"",                     // PrecursorIonType
-		"",                     // Accession
+	_, err = w.batch.Stmt(w.spectrumStmt).ExecContext(ctx,
+		w.compoundID,                         // SpectrumId (same as CompoundId for 1:1 mapping)
+		w.compoundID,                         // CompoundId
+		"",                                   // mzCloudURL
+		"",                                   // ScanFilter
+		rt,                                   // RetentionTime
+		0,                                    // ScanNumber
+		spec.PrecursorMZ,                     // PrecursorMass
+		neutralMass,                          // NeutralMass
+		ce,                                   // CollisionEnergy
+		spec.IonPolarity(),                   // Polarity
+		spec.FragmentationMode,               // FragmentationMode
+		ionization,                           // IonizationMode
+		spec.MassAnalyzer,                    // MassAnalyzer
+		spec.Instrument,                      // InstrumentName
+		"",                                   // InstrumentOperator
+		spec.SourceFile,                      // RawFileURL
+		mzBlob,                               // blobMass
+		intBlob,                              // blobIntensity
+		nil,                                  // blobAccuracy
+		nil,                                  // blobResolution
+		nil,                                  // blobNoises
+		nil,                                  // blobFlags
+		nil,                                  // blobTopPeaks
+		spectrumVersion,                      // Version
+		w.created.Format(spectrumDateFormat), // CreationDate
+		w.opts.Curator,                       // Curator
+		w.opts.CurationType,                  // CurationType
+		spec.PrecursorIonType(),              // PrecursorIonType
+		"",                                   // Accession
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert spectrum: %w", err)
 	}
 
 	w.compoundID++
+	w.written++
+
+	w.batch.Add()
 	return nil
 }
 
@@ -277,43 +482,91 @@ func encodePeaksFloat64(peaks []core.Peak, useMZ bool) []byte {
 	return buf
 }
 
-// Finalize writes the header and maintenance tables and closes the database
+// Finalize writes the header and maintenance tables, closes the database
+// and moves it to the output path
 func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := w.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	if w.opts.Checkpoint {
+		if err := w.dropCheckpoint(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if w.opts.Mode == ModeUpsert {
+		if err := w.dropUpsertIndexes(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+
+	now := time.Now()
+
 	// Write HeaderTable
-	_, err := w.db.Exec(`
-		INSERT INTO HeaderTable (version, CreationDate, LastModifiedDate, Description, Company, ReadOnly, UserAccess, PartialEdits)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, 5, time.Now().Format(headerDateFormat), time.Now().Format(headerDateFormat), "", "", false, "", false)
-	if err != nil {
-		return fmt.Errorf("failed to insert header: %w", err)
+	if err := w.writeHeader(now); err != nil {
+		w.Abort()
+		return err
 	}
 
 	// Write MaintenanceTable
-	_, err = w.db.Exec(`
+	_, err := w.db.Exec(`
 		INSERT INTO MaintenanceTable (CreationDate, NoofCompoundsModified, Description)
 		VALUES (?, ?, ?)
-	`, time.Now().Format(maintenanceDateFormat), nil, "")
+	`, now.Format(maintenanceDateFormat), w.written, w.maintenanceDescription())
 	if err != nil {
+		w.Abort()
 		return fmt.Errorf("failed to insert maintenance: %w", err)
 	}
 
-	// Close prepared statements
-	if w.compoundStmt != nil {
-		w.compoundStmt.Close()
-	}
-	if w.spectrumStmt != nil {
-		w.spectrumStmt.Close()
-	}
-
 	// Close database
-	if err := w.db.Close(); err != nil {
+	if err := w.closeDB(); err != nil {
+		w.out.Abort()
 		return fmt.Errorf("failed to close database: %w", err)
 	}
 
-	return nil
+	// Replace the output file in one step
+	return w.out.Commit()
 }
 
-// Close closes the database connection (alias for Finalize)
+// Close finalizes the writer (alias for Finalize)
 func (w *Writer) Close() error {
 	return w.Finalize()
 }
+
+// Abort rolls back the open transaction and deletes the library being
+// built, so an existing output is left as it was. With Options.Checkpoint
+// the partial output is kept for resuming. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	err := w.batch.Rollback()
+	if dbErr := w.closeDB(); err == nil {
+		err = dbErr
+	}
+	if w.opts.Checkpoint {
+		return err
+	}
+	if rmErr := w.out.Abort(); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// closeDB closes prepared statements and the database connection
+func (w *Writer) closeDB() error {
+	w.closed = true
+
+	for _, stmt := range []*sql.Stmt{w.compoundStmt, w.spectrumStmt, w.findStmt, w.deleteCompoundStmt, w.deleteSpectrumStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+
+	return w.db.Close()
+}
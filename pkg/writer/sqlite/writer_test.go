@@ -0,0 +1,303 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/internal/sqltest"
+)
+
+// testPeptide returns a peptide spectrum with one peak at the given m/z
+func testPeptide(seq string, charge int, peakMZ float64, mods ...core.Modification) *core.Spectrum {
+	return &core.Spectrum{
+		Sequence:          seq,
+		Charge:            charge,
+		PrecursorMZ:       500,
+		Peaks:             []core.Peak{{MZ: peakMZ, Intensity: 100}},
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Modifications:     mods,
+	}
+}
+
+// writeLibrary writes spectra to path with the given options
+func writeLibrary(t *testing.T, path string, opts Options, specs ...*core.Spectrum) *Writer {
+	t.Helper()
+	w, err := NewWriterWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	return w
+}
+
+func TestAppendContinuesIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	writeLibrary(t, path, Options{}, testPeptide("PEPTIDEK", 2, 200), testPeptide("AAAK", 2, 150))
+
+	// A spectrum added outside DBKey with a higher ID
+	db := sqltest.Open(t, path)
+	if _, err := db.Exec(`INSERT INTO SpectrumTable (SpectrumId, CompoundId) VALUES (7, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	w := writeLibrary(t, path, Options{Mode: ModeAppend, Description: "Appended"}, testPeptide("LLLK", 2, 300))
+	if w.Written() != 1 {
+		t.Errorf("Written() = %d, want 1", w.Written())
+	}
+
+	db = sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT CompoundId FROM CompoundTable WHERE Name = 'LLLK/2'`); got != 8 {
+		t.Errorf("appended CompoundId = %d, want 8", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT SpectrumId FROM SpectrumTable WHERE CompoundId = 8`); got != 8 {
+		t.Errorf("appended SpectrumId = %d, want 8", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM CompoundTable`); got != 3 {
+		t.Errorf("compounds = %d, want 3", got)
+	}
+
+	// The header is updated in place and each write adds a maintenance row
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM HeaderTable`); got != 1 {
+		t.Errorf("header rows = %d, want 1", got)
+	}
+	var description string
+	if err := db.QueryRow(`SELECT Description FROM HeaderTable`).Scan(&description); err != nil {
+		t.Fatal(err)
+	}
+	if description != "Appended" {
+		t.Errorf("header description = %q, want Appended", description)
+	}
+	rows, err := db.Query(`SELECT NoofCompoundsModified, Description FROM MaintenanceTable ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []struct {
+		count       int
+		description string
+	}{
+		{2, "Library created"},
+		{1, "Appended 1 compounds"},
+	}
+	i := 0
+	for ; rows.Next(); i++ {
+		var count int
+		var desc string
+		if err := rows.Scan(&count, &desc); err != nil {
+			t.Fatal(err)
+		}
+		if i < len(want) && (count != want[i].count || desc != want[i].description) {
+			t.Errorf("maintenance row %d = (%d, %q), want (%d, %q)", i, count, desc, want[i].count, want[i].description)
+		}
+	}
+	if i != len(want) {
+		t.Errorf("maintenance rows = %d, want %d", i, len(want))
+	}
+}
+
+func TestUpsertReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	oxidation := core.Modification{Mass: 15.994915, Position: 4}
+	carbamidomethyl := core.Modification{Mass: 57.021464, Position: 1}
+	writeLibrary(t, path, Options{},
+		testPeptide("PCPTMIDEK", 2, 200, oxidation, carbamidomethyl),
+		testPeptide("PCPTMIDEK", 3, 200, oxidation, carbamidomethyl),
+	)
+
+	// The same precursor with its modifications listed in the other order
+	// replaces the stored spectrum; a new precursor is added
+	w := writeLibrary(t, path, Options{Mode: ModeUpsert},
+		testPeptide("PCPTMIDEK", 2, 250, carbamidomethyl, oxidation),
+		testPeptide("AAAK", 2, 150),
+	)
+	if w.Replaced() != 1 {
+		t.Errorf("Replaced() = %d, want 1", w.Replaced())
+	}
+
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM CompoundTable`); got != 3 {
+		t.Errorf("compounds = %d, want 3", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); got != 3 {
+		t.Errorf("spectra = %d, want 3", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM CompoundTable WHERE Name = 'PCPTMIDEK/2'`); got != 1 {
+		t.Errorf("PCPTMIDEK/2 compounds = %d, want 1", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT CompoundId FROM CompoundTable WHERE Name = 'PCPTMIDEK/2'`); got != 3 {
+		t.Errorf("replaced CompoundId = %d, want 3", got)
+	}
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'DBKey%'`); got != 0 {
+		t.Errorf("upsert indexes left in library = %d, want 0", got)
+	}
+
+	var desc string
+	if err := db.QueryRow(`SELECT Description FROM MaintenanceTable ORDER BY rowid DESC LIMIT 1`).Scan(&desc); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Upserted 2 compounds (1 replaced, 1 added)"; desc != want {
+		t.Errorf("maintenance description = %q, want %q", desc, want)
+	}
+}
+
+func TestUpsertMatchesUnsortedModString(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	writeLibrary(t, path, Options{}, testPeptide("PCPTMIDEK", 2, 200))
+
+	// Libraries written by earlier versions stored modifications in input
+	// order
+	db := sqltest.Open(t, path)
+	if _, err := db.Exec(`UPDATE CompoundTable SET Formula = '15.994915@4;57.021464@1'`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	w := writeLibrary(t, path, Options{Mode: ModeUpsert}, testPeptide("PCPTMIDEK", 2, 250,
+		core.Modification{Mass: 57.021464, Position: 1},
+		core.Modification{Mass: 15.994915, Position: 4},
+	))
+	if w.Replaced() != 1 {
+		t.Errorf("Replaced() = %d, want 1", w.Replaced())
+	}
+}
+
+func TestSortModString(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"15.994915@4", "15.994915@4"},
+		{"15.994915@4;57.021464@1", "57.021464@1;15.994915@4"},
+		{"229.162932@-1;57.021464@3", "229.162932@-1;57.021464@3"},
+	}
+	for _, tt := range tests {
+		if got := sortModString(tt.input); got != tt.want {
+			t.Errorf("sortModString(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// tempFiles lists the temporary files left in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.db")
+
+	w, err := NewWriter(path)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2, 200)); err != nil {
+		t.Fatalf("WriteSpectrum() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("output exists before Finalize")
+	}
+	if got := tempFiles(t, dir); len(got) != 1 {
+		t.Errorf("temporary files while writing = %v, want 1", got)
+	}
+
+	// Close finalizes like Finalize
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := tempFiles(t, dir); len(got) != 0 {
+		t.Errorf("temporary files after Close = %v, want none", got)
+	}
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); got != 1 {
+		t.Errorf("spectra = %d, want 1", got)
+	}
+
+	// Abort does nothing after the output was finalized
+	if err := w.Abort(); err != nil {
+		t.Errorf("Abort() after Close error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("output removed by Abort after Close: %v", err)
+	}
+}
+
+func TestRefuseOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewWriterWithOptions(path, Options{}); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("NewWriterWithOptions() error = %v, want ErrOutputExists", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "existing" {
+		t.Errorf("existing output changed to %q", data)
+	}
+
+	writeLibrary(t, path, Options{Overwrite: true}, testPeptide("PEPTIDEK", 2, 200))
+	db := sqltest.Open(t, path)
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); got != 1 {
+		t.Errorf("spectra after overwrite = %d, want 1", got)
+	}
+}
+
+func TestAbortKeepsExistingOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.db")
+	if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriterWithOptions(path, Options{Overwrite: true, BatchSize: 1})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions() error = %v", err)
+	}
+	for _, seq := range []string{"PEPTIDEK", "AAAK", "LLLK"} {
+		if err := w.WriteSpectrum(testPeptide(seq, 2, 200)); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "existing" {
+		t.Errorf("existing output changed to %q", data)
+	}
+	if got := tempFiles(t, dir); len(got) != 0 {
+		t.Errorf("temporary files after Abort = %v, want none", got)
+	}
+	if err := w.WriteSpectrum(testPeptide("PEPTIDEK", 2, 200)); err == nil {
+		t.Error("WriteSpectrum() after Abort succeeded")
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	writeLibrary(t, "library.db", Options{}, testPeptide("PEPTIDEK", 2, 200))
+	writeLibrary(t, "library.db", Options{Mode: ModeAppend}, testPeptide("AAAK", 2, 150))
+
+	db := sqltest.Open(t, filepath.Join(dir, "library.db"))
+	if got := sqltest.QueryInt(t, db, `SELECT COUNT(*) FROM SpectrumTable`); got != 2 {
+		t.Errorf("spectra = %d, want 2", got)
+	}
+}
@@ -0,0 +1,317 @@
+package transition
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Layout selects the columns and notation of a transition list
+type Layout string
+
+// Supported layouts
+const (
+	LayoutSkyline     Layout = "skyline"
+	LayoutDIANN       Layout = "diann"
+	LayoutSpectronaut Layout = "spectronaut"
+	LayoutOpenSWATH   Layout = "openswath"
+)
+
+// Layouts lists the supported layouts
+var Layouts = []Layout{LayoutSkyline, LayoutDIANN, LayoutSpectronaut, LayoutOpenSWATH}
+
+// ParseLayout parses a layout name, case-insensitively
+func ParseLayout(s string) (Layout, error) {
+	name := Layout(strings.ToLower(strings.TrimSpace(s)))
+	for _, l := range Layouts {
+		if l == name {
+			return l, nil
+		}
+	}
+	names := make([]string, len(Layouts))
+	for i, l := range Layouts {
+		names[i] = string(l)
+	}
+	return "", fmt.Errorf("invalid layout '%s', must be one of %s", s, strings.Join(names, ", "))
+}
+
+// transition is one row of a transition list
+type transition struct {
+	spec       *core.Spectrum
+	peak       core.Peak
+	ion        core.IonAnnotation
+	intensity  float64 // Relative to the most intense selected transition
+	modified   string  // Modified sequence in the layout notation
+	group, row int     // 0-based precursor and transition numbers
+}
+
+// column is a named column and its value for a transition
+type column struct {
+	name  string
+	value func(t *transition) string
+}
+
+// layoutSpec describes how a layout is written
+type layoutSpec struct {
+	delim          rune
+	columns        []column
+	intensityScale float64 // Intensity of the most intense transition
+	losses         bool    // Whether neutral loss transitions can be listed
+	sequence       func(db *core.ModDatabase, spec *core.Spectrum) string
+}
+
+// layouts describes each layout. The DIA-NN, Spectronaut and OpenSWATH
+// columns use names read by the TSV library reader, so those lists can be
+// converted back.
+var layouts = map[Layout]*layoutSpec{
+	LayoutSkyline: {
+		delim: ',',
+		columns: []column{
+			{"Peptide Modified Sequence", modifiedSequence},
+			{"Precursor m/z", precursorMZ},
+			{"Precursor Charge", precursorCharge},
+			{"Product m/z", productMZ},
+			{"Product Charge", productCharge},
+			{"Fragment Ion", func(t *transition) string { return t.ion.Type + strconv.Itoa(t.ion.Number) }},
+			{"Library Intensity", intensity},
+			{"iRT", retentionTime},
+			{"Explicit Collision Energy", collisionEnergy},
+		},
+		intensityScale: 100,
+		sequence:       massSequence,
+	},
+	LayoutDIANN: {
+		delim: '\t',
+		columns: []column{
+			{"PrecursorMz", precursorMZ},
+			{"ModifiedPeptide", modifiedSequence},
+			{"StrippedPeptide", sequence},
+			{"PrecursorCharge", precursorCharge},
+			{"Tr_recalibrated", retentionTime},
+			{"FragmentMz", productMZ},
+			{"RelativeIntensity", intensity},
+			{"FragmentType", fragmentType},
+			{"FragmentSeriesNumber", fragmentNumber},
+			{"FragmentCharge", productCharge},
+			{"FragmentLossType", lossType},
+			{"CollisionEnergy", collisionEnergy},
+		},
+		intensityScale: 1,
+		losses:         true,
+		sequence: func(db *core.ModDatabase, spec *core.Spectrum) string {
+			return unimodSequence(db, spec, "", "")
+		},
+	},
+	LayoutSpectronaut: {
+		delim: '\t',
+		columns: []column{
+			{"PrecursorMz", precursorMZ},
+			{"ModifiedPeptide", modifiedSequence},
+			{"StrippedPeptide", sequence},
+			{"PrecursorCharge", precursorCharge},
+			{"iRT", retentionTime},
+			{"FragmentMz", productMZ},
+			{"RelativeIntensity", intensity},
+			{"FragmentType", fragmentType},
+			{"FragmentNumber", fragmentNumber},
+			{"FragmentCharge", productCharge},
+			{"FragmentLossType", lossType},
+			{"CollisionEnergy", collisionEnergy},
+		},
+		intensityScale: 100,
+		losses:         true,
+		sequence:       spectronautSequence,
+	},
+	LayoutOpenSWATH: {
+		delim: '\t',
+		columns: []column{
+			{"TransitionGroupId", func(t *transition) string { return fmt.Sprintf("%d_%s_%d", t.group, t.spec.Sequence, t.spec.Charge) }},
+			{"TransitionId", func(t *transition) string {
+				return fmt.Sprintf("%d_%s_%d_%d", t.group, t.spec.Sequence, t.spec.Charge, t.row)
+			}},
+			{"PrecursorMz", precursorMZ},
+			{"ProductMz", productMZ},
+			{"LibraryIntensity", intensity},
+			{"NormalizedRetentionTime", retentionTime},
+			{"PeptideSequence", sequence},
+			{"ModifiedPeptideSequence", modifiedSequence},
+			{"ProteinId", func(t *transition) string { return strings.Join(t.spec.Proteins, ";") }},
+			{"PrecursorCharge", precursorCharge},
+			{"ProductCharge", productCharge},
+			{"FragmentType", fragmentType},
+			{"FragmentSeriesNumber", fragmentNumber},
+			{"Annotation", func(t *transition) string { return t.ion.String() }},
+			{"CollisionEnergy", collisionEnergy},
+			{"Decoy", func(t *transition) string { return boolFlag(t.spec.Decoy) }},
+		},
+		intensityScale: 100,
+		losses:         true,
+		sequence:       UniModSequence,
+	},
+}
+
+func modifiedSequence(t *transition) string { return t.modified }
+func sequence(t *transition) string         { return t.spec.Sequence }
+func precursorMZ(t *transition) string      { return formatFloat(t.spec.PrecursorMZ, 6) }
+func precursorCharge(t *transition) string  { return strconv.Itoa(t.spec.Charge) }
+func productMZ(t *transition) string        { return formatFloat(t.peak.MZ, 6) }
+func productCharge(t *transition) string    { return strconv.Itoa(t.ion.Charge) }
+func fragmentType(t *transition) string     { return t.ion.Type }
+func fragmentNumber(t *transition) string   { return strconv.Itoa(t.ion.Number) }
+func intensity(t *transition) string        { return formatFloat(t.intensity, 4) }
+
+func retentionTime(t *transition) string {
+	if t.spec.RetentionTime == nil {
+		return ""
+	}
+	return formatFloat(*t.spec.RetentionTime, 4)
+}
+
+func collisionEnergy(t *transition) string {
+	if t.spec.CollisionEnergy == nil {
+		return ""
+	}
+	return formatFloat(*t.spec.CollisionEnergy, 2)
+}
+
+// boolFlag writes a flag as 1 or 0
+func boolFlag(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// lossType names a neutral loss as DIA-NN and Spectronaut do
+func lossType(t *transition) string {
+	switch t.ion.Loss {
+	case "":
+		return "noloss"
+	case "17":
+		return "NH3"
+	case "18":
+		return "H2O"
+	case "64":
+		return "CH4SO"
+	case "98":
+		return "H3PO4"
+	}
+	return t.ion.Loss
+}
+
+// formatFloat formats a value with at most prec decimals
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(core.RoundFloat(v, prec), 'f', -1, 64)
+}
+
+// sortedMods returns the modifications of a spectrum in sequence order
+func sortedMods(spec *core.Spectrum) []core.Modification {
+	mods := make([]core.Modification, len(spec.Modifications))
+	copy(mods, spec.Modifications)
+	sort.SliceStable(mods, func(i, j int) bool {
+		return mods[i].Position < mods[j].Position
+	})
+	return mods
+}
+
+// modName returns the database name of a modification, looking names that
+// are not in the database up by mass, or "" if unknown
+func modName(db *core.ModDatabase, mod core.Modification) string {
+	if _, ok := db.GetMass(mod.Name); ok {
+		return mod.Name
+	}
+	if name, ok := db.NameForMass(mod.Mass, 0.01); ok {
+		return name
+	}
+	return ""
+}
+
+// writeModified writes a sequence with a bracketed token after each modified
+// residue, before the sequence for N-terminal and after it for C-terminal
+// modifications, each prefixed with the terminus marker
+func writeModified(spec *core.Spectrum, nterm, cterm string, token func(mod core.Modification, site string) string) string {
+	var b strings.Builder
+	seq := spec.Sequence
+	next := 0
+	for _, mod := range sortedMods(spec) {
+		switch {
+		case mod.Position < 0:
+			b.WriteString(nterm)
+			b.WriteString(token(mod, "N-term"))
+		case mod.Position >= len(seq):
+			b.WriteString(seq[next:])
+			next = len(seq)
+			b.WriteString(cterm)
+			b.WriteString(token(mod, "C-term"))
+		default:
+			if mod.Position >= next {
+				b.WriteString(seq[next : mod.Position+1])
+				next = mod.Position + 1
+			}
+			b.WriteString(token(mod, seq[mod.Position:mod.Position+1]))
+		}
+	}
+	b.WriteString(seq[next:])
+	return b.String()
+}
+
+// massSequence writes the summed mass shift of each residue in brackets, as
+// Skyline does, with terminal modifications on the terminal residues:
+// "PEPTM[+15.9949]IDE"
+func massSequence(db *core.ModDatabase, spec *core.Spectrum) string {
+	seq := spec.Sequence
+	shifts := make([]float64, len(seq))
+	for _, mod := range spec.Modifications {
+		pos := mod.Position
+		if pos < 0 {
+			pos = 0
+		}
+		if pos >= len(seq) {
+			pos = len(seq) - 1
+		}
+		shifts[pos] += mod.Mass
+	}
+
+	var b strings.Builder
+	for i := range seq {
+		b.WriteByte(seq[i])
+		if shifts[i] != 0 {
+			fmt.Fprintf(&b, "[%+.4f]", shifts[i])
+		}
+	}
+	return b.String()
+}
+
+// unimodSequence writes UniMod accessions, e.g. "(UniMod:1)PEPTM(UniMod:35)IDE"
+// for DIA-NN. Modifications without an accession are written as signed mass
+// shifts in brackets.
+func unimodSequence(db *core.ModDatabase, spec *core.Spectrum, nterm, cterm string) string {
+	return writeModified(spec, nterm, cterm, func(mod core.Modification, site string) string {
+		if id, ok := db.UniModAccession(modName(db, mod), mod.Mass); ok {
+			return fmt.Sprintf("(UniMod:%d)", id)
+		}
+		return fmt.Sprintf("[%+.4f]", mod.Mass)
+	})
+}
+
+// UniModSequence writes a modified sequence in OpenSWATH notation, with
+// UniMod accessions and terminal modifications marked by a period, e.g.
+// ".(UniMod:1)PEPTM(UniMod:35)IDE"
+func UniModSequence(db *core.ModDatabase, spec *core.Spectrum) string {
+	return unimodSequence(db, spec, ".", ".")
+}
+
+// spectronautSequence writes modification names with their site, e.g.
+// "_[Acetyl (N-term)]M[Oxidation (M)]PEPTIDE_". Modifications without a
+// name are written as signed mass shifts.
+func spectronautSequence(db *core.ModDatabase, spec *core.Spectrum) string {
+	return "_" + writeModified(spec, "", "", func(mod core.Modification, site string) string {
+		if name := modName(db, mod); name != "" {
+			return fmt.Sprintf("[%s (%s)]", name, site)
+		}
+		return fmt.Sprintf("[%+.4f]", mod.Mass)
+	}) + "_"
+}
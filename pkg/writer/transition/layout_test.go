@@ -0,0 +1,80 @@
+package transition
+
+import (
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// oxidized returns PEPTMIDEK with an oxidized methionine and any further
+// modifications
+func oxidized(mods ...core.Modification) *core.Spectrum {
+	return &core.Spectrum{
+		Sequence:      "PEPTMIDEK",
+		Charge:        2,
+		Modifications: append([]core.Modification{{Mass: 15.994915, Position: 4, Name: "Oxidation"}}, mods...),
+	}
+}
+
+func TestModifiedSequence(t *testing.T) {
+	acetyl := core.Modification{Mass: 42.010565, Position: -1, Name: "Acetyl"}
+	amidated := core.Modification{Mass: -0.984016, Position: 9, Name: "Amidated"}
+	unknown := core.Modification{Mass: 12.3456, Position: 2}
+
+	tests := []struct {
+		name   string
+		layout Layout
+		spec   *core.Spectrum
+		want   string
+	}{
+		{"skyline", LayoutSkyline, oxidized(), "PEPTM[+15.9949]IDEK"},
+		{"skyline terminal on residues", LayoutSkyline, oxidized(acetyl), "P[+42.0106]EPTM[+15.9949]IDEK"},
+		{"skyline unnamed", LayoutSkyline, oxidized(unknown), "PEP[+12.3456]TM[+15.9949]IDEK"},
+		{"diann", LayoutDIANN, oxidized(), "PEPTM(UniMod:35)IDEK"},
+		{"diann n-term", LayoutDIANN, oxidized(acetyl), "(UniMod:1)PEPTM(UniMod:35)IDEK"},
+		{"diann unnamed", LayoutDIANN, oxidized(unknown), "PEP[+12.3456]TM(UniMod:35)IDEK"},
+		{"spectronaut", LayoutSpectronaut, oxidized(), "_PEPTM[Oxidation (M)]IDEK_"},
+		{"spectronaut n-term", LayoutSpectronaut, oxidized(acetyl), "_[Acetyl (N-term)]PEPTM[Oxidation (M)]IDEK_"},
+		{"spectronaut unnamed", LayoutSpectronaut, oxidized(unknown), "_PEP[+12.3456]TM[Oxidation (M)]IDEK_"},
+		{"openswath", LayoutOpenSWATH, oxidized(), "PEPTM(UniMod:35)IDEK"},
+		{"openswath n-term", LayoutOpenSWATH, oxidized(acetyl), ".(UniMod:1)PEPTM(UniMod:35)IDEK"},
+		{"openswath c-term", LayoutOpenSWATH, oxidized(amidated), "PEPTM(UniMod:35)IDEK.(UniMod:2)"},
+		{"unmodified", LayoutOpenSWATH, &core.Spectrum{Sequence: "PEPTIDEK"}, "PEPTIDEK"},
+	}
+
+	db := core.DefaultModDatabase()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := layouts[tt.layout].sequence(db, tt.spec); got != tt.want {
+				t.Errorf("sequence() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Layout
+		wantErr bool
+	}{
+		{"skyline", LayoutSkyline, false},
+		{"DIANN", LayoutDIANN, false},
+		{" Spectronaut ", LayoutSpectronaut, false},
+		{"openswath", LayoutOpenSWATH, false},
+		{"dia-nn", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLayout(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLayout(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLayout(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
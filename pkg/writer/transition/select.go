@@ -0,0 +1,103 @@
+package transition
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// Selection chooses the fragments listed as transitions of a precursor
+type Selection struct {
+	// TopN is the number of most intense transitions per precursor (0 = all)
+	TopN int
+	// IonTypes are the ion series to list, e.g. b and y (nil = all)
+	IonTypes []string
+	// MinIonNumber excludes shorter fragments, e.g. 3 excludes b1, b2, y1 and y2
+	MinIonNumber int
+	// MinMZ and MaxMZ limit fragment m/z (0 = no limit)
+	MinMZ, MaxMZ float64
+	// PrecursorWindow excludes fragments within this many m/z of the
+	// precursor m/z (0 = keep)
+	PrecursorWindow float64
+}
+
+// DefaultSelection lists the six most intense b and y ions from b3 and y3
+// upwards
+var DefaultSelection = Selection{
+	TopN:         6,
+	IonTypes:     []string{"b", "y"},
+	MinIonNumber: 3,
+}
+
+// Fragment is an annotated fragment selected as a transition
+type Fragment struct {
+	Peak      core.Peak
+	Ion       core.IonAnnotation
+	Intensity float64 // Relative to the most intense selected fragment
+}
+
+// Select returns the most intense annotated fragments of a peptide spectrum
+// allowed by the selection, most intense first. Neutral loss fragments are
+// only selected with losses.
+func (s Selection) Select(spec *core.Spectrum, losses bool) []Fragment {
+	if spec.Sequence == "" {
+		return nil
+	}
+
+	var selected []Fragment
+	for _, peak := range spec.Peaks {
+		ion, err := core.ParseAnnotation(peak.Annotation)
+		if err != nil || (ion.Loss != "" && !losses) || !s.keep(spec, peak, ion) {
+			continue
+		}
+		selected = append(selected, Fragment{Peak: peak, Ion: ion})
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Peak.Intensity > selected[j].Peak.Intensity
+	})
+	if s.TopN > 0 && len(selected) > s.TopN {
+		selected = selected[:s.TopN]
+	}
+
+	if len(selected) > 0 && selected[0].Peak.Intensity > 0 {
+		for i := range selected {
+			selected[i].Intensity = selected[i].Peak.Intensity / selected[0].Peak.Intensity
+		}
+	}
+	return selected
+}
+
+// keep applies the ion type and m/z rules to a fragment
+func (s Selection) keep(spec *core.Spectrum, peak core.Peak, ion core.IonAnnotation) bool {
+	if peak.Intensity <= 0 {
+		return false
+	}
+	if len(s.IonTypes) > 0 {
+		found := false
+		for _, t := range s.IonTypes {
+			if strings.EqualFold(t, ion.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if ion.Number < s.MinIonNumber {
+		return false
+	}
+	if s.MinMZ > 0 && peak.MZ < s.MinMZ {
+		return false
+	}
+	if s.MaxMZ > 0 && peak.MZ > s.MaxMZ {
+		return false
+	}
+	if s.PrecursorWindow > 0 && math.Abs(peak.MZ-spec.PrecursorMZ) <= s.PrecursorWindow {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package transition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// selectSpectrum returns an annotated peptide spectrum with a precursor at
+// m/z 500
+func selectSpectrum() *core.Spectrum {
+	return &core.Spectrum{
+		Sequence:    "PEPTIDEK",
+		Charge:      2,
+		PrecursorMZ: 500,
+		Peaks: []core.Peak{
+			{MZ: 147.11, Intensity: 90, Annotation: "y1"},
+			{MZ: 227.10, Intensity: 30, Annotation: "b2"},
+			{MZ: 324.16, Intensity: 60, Annotation: "b3"},
+			{MZ: 375.20, Intensity: 80, Annotation: "y3"},
+			{MZ: 357.19, Intensity: 70, Annotation: "y3-H2O"},
+			{MZ: 499.50, Intensity: 100, Annotation: "y4"},
+			{MZ: 610.30, Intensity: 50, Annotation: "y5"},
+			{MZ: 305.65, Intensity: 40, Annotation: "y5^2"},
+			{MZ: 420.00, Intensity: 95},
+			{MZ: 700.35, Intensity: 0, Annotation: "y6"},
+		},
+	}
+}
+
+// ions lists the annotations of selected fragments in order
+func ions(fragments []Fragment) string {
+	names := make([]string, len(fragments))
+	for i, f := range fragments {
+		names[i] = f.Ion.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name   string
+		sel    Selection
+		losses bool
+		want   string
+	}{
+		{"all", Selection{}, false, "y4,y1,y3,b3,y5,y5^2,b2"},
+		{"losses", Selection{}, true, "y4,y1,y3,y3-H2O,b3,y5,y5^2,b2"},
+		{"top n", Selection{TopN: 3}, false, "y4,y1,y3"},
+		{"min ion number", Selection{MinIonNumber: 3}, false, "y4,y3,b3,y5,y5^2"},
+		{"ion types", Selection{IonTypes: []string{"B"}}, false, "b3,b2"},
+		{"precursor window", Selection{PrecursorWindow: 1}, false, "y1,y3,b3,y5,y5^2,b2"},
+		{"precursor window edge", Selection{PrecursorWindow: 0.5}, false, "y1,y3,b3,y5,y5^2,b2"},
+		{"precursor window narrow", Selection{PrecursorWindow: 0.4}, false, "y4,y1,y3,b3,y5,y5^2,b2"},
+		{"mz range", Selection{MinMZ: 300, MaxMZ: 600}, false, "y4,y3,b3,y5^2"},
+		{"default", DefaultSelection, false, "y4,y3,b3,y5,y5^2"},
+		{"top n after exclusions", Selection{TopN: 2, MinIonNumber: 3, PrecursorWindow: 1}, false, "y3,b3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ions(tt.sel.Select(selectSpectrum(), tt.losses)); got != tt.want {
+				t.Errorf("Select() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectIntensity(t *testing.T) {
+	got := Selection{TopN: 3, PrecursorWindow: 1}.Select(selectSpectrum(), false)
+	want := []float64{1, 80.0 / 90, 60.0 / 90}
+	if len(got) != len(want) {
+		t.Fatalf("Select() returned %d fragments, want %d", len(got), len(want))
+	}
+	for i, f := range got {
+		if f.Intensity != want[i] {
+			t.Errorf("fragment %d intensity = %v, want %v", i, f.Intensity, want[i])
+		}
+	}
+}
+
+func TestSelectUnannotated(t *testing.T) {
+	spec := selectSpectrum()
+	spec.Sequence = ""
+	if got := DefaultSelection.Select(spec, true); got != nil {
+		t.Errorf("Select() without a sequence = %v, want nil", got)
+	}
+}
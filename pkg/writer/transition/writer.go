@@ -0,0 +1,184 @@
+// Package transition writes spectral libraries as transition lists for
+// targeted (PRM/SRM) and DIA assays, one row per fragment
+package transition
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/atomicfile"
+)
+
+// Options selects the layout and the transitions listed for each precursor
+type Options struct {
+	Layout Layout
+	Selection
+	// Overwrite allows replacing an existing file
+	Overwrite bool
+}
+
+// Writer writes spectra as a transition list. Only annotated fragments are
+// listed; precursors without a selected transition are skipped.
+type Writer struct {
+	out         *atomicfile.File
+	buf         *bufio.Writer
+	csv         *csv.Writer
+	closed      bool
+	opts        Options
+	layout      *layoutSpec
+	modDB       *core.ModDatabase
+	written     int // Precursors written
+	transitions int
+	skipped     int
+}
+
+// NewWriter creates a transition list writer. The list is written to a
+// temporary file next to outputPath, which only replaces outputPath when
+// Finalize succeeds.
+func NewWriter(outputPath string, opts Options, modDB *core.ModDatabase) (*Writer, error) {
+	if modDB == nil {
+		modDB = core.DefaultModDatabase()
+	}
+	if opts.Layout == "" {
+		opts.Layout = LayoutSkyline
+	}
+	layout, ok := layouts[opts.Layout]
+	if !ok {
+		return nil, fmt.Errorf("unsupported layout '%s'", opts.Layout)
+	}
+	out, err := atomicfile.Create(outputPath, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		out:    out,
+		buf:    bufio.NewWriter(out),
+		opts:   opts,
+		layout: layout,
+		modDB:  modDB,
+	}
+	w.csv = csv.NewWriter(w.buf)
+	w.csv.Comma = layout.delim
+
+	header := make([]string, len(layout.columns))
+	for i, col := range layout.columns {
+		header[i] = col.name
+	}
+	if err := w.csv.Write(header); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return w, nil
+}
+
+// WriteSpectrum writes the transitions of a spectrum
+func (w *Writer) WriteSpectrum(spec *core.Spectrum) error {
+	return w.WriteSpectrumContext(context.Background(), spec)
+}
+
+// WriteSpectrumContext writes the transitions of a spectrum unless ctx has
+// been cancelled
+func (w *Writer) WriteSpectrumContext(ctx context.Context, spec *core.Spectrum) error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	selected := w.selectTransitions(spec)
+	if len(selected) == 0 {
+		w.skipped++
+		return nil
+	}
+
+	modified := w.layout.sequence(w.modDB, spec)
+	record := make([]string, len(w.layout.columns))
+	for i := range selected {
+		t := &selected[i]
+		t.modified = modified
+		t.group = w.written
+		t.row = i
+		for j, col := range w.layout.columns {
+			record[j] = col.value(t)
+		}
+		if err := w.csv.Write(record); err != nil {
+			return fmt.Errorf("failed to write transition: %w", err)
+		}
+	}
+
+	w.written++
+	w.transitions += len(selected)
+	return nil
+}
+
+// selectTransitions returns the fragments selected for the layout as
+// transitions, with intensities scaled to the layout
+func (w *Writer) selectTransitions(spec *core.Spectrum) []transition {
+	fragments := w.opts.Select(spec, w.layout.losses)
+	selected := make([]transition, len(fragments))
+	for i, f := range fragments {
+		selected[i] = transition{
+			spec:      spec,
+			peak:      f.Peak,
+			ion:       f.Ion,
+			intensity: f.Intensity * w.layout.intensityScale,
+		}
+	}
+	return selected
+}
+
+// Written returns the number of precursors written
+func (w *Writer) Written() int {
+	return w.written
+}
+
+// Transitions returns the number of transitions written
+func (w *Writer) Transitions() int {
+	return w.transitions
+}
+
+// Skipped returns the number of spectra without any selected transition
+func (w *Writer) Skipped() int {
+	return w.skipped
+}
+
+// Finalize flushes the list and moves it to the output path
+func (w *Writer) Finalize() error {
+	if w.closed {
+		return fmt.Errorf("writer already closed")
+	}
+
+	w.csv.Flush()
+	err := w.csv.Error()
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	if err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write transition list: %w", err)
+	}
+
+	w.closed = true
+	return w.out.Commit()
+}
+
+// Close finalizes the writer (alias for Finalize)
+func (w *Writer) Close() error {
+	return w.Finalize()
+}
+
+// Abort drops the unfinished list; an existing list at the output path is
+// kept. It does nothing after Finalize.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.out.Abort()
+}
@@ -0,0 +1,143 @@
+package transition
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+// writeList writes spectra as a transition list and returns its rows
+func writeList(t *testing.T, opts Options, specs ...*core.Spectrum) (*Writer, [][]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "transitions.txt")
+	w, err := NewWriter(path, opts, nil)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, spec := range specs {
+		if err := w.WriteSpectrum(spec); err != nil {
+			t.Fatalf("WriteSpectrum() error = %v", err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = layouts[opts.Layout].delim
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("reading %s: %v", opts.Layout, err)
+	}
+	return w, rows
+}
+
+// listSpectrum returns an oxidized peptide with two b/y fragments and an
+// unannotated peak
+func listSpectrum() *core.Spectrum {
+	spec := oxidized()
+	rt, ce := 42.5, 27.0
+	spec.PrecursorMZ = 532.7522
+	spec.RetentionTime = &rt
+	spec.CollisionEnergy = &ce
+	spec.Proteins = []string{"P12345", "Q67890"}
+	spec.Peaks = []core.Peak{
+		{MZ: 375.2031, Intensity: 50, Annotation: "y3"},
+		{MZ: 327.1288, Intensity: 100, Annotation: "b3"},
+		{MZ: 400.1, Intensity: 200},
+	}
+	return spec
+}
+
+func TestWriterColumns(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		header string
+		first  string // Row of the most intense transition
+	}{
+		{
+			layout: LayoutSkyline,
+			header: "Peptide Modified Sequence,Precursor m/z,Precursor Charge,Product m/z,Product Charge,Fragment Ion,Library Intensity,iRT,Explicit Collision Energy",
+			first:  "PEPTM[+15.9949]IDEK,532.7522,2,327.1288,1,b3,100,42.5,27",
+		},
+		{
+			layout: LayoutDIANN,
+			header: "PrecursorMz,ModifiedPeptide,StrippedPeptide,PrecursorCharge,Tr_recalibrated,FragmentMz,RelativeIntensity,FragmentType,FragmentSeriesNumber,FragmentCharge,FragmentLossType,CollisionEnergy",
+			first:  "532.7522,PEPTM(UniMod:35)IDEK,PEPTMIDEK,2,42.5,327.1288,1,b,3,1,noloss,27",
+		},
+		{
+			layout: LayoutSpectronaut,
+			header: "PrecursorMz,ModifiedPeptide,StrippedPeptide,PrecursorCharge,iRT,FragmentMz,RelativeIntensity,FragmentType,FragmentNumber,FragmentCharge,FragmentLossType,CollisionEnergy",
+			first:  "532.7522,_PEPTM[Oxidation (M)]IDEK_,PEPTMIDEK,2,42.5,327.1288,100,b,3,1,noloss,27",
+		},
+		{
+			layout: LayoutOpenSWATH,
+			header: "TransitionGroupId,TransitionId,PrecursorMz,ProductMz,LibraryIntensity,NormalizedRetentionTime,PeptideSequence,ModifiedPeptideSequence,ProteinId,PrecursorCharge,ProductCharge,FragmentType,FragmentSeriesNumber,Annotation,CollisionEnergy,Decoy",
+			first:  "0_PEPTMIDEK_2,0_PEPTMIDEK_2_0,532.7522,327.1288,100,42.5,PEPTMIDEK,PEPTM(UniMod:35)IDEK,P12345;Q67890,2,1,b,3,b3,27,0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.layout), func(t *testing.T) {
+			w, rows := writeList(t, Options{Layout: tt.layout, Selection: DefaultSelection}, listSpectrum())
+			if len(rows) != 3 {
+				t.Fatalf("rows = %d, want header and 2 transitions", len(rows))
+			}
+			if got := strings.Join(rows[0], ","); got != tt.header {
+				t.Errorf("header = %s, want %s", got, tt.header)
+			}
+			if got := strings.Join(rows[1], ","); got != tt.first {
+				t.Errorf("first row = %s, want %s", got, tt.first)
+			}
+			if w.Written() != 1 || w.Transitions() != 2 || w.Skipped() != 0 {
+				t.Errorf("Written, Transitions, Skipped = %d, %d, %d, want 1, 2, 0", w.Written(), w.Transitions(), w.Skipped())
+			}
+		})
+	}
+}
+
+func TestWriterSkipped(t *testing.T) {
+	unannotated := listSpectrum()
+	unannotated.Peaks = []core.Peak{{MZ: 400.1, Intensity: 200}}
+
+	w, rows := writeList(t, Options{Layout: LayoutOpenSWATH}, unannotated, listSpectrum())
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d, want header and 2 transitions", len(rows))
+	}
+	// Transition groups are numbered by written precursor
+	if rows[1][0] != "0_PEPTMIDEK_2" {
+		t.Errorf("TransitionGroupId = %s, want 0_PEPTMIDEK_2", rows[1][0])
+	}
+	if w.Written() != 1 || w.Skipped() != 1 {
+		t.Errorf("Written, Skipped = %d, %d, want 1, 1", w.Written(), w.Skipped())
+	}
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.csv")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewWriter(filepath.Join(dir, "new.csv"), Options{Layout: "mascot"}, nil); err == nil {
+		t.Error("NewWriter() with an unknown layout succeeded")
+	}
+	if _, err := NewWriter(existing, Options{}, nil); err == nil {
+		t.Error("NewWriter() over an existing file succeeded")
+	}
+	w, err := NewWriter(existing, Options{Overwrite: true}, nil)
+	if err != nil {
+		t.Fatalf("NewWriter() with Overwrite error = %v", err)
+	}
+	w.Abort()
+}
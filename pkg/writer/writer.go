@@ -0,0 +1,150 @@
+// Package writer provides a common interface over the format-specific
+// spectral library writers, which are registered by name so that one
+// conversion can write to several outputs.
+package writer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/shard"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/sqlite"
+	"github.com/ChrisMcGann/DBKey/pkg/writer/transition"
+)
+
+// Writer is implemented by every output format
+type Writer interface {
+	// Write writes a spectrum
+	Write(spec *core.Spectrum) error
+	// Close finishes the output and moves it to its path
+	Close() error
+}
+
+// Aborter is implemented by writers that can discard an unfinished output
+type Aborter interface {
+	// Abort removes the unfinished output, leaving any existing file
+	// untouched. It does nothing after Close.
+	Abort() error
+}
+
+// Config holds the settings passed to every writer. Each format uses the
+// settings that apply to it.
+type Config struct {
+	// Library is the mzVault metadata and update mode of "db" outputs
+	Library sqlite.Options
+	// Shard splits "db" outputs into several databases when enabled
+	Shard shard.Options
+	// Selection chooses the fragments of transition list and PQP outputs
+	Selection transition.Selection
+	// RowGroupRows is the Parquet row group size (0 = format default)
+	RowGroupRows int
+	// BatchSize is the number of spectra committed per transaction by
+	// SQLite-based outputs (0 = sqlite.DefaultBatchSize)
+	BatchSize int
+	// Overwrite allows replacing existing files
+	Overwrite bool
+	// ModDB names modifications in outputs that need UniMod accessions
+	ModDB *core.ModDatabase
+}
+
+// Factory creates a writer for path. Writes are made under ctx; once ctx is
+// cancelled Write returns ctx.Err().
+type Factory func(ctx context.Context, path string, cfg Config) (Writer, error)
+
+// format is a registered output format
+type format struct {
+	factory    Factory
+	extensions []string
+}
+
+var formats = make(map[string]format)
+
+// Register makes an output format available by name, and for detection
+// from the given file extensions (e.g. ".blib"). It panics if the name is
+// already registered.
+func Register(name string, factory Factory, extensions ...string) {
+	name = strings.ToLower(name)
+	if _, ok := formats[name]; ok {
+		panic("writer: format registered twice: " + name)
+	}
+	for i, ext := range extensions {
+		extensions[i] = strings.ToLower(ext)
+	}
+	formats[name] = format{factory: factory, extensions: extensions}
+}
+
+// Formats lists the registered output formats
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectFormat returns the output format for a file based on its extension
+func DetectFormat(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, name := range Formats() {
+		for _, e := range formats[name].extensions {
+			if e == ext {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot detect output format from extension '%s'", ext)
+}
+
+// Open creates a writer of the named format for path
+func Open(ctx context.Context, name, path string, cfg Config) (Writer, error) {
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format '%s', must be one of %s", name, strings.Join(Formats(), ", "))
+	}
+	return f.factory(ctx, path, cfg)
+}
+
+// Abort discards the output of a writer that has not been closed, if the
+// writer supports it
+func Abort(w Writer) error {
+	if a, ok := w.(Aborter); ok {
+		return a.Abort()
+	}
+	return nil
+}
+
+// Target is an output file and its format
+type Target struct {
+	Format string
+	Path   string
+}
+
+// ParseTarget parses an output given as "format:path" or as a path whose
+// format is detected from its extension. A prefix that is not a registered
+// format, such as a Windows drive letter, is part of the path.
+func ParseTarget(s string) (Target, error) {
+	if i := strings.IndexByte(s, ':'); i > 0 {
+		name := strings.ToLower(s[:i])
+		if _, ok := formats[name]; ok {
+			if s[i+1:] == "" {
+				return Target{}, fmt.Errorf("missing path in output '%s'", s)
+			}
+			return Target{Format: name, Path: s[i+1:]}, nil
+		}
+	}
+	name, err := DetectFormat(s)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w, please give the output as format:%s", err, s)
+	}
+	return Target{Format: name, Path: s}, nil
+}
+
+// String returns the target as "format:path"
+func (t Target) String() string {
+	return t.Format + ":" + t.Path
+}
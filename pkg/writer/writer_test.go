@@ -0,0 +1,208 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ChrisMcGann/DBKey/pkg/core"
+)
+
+func TestFormats(t *testing.T) {
+	got := Formats()
+	want := []string{"blib", "db", "diann", "jsonl", "openswath", "parquet", "pqp", "skyline", "spectronaut"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Formats() = %v, want %v", got, want)
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Errorf("Formats() = %v, want sorted", got)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() of a registered format did not panic")
+		}
+	}()
+	Register("DB", func(ctx context.Context, path string, cfg Config) (Writer, error) { return nil, nil })
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "library.db", want: FormatDB},
+		{path: "library.DB3", want: FormatDB},
+		{path: "library.sqlite", want: FormatDB},
+		{path: "library.blib", want: FormatBLIB},
+		{path: "library.jsonl", want: FormatJSONL},
+		{path: "library.ndjson", want: FormatJSONL},
+		{path: "library.parquet", want: FormatParquet},
+		{path: "library.pqp", want: FormatPQP},
+		{path: "transitions.csv", want: "skyline"},
+		{path: "transitions.tsv", wantErr: true},
+		{path: "library", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := DetectFormat(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectFormat(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFormat(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Target
+		wantErr string
+	}{
+		{input: "library.db", want: Target{Format: FormatDB, Path: "library.db"}},
+		{input: "blib:library.lib", want: Target{Format: FormatBLIB, Path: "library.lib"}},
+		{input: "PQP:out/library", want: Target{Format: FormatPQP, Path: "out/library"}},
+		{input: "diann:transitions.tsv", want: Target{Format: "diann", Path: "transitions.tsv"}},
+		{input: "db:C:\\out\\library.db", want: Target{Format: FormatDB, Path: "C:\\out\\library.db"}},
+		// A drive letter is not a format, so the format comes from the extension
+		{input: "C:\\out\\library.blib", want: Target{Format: FormatBLIB, Path: "C:\\out\\library.blib"}},
+		{input: "c:/out/library.jsonl", want: Target{Format: FormatJSONL, Path: "c:/out/library.jsonl"}},
+		{input: "C:\\out\\transitions.tsv", wantErr: "please give the output as format:C:\\out\\transitions.tsv"},
+		{input: "blib:", wantErr: "missing path in output 'blib:'"},
+		{input: "library", wantErr: "cannot detect output format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTarget(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseTarget(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTarget(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	if s := (Target{Format: FormatBLIB, Path: "C:\\library.blib"}).String(); s != "blib:C:\\library.blib" {
+		t.Errorf("String() = %q, want blib:C:\\library.blib", s)
+	}
+}
+
+func TestOpenUnsupported(t *testing.T) {
+	_, err := Open(context.Background(), "mgf", "library.mgf", Config{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported output format 'mgf'") {
+		t.Errorf("Open() error = %v, want unsupported output format", err)
+	}
+}
+
+// testSpectrum returns an annotated peptide spectrum every format accepts
+func testSpectrum() *core.Spectrum {
+	rt := 10.0
+	return &core.Spectrum{
+		Sequence:          "PEPTIDEK",
+		Charge:            2,
+		PrecursorMZ:       464.7348,
+		RetentionTime:     &rt,
+		FragmentationMode: "HCD",
+		MassAnalyzer:      "FT",
+		Proteins:          []string{"P1"},
+		Peaks: []core.Peak{
+			{MZ: 375.2, Intensity: 50, Annotation: "y3"},
+			{MZ: 504.2, Intensity: 100, Annotation: "y4"},
+		},
+	}
+}
+
+// openAll opens a writer of every registered format in dir
+func openAll(t *testing.T, ctx context.Context, dir string) map[string]Writer {
+	t.Helper()
+	writers := make(map[string]Writer)
+	for _, name := range Formats() {
+		w, err := Open(ctx, name, filepath.Join(dir, name+".out"), Config{ModDB: core.DefaultModDatabase()})
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", name, err)
+		}
+		writers[name] = w
+	}
+	return writers
+}
+
+func TestOpenAll(t *testing.T) {
+	dir := t.TempDir()
+	for name, w := range openAll(t, context.Background(), dir) {
+		if err := w.Write(testSpectrum()); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close(%s) error = %v", name, err)
+		}
+		if err := Abort(w); err != nil {
+			t.Errorf("Abort(%s) after Close error = %v", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	// Parquet writes two tables named after the output prefix
+	want := []string{
+		"blib.out", "db.out", "diann.out", "jsonl.out", "openswath.out",
+		"parquet.out.peaks.parquet", "parquet.out.precursors.parquet",
+		"pqp.out", "skyline.out", "spectronaut.out",
+	}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("outputs = %v, want %v", files, want)
+	}
+}
+
+func TestAbortAll(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	writers := openAll(t, ctx, dir)
+	for name, w := range writers {
+		if err := w.Write(testSpectrum()); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+
+	// Writes stop once the context is cancelled
+	cancel()
+	for name, w := range writers {
+		if err := w.Write(testSpectrum()); err != context.Canceled {
+			t.Errorf("Write(%s) after cancel error = %v, want %v", name, err, context.Canceled)
+		}
+	}
+
+	for name, w := range writers {
+		if err := Abort(w); err != nil {
+			t.Errorf("Abort(%s) error = %v", name, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files left after Abort: %v", entries)
+	}
+}